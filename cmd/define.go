@@ -16,6 +16,7 @@ var (
 	cfgWebSocketURL     string
 	cfgAppID            string
 	cfgKey              string
+	cfgKeyID            string
 	cfgNumber           uint
 	cfgBase             uint
 	cfgInterval         uint
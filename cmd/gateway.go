@@ -71,6 +71,18 @@ func init() {
 	gatewayCmd.PersistentFlags().StringSlice("app-config", nil, "应用配置文件.")
 	viper.BindPFlag("gateway.app-config", gatewayCmd.PersistentFlags().Lookup("app-config"))
 
+	gatewayCmd.PersistentFlags().String("app-dir", "", "应用配置目录，目录中每个.json文件为一个应用配置")
+	viper.BindPFlag("gateway.app-dir", gatewayCmd.PersistentFlags().Lookup("app-dir"))
+
+	gatewayCmd.PersistentFlags().String("app-url", "", "应用配置HTTP接口，返回应用配置的JSON数组")
+	viper.BindPFlag("gateway.app-url", gatewayCmd.PersistentFlags().Lookup("app-url"))
+
+	gatewayCmd.PersistentFlags().String("admin-bind", "", "管理接口绑定地址，为空时不启动")
+	viper.BindPFlag("gateway.admin-bind", gatewayCmd.PersistentFlags().Lookup("admin-bind"))
+
+	gatewayCmd.PersistentFlags().String("admin-token", "", "管理接口认证Token")
+	viper.BindPFlag("gateway.admin-token", gatewayCmd.PersistentFlags().Lookup("admin-token"))
+
 	gatewayCmd.PersistentFlags().String("wss-cert-file", "", "WebSocket加密服务证书文件路径")
 	viper.BindPFlag("gateway.wss-cert-file", gatewayCmd.PersistentFlags().Lookup("wss-cert-file"))
 
@@ -29,6 +29,7 @@ func init() {
 	stressCmd.PersistentFlags().StringVar(&cfgWebSocketURL, "ws-url", "ws://127.0.0.1:8870/ws", "WebSocket服务URL")
 	stressCmd.PersistentFlags().StringVar(&cfgAppID, "appid", "test", "App ID")
 	stressCmd.PersistentFlags().StringVar(&cfgKey, "key", "1234567890", "客户端Token验证密钥")
+	stressCmd.PersistentFlags().StringVar(&cfgKeyID, "keyid", "", "客户端Token验证密钥ID")
 	stressCmd.PersistentFlags().UintVar(&cfgNumber, "number", 100, "连接数")
 	stressCmd.PersistentFlags().UintVar(&cfgBase, "base", 1, "起始ID")
 	stressCmd.PersistentFlags().UintVar(&cfgInterval, "interval", 10, "消息发送间隔时间（单位：秒）")
@@ -115,6 +116,7 @@ func stressLoop(id uint) {
 		DeviceID:  "web",
 		Timestamp: now,
 		Token:     "",
+		KeyID:     cfgKeyID,
 	}
 	loginCmd.Token = loginCmd.CalToken([]byte(cfgKey))
 
@@ -28,6 +28,8 @@ const (
 
 var (
 	globalContext context.Context
+	// stubNonces 已使用的Nonce
+	stubNonces = httpapi.NewNonceCache(0)
 )
 
 // stubCmd represents the stub command
@@ -97,10 +99,10 @@ func HandleHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(400)
 		return
 	}
-	if cmd, err = httpapi.ParseCommand(globalContext, Tag, r.Header, payload, 10); err != nil {
+	if cmd, err = httpapi.ParseCommand(globalContext, Tag, r.Header, payload, 10, stubNonces); err != nil {
 		glog.Warningf("HandleLogin() ParseCommand error: %s\n",
 			err)
-		w.WriteHeader(400)
+		httpapi.WriteError(w, err)
 		return
 	}
 	glog.Info("got cmd:", cmd)
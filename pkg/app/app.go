@@ -7,20 +7,49 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/json"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/interceptor"
+	"github.com/zhangpeihao/zim/pkg/jwt"
+	"github.com/zhangpeihao/zim/pkg/ratelimit"
 	"github.com/zhangpeihao/zim/pkg/util"
 )
 
 // App 应用数据
 type App struct {
-	ID         string  `json:"id"`
-	Key        string  `json:"key"`
-	KeyBytes   []byte  `json:"-"`
-	RouteMap   InfoMap `json:"router"`
-	Router     *Router `json:"-"`
-	TokenCheck string  `json:"token-check"`
+	ID string `json:"id"`
+	// Key 旧配置的单一密钥，客户端和服务端共用，建议使用Keys
+	Key      string `json:"key"`
+	KeyBytes []byte `json:"-"`
+	// Keys 密钥列表
+	Keys     []*Key  `json:"keys"`
+	RouteMap InfoMap `json:"router"`
+	Router   *Router `json:"-"`
+	// TokenCheck 登入认证方式：yes、md5、hmac、jwt、allow、broker、no，参看auth包。
+	// 为空时使用broker，其他没有注册的值解析时返回错误
+	TokenCheck string `json:"token-check"`
+	// AllowList 认证方式为allow时允许登入的用户ID
+	AllowList []string `json:"allow-list"`
+	// JWT TokenCheck为jwt时的验证参数
+	JWT *JWTConfig `json:"jwt"`
+	// SignVersion 发送HTTP请求时使用的签名版本，默认为1
+	SignVersion string `json:"sign-version"`
+	// MinSignVersion 接受的最低签名版本，迁移完成后设置为2可以拒绝旧签名
+	MinSignVersion string `json:"min-sign-version"`
+	// Webhooks 事件通知配置
+	Webhooks []*Webhook `json:"webhooks"`
+	// Interceptors 信令拦截器配置，参看interceptor包
+	Interceptors []*interceptor.Config `json:"interceptors"`
+	// Chain 拦截器链，没有配置拦截器时为nil
+	Chain *interceptor.Chain `json:"-"`
+	// RateLimits 客户端信令限流规则，参看ratelimit包
+	RateLimits []*ratelimit.Rule `json:"rate-limits"`
+	// Limiter 限流，没有配置限流规则时为nil
+	Limiter *ratelimit.Limiter `json:"-"`
 }
 
 // CheckSum CheckSum接口
@@ -38,19 +67,88 @@ func NewApp(config string) (*App, error) {
 		glog.Errorf("define::NewApp(%s) os.Open() error: %s\n", config, err)
 		return nil, err
 	}
-	dec := json.NewDecoder(f)
+	defer f.Close()
+	app, err := ParseApp(f)
+	if err != nil {
+		glog.Errorf("define::NewApp(%s) error: %s\n", config, err)
+		return nil, err
+	}
+	return app, nil
+}
+
+// ParseApp 从JSON解析App，并新建路由
+func ParseApp(r io.Reader) (*App, error) {
+	dec := json.NewDecoder(r)
 	var app App
-	err = dec.Decode(&app)
+	err := dec.Decode(&app)
 	if err != nil {
-		glog.Errorf("define::NewApp(%s) json.Decode error: %s\n", config, err)
+		glog.Errorf("define::ParseApp() json.Decode error: %s\n", err)
 		return nil, err
 	}
-	app.Router, err = NewRouter(app.RouteMap)
+	if len(app.ID) == 0 {
+		glog.Errorln("define::ParseApp() empty app id")
+		return nil, define.ErrInvalidParameter
+	}
+	app.Chain, err = interceptor.NewChain(app.Interceptors)
+	if err != nil {
+		glog.Errorf("define::ParseApp(%s) NewChain error: %s\n", app.ID, err)
+		return nil, err
+	}
+	app.Limiter, err = ratelimit.NewLimiter(app.ID, app.RateLimits)
 	if err != nil {
-		glog.Errorf("define::NewApp(%s) NewRouter error: %s\n", config, err)
+		glog.Errorf("define::ParseApp(%s) NewLimiter error: %s\n", app.ID, err)
 		return nil, err
 	}
+	if len(app.TokenCheck) > 0 && !validTokenCheck(app.TokenCheck) {
+		glog.Errorf("define::ParseApp(%s) unknown token-check: %s\n", app.ID, app.TokenCheck)
+		return nil, define.ErrInvalidParameter
+	}
+	if strings.ToLower(app.TokenCheck) == TokenCheckJWT && (app.JWT == nil || len(app.JWT.Audience) == 0) {
+		glog.Errorf("define::ParseApp(%s) jwt token-check without audience\n", app.ID)
+		return nil, define.ErrInvalidParameter
+	}
+	if (len(app.SignVersion) > 0 && !validSignVersion(app.SignVersion)) ||
+		(len(app.MinSignVersion) > 0 && !validSignVersion(app.MinSignVersion)) {
+		glog.Errorf("define::ParseApp(%s) invalid sign version\n", app.ID)
+		return nil, define.ErrInvalidParameter
+	}
+	for _, webhook := range app.Webhooks {
+		if len(webhook.URL) == 0 {
+			glog.Errorf("define::ParseApp(%s) webhook without url\n", app.ID)
+			return nil, define.ErrInvalidParameter
+		}
+	}
 	app.KeyBytes = []byte(app.Key)
+	ids := make(map[string]bool)
+	for _, key := range app.Keys {
+		if (len(key.Secret) == 0 && len(key.PublicKey) == 0) ||
+			(len(key.Usage) > 0 && key.Usage != KeyUsageClient && key.Usage != KeyUsageServer) ||
+			(len(key.Secret) == 0 && key.Usage == KeyUsageServer) {
+			glog.Errorf("define::ParseApp(%s) invalid key(%s)\n", app.ID, key.ID)
+			return nil, define.ErrInvalidParameter
+		}
+		id := key.ID + "/" + key.Usage
+		if ids[id] {
+			glog.Errorf("define::ParseApp(%s) duplicate key(%s)\n", app.ID, key.ID)
+			return nil, define.ErrInvalidParameter
+		}
+		ids[id] = true
+		if len(key.Secret) > 0 {
+			key.secret = []byte(key.Secret)
+		}
+		if len(key.PublicKey) > 0 {
+			if key.publicKey, err = jwt.ParsePublicKey([]byte(key.PublicKey)); err != nil {
+				glog.Errorf("define::ParseApp(%s) key(%s) parse public key error: %s\n", app.ID, key.ID, err)
+				return nil, err
+			}
+		}
+	}
+	// 最后新建路由，配置无效时不会新建Broker实例
+	app.Router, err = NewRouter(app.RouteMap)
+	if err != nil {
+		glog.Errorf("define::ParseApp(%s) NewRouter error: %s\n", app.ID, err)
+		return nil, err
+	}
 	return &app, nil
 }
 
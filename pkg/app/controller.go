@@ -2,24 +2,52 @@
 
 package app
 
-import "context"
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
 
 const (
 	// ContextAppController AppController名
 	ContextAppController contextKey = "zim/appcontroller"
+	// ReloadDelay 配置文件变化后，等待这段时间没有新的变化再重新加载（合并编辑器的多次写入）
+	ReloadDelay = time.Millisecond * 200
 )
 
 type contextKey string
 
+// AppRemovedHandler App被删除时的回调函数
+type AppRemovedHandler func(app *App)
+
+// AppUpdatedHandler App被添加或配置变化时的回调函数
+type AppUpdatedHandler func(app *App)
+
 // Controller App map controller
 type Controller struct {
+	sync.RWMutex
 	apps map[string]*App
+	// sources 应用配置来源
+	sources []AppSource
+	// sourceApps 每个来源加载的App ID
+	sourceApps []map[string]bool
+	// removedHandlers App被删除时的回调函数
+	removedHandlers []AppRemovedHandler
+	// updatedHandlers App被添加或配置变化时的回调函数
+	updatedHandlers []AppUpdatedHandler
 }
 
 // NewController create a new controller from configs
 func NewController(configs []string) (*Controller, error) {
 	controller := &Controller{
-		apps: make(map[string]*App),
+		apps:       make(map[string]*App),
+		sources:    []AppSource{NewFileSource(configs)},
+		sourceApps: []map[string]bool{make(map[string]bool)},
 	}
 	if configs != nil {
 		for _, config := range configs {
@@ -28,22 +56,237 @@ func NewController(configs []string) (*Controller, error) {
 				return nil, err
 			}
 			controller.apps[appConfig.ID] = appConfig
+			controller.sourceApps[0][appConfig.ID] = true
 		}
 	}
 	return controller, nil
 }
 
+// AddSource 添加应用配置来源，并加载来源的所有应用
+func (controller *Controller) AddSource(source AppSource) error {
+	apps, err := source.Load()
+	if err != nil {
+		glog.Errorf("app::Controller::AddSource(%s) load error: %s\n", source, err)
+		return err
+	}
+	controller.Lock()
+	controller.sources = append(controller.sources, source)
+	controller.sourceApps = append(controller.sourceApps, make(map[string]bool))
+	index := len(controller.sources) - 1
+	controller.Unlock()
+	controller.update(index, apps)
+	return nil
+}
+
 // GetApp find app by app ID
 func (controller *Controller) GetApp(appid string) *App {
+	controller.RLock()
+	defer controller.RUnlock()
 	if app, ok := controller.apps[appid]; ok {
 		return app
 	}
 	return nil
 }
 
-// AddApp append an app into map (multi-thread unsafe!)
+// AddApp append or replace an app, log the changes and notify the updated handlers
 func (controller *Controller) AddApp(app *App) {
+	controller.Lock()
+	old, found := controller.apps[app.ID]
 	controller.apps[app.ID] = app
+	updatedHandlers := controller.updatedHandlers
+	controller.Unlock()
+	if !found {
+		glog.Infof("app::Controller::AddApp() added: %s\n", app.ID)
+	} else if changes := diff(old, app); len(changes) > 0 {
+		glog.Infof("app::Controller::AddApp() updated: %s(%s)\n", app.ID, strings.Join(changes, ","))
+	} else {
+		return
+	}
+	for _, handler := range updatedHandlers {
+		handler(app)
+	}
+}
+
+// RemoveApp remove an app and notify the removed handlers, return the removed app
+func (controller *Controller) RemoveApp(appid string) *App {
+	controller.Lock()
+	app, found := controller.apps[appid]
+	delete(controller.apps, appid)
+	for _, ids := range controller.sourceApps {
+		delete(ids, appid)
+	}
+	handlers := controller.removedHandlers
+	controller.Unlock()
+	if !found {
+		return nil
+	}
+	for _, handler := range handlers {
+		handler(app)
+	}
+	return app
+}
+
+// Apps list all apps sorted by ID
+func (controller *Controller) Apps() []*App {
+	controller.RLock()
+	defer controller.RUnlock()
+	apps := make([]*App, 0, len(controller.apps))
+	for _, app := range controller.apps {
+		apps = append(apps, app)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].ID < apps[j].ID
+	})
+	return apps
+}
+
+// OnAppRemoved 添加App被删除（包括重新加载时配置被删除）的回调函数
+func (controller *Controller) OnAppRemoved(handler AppRemovedHandler) {
+	controller.Lock()
+	defer controller.Unlock()
+	controller.removedHandlers = append(controller.removedHandlers, handler)
+}
+
+// OnAppUpdated 添加App被添加或配置变化（重新加载时）的回调函数
+func (controller *Controller) OnAppUpdated(handler AppUpdatedHandler) {
+	controller.Lock()
+	defer controller.Unlock()
+	controller.updatedHandlers = append(controller.updatedHandlers, handler)
+}
+
+// Reload 重新加载所有来源
+// 所有来源都加载成功后才替换，否则保留原配置；来源中不再存在的App将被删除。
+// 通过AddApp添加的App不受影响
+func (controller *Controller) Reload() error {
+	controller.RLock()
+	sources := controller.sources
+	controller.RUnlock()
+	loaded := make([][]*App, len(sources))
+	for index, source := range sources {
+		apps, err := source.Load()
+		if err != nil {
+			glog.Errorf("app::Controller::Reload() %s load error: %s, keep the old configs\n", source, err)
+			return err
+		}
+		loaded[index] = apps
+	}
+	for index, apps := range loaded {
+		controller.update(index, apps)
+	}
+	return nil
+}
+
+// update 用来源的全部应用替换来源原有的应用，记录变化并通知被删除的应用
+func (controller *Controller) update(index int, apps []*App) {
+	controller.Lock()
+	source := controller.sources[index]
+	ids := make(map[string]bool)
+	for _, app := range apps {
+		ids[app.ID] = true
+	}
+	var added, updated, removed []string
+	var removedApps, updatedApps []*App
+	for id := range controller.sourceApps[index] {
+		if !ids[id] {
+			removed = append(removed, id)
+			removedApps = append(removedApps, controller.apps[id])
+			delete(controller.apps, id)
+		}
+	}
+	for _, app := range apps {
+		if old, found := controller.apps[app.ID]; !found {
+			added = append(added, app.ID)
+			updatedApps = append(updatedApps, app)
+		} else if changes := diff(old, app); len(changes) > 0 {
+			updated = append(updated, app.ID+"("+strings.Join(changes, ",")+")")
+			updatedApps = append(updatedApps, app)
+		}
+		controller.apps[app.ID] = app
+		// 同一个App只属于最后加载它的来源
+		for _, sourceIDs := range controller.sourceApps {
+			delete(sourceIDs, app.ID)
+		}
+	}
+	controller.sourceApps[index] = ids
+	handlers := controller.removedHandlers
+	updatedHandlers := controller.updatedHandlers
+	controller.Unlock()
+
+	sort.Strings(added)
+	sort.Strings(updated)
+	sort.Strings(removed)
+	glog.Infof("app::Controller::update(%s) added: %v, updated: %v, removed: %v\n",
+		source, added, updated, removed)
+	for _, app := range removedApps {
+		for _, handler := range handlers {
+			handler(app)
+		}
+	}
+	for _, app := range updatedApps {
+		for _, handler := range updatedHandlers {
+			handler(app)
+		}
+	}
+}
+
+// diff 比较App配置，返回变化的字段
+func diff(old, app *App) (changes []string) {
+	if old.Key != app.Key {
+		changes = append(changes, "key")
+	}
+	if !reflect.DeepEqual(old.Keys, app.Keys) {
+		changes = append(changes, "keys")
+	}
+	if old.SignVersion != app.SignVersion || old.MinSignVersion != app.MinSignVersion {
+		changes = append(changes, "sign-version")
+	}
+	if old.TokenCheck != app.TokenCheck {
+		changes = append(changes, "token-check")
+	}
+	if !reflect.DeepEqual(old.Webhooks, app.Webhooks) {
+		changes = append(changes, "webhooks")
+	}
+	if !reflect.DeepEqual(old.Interceptors, app.Interceptors) {
+		changes = append(changes, "interceptors")
+	}
+	if !reflect.DeepEqual(old.RateLimits, app.RateLimits) {
+		changes = append(changes, "rate-limits")
+	}
+	for key, info := range app.RouteMap {
+		if oldInfo, found := old.RouteMap[key]; !found {
+			changes = append(changes, "+route:"+key)
+		} else if !reflect.DeepEqual(oldInfo, info) {
+			changes = append(changes, "route:"+key)
+		}
+	}
+	for key := range old.RouteMap {
+		if _, found := app.RouteMap[key]; !found {
+			changes = append(changes, "-route:"+key)
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// Watch 监控所有来源的变化并更新，阻塞直到ctx结束
+func (controller *Controller) Watch(ctx context.Context) error {
+	controller.RLock()
+	sources := controller.sources
+	controller.RUnlock()
+	var wg sync.WaitGroup
+	for index, source := range sources {
+		wg.Add(1)
+		go func(index int, source AppSource) {
+			defer wg.Done()
+			if err := source.Watch(ctx, func(apps []*App) {
+				controller.update(index, apps)
+			}); err != nil {
+				glog.Errorf("app::Controller::Watch() %s error: %s\n", source, err)
+			}
+		}(index, source)
+	}
+	wg.Wait()
+	return nil
 }
 
 // SaveIntoContext 设置AppController到Context中
@@ -5,5 +5,14 @@ Package app 应用配置（包含路由和CheckSum计算）
 
 收到IM信令后，根据信令名（例如：msg/foo/bar），查询信息的处理路由。
 可以提供多种路由设置与维护方式，包括：本地配置文件、Redis服务、etcd服务和consul服务。
+
+应用配置通过AppSource加载，目前支持：
+
+	FileSource 配置文件列表
+	DirSource  配置目录，目录中每个.json文件为一个应用配置
+	HTTPSource HTTP接口，返回应用配置的JSON数组，定时轮询（支持ETag）
+	KVSource   KV存储（例如etcd），每个key为一个应用配置，通过KV接口适配具体存储
+
+Controller可以同时使用多个来源，监控来源的变化并原子地替换应用配置。
 */
 package app
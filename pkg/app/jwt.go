@@ -0,0 +1,142 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/jwt"
+)
+
+const (
+	// TokenCheckMD5 使用MD5 Token检查登入
+	TokenCheckMD5 = "yes"
+	// TokenCheckJWT 使用JWT检查登入
+	TokenCheckJWT = "jwt"
+	// DefaultUserClaim 默认用户ID Claim
+	DefaultUserClaim = "sub"
+	// DefaultDeviceClaim 默认设备ID Claim
+	DefaultDeviceClaim = "deviceid"
+	// DefaultTagsClaim 默认标签Claim
+	DefaultTagsClaim = "tags"
+)
+
+var (
+	// tokenChecks 已注册的登入认证方式
+	tokenChecks       = make(map[string]bool)
+	tokenChecksLocker sync.RWMutex
+)
+
+// RegisterTokenCheck 注册登入认证方式名称，由auth包注册认证方式时调用
+func RegisterTokenCheck(name string) {
+	tokenChecksLocker.Lock()
+	defer tokenChecksLocker.Unlock()
+	tokenChecks[strings.ToLower(name)] = true
+}
+
+// validTokenCheck 检查登入认证方式是否已注册
+func validTokenCheck(name string) bool {
+	tokenChecksLocker.RLock()
+	defer tokenChecksLocker.RUnlock()
+	return tokenChecks[strings.ToLower(name)]
+}
+
+// JWTConfig JWT验证参数
+//
+// JWT使用应用的客户端密钥验证：Header中的kid对应密钥ID，
+// HS256使用密钥的secret，RS256和ES256使用密钥的public-key
+//
+// token-check为jwt时必须设置audience，Token必须设置exp
+type JWTConfig struct {
+	// Audience 检查aud，token-check为jwt时必须设置，避免接受签发给其他服务的Token
+	Audience string `json:"audience"`
+	// Issuer 不为空时检查iss
+	Issuer string `json:"issuer"`
+	// Leeway 检查exp、nbf时允许的时钟误差（单位：秒）
+	Leeway int `json:"leeway"`
+	// UserClaim 用户ID Claim，默认为sub
+	UserClaim string `json:"user-claim"`
+	// DeviceClaim 设备ID Claim，默认为deviceid
+	DeviceClaim string `json:"device-claim"`
+	// TagsClaim 标签Claim，默认为tags
+	TagsClaim string `json:"tags-claim"`
+}
+
+// Identity JWT中的用户信息
+type Identity struct {
+	// UserID 用户ID
+	UserID string
+	// DeviceID 设备ID
+	DeviceID string
+	// Tags 标签
+	Tags []string
+}
+
+// verifyKey 取得验证algorithm签名的密钥，密钥类型与算法不匹配时返回nil
+func (key *Key) verifyKey(algorithm string) interface{} {
+	switch algorithm {
+	case jwt.HS256:
+		if len(key.Secret) > 0 {
+			return key.SecretBytes()
+		}
+	case jwt.RS256:
+		if publicKey, ok := key.publicKey.(*rsa.PublicKey); ok {
+			return publicKey
+		}
+	case jwt.ES256:
+		if publicKey, ok := key.publicKey.(*ecdsa.PublicKey); ok {
+			return publicKey
+		}
+	}
+	return nil
+}
+
+// VerifyJWT 验证登入JWT，返回用户信息
+func (app *App) VerifyJWT(token string, now time.Time) (*Identity, error) {
+	config := app.JWT
+	if config == nil || len(config.Audience) == 0 {
+		return nil, jwt.ErrAudience
+	}
+	t, err := jwt.Parse(token, func(header *jwt.Header) (interface{}, error) {
+		key := app.findKey(header.KeyID, KeyUsageClient, now, func(key *Key) bool {
+			return key.hasSecret() || key.publicKey != nil
+		})
+		if key == nil {
+			return nil, define.ErrAuthFailed
+		}
+		verifyKey := key.verifyKey(header.Algorithm)
+		if verifyKey == nil {
+			return nil, jwt.ErrInvalidKey
+		}
+		return verifyKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = t.Claims.Validate(now, time.Duration(config.Leeway)*time.Second,
+		config.Audience, config.Issuer); err != nil {
+		return nil, err
+	}
+	identity := &Identity{
+		UserID:   t.Claims.String(claimName(config.UserClaim, DefaultUserClaim)),
+		DeviceID: t.Claims.String(claimName(config.DeviceClaim, DefaultDeviceClaim)),
+		Tags:     t.Claims.Strings(claimName(config.TagsClaim, DefaultTagsClaim)),
+	}
+	if len(identity.UserID) == 0 {
+		return nil, define.ErrAuthFailed
+	}
+	return identity, nil
+}
+
+// claimName 取得Claim名，为空时使用默认值
+func claimName(name, defaultName string) string {
+	if len(name) == 0 {
+		return defaultName
+	}
+	return name
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package app
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/util"
+)
+
+const (
+	// KeyUsageClient 客户端密钥，用于计算登入Token
+	KeyUsageClient = "client"
+	// KeyUsageServer 服务端密钥，用于计算HTTP接口的CheckSum
+	KeyUsageServer = "server"
+)
+
+// Key 应用密钥
+//
+// 每个应用可以配置多个密钥，通过ID区分。密钥轮换时，先添加新密钥（新旧密钥同时有效），
+// 等所有客户端和服务端都切换到新密钥后，再设置旧密钥的NotAfter或者删除旧密钥。
+type Key struct {
+	// ID 密钥ID
+	ID string `json:"id"`
+	// Secret 密钥
+	Secret string `json:"secret"`
+	// PublicKey PEM格式的公钥，用于验证RS256、ES256签名的JWT。
+	// 没有Secret时只能用于验证JWT，usage不能为server
+	PublicKey string `json:"public-key"`
+	// Usage 用途：client、server，为空时两者都可以使用
+	Usage string `json:"usage"`
+	// NotBefore 生效时间（Unix时间戳，单位秒），0表示不限制
+	NotBefore int64 `json:"not-before"`
+	// NotAfter 失效时间（Unix时间戳，单位秒），0表示不限制
+	NotAfter int64 `json:"not-after"`
+	// secret 密钥字节，ParseApp时设置
+	secret []byte
+	// publicKey 解析后的公钥，ParseApp时设置
+	publicKey interface{}
+}
+
+// SecretBytes 密钥字节
+func (key *Key) SecretBytes() []byte {
+	if key.secret == nil {
+		return []byte(key.Secret)
+	}
+	return key.secret
+}
+
+// Valid 检查密钥在now时刻是否有效
+func (key *Key) Valid(now time.Time) bool {
+	ts := now.Unix()
+	if key.NotBefore > 0 && ts < key.NotBefore {
+		return false
+	}
+	if key.NotAfter > 0 && ts >= key.NotAfter {
+		return false
+	}
+	return true
+}
+
+// hasSecret 是否设置了密钥，只有公钥的密钥只能用于验证JWT
+func (key *Key) hasSecret() bool {
+	return len(key.SecretBytes()) > 0
+}
+
+// Allow 检查密钥是否可以用于usage
+func (key *Key) Allow(usage string) bool {
+	return len(key.Usage) == 0 || len(usage) == 0 || key.Usage == usage
+}
+
+// CheckSumSHA1 取得CheckSum SHA1算法
+func (key *Key) CheckSumSHA1(fields ...[]byte) string {
+	h := sha1.New()
+	return util.CheckSumWithKey(h, key.SecretBytes(), fields...)
+}
+
+// CheckSumSHA256 取得CheckSum SHA256算法
+func (key *Key) CheckSumSHA256(fields ...[]byte) string {
+	h := sha256.New()
+	return util.CheckSumWithKey(h, key.SecretBytes(), fields...)
+}
+
+// CheckSumMD5 取得CheckSum MD5算法
+func (key *Key) CheckSumMD5(fields ...[]byte) string {
+	h := md5.New()
+	return util.CheckSumWithKey(h, key.SecretBytes(), fields...)
+}
+
+// legacyKey 兼容旧配置的单一密钥（ID为空，客户端和服务端都可以使用）
+func (app *App) legacyKey() *Key {
+	if len(app.Key) == 0 && len(app.KeyBytes) == 0 {
+		return nil
+	}
+	secret := app.KeyBytes
+	if secret == nil {
+		secret = []byte(app.Key)
+	}
+	return &Key{
+		Secret: string(secret),
+		secret: secret,
+	}
+}
+
+// FindKey 根据密钥ID查找now时刻有效、可用于usage的密钥，没有找到时返回nil
+// 空ID优先匹配Keys中ID为空的密钥，其次是旧配置的Key。只有公钥的密钥不会返回
+func (app *App) FindKey(id, usage string, now time.Time) *Key {
+	return app.findKey(id, usage, now, (*Key).hasSecret)
+}
+
+// findKey 根据密钥ID查找now时刻有效、可用于usage并且满足usable的密钥
+func (app *App) findKey(id, usage string, now time.Time, usable func(*Key) bool) *Key {
+	for _, key := range app.Keys {
+		if key.ID == id && key.Allow(usage) && key.Valid(now) && usable(key) {
+			return key
+		}
+	}
+	if len(id) == 0 {
+		return app.legacyKey()
+	}
+	return nil
+}
+
+// SigningKey 取得now时刻用于usage签名的密钥
+// 选择有效密钥中生效时间最晚的一个（相同时取配置中靠后的），没有时使用旧配置的Key。只有公钥的密钥不会返回
+func (app *App) SigningKey(usage string, now time.Time) *Key {
+	var found *Key
+	for _, key := range app.Keys {
+		if !key.Allow(usage) || !key.Valid(now) || !key.hasSecret() {
+			continue
+		}
+		if found == nil || key.NotBefore >= found.NotBefore {
+			found = key
+		}
+	}
+	if found != nil {
+		return found
+	}
+	return app.legacyKey()
+}
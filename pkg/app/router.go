@@ -5,81 +5,139 @@ package app
 import (
 	"bytes"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/golang/glog"
 	"github.com/zhangpeihao/zim/pkg/broker"
-	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
 )
 
 // Info 最简单的路由信息
 type Info struct {
 	Broker string `json:"broker"`
 	Tag    string `json:"tag"`
+	// Instance Broker实例名，为空时使用默认实例。实例名在所有应用之间共享，
+	// 同名实例的Options必须相同，修改Options需要使用新的实例名
+	Instance string `json:"instance,omitempty"`
+	// Options Broker实例参数，覆盖配置文件中的参数（需要设置Instance）
+	Options map[string]interface{} `json:"options,omitempty"`
 }
 
 // InfoMap 最简单的路由信息Map
 type InfoMap map[string]Info
 
+// Route 路由结果
+type Route struct {
+	// Broker 消息Broker
+	Broker broker.Broker
+	// Tag 消息队列tag，为空时使用网关的默认tag
+	Tag string
+}
+
+// String 输出
+func (route *Route) String() string {
+	if len(route.Tag) == 0 {
+		return route.Broker.String()
+	}
+	return route.Broker.String() + "(" + route.Tag + ")"
+}
+
 // Router 路由
+//
+// 路由key按"/"分段匹配信令名：
+//
+// * 不含通配符的key精确匹配，同时匹配以它为前缀的信令名，例如："msg"匹配"msg/foo/bar"
+//
+// * "+"匹配一段，例如："msg/+/bar"匹配"msg/foo/bar"
+//
+// * 最后一段为"*"匹配一段或多段，例如："msg/*"匹配"msg/foo"和"msg/foo/bar"，单独的"*"为默认路由
+//
+// * 最后一段为"**"匹配零段或多段，例如："msg/**"匹配"msg"和"msg/foo/bar"
+//
+// 多个key都匹配时，逐段比较，优先级：字面值 > "+" > "*" > "**" > 前缀匹配
 type Router struct {
-	defaultBroker broker.Broker
-	brokers       map[string]broker.Broker
+	root   *trieNode
+	routes map[string]*Route
 }
 
 // NewRouter 新建Router
 func NewRouter(routerMap InfoMap) (r *Router, err error) {
 	r = &Router{
-		brokers: make(map[string]broker.Broker),
+		root:   newTrieNode(),
+		routes: make(map[string]*Route),
 	}
 
 	for key, routeInfo := range routerMap {
-		switch routeInfo.Broker {
-		case "httpapi":
-			fallthrough
-		case "mock":
-			if key == "*" {
-				if r.defaultBroker = broker.Get(routeInfo.Broker); r.defaultBroker == nil {
-					return nil, fmt.Errorf("unsupport default broker: %s", routeInfo.Broker)
-				}
-			} else {
-				if broker := broker.Get(routeInfo.Broker); broker != nil {
-					r.brokers[key] = broker
-				} else {
-					return nil, fmt.Errorf("unsupport broker: %s", routeInfo.Broker)
-				}
-			}
-		default:
-			glog.Errorf("router::driver::jsonfile::NewRouter() unsupport protocol %s\n",
-				routeInfo.Broker)
-			return nil, define.ErrUnsupportProtocol
+		b, err := register.NewInstance(routeInfo.Broker, routeInfo.Instance, routeInfo.Options)
+		if err != nil {
+			name := register.InstanceName(routeInfo.Broker, routeInfo.Instance)
+			glog.Errorf("app::NewRouter() route %s broker %s error: %s\n", key, name, err)
+			return nil, fmt.Errorf("route %s broker %s: %s", key, name, err)
 		}
+		route := &Route{
+			Broker: b,
+			Tag:    routeInfo.Tag,
+		}
+		if err = r.root.insert(key, route); err != nil {
+			glog.Errorf("app::NewRouter() error: %s\n", err)
+			return nil, err
+		}
+		r.routes[key] = route
 	}
 	return r, nil
 }
 
-// Find 查询路由
-func (r *Router) Find(name string) broker.Broker {
-	glog.Infof("Router::Find(%s)\n", name)
-	broker, found := r.brokers[name]
-	if !found {
-		return r.defaultBroker
-	}
-	return broker
+// Find 查询路由，没有匹配的路由时返回nil
+func (r *Router) Find(name string) *Route {
+	return r.root.match(strings.Split(name, Separator))
 }
 
 // String 输出
 func (r *Router) String() string {
-	buf := new(bytes.Buffer)
-	if r.defaultBroker != nil {
-		buf.WriteString("*: ")
-		buf.WriteString(r.defaultBroker.String())
-		buf.WriteString("\n")
+	keys := make([]string, 0, len(r.routes))
+	for key := range r.routes {
+		keys = append(keys, key)
 	}
-	for key, broker := range r.brokers {
+	sort.Strings(keys)
+	buf := new(bytes.Buffer)
+	for _, key := range keys {
 		buf.WriteString(key)
 		buf.WriteString(": ")
-		buf.WriteString(broker.String())
+		buf.WriteString(r.routes[key].String())
 		buf.WriteString("\n")
 	}
 	return string(buf.Bytes())
 }
+
+// Brokers 路由使用的所有Broker，同一个Broker实例只返回一次
+func (r *Router) Brokers() []broker.Broker {
+	keys := make([]string, 0, len(r.routes))
+	for key := range r.routes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	found := make(map[broker.Broker]bool)
+	var brokers []broker.Broker
+	for _, key := range keys {
+		if b := r.routes[key].Broker; !found[b] {
+			found[b] = true
+			brokers = append(brokers, b)
+		}
+	}
+	return brokers
+}
+
+// Tags 路由设置的所有tag，不包括为空的tag
+func (r *Router) Tags() []string {
+	found := make(map[string]bool)
+	var tags []string
+	for _, route := range r.routes {
+		if len(route.Tag) > 0 && !found[route.Tag] {
+			found[route.Tag] = true
+			tags = append(tags, route.Tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package app
+
+import (
+	"strconv"
+
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/util"
+)
+
+const (
+	// SignVersion1 旧签名方式：SHA256(key + fields...)，Payload摘要为MD5(key + payload)
+	SignVersion1 = "1"
+	// SignVersion2 HMAC-SHA256(key, 每个字段加4字节长度后拼接)，Payload摘要为SHA256(payload)
+	SignVersion2 = "2"
+	// DefaultSignVersion 默认签名版本，兼容旧的应用服务
+	DefaultSignVersion = SignVersion1
+	// MaxSignVersion 当前支持的最高签名版本
+	MaxSignVersion = 2
+)
+
+// signVersionNumber 解析签名版本号，无效版本返回0
+func signVersionNumber(version string) int {
+	n, err := strconv.Atoi(version)
+	if err != nil || n < 1 || n > MaxSignVersion || strconv.Itoa(n) != version {
+		return 0
+	}
+	return n
+}
+
+// validSignVersion 检查签名版本
+func validSignVersion(version string) bool {
+	return signVersionNumber(version) > 0
+}
+
+// Sign 使用version版本的签名方式计算签名
+func (key *Key) Sign(version string, fields ...[]byte) (string, error) {
+	switch version {
+	case SignVersion1:
+		return key.CheckSumSHA256(fields...), nil
+	case SignVersion2:
+		return util.SignHMACSHA256(key.SecretBytes(), fields...), nil
+	}
+	return "", define.ErrInvalidParameter
+}
+
+// PayloadDigest 使用version版本的签名方式计算Payload摘要
+func (key *Key) PayloadDigest(version string, payload []byte) (string, error) {
+	switch version {
+	case SignVersion1:
+		return key.CheckSumMD5(payload), nil
+	case SignVersion2:
+		return util.CheckSumSHA256(payload), nil
+	}
+	return "", define.ErrInvalidParameter
+}
+
+// ComposeSignVersion 发送请求时使用的签名版本
+func (app *App) ComposeSignVersion() string {
+	if len(app.SignVersion) == 0 {
+		return DefaultSignVersion
+	}
+	return app.SignVersion
+}
+
+// AcceptSignVersion 检查是否接受version版本的签名
+func (app *App) AcceptSignVersion(version string) bool {
+	n := signVersionNumber(version)
+	if n == 0 {
+		return false
+	}
+	return len(app.MinSignVersion) == 0 || n >= signVersionNumber(app.MinSignVersion)
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+// AppSource 应用配置来源
+type AppSource interface {
+	// Load 加载所有应用
+	Load() ([]*App, error)
+	// Watch 监控配置变化，每次变化后调用onChange（参数为来源的全部应用），阻塞直到ctx结束
+	Watch(ctx context.Context, onChange func(apps []*App)) error
+	// String 来源描述
+	String() string
+}
+
+// FileSource 配置文件列表，不存在的文件将被忽略
+type FileSource struct {
+	// Files 配置文件
+	Files []string
+}
+
+// NewFileSource 新建配置文件来源
+func NewFileSource(files []string) *FileSource {
+	return &FileSource{
+		Files: files,
+	}
+}
+
+// Load 加载所有应用
+func (source *FileSource) Load() ([]*App, error) {
+	var apps []*App
+	for _, file := range source.Files {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			glog.Warningf("app::FileSource::Load() config %s not exist\n", file)
+			continue
+		}
+		app, err := NewApp(file)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// Watch 监控配置文件所在目录，以支持编辑器通过rename保存文件
+func (source *FileSource) Watch(ctx context.Context, onChange func(apps []*App)) error {
+	files := make(map[string]bool)
+	var dirs []string
+	for _, file := range source.Files {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		files[path] = true
+		dirs = append(dirs, filepath.Dir(path))
+	}
+	return watchFiles(ctx, dirs, func(path string) bool {
+		return files[path]
+	}, source, onChange)
+}
+
+// String 来源描述
+func (source *FileSource) String() string {
+	return "file"
+}
+
+// watchFiles 监控目录中满足filter的文件变化，等待ReloadDelay没有新的变化后重新加载
+func watchFiles(ctx context.Context, dirs []string, filter func(path string) bool,
+	source AppSource, onChange func(apps []*App)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Errorln("app::watchFiles() fsnotify.NewWatcher() error:", err)
+		return err
+	}
+	defer watcher.Close()
+	watched := make(map[string]bool)
+	for _, dir := range dirs {
+		if watched[dir] {
+			continue
+		}
+		if err = watcher.Add(dir); err != nil {
+			glog.Errorf("app::watchFiles() watch %s error: %s\n", dir, err)
+			return err
+		}
+		watched[dir] = true
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case event := <-watcher.Events:
+			if path, err := filepath.Abs(event.Name); err == nil && filter(path) {
+				glog.Infof("app::watchFiles() %s\n", event)
+				reload = time.After(ReloadDelay)
+			}
+		case err := <-watcher.Errors:
+			glog.Warningln("app::watchFiles() error:", err)
+		case <-reload:
+			reload = nil
+			apps, err := source.Load()
+			if err != nil {
+				glog.Errorf("app::watchFiles() %s load error: %s, keep the old configs\n", source, err)
+				continue
+			}
+			onChange(apps)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// ConfigExt 应用配置文件扩展名
+	ConfigExt = ".json"
+)
+
+// DirSource 配置目录，目录中每个.json文件为一个应用配置
+type DirSource struct {
+	// Dir 配置目录
+	Dir string
+}
+
+// NewDirSource 新建配置目录来源
+func NewDirSource(dir string) *DirSource {
+	return &DirSource{
+		Dir: dir,
+	}
+}
+
+// Load 加载所有应用，任意一个配置文件出错都返回错误
+func (source *DirSource) Load() ([]*App, error) {
+	infos, err := ioutil.ReadDir(source.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ConfigExt) {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	var apps []*App
+	for _, name := range names {
+		app, err := NewApp(filepath.Join(source.Dir, name))
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// Watch 监控配置目录中.json文件的变化
+func (source *DirSource) Watch(ctx context.Context, onChange func(apps []*App)) error {
+	dir, err := filepath.Abs(source.Dir)
+	if err != nil {
+		return err
+	}
+	return watchFiles(ctx, []string{dir}, func(path string) bool {
+		return filepath.Dir(path) == dir && strings.HasSuffix(path, ConfigExt)
+	}, source, onChange)
+}
+
+// String 来源描述
+func (source *DirSource) String() string {
+	return "dir:" + source.Dir
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultHTTPSourceInterval 默认HTTP配置轮询间隔
+	DefaultHTTPSourceInterval = time.Second * 30
+	// DefaultHTTPSourceTimeout 默认HTTP请求超时
+	DefaultHTTPSourceTimeout = time.Second * 10
+)
+
+// HTTPSource HTTP JSON接口，返回应用配置数组
+// 轮询接口，内容变化（或ETag变化）时更新
+type HTTPSource struct {
+	// URL 接口地址
+	URL string
+	// Interval 轮询间隔
+	Interval time.Duration
+	// Header 请求Header（例如：认证信息）
+	Header http.Header
+	// Client HTTP客户端
+	Client *http.Client
+	// locker 保护etag和body
+	locker sync.Mutex
+	// etag 上次响应的ETag
+	etag string
+	// body 上次响应的内容
+	body []byte
+}
+
+// NewHTTPSource 新建HTTP配置来源，interval小于等于0时使用默认间隔
+func NewHTTPSource(url string, interval time.Duration) *HTTPSource {
+	if interval <= 0 {
+		interval = DefaultHTTPSourceInterval
+	}
+	return &HTTPSource{
+		URL:      url,
+		Interval: interval,
+		Header:   make(http.Header),
+		Client:   &http.Client{Timeout: DefaultHTTPSourceTimeout},
+	}
+}
+
+// fetch 请求接口，内容没有变化时changed返回false
+func (source *HTTPSource) fetch() (body []byte, changed bool, err error) {
+	source.locker.Lock()
+	defer source.locker.Unlock()
+	req, err := http.NewRequest("GET", source.URL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	for key, values := range source.Header {
+		req.Header[key] = values
+	}
+	if len(source.etag) > 0 {
+		req.Header.Set("If-None-Match", source.etag)
+	}
+	resp, err := source.Client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return source.body, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("app source %s response status code %d", source.URL, resp.StatusCode)
+	}
+	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+		return nil, false, err
+	}
+	changed = !bytes.Equal(body, source.body)
+	source.etag = resp.Header.Get("ETag")
+	source.body = body
+	return body, changed, nil
+}
+
+// parseApps 解析应用配置数组
+func parseApps(body []byte) ([]*App, error) {
+	var configs []json.RawMessage
+	if err := json.Unmarshal(body, &configs); err != nil {
+		return nil, err
+	}
+	apps := make([]*App, 0, len(configs))
+	for _, config := range configs {
+		app, err := ParseApp(bytes.NewReader(config))
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// Load 加载所有应用
+func (source *HTTPSource) Load() ([]*App, error) {
+	body, _, err := source.fetch()
+	if err != nil {
+		return nil, err
+	}
+	return parseApps(body)
+}
+
+// Watch 轮询接口
+func (source *HTTPSource) Watch(ctx context.Context, onChange func(apps []*App)) error {
+	ticker := time.NewTicker(source.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			body, changed, err := source.fetch()
+			if err != nil {
+				glog.Warningf("app::HTTPSource::Watch() %s error: %s\n", source.URL, err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			apps, err := parseApps(body)
+			if err != nil {
+				glog.Errorf("app::HTTPSource::Watch() %s parse error: %s, keep the old configs\n", source.URL, err)
+				// 下次轮询重新解析
+				source.locker.Lock()
+				source.etag, source.body = "", nil
+				source.locker.Unlock()
+				continue
+			}
+			onChange(apps)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// String 来源描述
+func (source *HTTPSource) String() string {
+	return "http:" + source.URL
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package app
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// KV 键值存储接口，用于对接etcd、consul和Redis等服务
+type KV interface {
+	// List 列出前缀下的所有键值
+	List(prefix string) (map[string][]byte, error)
+	// Watch 监控前缀下的变化，有变化时向返回的channel发送信号，ctx结束后关闭channel
+	Watch(ctx context.Context, prefix string) (<-chan struct{}, error)
+}
+
+// KVSource 键值存储，前缀下每个值为一个应用配置JSON
+type KVSource struct {
+	// KV 键值存储
+	KV KV
+	// Prefix 键前缀，例如："/zim/apps/"
+	Prefix string
+}
+
+// NewKVSource 新建键值存储配置来源
+func NewKVSource(kv KV, prefix string) *KVSource {
+	return &KVSource{
+		KV:     kv,
+		Prefix: prefix,
+	}
+}
+
+// Load 加载所有应用，任意一个配置出错都返回错误
+func (source *KVSource) Load() ([]*App, error) {
+	values, err := source.KV.List(source.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	apps := make([]*App, 0, len(keys))
+	for _, key := range keys {
+		app, err := ParseApp(bytes.NewReader(values[key]))
+		if err != nil {
+			glog.Errorf("app::KVSource::Load() key %s error: %s\n", key, err)
+			return nil, err
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// Watch 监控键值变化
+func (source *KVSource) Watch(ctx context.Context, onChange func(apps []*App)) error {
+	changes, err := source.KV.Watch(ctx, source.Prefix)
+	if err != nil {
+		return err
+	}
+	for range changes {
+		apps, err := source.Load()
+		if err != nil {
+			glog.Errorf("app::KVSource::Watch() %s load error: %s, keep the old configs\n", source, err)
+			continue
+		}
+		onChange(apps)
+	}
+	return nil
+}
+
+// String 来源描述
+func (source *KVSource) String() string {
+	return "kv:" + source.Prefix
+}
+
+// MemoryKV 内存实现的KV（用于测试）
+type MemoryKV struct {
+	sync.Mutex
+	values   map[string][]byte
+	watchers map[chan struct{}]string
+}
+
+// NewMemoryKV 新建内存KV
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{
+		values:   make(map[string][]byte),
+		watchers: make(map[chan struct{}]string),
+	}
+}
+
+// Put 设置键值
+func (kv *MemoryKV) Put(key string, value []byte) {
+	kv.Lock()
+	defer kv.Unlock()
+	kv.values[key] = append([]byte(nil), value...)
+	kv.notify(key)
+}
+
+// Delete 删除键值
+func (kv *MemoryKV) Delete(key string) {
+	kv.Lock()
+	defer kv.Unlock()
+	if _, found := kv.values[key]; found {
+		delete(kv.values, key)
+		kv.notify(key)
+	}
+}
+
+// notify 通知监控前缀的watcher，调用者需持有锁
+func (kv *MemoryKV) notify(key string) {
+	for watcher, prefix := range kv.watchers {
+		if strings.HasPrefix(key, prefix) {
+			select {
+			case watcher <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// List 列出前缀下的所有键值
+func (kv *MemoryKV) List(prefix string) (map[string][]byte, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	values := make(map[string][]byte)
+	for key, value := range kv.values {
+		if strings.HasPrefix(key, prefix) {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// Watch 监控前缀下的变化，多次变化可能合并为一个信号
+func (kv *MemoryKV) Watch(ctx context.Context, prefix string) (<-chan struct{}, error) {
+	watcher := make(chan struct{}, 1)
+	kv.Lock()
+	kv.watchers[watcher] = prefix
+	kv.Unlock()
+	go func() {
+		<-ctx.Done()
+		kv.Lock()
+		delete(kv.watchers, watcher)
+		close(watcher)
+		kv.Unlock()
+	}()
+	return watcher, nil
+}
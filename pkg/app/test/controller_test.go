@@ -0,0 +1,101 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+)
+
+func writeAppConfig(t *testing.T, path, id, key string) {
+	config := fmt.Sprintf(`{"id": "%s", "key": "%s", "router": {"*": {"broker": "mock"}}}`, id, key)
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal("WriteFile() error:", err)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	t.Fatal("wait condition timeout")
+}
+
+func TestControllerReload(t *testing.T) {
+	if err := register.Init("test"); err != nil {
+		t.Fatal("register.Init() error:", err)
+	}
+	dir, err := ioutil.TempDir("", "zim-app")
+	if err != nil {
+		t.Fatal("TempDir() error:", err)
+	}
+	defer os.RemoveAll(dir)
+	foo, bar := filepath.Join(dir, "foo.json"), filepath.Join(dir, "bar.json")
+	writeAppConfig(t, foo, "foo", "key1")
+	writeAppConfig(t, bar, "bar", "key1")
+
+	controller, err := app.NewController([]string{foo, bar})
+	if err != nil {
+		t.Fatal("NewController() error:", err)
+	}
+	removed := make(chan string, 1)
+	controller.OnAppRemoved(func(a *app.App) {
+		removed <- a.ID
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go controller.Watch(ctx)
+	time.Sleep(time.Millisecond * 100)
+
+	// 修改Key
+	writeAppConfig(t, foo, "foo", "key2")
+	waitFor(t, func() bool {
+		return controller.GetApp("foo").Key == "key2"
+	})
+
+	// 非法配置不替换原配置
+	if err = ioutil.WriteFile(foo, []byte("{"), 0644); err != nil {
+		t.Fatal("WriteFile() error:", err)
+	}
+	if err = controller.Reload(); err == nil {
+		t.Error("Reload() invalid config should return error")
+	}
+	if a := controller.GetApp("foo"); a == nil || a.Key != "key2" {
+		t.Errorf("invalid config should keep the old app, got: %+v\n", a)
+	}
+	writeAppConfig(t, foo, "foo", "key2")
+
+	// 删除配置文件
+	os.Remove(bar)
+	select {
+	case id := <-removed:
+		if id != "bar" {
+			t.Errorf("removed app expect: bar, got: %s\n", id)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait app removed timeout")
+	}
+	if controller.GetApp("bar") != nil {
+		t.Error("app bar should be removed")
+	}
+
+	// 通过AddApp添加的App不受重新加载影响
+	controller.AddApp(&app.App{ID: "manual"})
+	if err = controller.Reload(); err != nil {
+		t.Fatal("Reload() error:", err)
+	}
+	if apps := controller.Apps(); len(apps) != 2 || apps[0].ID != "foo" || apps[1].ID != "manual" {
+		t.Errorf("Apps() got: %v\n", apps)
+	}
+}
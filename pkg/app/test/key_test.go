@@ -0,0 +1,109 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+)
+
+func TestKeys(t *testing.T) {
+	if err := register.Init("test"); err != nil {
+		t.Fatal("register.Init() error:", err)
+	}
+	a, err := app.ParseApp(strings.NewReader(`{
+		"id": "foo",
+		"key": "legacy",
+		"keys": [
+			{"id": "c1", "secret": "client1", "usage": "client", "not-after": 2000},
+			{"id": "c2", "secret": "client2", "usage": "client", "not-before": 1000},
+			{"id": "s1", "secret": "server1", "usage": "server"}
+		],
+		"router": {"*": {"broker": "mock"}}
+	}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+
+	testCases := []struct {
+		id     string
+		usage  string
+		now    int64
+		expect string
+	}{
+		{"c1", app.KeyUsageClient, 500, "client1"},
+		{"c1", app.KeyUsageClient, 2000, ""},
+		{"c2", app.KeyUsageClient, 500, ""},
+		{"c2", app.KeyUsageClient, 1500, "client2"},
+		{"c1", app.KeyUsageServer, 500, ""},
+		{"s1", app.KeyUsageServer, 500, "server1"},
+		{"s1", app.KeyUsageClient, 500, ""},
+		{"", app.KeyUsageServer, 500, "legacy"},
+		{"none", app.KeyUsageClient, 500, ""},
+	}
+	for index, testCase := range testCases {
+		key := a.FindKey(testCase.id, testCase.usage, time.Unix(testCase.now, 0))
+		got := ""
+		if key != nil {
+			got = string(key.SecretBytes())
+		}
+		if got != testCase.expect {
+			t.Errorf("Case(%d): FindKey(%s, %s, %d) expect: %q, got: %q\n", index+1,
+				testCase.id, testCase.usage, testCase.now, testCase.expect, got)
+		}
+	}
+
+	// 轮换期间使用最新生效的密钥签名
+	if key := a.SigningKey(app.KeyUsageClient, time.Unix(1500, 0)); key == nil || key.ID != "c2" {
+		t.Errorf("SigningKey() expect c2, got: %+v\n", key)
+	}
+	if key := a.SigningKey(app.KeyUsageClient, time.Unix(500, 0)); key == nil || key.ID != "c1" {
+		t.Errorf("SigningKey() expect c1, got: %+v\n", key)
+	}
+
+	for _, config := range []string{
+		`{"id": "foo", "keys": [{"id": "k", "secret": ""}]}`,
+		`{"id": "foo", "keys": [{"id": "k", "secret": "s", "usage": "other"}]}`,
+		`{"id": "foo", "keys": [{"id": "k", "secret": "s"}, {"id": "k", "secret": "t"}]}`,
+	} {
+		if _, err = app.ParseApp(strings.NewReader(config)); err == nil {
+			t.Errorf("ParseApp(%s) should return error\n", config)
+		}
+	}
+}
+
+func TestSignVersion(t *testing.T) {
+	testCases := []struct {
+		min     string
+		version string
+		expect  bool
+	}{
+		{"", app.SignVersion1, true},
+		{"", app.SignVersion2, true},
+		{app.SignVersion2, app.SignVersion1, false},
+		{app.SignVersion2, app.SignVersion2, true},
+		{"", "02", false},
+		{"", "10", false},
+		{"", "v2", false},
+		{"", "", false},
+	}
+	for index, testCase := range testCases {
+		a := &app.App{ID: "foo", MinSignVersion: testCase.min}
+		if got := a.AcceptSignVersion(testCase.version); got != testCase.expect {
+			t.Errorf("Case(%d): AcceptSignVersion(%q) expect: %v, got: %v\n", index+1, testCase.version, testCase.expect, got)
+		}
+	}
+
+	// 版本2的Payload摘要使用SHA256
+	key := &app.Key{ID: "k", Secret: "secret"}
+	if digest, err := key.PayloadDigest(app.SignVersion2, []byte("foo")); err != nil || len(digest) != 64 {
+		t.Errorf("PayloadDigest(v2) expect SHA256 digest, got: %q, %v\n", digest, err)
+	}
+	if _, err := key.PayloadDigest("10", []byte("foo")); err == nil {
+		t.Error("PayloadDigest(10) should return error")
+	}
+}
@@ -3,12 +3,21 @@
 package test
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/spf13/viper"
 	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/httpapi"
+	_ "github.com/zhangpeihao/zim/pkg/broker/memory"
 	_ "github.com/zhangpeihao/zim/pkg/broker/mock"
 	"github.com/zhangpeihao/zim/pkg/broker/register"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
 )
 
 func TestRouter(t *testing.T) {
@@ -27,6 +36,7 @@ func TestRouter(t *testing.T) {
 		},
 		"msg": {
 			Broker: "mock",
+			Tag:    "chat",
 		},
 		"logout": {
 			Broker: "mock",
@@ -36,15 +46,21 @@ func TestRouter(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewRouter error: %s\n", err)
 	}
-	broker := r.Find("msg")
-	if broker == nil {
+	route := r.Find("msg")
+	if route == nil || route.Broker == nil {
 		t.Fatalf("Find(%s) return nil", "msg")
 	}
+	if route.Tag != "chat" {
+		t.Errorf("Find(%s) expect tag: chat, got: %s\n", "msg", route.Tag)
+	}
 
-	broker = r.Find("xxx")
-	if broker == nil {
+	route = r.Find("xxx")
+	if route == nil || route.Broker == nil {
 		t.Fatalf("Find(%s) return nil", "xxx")
 	}
+	if route.Tag != "" {
+		t.Errorf("Find(%s) expect empty tag, got: %s\n", "xxx", route.Tag)
+	}
 
 	strs := strings.Split(strings.Trim(r.String(), "\n"), "\n")
 
@@ -62,3 +78,247 @@ func TestRouter(t *testing.T) {
 		t.Errorf("TestErrorRouter should return error\n")
 	}
 }
+
+func TestRouterInstance(t *testing.T) {
+	if err := register.Init("test"); err != nil {
+		t.Fatal("register.Init() error:", err)
+	}
+
+	// 同一种Broker，不同路由使用不同参数的实例
+	r, err := app.NewRouter(app.InfoMap{
+		"msg": {
+			Broker: "memory",
+		},
+		"login": {
+			Broker:   "memory",
+			Instance: "small",
+			Options: map[string]interface{}{
+				"queue-size": 1,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRouter error: %s\n", err)
+	}
+	msgBroker, loginBroker := r.Find("msg").Broker, r.Find("login").Broker
+	if msgBroker == nil || loginBroker == nil || msgBroker == loginBroker {
+		t.Fatalf("Find() got msg: %v, login: %v\n", msgBroker, loginBroker)
+	}
+	cmd := &protocol.Command{Version: "t1", AppID: "test", Name: "msg"}
+	for i := 0; i < 2; i++ {
+		if _, err = msgBroker.Publish("instance", cmd); err != nil {
+			t.Errorf("default instance Publish() error: %s\n", err)
+		}
+	}
+	loginBroker.Publish("instance", cmd)
+	if _, err = loginBroker.Publish("instance", cmd); err != define.ErrQueueFull {
+		t.Errorf("small instance Publish() expect queue full, got: %v\n", err)
+	}
+
+	// 设置参数需要实例名
+	_, err = app.NewRouter(app.InfoMap{
+		"msg": {
+			Broker: "memory",
+			Options: map[string]interface{}{
+				"queue-size": 1,
+			},
+		},
+	})
+	if err == nil {
+		t.Errorf("NewRouter with options but no instance should return error\n")
+	}
+
+	t.Run("httpapi", testRouterInstanceHTTPAPI)
+}
+
+func TestRejectedAppKeepsInstance(t *testing.T) {
+	if err := register.Init("test"); err != nil {
+		t.Fatal("register.Init() error:", err)
+	}
+	a, err := app.ParseApp(strings.NewReader(`{"id": "a",
+		"router": {"*": {"broker": "memory", "instance": "keep", "options": {"queue-size": 10}}}}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	// 无效配置和参数冲突的配置都被拒绝，已有实例不会被关闭
+	for _, config := range []string{
+		`{"id": "a", "token-check": "bogus",
+			"router": {"*": {"broker": "memory", "instance": "keep", "options": {"queue-size": 20}}}}`,
+		`{"id": "b", "router": {"*": {"broker": "memory", "instance": "keep", "options": {"queue-size": 20}}}}`,
+	} {
+		if _, err = app.ParseApp(strings.NewReader(config)); err == nil {
+			t.Errorf("ParseApp(%s) should return error\n", config)
+		}
+	}
+	cmd := &protocol.Command{Version: "t1", AppID: "a", Name: "msg"}
+	if _, err = a.Router.Find("msg").Broker.Publish("keep", cmd); err != nil {
+		t.Error("Publish() after rejected config error:", err)
+	}
+}
+
+func testRouterInstanceHTTPAPI(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("net.Listen() error:", err)
+	}
+	bind := listener.Addr().String()
+	listener.Close()
+	viper.Set("test.httpapi.subscribe-bind", bind)
+	viper.Set("test.httpapi.request-url", "http://127.0.0.1:8880")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 同一种Broker，不同路由使用不同的请求地址，命名实例不启动订阅HTTP服务，不会与默认实例冲突
+	newRouter := func(urlA string) *app.Router {
+		r, err := app.NewRouter(app.InfoMap{
+			"push": {Broker: httpapi.Name},
+			"msg": {Broker: httpapi.Name, Instance: "a",
+				Options: map[string]interface{}{"request-url": urlA}},
+			"login": {Broker: httpapi.Name, Instance: "b",
+				Options: map[string]interface{}{"request-url": "http://127.0.0.1:8882"}},
+		})
+		if err != nil {
+			t.Fatalf("NewRouter error: %s\n", err)
+		}
+		return r
+	}
+	r := newRouter("http://127.0.0.1:8881")
+	for _, b := range r.Brokers() {
+		if err = b.Run(ctx); err != nil {
+			t.Fatalf("%s Run() error: %s\n", b, err)
+		}
+		defer b.Close(time.Second)
+	}
+	for name, expect := range map[string]string{
+		"push":  httpapi.Name + " http://127.0.0.1:8880 " + bind,
+		"msg":   httpapi.Name + ":a http://127.0.0.1:8881 " + httpapi.NoBind,
+		"login": httpapi.Name + ":b http://127.0.0.1:8882 " + httpapi.NoBind,
+	} {
+		b := r.Find(name).Broker.(*httpapi.BrokerImpl)
+		if got := b.String() + " " + b.RequestURL + " " + b.BindAddress; got != expect {
+			t.Errorf("Find(%s) expect: %s, got: %s\n", name, expect, got)
+		}
+	}
+	conn, err := net.Dial("tcp4", bind)
+	if err != nil {
+		t.Fatal("default instance subscribe not listening:", err)
+	}
+	conn.Close()
+
+	// 参数不变时使用原实例，参数不同时拒绝，原实例继续运行
+	if same := newRouter("http://127.0.0.1:8881"); same.Find("msg").Broker != r.Find("msg").Broker {
+		t.Error("same options should reuse the instance")
+	}
+	if _, err = app.NewRouter(app.InfoMap{"msg": {Broker: httpapi.Name, Instance: "a",
+		Options: map[string]interface{}{"request-url": "http://127.0.0.1:8883"}}}); err == nil {
+		t.Error("conflicting options should return error")
+	}
+	if b := r.Find("msg").Broker.(*httpapi.BrokerImpl); b.RequestURL != "http://127.0.0.1:8881" {
+		t.Errorf("instance should keep its options, got: %s\n", b.RequestURL)
+	}
+}
+
+func newMatchRouter(tb testing.TB, keys ...string) *app.Router {
+	if err := register.Init("test"); err != nil {
+		tb.Fatal("register.Init() error:", err)
+	}
+	routerMap := make(app.InfoMap)
+	for _, key := range keys {
+		routerMap[key] = app.Info{
+			Broker: "mock",
+			Tag:    key,
+		}
+	}
+	r, err := app.NewRouter(routerMap)
+	if err != nil {
+		tb.Fatalf("NewRouter error: %s\n", err)
+	}
+	return r
+}
+
+func TestRouterMatch(t *testing.T) {
+	r := newMatchRouter(t,
+		"*",
+		"login",
+		"msg",
+		"msg/foo/bar",
+		"msg/+/bar",
+		"msg/order/*",
+		"msg/chat/**",
+		"msg/+/+/baz",
+		"push/**",
+	)
+	testCases := []struct {
+		name  string
+		route string
+	}{
+		{"login", "login"},
+		{"login/sub", "login"},
+		{"msg", "msg"},
+		{"msg/foo/bar", "msg/foo/bar"},
+		{"msg/xxx/bar", "msg/+/bar"},
+		// 逐段比较，第二段字面值优先于"+"
+		{"msg/order/bar", "msg/order/*"},
+		{"msg/order/create", "msg/order/*"},
+		{"msg/order/create/now", "msg/order/*"},
+		{"msg/order", "msg"},
+		{"msg/chat", "msg/chat/**"},
+		{"msg/chat/room/1", "msg/chat/**"},
+		{"msg/xxx/yyy/baz", "msg/+/+/baz"},
+		{"msg/foo/bar/baz", "msg/foo/bar"},
+		{"msg/xxx/yyy/qux", "msg"},
+		{"msg/foo", "msg"},
+		{"push", "push/**"},
+		{"push/all", "push/**"},
+		{"logout", "*"},
+		{"", "*"},
+	}
+	for _, testCase := range testCases {
+		route := r.Find(testCase.name)
+		if route == nil {
+			t.Errorf("Find(%s) return nil\n", testCase.name)
+		} else if route.Tag != testCase.route {
+			t.Errorf("Find(%s) expect: %s, got: %s\n", testCase.name, testCase.route, route.Tag)
+		}
+	}
+
+	// 没有默认路由
+	r = newMatchRouter(t, "msg/+")
+	for _, name := range []string{"msg", "msg/foo/bar", "login"} {
+		if route := r.Find(name); route != nil {
+			t.Errorf("Find(%s) expect nil, got: %s\n", name, route)
+		}
+	}
+
+	// 通配符"*"和"**"只能作为最后一段
+	for _, key := range []string{"msg/*/bar", "**/bar"} {
+		if _, err := app.NewRouter(app.InfoMap{key: {Broker: "mock"}}); err == nil {
+			t.Errorf("NewRouter(%s) should return error\n", key)
+		}
+	}
+}
+
+func BenchmarkRouterFind(b *testing.B) {
+	keys := []string{"*", "login", "msg/+/bar", "msg/chat/**"}
+	for i := 0; i < 50; i++ {
+		keys = append(keys, fmt.Sprintf("msg/cmd%d", i), fmt.Sprintf("msg/cmd%d/*", i))
+	}
+	r := newMatchRouter(b, keys...)
+	names := map[string]string{
+		"Literal":  "login",
+		"Prefix":   "msg/cmd42",
+		"Wildcard": "msg/cmd42/foo/bar",
+		"One":      "msg/foo/bar",
+		"Any":      "msg/chat/room/1",
+		"Default":  "logout",
+	}
+	for benchmark, name := range names {
+		b.Run(benchmark, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if r.Find(name) == nil {
+					b.Fatal("Find() return nil")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+)
+
+func appConfig(id, key string) string {
+	return fmt.Sprintf(`{"id": "%s", "key": "%s", "router": {"*": {"broker": "mock"}}}`, id, key)
+}
+
+func watchController(t *testing.T, source app.AppSource) (*app.Controller, chan string, context.CancelFunc) {
+	if err := register.Init("test"); err != nil {
+		t.Fatal("register.Init() error:", err)
+	}
+	controller, err := app.NewController(nil)
+	if err != nil {
+		t.Fatal("NewController() error:", err)
+	}
+	if err = controller.AddSource(source); err != nil {
+		t.Fatal("AddSource() error:", err)
+	}
+	removed := make(chan string, 4)
+	controller.OnAppRemoved(func(a *app.App) {
+		removed <- a.ID
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go controller.Watch(ctx)
+	time.Sleep(time.Millisecond * 100)
+	return controller, removed, cancel
+}
+
+// expectApp 等待回调函数通知的App
+func expectApp(t *testing.T, ids chan string, id string) {
+	select {
+	case got := <-ids:
+		if got != id {
+			t.Errorf("app expect: %s, got: %s\n", id, got)
+		}
+	case <-time.After(time.Second * 4):
+		t.Errorf("wait %s timeout\n", id)
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zim-app")
+	if err != nil {
+		t.Fatal("TempDir() error:", err)
+	}
+	defer os.RemoveAll(dir)
+	writeAppConfig(t, filepath.Join(dir, "foo.json"), "foo", "key1")
+	// 非.json文件不加载
+	writeAppConfig(t, filepath.Join(dir, "bar.bak"), "bar", "key1")
+
+	controller, removed, cancel := watchController(t, app.NewDirSource(dir))
+	defer cancel()
+	if controller.GetApp("foo") == nil || controller.GetApp("bar") != nil {
+		t.Fatalf("Apps() got: %v\n", controller.Apps())
+	}
+	updated := make(chan string, 4)
+	controller.OnAppUpdated(func(a *app.App) {
+		updated <- a.ID
+	})
+
+	// 新增配置文件
+	writeAppConfig(t, filepath.Join(dir, "bar.json"), "bar", "key1")
+	waitFor(t, func() bool {
+		return controller.GetApp("bar") != nil
+	})
+	expectApp(t, updated, "bar")
+	// 修改配置文件
+	writeAppConfig(t, filepath.Join(dir, "foo.json"), "foo", "key2")
+	waitFor(t, func() bool {
+		return controller.GetApp("foo").Key == "key2"
+	})
+	expectApp(t, updated, "foo")
+	// 删除配置文件
+	os.Remove(filepath.Join(dir, "foo.json"))
+	expectApp(t, removed, "foo")
+	if controller.GetApp("foo") != nil || controller.GetApp("bar") == nil {
+		t.Errorf("Apps() got: %v\n", controller.Apps())
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	var locker sync.Mutex
+	body := "[" + appConfig("foo", "key1") + "," + appConfig("bar", "key1") + "]"
+	etag, notModified := 1, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locker.Lock()
+		defer locker.Unlock()
+		tag := fmt.Sprintf(`"%d"`, etag)
+		if r.Header.Get("If-None-Match") == tag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", tag)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	controller, removed, cancel := watchController(t, app.NewHTTPSource(server.URL, time.Millisecond*50))
+	defer cancel()
+	if len(controller.Apps()) != 2 {
+		t.Fatalf("Apps() got: %v\n", controller.Apps())
+	}
+	waitFor(t, func() bool {
+		locker.Lock()
+		defer locker.Unlock()
+		return notModified > 0
+	})
+
+	locker.Lock()
+	body = "[" + appConfig("foo", "key2") + "]"
+	etag++
+	locker.Unlock()
+	expectApp(t, removed, "bar")
+	if a := controller.GetApp("foo"); a == nil || a.Key != "key2" {
+		t.Errorf("GetApp(foo) got: %+v\n", a)
+	}
+}
+
+func TestKVSource(t *testing.T) {
+	kv := app.NewMemoryKV()
+	kv.Put("/zim/apps/foo", []byte(appConfig("foo", "key1")))
+	// 其他前缀不加载
+	kv.Put("/zim/other/bar", []byte(appConfig("bar", "key1")))
+
+	controller, removed, cancel := watchController(t, app.NewKVSource(kv, "/zim/apps/"))
+	defer cancel()
+	if controller.GetApp("foo") == nil || controller.GetApp("bar") != nil {
+		t.Fatalf("Apps() got: %v\n", controller.Apps())
+	}
+
+	kv.Put("/zim/apps/bar", []byte(appConfig("bar", "key1")))
+	waitFor(t, func() bool {
+		return controller.GetApp("bar") != nil
+	})
+	kv.Put("/zim/apps/foo", []byte(appConfig("foo", "key2")))
+	waitFor(t, func() bool {
+		return controller.GetApp("foo").Key == "key2"
+	})
+
+	// 非法配置不替换原配置
+	kv.Put("/zim/apps/foo", []byte("{"))
+	time.Sleep(time.Millisecond * 100)
+	if a := controller.GetApp("foo"); a == nil || a.Key != "key2" {
+		t.Errorf("invalid config should keep the old app, got: %+v\n", a)
+	}
+
+	kv.Delete("/zim/apps/foo")
+	expectApp(t, removed, "foo")
+}
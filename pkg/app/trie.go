@@ -0,0 +1,109 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Separator 信令名分隔符
+	Separator = "/"
+	// WildcardOne 匹配一段
+	WildcardOne = "+"
+	// WildcardSome 匹配一段或多段（只能作为最后一段）
+	WildcardSome = "*"
+	// WildcardAny 匹配零段或多段（只能作为最后一段）
+	WildcardAny = "**"
+)
+
+// trieNode 路由前缀树节点
+type trieNode struct {
+	// route 精确匹配的路由
+	route *Route
+	// prefix 不含通配符的路由，同时作为前缀匹配
+	prefix *Route
+	// children 字面值子节点
+	children map[string]*trieNode
+	// one "+"子节点
+	one *trieNode
+	// some 以"*"结尾的路由
+	some *Route
+	// any 以"**"结尾的路由
+	any *Route
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{
+		children: make(map[string]*trieNode),
+	}
+}
+
+// insert 添加路由
+func (n *trieNode) insert(pattern string, route *Route) error {
+	segments := strings.Split(pattern, Separator)
+	literal := true
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case WildcardSome, WildcardAny:
+			if !last {
+				return fmt.Errorf("route %s: %s must be the last segment", pattern, segment)
+			}
+			if segment == WildcardSome {
+				n.some = route
+			} else {
+				n.any = route
+			}
+			return nil
+		case WildcardOne:
+			literal = false
+			if n.one == nil {
+				n.one = newTrieNode()
+			}
+			n = n.one
+		default:
+			child, found := n.children[segment]
+			if !found {
+				child = newTrieNode()
+				n.children[segment] = child
+			}
+			n = child
+		}
+	}
+	n.route = route
+	if literal {
+		n.prefix = route
+	}
+	return nil
+}
+
+// match 查询路由
+// 每一层的优先级：字面值 > "+" > "*" > "**" > 不含通配符路由的前缀匹配，
+// 字面值和"+"匹配失败时回溯
+func (n *trieNode) match(segments []string) *Route {
+	if len(segments) == 0 {
+		if n.route != nil {
+			return n.route
+		}
+		return n.any
+	}
+	if child, found := n.children[segments[0]]; found {
+		if route := child.match(segments[1:]); route != nil {
+			return route
+		}
+	}
+	if n.one != nil {
+		if route := n.one.match(segments[1:]); route != nil {
+			return route
+		}
+	}
+	if n.some != nil {
+		return n.some
+	}
+	if n.any != nil {
+		return n.any
+	}
+	return n.prefix
+}
@@ -0,0 +1,28 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package app
+
+// WebhookAllEvents 通知所有事件
+const WebhookAllEvents = "*"
+
+// Webhook 事件通知配置，网关事件（连接、断开、登入失败等）以HTTP POST通知应用服务，
+// 请求使用应用的服务端密钥签名，签名方式与httpapi Broker相同，参看webhook包
+type Webhook struct {
+	// URL 通知地址
+	URL string `json:"url"`
+	// Events 通知的事件类型，为空或者包含"*"时通知所有事件
+	Events []string `json:"events"`
+}
+
+// Accept 是否通知事件
+func (webhook *Webhook) Accept(event string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, e := range webhook.Events {
+		if e == event || e == WebhookAllEvents {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package auth
+
+import (
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/define"
+)
+
+const (
+	// AllowName 白名单认证
+	AllowName = "allow"
+	// AllowAll 允许所有用户
+	AllowAll = "*"
+)
+
+// AllowAuthenticator 白名单认证，不检查Token，只用于开发环境
+type AllowAuthenticator struct{}
+
+// Authenticate 认证
+func (*AllowAuthenticator) Authenticate(req *Request) (*Result, error) {
+	if len(req.Login.UserID) > 0 {
+		for _, id := range req.App.AllowList {
+			if id == AllowAll || id == req.Login.UserID {
+				glog.Warningf("auth::AllowAuthenticator::Authenticate() app(%s) user(%s) allowed without token check!\n",
+					req.App.ID, req.Login.UserID)
+				return loginResult(req.Login), nil
+			}
+		}
+	}
+	glog.Warningf("auth::AllowAuthenticator::Authenticate() app(%s) user(%s) not in allow list\n",
+		req.App.ID, req.Login.UserID)
+	return nil, define.ErrNeedAuth
+}
+
+// String 认证方式名称
+func (*AllowAuthenticator) String() string {
+	return AllowName
+}
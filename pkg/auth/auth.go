@@ -0,0 +1,116 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package auth
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	// DefaultName 默认认证方式，token-check为空时使用
+	DefaultName = BrokerName
+	// LoginTimeout 登入Token有效期（单位：秒）
+	LoginTimeout = 3600
+)
+
+var (
+	// ErrRejected 应用服务拒绝登入
+	ErrRejected = errors.New("login rejected")
+	// ErrTokenVersion 不支持的Token格式版本，客户端需要升级
+	ErrTokenVersion = errors.New("unsupported token version")
+)
+
+// Request 登入认证请求
+type Request struct {
+	// App 应用
+	App *app.App
+	// Command 登入信令
+	Command *protocol.Command
+	// Login 登入信令数据
+	Login *protocol.GatewayLoginCommand
+	// Now 当前时间
+	Now time.Time
+	// Publish 发布登入信令到应用服务
+	Publish func(cmd *protocol.Command) (*protocol.Command, error)
+}
+
+// Result 登入认证结果
+type Result struct {
+	// UserID 用户ID
+	UserID string
+	// DeviceID 设备ID
+	DeviceID string
+	// Tags 用户标签，只能来自JWT Claims或者应用服务的登入响应
+	Tags []string
+	// Metadata 用户附加信息
+	Metadata map[string]string
+	// Published 认证过程中已经发布了登入信令
+	Published bool
+	// Response 应用服务的响应
+	Response *protocol.Command
+}
+
+// Authenticator 登入认证接口
+type Authenticator interface {
+	// Authenticate 认证，失败时返回错误
+	Authenticate(req *Request) (*Result, error)
+	// String 认证方式名称
+	String() string
+}
+
+var (
+	authenticators = make(map[string]Authenticator)
+	locker         sync.RWMutex
+)
+
+func init() {
+	Register(MD5Name, &MD5Authenticator{})
+	Register(MD5Alias, &MD5Authenticator{})
+	Register(HMACName, &HMACAuthenticator{})
+	Register(JWTName, &JWTAuthenticator{})
+	Register(AllowName, &AllowAuthenticator{})
+	Register(BrokerName, &BrokerAuthenticator{})
+	Register(BrokerAlias, &BrokerAuthenticator{})
+}
+
+// Register 注册认证方式，名称不区分大小写。注册后应用配置才能使用该名称
+func Register(name string, authenticator Authenticator) {
+	locker.Lock()
+	defer locker.Unlock()
+	name = strings.ToLower(name)
+	if _, found := authenticators[name]; found {
+		glog.Warningf("auth::Register() Authenticator[%s] existed\n", name)
+	}
+	authenticators[name] = authenticator
+	app.RegisterTokenCheck(name)
+}
+
+// Get 根据名称取得认证方式，名称为空时返回默认认证方式，没有找到时返回nil
+func Get(name string) Authenticator {
+	if len(name) == 0 {
+		name = DefaultName
+	}
+	locker.RLock()
+	defer locker.RUnlock()
+	return authenticators[strings.ToLower(name)]
+}
+
+// ForApp 取得应用的认证方式，没有找到时返回nil
+func ForApp(a *app.App) Authenticator {
+	return Get(a.TokenCheck)
+}
+
+// loginResult 使用登入信令中的用户ID和设备ID生成认证结果，不使用客户端设置的标签
+func loginResult(login *protocol.GatewayLoginCommand) *Result {
+	return &Result{
+		UserID:   login.UserID,
+		DeviceID: login.DeviceID,
+	}
+}
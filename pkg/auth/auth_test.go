@@ -0,0 +1,243 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/jwt"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+func newTestRequest(tokenCheck string, login *protocol.GatewayLoginCommand) *Request {
+	return &Request{
+		App: &app.App{
+			ID:         "test",
+			Keys:       []*app.Key{{ID: "k1", Secret: "client", Usage: app.KeyUsageClient}},
+			TokenCheck: tokenCheck,
+			AllowList:  []string{"dev"},
+			JWT:        &app.JWTConfig{Audience: "zim"},
+		},
+		Command: &protocol.Command{Version: "t1", AppID: "test", Name: protocol.Login, Data: login},
+		Login:   login,
+		Now:     time.Unix(10000, 0),
+	}
+}
+
+func TestGet(t *testing.T) {
+	for tokenCheck, expect := range map[string]string{
+		"yes":   MD5Name,
+		"MD5":   MD5Name,
+		"hmac":  HMACName,
+		"jwt":   JWTName,
+		"allow": AllowName,
+		"":      BrokerName,
+		"no":    BrokerName,
+	} {
+		if got := ForApp(&app.App{TokenCheck: tokenCheck}).String(); got != expect {
+			t.Errorf("ForApp(%s) expect: %s, got: %s\n", tokenCheck, expect, got)
+		}
+	}
+	if authenticator := Get("hamc"); authenticator != nil {
+		t.Errorf("Get(hamc) expect nil, got: %s\n", authenticator)
+	}
+	if _, err := app.ParseApp(strings.NewReader(`{"id": "test", "token-check": "hamc"}`)); err == nil {
+		t.Error("ParseApp() with unknown token-check expect error")
+	}
+	if _, err := app.ParseApp(strings.NewReader(`{"id": "test", "token-check": "HMAC"}`)); err != nil {
+		t.Error("ParseApp() with token-check HMAC error:", err)
+	}
+}
+
+func TestTokenAuthenticators(t *testing.T) {
+	for _, tokenCheck := range []string{MD5Name, HMACName} {
+		login := &protocol.GatewayLoginCommand{UserID: "u1", DeviceID: "web", Timestamp: 10000, KeyID: "k1",
+			Tags: []string{"admin"}}
+		calToken := login.CalToken
+		if tokenCheck == HMACName {
+			calToken = login.CalHMACToken
+		}
+		login.Token = strings.ToLower(calToken([]byte("client")))
+		req := newTestRequest(tokenCheck, login)
+		result, err := Get(tokenCheck).Authenticate(req)
+		if err != nil || result.UserID != "u1" || result.DeviceID != "web" || result.Published || len(result.Tags) > 0 {
+			t.Errorf("%s Authenticate() got: %+v, %v\n", tokenCheck, result, err)
+		}
+
+		// Token错误、密钥ID错误、超时
+		for _, modify := range []func(){
+			func() { login.Token = calToken([]byte("other")) },
+			func() { login.KeyID = "k2"; login.Token = calToken([]byte("client")) },
+			func() { login.Timestamp = 10000 - LoginTimeout - 1; login.Token = calToken([]byte("client")) },
+		} {
+			login.KeyID, login.Timestamp = "k1", 10000
+			modify()
+			if _, err = Get(tokenCheck).Authenticate(req); err != define.ErrNeedAuth {
+				t.Errorf("%s Authenticate(%+v) expect ErrNeedAuth, got: %v\n", tokenCheck, login, err)
+			}
+		}
+	}
+}
+
+func TestHMACTokenFieldBoundary(t *testing.T) {
+	// 字段直接拼接时，alice+1234与alice1+234的Token相同
+	login := &protocol.GatewayLoginCommand{UserID: "alice", DeviceID: "1234", Timestamp: 10000, KeyID: "k1"}
+	login.Token = login.CalHMACToken([]byte("client"))
+	forged := &protocol.GatewayLoginCommand{UserID: "alice1", DeviceID: "234", Timestamp: 10000, KeyID: "k1",
+		Token: login.Token}
+	if _, err := Get(HMACName).Authenticate(newTestRequest(HMACName, forged)); err != define.ErrNeedAuth {
+		t.Errorf("forged user expect ErrNeedAuth, got: %v\n", err)
+	}
+	if _, err := Get(HMACName).Authenticate(newTestRequest(HMACName, login)); err != nil {
+		t.Error("Authenticate() error:", err)
+	}
+
+	// 没有版本前缀的旧Token
+	login.Token = strings.TrimPrefix(login.Token, protocol.HMACTokenVersion+protocol.HMACTokenSeparator)
+	if _, err := Get(HMACName).Authenticate(newTestRequest(HMACName, login)); err != ErrTokenVersion {
+		t.Errorf("old token expect ErrTokenVersion, got: %v\n", err)
+	}
+}
+
+func TestPublicKeyOnly(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("ecdsa.GenerateKey() error:", err)
+	}
+	pkix, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	publicKey, _ := json.Marshal(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix})))
+	a, err := app.ParseApp(strings.NewReader(`{
+		"id": "test",
+		"jwt": {"audience": "zim"},
+		"keys": [{"id": "pk", "public-key": ` + string(publicKey) + `}]
+	}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	now := time.Unix(10000, 0)
+	if key := a.FindKey("pk", app.KeyUsageServer, now); key != nil {
+		t.Errorf("FindKey(pk) expect nil, got: %+v\n", key)
+	}
+	if key := a.SigningKey(app.KeyUsageServer, now); key != nil {
+		t.Errorf("SigningKey() expect nil, got: %+v\n", key)
+	}
+
+	// 使用空密钥伪造的Token不能登入
+	for _, tokenCheck := range []string{MD5Name, HMACName} {
+		login := &protocol.GatewayLoginCommand{UserID: "u1", DeviceID: "web", Timestamp: 10000, KeyID: "pk"}
+		login.Token = login.CalToken(nil)
+		if tokenCheck == HMACName {
+			login.Token = login.CalHMACToken(nil)
+		}
+		req := newTestRequest(tokenCheck, login)
+		req.App = a
+		if _, err = Get(tokenCheck).Authenticate(req); err != define.ErrNeedAuth {
+			t.Errorf("%s forged token expect ErrNeedAuth, got: %v\n", tokenCheck, err)
+		}
+	}
+
+	// 公钥仍然可以验证JWT
+	token, err := jwt.Sign(jwt.ES256, "pk", jwt.Claims{"sub": "u1", "aud": "zim", "exp": 20000}, ecKey)
+	if err != nil {
+		t.Fatal("jwt.Sign() error:", err)
+	}
+	req := newTestRequest(JWTName, &protocol.GatewayLoginCommand{DeviceID: "web", Token: token})
+	req.App = a
+	if result, err := Get(JWTName).Authenticate(req); err != nil || result.UserID != "u1" {
+		t.Errorf("jwt Authenticate() got: %+v, %v\n", result, err)
+	}
+
+	// 只有公钥的密钥不能用于服务端
+	if _, err = app.ParseApp(strings.NewReader(`{"id": "test", "keys": [{"id": "pk", "public-key": ` +
+		string(publicKey) + `, "usage": "server"}]}`)); err == nil {
+		t.Error("ParseApp() public key for server expect error")
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	token, err := jwt.Sign(jwt.HS256, "k1", jwt.Claims{"sub": "u1", "aud": "zim", "tags": "vip", "exp": 20000}, []byte("client"))
+	if err != nil {
+		t.Fatal("jwt.Sign() error:", err)
+	}
+	req := newTestRequest(JWTName, &protocol.GatewayLoginCommand{DeviceID: "web", Token: token})
+	result, err := Get(JWTName).Authenticate(req)
+	if err != nil || result.UserID != "u1" || result.DeviceID != "web" || strings.Join(result.Tags, ",") != "vip" {
+		t.Errorf("Authenticate() got: %+v, %v\n", result, err)
+	}
+	req.Now = time.Unix(20000, 0)
+	if _, err = Get(JWTName).Authenticate(req); err != define.ErrNeedAuth {
+		t.Errorf("expired jwt expect ErrNeedAuth, got: %v\n", err)
+	}
+
+	// 没有exp的Token永不过期，不被接受
+	req.Now = time.Unix(10000, 0)
+	req.Login.Token, _ = jwt.Sign(jwt.HS256, "k1", jwt.Claims{"sub": "u1", "aud": "zim"}, []byte("client"))
+	if _, err = Get(JWTName).Authenticate(req); err != define.ErrNeedAuth {
+		t.Errorf("jwt without exp expect ErrNeedAuth, got: %v\n", err)
+	}
+
+	// token-check为jwt时必须设置audience
+	if _, err = app.ParseApp(strings.NewReader(`{"id": "test", "token-check": "jwt"}`)); err == nil {
+		t.Error("ParseApp() jwt without audience expect error")
+	}
+}
+
+func TestAllowAuthenticator(t *testing.T) {
+	for userID, ok := range map[string]bool{"dev": true, "u1": false, "": false} {
+		req := newTestRequest(AllowName, &protocol.GatewayLoginCommand{UserID: userID, Tags: []string{"admin"}})
+		result, err := Get(AllowName).Authenticate(req)
+		if (err == nil) != ok {
+			t.Errorf("Authenticate(%s) expect ok: %t, got: %v\n", userID, ok, err)
+		}
+		if err == nil && len(result.Tags) > 0 {
+			t.Errorf("Authenticate(%s) client tags should be dropped, got: %v\n", userID, result.Tags)
+		}
+	}
+	req := newTestRequest(AllowName, &protocol.GatewayLoginCommand{UserID: "u1"})
+	req.App.AllowList = []string{AllowAll}
+	if _, err := Get(AllowName).Authenticate(req); err != nil {
+		t.Error("Authenticate() with * error:", err)
+	}
+}
+
+func TestBrokerAuthenticator(t *testing.T) {
+	testCases := []struct {
+		resp   *protocol.Command
+		err    error
+		expect error
+		tags   string
+	}{
+		{nil, nil, nil, ""},
+		{nil, errors.New("publish"), define.ErrAuthFailed, ""},
+		{&protocol.Command{Name: protocol.Close}, nil, ErrRejected, ""},
+		{&protocol.Command{Name: protocol.Login, Data: &protocol.GatewayLoginCommand{
+			UserID: "u1", Tags: []string{"vip"}, Metadata: map[string]string{"level": "3"}}}, nil, nil, "vip"},
+	}
+	for index, testCase := range testCases {
+		req := newTestRequest(BrokerName, &protocol.GatewayLoginCommand{UserID: "u1", Tags: []string{"admin"}})
+		published := 0
+		req.Publish = func(cmd *protocol.Command) (*protocol.Command, error) {
+			published++
+			return testCase.resp, testCase.err
+		}
+		result, err := Get(BrokerName).Authenticate(req)
+		if err != testCase.expect || published != 1 {
+			t.Errorf("Case(%d): Authenticate() expect: %v, got: %v, published: %d\n", index+1, testCase.expect, err, published)
+			continue
+		}
+		if err == nil && (!result.Published || strings.Join(result.Tags, ",") != testCase.tags) {
+			t.Errorf("Case(%d): Authenticate() got: %+v\n", index+1, result)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package auth
+
+import (
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	// BrokerName 委托应用服务认证
+	BrokerName = "broker"
+	// BrokerAlias 委托应用服务认证（兼容旧配置token-check: no）
+	BrokerAlias = "no"
+)
+
+// BrokerAuthenticator 委托应用服务认证
+//
+// 通过Broker发布登入信令，应用服务返回close信令时拒绝登入；
+// 应用服务返回login信令时，使用其中的标签，否则用户没有标签
+type BrokerAuthenticator struct{}
+
+// Authenticate 认证
+func (*BrokerAuthenticator) Authenticate(req *Request) (*Result, error) {
+	if req.Publish == nil {
+		return nil, define.ErrAuthFailed
+	}
+	resp, err := req.Publish(req.Command)
+	if err != nil {
+		glog.Warningf("auth::BrokerAuthenticator::Authenticate() publish error: %s\n", err)
+		return nil, define.ErrAuthFailed
+	}
+	if resp != nil && resp.Name == protocol.Close {
+		glog.Warningf("auth::BrokerAuthenticator::Authenticate() app(%s) user(%s) rejected\n",
+			req.App.ID, req.Login.UserID)
+		return nil, ErrRejected
+	}
+	result := loginResult(req.Login)
+	result.Published = true
+	result.Response = resp
+	if resp != nil && resp.Name == protocol.Login {
+		if loginCmd, ok := resp.Data.(*protocol.GatewayLoginCommand); ok && loginCmd.UserID == req.Login.UserID {
+			result.Tags = loginCmd.Tags
+			result.Metadata = loginCmd.Metadata
+			// 登入响应不再推送给用户
+			result.Response = nil
+		}
+	}
+	return result, nil
+}
+
+// String 认证方式名称
+func (*BrokerAuthenticator) String() string {
+	return BrokerName
+}
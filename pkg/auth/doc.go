@@ -0,0 +1,21 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+/*
+Package auth 网关登入认证
+
+每个应用通过token-check选择认证方式：
+
+	yes、md5 MD5 Token，Token=MD5(<client key>,UserID,DeviceID,Timestamp)
+	hmac     HMAC-SHA256 Token，Token="v2."+HMAC-SHA256(<client key>,F("v2")+F(UserID)+F(DeviceID)+F(Timestamp))，
+	         F(x)为4字节（大端）长度加上x。没有版本前缀的旧Token返回ErrTokenVersion
+	jwt      JWT，从Claims中取得用户ID、设备ID和标签
+	allow    白名单（只用于开发环境），用户ID在应用的allow-list中时允许登入，"*"允许所有用户
+	broker   委托应用服务认证：通过Broker发布登入信令，应用服务返回close信令时拒绝登入
+	no       同broker（兼容旧配置）
+
+token-check为空时使用broker，其他没有注册的值在解析应用配置时返回错误。
+除broker外，认证通过后网关仍会发布登入信令通知应用服务。
+
+用户标签只来自JWT Claims或者应用服务的登入响应，客户端在登入信令中设置的标签和附加信息会被忽略。
+*/
+package auth
@@ -0,0 +1,45 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package auth
+
+import (
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/define"
+)
+
+const (
+	// JWTName JWT认证
+	JWTName = app.TokenCheckJWT
+)
+
+// JWTAuthenticator JWT认证，用户ID、设备ID和标签从Claims中取得
+type JWTAuthenticator struct{}
+
+// Authenticate 认证
+func (*JWTAuthenticator) Authenticate(req *Request) (*Result, error) {
+	identity, err := req.App.VerifyJWT(req.Login.Token, req.Now)
+	if err != nil {
+		glog.Warningf("auth::JWTAuthenticator::Authenticate() verify jwt error: %s\n", err)
+		return nil, define.ErrNeedAuth
+	}
+	if len(req.Login.UserID) > 0 && req.Login.UserID != identity.UserID {
+		glog.Warningf("auth::JWTAuthenticator::Authenticate() user unmatch! UserID: %s, claim: %s\n",
+			req.Login.UserID, identity.UserID)
+		return nil, define.ErrNeedAuth
+	}
+	result := &Result{
+		UserID:   identity.UserID,
+		DeviceID: identity.DeviceID,
+		Tags:     identity.Tags,
+	}
+	if len(result.DeviceID) == 0 {
+		result.DeviceID = req.Login.DeviceID
+	}
+	return result, nil
+}
+
+// String 认证方式名称
+func (*JWTAuthenticator) String() string {
+	return JWTName
+}
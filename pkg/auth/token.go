@@ -0,0 +1,90 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package auth
+
+import (
+	"crypto/hmac"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	// MD5Name MD5 Token认证
+	MD5Name = "md5"
+	// MD5Alias MD5 Token认证（兼容旧配置token-check: yes）
+	MD5Alias = app.TokenCheckMD5
+	// HMACName HMAC-SHA256 Token认证
+	HMACName = "hmac"
+)
+
+// MD5Authenticator MD5 Token认证
+type MD5Authenticator struct{}
+
+// Authenticate 认证
+func (*MD5Authenticator) Authenticate(req *Request) (*Result, error) {
+	now := req.Now.Unix()
+	if req.Login.Timestamp+LoginTimeout < now {
+		glog.Warningf("auth::MD5Authenticator::Authenticate() login timeout! Timestamp: %d, LoginTimeout: %d, now: %d\n",
+			req.Login.Timestamp, LoginTimeout, now)
+		return nil, define.ErrNeedAuth
+	}
+	key := req.App.FindKey(req.Login.KeyID, app.KeyUsageClient, req.Now)
+	if key == nil {
+		glog.Warningf("auth::MD5Authenticator::Authenticate() no valid client key(%s) for app(%s)\n",
+			req.Login.KeyID, req.App.ID)
+		return nil, define.ErrNeedAuth
+	}
+	token := req.Login.CalToken(key.SecretBytes())
+	if token != strings.ToUpper(req.Login.Token) {
+		glog.Warningf("auth::MD5Authenticator::Authenticate() token unmatch! Token: %s, expect: %s\n",
+			req.Login.Token, token)
+		return nil, define.ErrNeedAuth
+	}
+	return loginResult(req.Login), nil
+}
+
+// String 认证方式名称
+func (*MD5Authenticator) String() string {
+	return MD5Name
+}
+
+// HMACAuthenticator HMAC-SHA256 Token认证，Timestamp前后LoginTimeout内有效。
+// Token版本不是protocol.HMACTokenVersion时返回ErrTokenVersion
+type HMACAuthenticator struct{}
+
+// Authenticate 认证
+func (*HMACAuthenticator) Authenticate(req *Request) (*Result, error) {
+	now := req.Now.Unix()
+	if req.Login.Timestamp+LoginTimeout < now || req.Login.Timestamp-LoginTimeout > now {
+		glog.Warningf("auth::HMACAuthenticator::Authenticate() login timeout! Timestamp: %d, LoginTimeout: %d, now: %d\n",
+			req.Login.Timestamp, LoginTimeout, now)
+		return nil, define.ErrNeedAuth
+	}
+	key := req.App.FindKey(req.Login.KeyID, app.KeyUsageClient, req.Now)
+	if key == nil {
+		glog.Warningf("auth::HMACAuthenticator::Authenticate() no valid client key(%s) for app(%s)\n",
+			req.Login.KeyID, req.App.ID)
+		return nil, define.ErrNeedAuth
+	}
+	version := strings.SplitN(req.Login.Token, protocol.HMACTokenSeparator, 2)[0]
+	if len(version) == len(req.Login.Token) || strings.ToLower(version) != protocol.HMACTokenVersion {
+		glog.Warningf("auth::HMACAuthenticator::Authenticate() app(%s) user(%s) unsupported token version, expect: %s\n",
+			req.App.ID, req.Login.UserID, protocol.HMACTokenVersion)
+		return nil, ErrTokenVersion
+	}
+	token := strings.ToUpper(req.Login.CalHMACToken(key.SecretBytes()))
+	if !hmac.Equal([]byte(token), []byte(strings.ToUpper(req.Login.Token))) {
+		glog.Warningf("auth::HMACAuthenticator::Authenticate() token unmatch! Token: %s\n", req.Login.Token)
+		return nil, define.ErrNeedAuth
+	}
+	return loginResult(req.Login), nil
+}
+
+// String 认证方式名称
+func (*HMACAuthenticator) String() string {
+	return HMACName
+}
@@ -0,0 +1,275 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package boltdb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	// Name 调用类型的名称
+	Name = "boltdb"
+	// DefaultPath 默认数据库文件路径
+	DefaultPath = "./zim-broker.db"
+	// DefaultMaxRetry 默认最大投递次数
+	DefaultMaxRetry = 5
+	// DefaultBackoff 默认第一次重试等待时间（单位：毫秒）
+	DefaultBackoff = 100
+	// DefaultMaxBackoff 默认重试等待时间上限（单位：毫秒）
+	DefaultMaxBackoff = 10000
+	// OpenTimeout 打开数据库文件锁超时时间
+	OpenTimeout = time.Second
+)
+
+var (
+	// queueBucketPrefix 消息桶前缀
+	queueBucketPrefix = []byte("queue/")
+	// deadBucketPrefix 死信桶前缀
+	deadBucketPrefix = []byte("dead/")
+	// offsetBucket 消费位置桶
+	offsetBucket = []byte("offset")
+)
+
+// BrokerImpl BoltDB实现的Broker
+type BrokerImpl struct {
+	sync.Mutex
+	// Path 数据库文件路径
+	Path string
+	// MaxRetry 最大投递次数
+	MaxRetry int
+	// Backoff 第一次重试等待时间
+	Backoff time.Duration
+	// MaxBackoff 重试等待时间上限
+	MaxBackoff time.Duration
+	// db 数据库
+	db *bolt.DB
+	// ctx 上下文接口
+	ctx context.Context
+	// closing 关闭信号
+	closing chan struct{}
+	// signals 新消息通知
+	signals map[string]chan struct{}
+	// consumers 每个tag的消费锁，保证顺序投递
+	consumers map[string]*sync.Mutex
+}
+
+// record 保存在数据库中的命令
+type record struct {
+	Version string          `json:"version"`
+	AppID   string          `json:"appid"`
+	Name    string          `json:"name"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Payload []byte          `json:"payload,omitempty"`
+	// Attempts 已投递次数
+	Attempts int `json:"attempts,omitempty"`
+	// LastError 最后一次处理错误
+	LastError string `json:"last-error,omitempty"`
+}
+
+func init() {
+	register.Register(Name, NewBoltDBBroker)
+}
+
+// NewBoltDBBroker 新建服务，数据库文件在Run时打开
+func NewBoltDBBroker(viperPerfix string) (broker.Broker, error) {
+	glog.Infoln("broker::boltdb::NewBoltDBBroker")
+	b := &BrokerImpl{
+		Path:       viper.GetString(viperPerfix + ".boltdb.path"),
+		MaxRetry:   viper.GetInt(viperPerfix + ".boltdb.max-retry"),
+		Backoff:    time.Duration(viper.GetInt(viperPerfix+".boltdb.backoff")) * time.Millisecond,
+		MaxBackoff: time.Duration(viper.GetInt(viperPerfix+".boltdb.max-backoff")) * time.Millisecond,
+		closing:    make(chan struct{}),
+		signals:    make(map[string]chan struct{}),
+		consumers:  make(map[string]*sync.Mutex),
+	}
+	if len(b.Path) == 0 {
+		b.Path = DefaultPath
+	}
+	if b.MaxRetry <= 0 {
+		b.MaxRetry = DefaultMaxRetry
+	}
+	if b.Backoff <= 0 {
+		b.Backoff = DefaultBackoff * time.Millisecond
+	}
+	if b.MaxBackoff < b.Backoff {
+		b.MaxBackoff = DefaultMaxBackoff * time.Millisecond
+		if b.MaxBackoff < b.Backoff {
+			b.MaxBackoff = b.Backoff
+		}
+	}
+	return b, nil
+}
+
+// Run 运行，打开数据库
+func (b *BrokerImpl) Run(ctx context.Context) (err error) {
+	glog.Infof("broker::boltdb::Run() path: %s\n", b.Path)
+	b.Lock()
+	defer b.Unlock()
+	b.ctx = ctx
+	if b.db != nil {
+		return nil
+	}
+	b.db, err = bolt.Open(b.Path, 0600, &bolt.Options{Timeout: OpenTimeout})
+	if err != nil {
+		glog.Errorf("broker::boltdb::Run() open(%s) error: %s\n", b.Path, err)
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(offsetBucket)
+		return err
+	})
+}
+
+// Close 关闭
+func (b *BrokerImpl) Close(timeout time.Duration) (err error) {
+	glog.Warningln("broker::boltdb::Close()")
+	defer glog.Warningln("broker::boltdb::Close() Done")
+	b.Lock()
+	select {
+	case <-b.closing:
+	default:
+		close(b.closing)
+	}
+	db := b.db
+	b.Unlock()
+	if db == nil {
+		return nil
+	}
+
+	// 等待正在处理消息的订阅者退出
+	done := make(chan struct{})
+	go func() {
+		b.Lock()
+		consumers := make([]*sync.Mutex, 0, len(b.consumers))
+		for _, consumer := range b.consumers {
+			consumers = append(consumers, consumer)
+		}
+		b.Unlock()
+		for _, consumer := range consumers {
+			consumer.Lock()
+			consumer.Unlock()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		glog.Warningln("broker::boltdb::Close() wait consumers timeout")
+	}
+	return db.Close()
+}
+
+// String 发布
+func (b *BrokerImpl) String() string {
+	return Name
+}
+
+// database 获取已打开的数据库
+func (b *BrokerImpl) database() (*bolt.DB, error) {
+	b.Lock()
+	defer b.Unlock()
+	select {
+	case <-b.closing:
+		return nil, define.ErrConnectionClosed
+	default:
+	}
+	if b.db == nil {
+		return nil, define.ErrConnectionClosed
+	}
+	return b.db, nil
+}
+
+// signal 获取tag的新消息通知
+func (b *BrokerImpl) signal(tag string) chan struct{} {
+	b.Lock()
+	defer b.Unlock()
+	signal, found := b.signals[tag]
+	if !found {
+		signal = make(chan struct{}, 1)
+		b.signals[tag] = signal
+	}
+	return signal
+}
+
+// consumer 获取tag的消费锁
+func (b *BrokerImpl) consumer(tag string) *sync.Mutex {
+	b.Lock()
+	defer b.Unlock()
+	consumer, found := b.consumers[tag]
+	if !found {
+		consumer = new(sync.Mutex)
+		b.consumers[tag] = consumer
+	}
+	return consumer
+}
+
+func queueBucket(tag string) []byte {
+	return append(append([]byte{}, queueBucketPrefix...), tag...)
+}
+
+func deadBucket(tag string) []byte {
+	return append(append([]byte{}, deadBucketPrefix...), tag...)
+}
+
+func itob(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf
+}
+
+func btoi(buf []byte) uint64 {
+	if len(buf) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(buf)
+}
+
+// encodeRecord 编码命令
+func encodeRecord(cmd *protocol.Command) ([]byte, error) {
+	r := record{
+		Version: cmd.Version,
+		AppID:   cmd.AppID,
+		Name:    cmd.Name,
+		Payload: cmd.Payload,
+	}
+	if cmd.Data != nil {
+		data, err := json.Marshal(cmd.Data)
+		if err != nil {
+			return nil, err
+		}
+		r.Data = data
+	}
+	return json.Marshal(&r)
+}
+
+// decodeRecord 解码命令
+func decodeRecord(value []byte) (r *record, cmd *protocol.Command, err error) {
+	r = new(record)
+	if err = json.Unmarshal(value, r); err != nil {
+		return nil, nil, err
+	}
+	cmd = &protocol.Command{
+		Version: r.Version,
+		AppID:   r.AppID,
+		Name:    r.Name,
+		Payload: r.Payload,
+	}
+	if len(r.Data) > 0 {
+		if err = cmd.ParseData(r.Data); err != nil {
+			return r, nil, err
+		}
+	}
+	return r, cmd, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package boltdb
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/brokertest"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	viperPerfix = "test"
+)
+
+func init() {
+	flag.Set("v", "4")
+	flag.Set("logtostderr", "true")
+
+	viper.Set(viperPerfix+".boltdb.max-retry", 3)
+	viper.Set(viperPerfix+".boltdb.backoff", 10)
+	viper.Set(viperPerfix+".boltdb.max-backoff", 20)
+}
+
+func newTestBroker(t *testing.T, dir string) broker.Broker {
+	viper.Set(viperPerfix+".boltdb.path", filepath.Join(dir, "broker.db"))
+	b, err := NewBoltDBBroker(viperPerfix)
+	if err != nil {
+		t.Fatal("NewBoltDBBroker() error:", err)
+	}
+	if err = b.Run(nil); err != nil {
+		t.Fatal("b.Run() error:", err)
+	}
+	return b
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "zim-boltdb")
+	if err != nil {
+		t.Fatal("TempDir() error:", err)
+	}
+	return dir
+}
+
+func TestContract(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) (broker.Broker, func()) {
+		dir := tempDir(t)
+		b := newTestBroker(t, dir)
+		if b.String() != Name {
+			t.Errorf("b.String: %s\n", b.String())
+		}
+		return b, func() { os.RemoveAll(dir) }
+	})
+}
+
+func TestRedeliverAndDeadLetter(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestBroker(t, dir)
+	defer b.Close(time.Second)
+
+	b.Publish("retry", brokertest.NewCommand(1))
+	b.Publish("retry", brokertest.NewCommand(2))
+
+	signal := make(chan *protocol.Command, 16)
+	go b.Subscribe("retry", func(tag string, cmd *protocol.Command) error {
+		signal <- cmd
+		if string(cmd.Payload) == "foo bar 1" {
+			return errors.New("poison")
+		}
+		return nil
+	})
+
+	// 第一条消息投递max-retry次后进入死信，之后投递第二条
+	for i := 0; i < 3; i++ {
+		if got := brokertest.Receive(t, signal); string(got.Payload) != "foo bar 1" {
+			t.Fatalf("attempt %d expect foo bar 1, got: %s\n", i+1, got.Payload)
+		}
+	}
+	if got := brokertest.Receive(t, signal); string(got.Payload) != "foo bar 2" {
+		t.Fatalf("expect foo bar 2, got: %s\n", got.Payload)
+	}
+
+	dead, err := b.(*BrokerImpl).Dead("retry")
+	if err != nil {
+		t.Fatal("Dead() error:", err)
+	}
+	if len(dead) != 1 || !brokertest.NewCommand(1).Equal(dead[0]) {
+		t.Errorf("Dead() got: %v\n", dead)
+	}
+}
+
+func TestOffsetSurviveRestart(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestBroker(t, dir)
+	for i := 1; i <= 3; i++ {
+		b.Publish("restart", brokertest.NewCommand(i))
+	}
+
+	signal := make(chan *protocol.Command, 16)
+	release := make(chan struct{})
+	go b.Subscribe("restart", func(tag string, cmd *protocol.Command) error {
+		signal <- cmd
+		if string(cmd.Payload) == "foo bar 2" {
+			// 模拟重启前未能处理的消息
+			<-release
+			return errors.New("restart")
+		}
+		return nil
+	})
+	brokertest.Receive(t, signal)
+	brokertest.Receive(t, signal)
+	closed := make(chan struct{})
+	go func() {
+		b.Close(time.Second * 4)
+		close(closed)
+	}()
+	<-b.(*BrokerImpl).closing
+	close(release)
+	<-closed
+
+	b = newTestBroker(t, dir)
+	defer b.Close(time.Second)
+	go b.Subscribe("restart", func(tag string, cmd *protocol.Command) error {
+		signal <- cmd
+		return nil
+	})
+	for i := 2; i <= 3; i++ {
+		if got := brokertest.Receive(t, signal); string(got.Payload) != fmt.Sprintf("foo bar %d", i) {
+			t.Errorf("expect foo bar %d, got: %s\n", i, got.Payload)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package boltdb
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/shutdown"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	// PollInterval 没有新消息通知时，检查队列的间隔时间
+	PollInterval = time.Second
+)
+
+// Subscribe 订阅，阻塞直到Broker关闭
+func (b *BrokerImpl) Subscribe(tag string, handler broker.SubscribeHandler) error {
+	glog.Infof("broker::boltdb::Subscribe(%s)\n", tag)
+	defer glog.Infof("broker::boltdb::Subscribe(%s) done\n", tag)
+	var ctxDone <-chan struct{}
+	if b.ctx != nil {
+		if err := shutdown.ExitWaitGroupAdd(b.ctx, 1); err != nil {
+			glog.Errorf("broker::boltdb::Subscribe(%s) ExitWaitGroupAdd error: %s\n", tag, err)
+			return err
+		}
+		defer shutdown.ExitWaitGroupDone(b.ctx)
+		ctxDone = b.ctx.Done()
+	}
+	signal := b.signal(tag)
+	for {
+		delivered, err := b.deliver(tag, handler)
+		if err == define.ErrConnectionClosed {
+			return nil
+		}
+		if err != nil {
+			glog.Errorf("broker::boltdb::Subscribe(%s) error: %s\n", tag, err)
+			return err
+		}
+		if delivered {
+			continue
+		}
+		select {
+		case <-signal:
+		case <-time.After(PollInterval):
+		case <-b.closing:
+			return nil
+		case <-ctxDone:
+			glog.Infof("broker::boltdb::Subscribe(%s) break by context\n", tag)
+			return nil
+		}
+	}
+}
+
+// Dead 取出tag死信桶中的命令
+func (b *BrokerImpl) Dead(tag string) (cmds []*protocol.Command, err error) {
+	db, err := b.database()
+	if err != nil {
+		return nil, err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deadBucket(tag))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if _, cmd, err := decodeRecord(v); err == nil {
+				cmds = append(cmds, cmd)
+			}
+			return nil
+		})
+	})
+	return
+}
+
+// deliver 投递下一条消息，没有消息时返回false
+func (b *BrokerImpl) deliver(tag string, handler broker.SubscribeHandler) (bool, error) {
+	consumer := b.consumer(tag)
+	consumer.Lock()
+	defer consumer.Unlock()
+
+	db, err := b.database()
+	if err != nil {
+		return false, err
+	}
+
+	// 读取消费位置后的第一条消息
+	var key, value []byte
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(queueBucket(tag))
+		if bucket == nil {
+			return nil
+		}
+		offset := btoi(tx.Bucket(offsetBucket).Get([]byte(tag)))
+		k, v := bucket.Cursor().Seek(itob(offset + 1))
+		if k != nil {
+			key = append([]byte{}, k...)
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil || key == nil {
+		return false, err
+	}
+
+	r, cmd, err := decodeRecord(value)
+	if err != nil {
+		glog.Errorf("broker::boltdb::deliver(%s) decode message %d error: %s, move to dead letter\n",
+			tag, btoi(key), err)
+		return true, b.moveToDead(tag, key, value)
+	}
+
+	if err = broker.Handle(handler, tag, cmd); err == nil {
+		return true, b.ack(tag, key)
+	}
+
+	r.Attempts++
+	r.LastError = err.Error()
+	if r.Attempts >= b.MaxRetry {
+		glog.Warningf("broker::boltdb::deliver(%s) message %d failed %d times, move to dead letter: %s\n",
+			tag, btoi(key), r.Attempts, err)
+	} else {
+		glog.Warningf("broker::boltdb::deliver(%s) message %d attempt %d error: %s\n",
+			tag, btoi(key), r.Attempts, err)
+	}
+	if value, err = json.Marshal(r); err != nil {
+		return false, err
+	}
+	if r.Attempts >= b.MaxRetry {
+		return true, b.moveToDead(tag, key, value)
+	}
+	if err = b.update(tag, key, value); err != nil {
+		return false, err
+	}
+	select {
+	case <-time.After(b.backoff(r.Attempts)):
+	case <-b.closing:
+	}
+	return true, nil
+}
+
+// backoff 第attempts次失败后的重试等待时间
+func (b *BrokerImpl) backoff(attempts int) time.Duration {
+	d := b.Backoff
+	for i := 1; i < attempts && d < b.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > b.MaxBackoff {
+		d = b.MaxBackoff
+	}
+	return d
+}
+
+// ack 删除已处理的消息，并记录消费位置
+func (b *BrokerImpl) ack(tag string, key []byte) error {
+	db, err := b.database()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(queueBucket(tag)).Delete(key); err != nil {
+			return err
+		}
+		return tx.Bucket(offsetBucket).Put([]byte(tag), key)
+	})
+}
+
+// update 更新消息投递信息
+func (b *BrokerImpl) update(tag string, key, value []byte) error {
+	db, err := b.database()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket(tag)).Put(key, value)
+	})
+}
+
+// moveToDead 将消息移入死信桶，并记录消费位置
+func (b *BrokerImpl) moveToDead(tag string, key, value []byte) error {
+	db, err := b.database()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		dead, err := tx.CreateBucketIfNotExists(deadBucket(tag))
+		if err != nil {
+			return err
+		}
+		if err = dead.Put(key, value); err != nil {
+			return err
+		}
+		if err = tx.Bucket(queueBucket(tag)).Delete(key); err != nil {
+			return err
+		}
+		return tx.Bucket(offsetBucket).Put([]byte(tag), key)
+	})
+}
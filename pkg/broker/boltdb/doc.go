@@ -0,0 +1,24 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+/*
+Package boltdb 基于BoltDB的持久化Broker，提供至少一次（at-least-once）投递
+
+Publish的命令按tag保存在"queue/<tag>"桶中，key为递增序号。
+
+Subscribe按序号顺序投递，SubscribeHandler返回nil后删除消息并记录消费位置（保存在"offset"桶中），
+网关重启后从记录的位置继续消费；返回error或panic时，按指数退避等待后重新投递，
+投递次数达到max-retry的消息移入死信桶"dead/<tag>"，不再投递。
+
+同一个tag的多个订阅者依次处理消息，保证消息有序。
+
+配置（viper参数前缀 + ".boltdb."）：
+
+* path: 数据库文件路径，默认"./zim-broker.db"
+
+* max-retry: 最大投递次数，默认5
+
+* backoff: 第一次重试的等待时间（单位：毫秒），之后每次翻倍，默认100
+
+* max-backoff: 重试等待时间上限（单位：毫秒），默认10000
+*/
+package boltdb
@@ -0,0 +1,46 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package boltdb
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// Publish 发布，命令写入数据库后返回，没有响应信令
+func (b *BrokerImpl) Publish(tag string, cmd *protocol.Command) (*protocol.Command, error) {
+	glog.Infof("broker::boltdb::Publish(%s)%s\n", tag, cmd)
+	db, err := b.database()
+	if err != nil {
+		glog.Warningf("broker::boltdb::Publish(%s) error: %s\n", tag, err)
+		return nil, err
+	}
+	value, err := encodeRecord(cmd)
+	if err != nil {
+		glog.Warningf("broker::boltdb::Publish(%s) encode error: %s\n", tag, err)
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(queueBucket(tag))
+		if err != nil {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(seq), value)
+	})
+	if err != nil {
+		glog.Errorf("broker::boltdb::Publish(%s) db error: %s\n", tag, err)
+		return nil, err
+	}
+
+	// 通知订阅者
+	select {
+	case b.signal(tag) <- struct{}{}:
+	default:
+	}
+	return nil, nil
+}
@@ -5,11 +5,14 @@ package broker
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/zhangpeihao/zim/pkg/define"
 	"github.com/zhangpeihao/zim/pkg/protocol"
+	"github.com/zhangpeihao/zim/pkg/util"
 )
 
 // Broker 异步消息接口
@@ -26,17 +29,50 @@ type Broker interface {
 // SubscribeHandler 订阅消息处理函数，返回error，消息将保留在队列中
 type SubscribeHandler func(tag string, cmd *protocol.Command) error
 
+// Handle 调用订阅处理函数，处理函数panic时返回ErrHandlerPanic，视为处理失败
+func Handle(handler SubscribeHandler, tag string, cmd *protocol.Command) (err error) {
+	err = ErrHandlerPanic
+	defer util.RecoverFromPanic()
+	return handler(tag, cmd)
+}
+
+var (
+	// ErrHandlerPanic 订阅处理函数panic
+	ErrHandlerPanic = errors.New("subscribe handler panic")
+)
+
 var (
 	brokers = make(map[string]Broker)
+	// runContext Run的上下文，Run之后添加的Broker立即运行
+	runContext context.Context
+	locker     sync.Mutex
 )
 
-// Set 获取Broker
+// Set 设置Broker
 func Set(name string, broker Broker) {
+	locker.Lock()
+	defer locker.Unlock()
 	brokers[name] = broker
 }
 
+// Add 添加Broker，如果已经调用过Run，立即运行新添加的Broker
+func Add(name string, broker Broker) error {
+	locker.Lock()
+	defer locker.Unlock()
+	if runContext != nil {
+		if err := broker.Run(runContext); err != nil {
+			glog.Errorf("broker::Add(%s) Run error: %s\n", name, err)
+			return err
+		}
+	}
+	brokers[name] = broker
+	return nil
+}
+
 // Get 获取Broker
 func Get(name string) Broker {
+	locker.Lock()
+	defer locker.Unlock()
 	if broker, found := brokers[name]; found {
 		return broker
 	}
@@ -47,6 +83,9 @@ func Get(name string) Broker {
 func Run(ctx context.Context) (err error) {
 	glog.Infoln("broker::define::Run()")
 	defer glog.Infoln("broker::define::Run() done")
+	locker.Lock()
+	defer locker.Unlock()
+	runContext = ctx
 	for _, broker := range brokers {
 		if err = broker.Run(ctx); err != nil {
 			glog.Errorln("broker::Run() error:", err)
@@ -58,6 +97,8 @@ func Run(ctx context.Context) (err error) {
 
 // Close 关闭
 func Close(timeout time.Duration) error {
+	locker.Lock()
+	defer locker.Unlock()
 	var err error
 	for _, broker := range brokers {
 		closeErr := broker.Close(timeout)
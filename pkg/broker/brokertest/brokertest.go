@@ -0,0 +1,126 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+// Package brokertest Broker的公共测试，各个Broker的测试调用Run检查发布订阅、处理失败和panic后重新投递
+package brokertest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	// Tag 公共测试使用的tag
+	Tag = "contract"
+	// Timeout 等待消息的超时时间
+	Timeout = time.Second * 4
+	// QuietPeriod 检查没有重复投递的等待时间
+	QuietPeriod = time.Millisecond * 300
+)
+
+// NewBroker 新建测试使用的Broker，返回的cleanup在关闭Broker后调用，可以为nil
+type NewBroker func(t *testing.T) (b broker.Broker, cleanup func())
+
+// NewCommand 新建第i条测试消息
+func NewCommand(i int) *protocol.Command {
+	return &protocol.Command{
+		Version: "t1",
+		AppID:   "test",
+		Name:    "msg/foo/bar",
+		Data:    &protocol.GatewayMessageCommand{UserID: fmt.Sprintf("%d", i)},
+		Payload: []byte(fmt.Sprintf("foo bar %d", i)),
+	}
+}
+
+// Receive 等待并返回一条消息，超时时测试失败
+func Receive(t *testing.T, signal chan *protocol.Command) *protocol.Command {
+	select {
+	case cmd := <-signal:
+		return cmd
+	case <-time.After(Timeout):
+		t.Fatal("wait command timeout")
+	}
+	return nil
+}
+
+// Run 运行公共测试，每个用例使用newBroker新建的Broker
+func Run(t *testing.T, newBroker NewBroker) {
+	testCases := []struct {
+		name string
+		test func(t *testing.T, b broker.Broker)
+	}{
+		{"PublishSubscribe", testPublishSubscribe},
+		{"NackRequeue", func(t *testing.T, b broker.Broker) {
+			testRequeue(t, b, func() error { return errors.New("nack") })
+		}},
+		{"PanicRequeue", func(t *testing.T, b broker.Broker) {
+			testRequeue(t, b, func() error { panic("handler panic") })
+		}},
+	}
+	for _, testCase := range testCases {
+		test := testCase.test
+		t.Run(testCase.name, func(t *testing.T) {
+			b, cleanup := newBroker(t)
+			if cleanup != nil {
+				defer cleanup()
+			}
+			defer b.Close(time.Second)
+			test(t, b)
+		})
+	}
+}
+
+// testPublishSubscribe 订阅前和订阅后发布的消息都能收到
+func testPublishSubscribe(t *testing.T, b broker.Broker) {
+	cmd := NewCommand(1)
+	if resp, err := b.Publish(Tag, cmd); err != nil || resp != nil {
+		t.Fatalf("b.Publish() got: %v, %v\n", resp, err)
+	}
+	signal := make(chan *protocol.Command, 16)
+	go b.Subscribe(Tag, func(tag string, got *protocol.Command) error {
+		signal <- got
+		return nil
+	})
+	if got := Receive(t, signal); !cmd.Equal(got) {
+		t.Errorf("Subscribe expect: %s, got: %s\n", cmd, got)
+	}
+
+	cmd = NewCommand(2)
+	if _, err := b.Publish(Tag, cmd); err != nil {
+		t.Fatal("b.Publish() error:", err)
+	}
+	if got := Receive(t, signal); !cmd.Equal(got) {
+		t.Errorf("Subscribe expect: %s, got: %s\n", cmd, got)
+	}
+}
+
+// testRequeue 第一次处理调用fail（返回错误或者panic），消息重新投递，处理成功后不再投递
+func testRequeue(t *testing.T, b broker.Broker, fail func() error) {
+	cmd := NewCommand(1)
+	if _, err := b.Publish(Tag, cmd); err != nil {
+		t.Fatal("b.Publish() error:", err)
+	}
+	signal := make(chan *protocol.Command, 16)
+	attempts := 0
+	go b.Subscribe(Tag, func(tag string, got *protocol.Command) error {
+		signal <- got
+		if attempts++; attempts == 1 {
+			return fail()
+		}
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		if got := Receive(t, signal); !cmd.Equal(got) {
+			t.Errorf("attempt %d expect: %s, got: %s\n", i+1, cmd, got)
+		}
+	}
+	select {
+	case got := <-signal:
+		t.Errorf("command should not be delivered again: %s\n", got)
+	case <-time.After(QuietPeriod):
+	}
+}
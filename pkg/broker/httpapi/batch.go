@@ -0,0 +1,197 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	// BatchName 批量请求的信令名（Zim-Name）
+	BatchName = "batch"
+	// BatchPath 批量请求URL的path后缀，计算CheckSum使用的tag为"<tag>/batch"
+	BatchPath = "/" + BatchName
+	// DefaultMaxBatchSize 默认批量请求的最大命令数
+	DefaultMaxBatchSize = 1000
+)
+
+// BatchItem 批量请求中的一个命令，AppID使用请求的AppID
+type BatchItem struct {
+	// Name 信令名
+	Name string `json:"name"`
+	// Data 信令Data，JSON对象或者JSON字符串（与Zim-Data相同）
+	Data json.RawMessage `json:"data,omitempty"`
+	// Payload 信令内容
+	Payload string `json:"payload,omitempty"`
+}
+
+// BatchResult 批量请求中一个命令的处理结果
+type BatchResult struct {
+	// Index 命令在请求中的序号，从0开始
+	Index int `json:"index"`
+	// Code 错误码，成功时为200
+	Code int `json:"code"`
+	// Message 错误信息
+	Message string `json:"message,omitempty"`
+	// Detail 详细信息
+	Detail string `json:"detail,omitempty"`
+}
+
+// BatchResponse 批量请求的响应
+type BatchResponse struct {
+	// Accepted 成功的命令数
+	Accepted int `json:"accepted"`
+	// Rejected 失败的命令数
+	Rejected int `json:"rejected"`
+	// Results 每个命令的处理结果，与请求中的命令顺序相同
+	Results []*BatchResult `json:"results"`
+}
+
+// add 记录命令的处理结果
+func (resp *BatchResponse) add(index int, err *Error) {
+	result := &BatchResult{Index: index, Code: CodeOK}
+	if err != nil {
+		result.Code, result.Message, result.Detail = err.Code, err.Message, err.Detail
+		resp.Rejected++
+	} else {
+		resp.Accepted++
+	}
+	resp.Results = append(resp.Results, result)
+}
+
+// DecodeBatch 解析批量请求的内容，支持JSON数组和NDJSON（每行一个JSON对象）
+func DecodeBatch(payload []byte) (items []*BatchItem, err error) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) > 0 && payload[0] == '[' {
+		err = json.Unmarshal(payload, &items)
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	for {
+		var item BatchItem
+		if err = decoder.Decode(&item); err == io.EOF {
+			return items, nil
+		} else if err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+}
+
+// ParseBatchItem 从批量请求的命令生成命令对象，检查规则与ParseCommand中的信令名和Data相同
+func ParseBatchItem(appid string, item *BatchItem) (*protocol.Command, *Error) {
+	if len(item.Name) == 0 {
+		return nil, &Error{Code: CodeInvalidParameter, Message: ErrInvalidParameter.Message, Detail: "name"}
+	}
+	cmd := &protocol.Command{
+		AppID:   appid,
+		Name:    item.Name,
+		Payload: []byte(item.Payload),
+	}
+	data := []byte(item.Data)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, &Error{Code: CodeInvalidParameter, Message: ErrInvalidParameter.Message, Detail: "data"}
+		}
+		data = []byte(s)
+	}
+	if len(data) > 0 && string(data) != "null" {
+		if err := cmd.ParseData(data); err != nil {
+			glog.Warningf("broker::httpapi::ParseBatchItem() ParseData(%s) error %s\n", data, err)
+			return nil, &Error{Code: CodeInvalidParameter, Message: ErrInvalidParameter.Message, Detail: "data"}
+		}
+	}
+	return cmd, nil
+}
+
+// ComposeBatch 生成批量请求，所有命令必须属于同一个应用，返回请求内容
+func ComposeBatch(ctx context.Context, tag string, header http.Header,
+	cmds []*protocol.Command) (payload []byte, err error) {
+	if len(cmds) == 0 {
+		return nil, ErrInvalidParameter
+	}
+	items := make([]*BatchItem, len(cmds))
+	for index, cmd := range cmds {
+		if cmd.AppID != cmds[0].AppID {
+			glog.Warningf("broker::httpapi::ComposeBatch() command %d app(%s) differ from app(%s)\n",
+				index, cmd.AppID, cmds[0].AppID)
+			return nil, ErrInvalidParameter
+		}
+		item := &BatchItem{Name: cmd.Name, Payload: string(cmd.Payload)}
+		if cmd.Data != nil {
+			if item.Data, err = json.Marshal(cmd.Data); err != nil {
+				return nil, err
+			}
+		}
+		items[index] = item
+	}
+	if payload, err = json.Marshal(items); err != nil {
+		return nil, err
+	}
+	err = ComposeCommand(ctx, tag+BatchPath, header, &protocol.Command{
+		AppID:   cmds[0].AppID,
+		Name:    BatchName,
+		Payload: payload,
+	})
+	return payload, err
+}
+
+// serveBatch 处理批量请求，请求签名检查与单个命令相同，每个命令单独检查并放入消息队列
+func (b *BrokerImpl) serveBatch(w http.ResponseWriter, tag string, header http.Header, payload []byte) {
+	b.Lock()
+	queue, ok := b.queues[tag]
+	b.Unlock()
+	if !ok {
+		glog.Warningln("broker::httpapi::serveBatch() no tag(", tag, ")")
+		WriteError(w, ErrNotFound)
+		return
+	}
+	batch, err := ParseCommand(b.ctx, tag+BatchPath, header, payload, b.timeout, b.nonces)
+	if err != nil {
+		glog.Warningf("broker::httpapi::serveBatch() ParseCommand error: %s\n", err)
+		WriteError(w, err)
+		return
+	}
+	if batch.Name != BatchName {
+		glog.Warningf("broker::httpapi::serveBatch() name(%s) is not %s\n", batch.Name, BatchName)
+		WriteError(w, &Error{Code: CodeInvalidParameter, Message: ErrInvalidParameter.Message, Detail: HeaderName})
+		return
+	}
+	items, err := DecodeBatch(payload)
+	if err != nil {
+		glog.Warningf("broker::httpapi::serveBatch() decode error: %s\n", err)
+		WriteError(w, ErrInvalidParameter)
+		return
+	}
+	if len(items) == 0 || len(items) > b.maxBatchSize {
+		glog.Warningf("broker::httpapi::serveBatch() batch size(%d) out of range\n", len(items))
+		WriteError(w, &Error{Code: CodeInvalidParameter, Message: ErrInvalidParameter.Message, Detail: "batch size"})
+		return
+	}
+
+	resp := &BatchResponse{Results: make([]*BatchResult, 0, len(items))}
+	for index, item := range items {
+		cmd, e := ParseBatchItem(batch.AppID, item)
+		if e == nil {
+			select {
+			case queue <- cmd:
+			default:
+				e = ErrQueueFull
+			}
+		}
+		resp.add(index, e)
+	}
+	glog.Infof("broker::httpapi::serveBatch(%s) app(%s) accepted: %d, rejected: %d\n",
+		tag, batch.AppID, resp.Accepted, resp.Rejected)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+func TestBatch(t *testing.T) {
+	controller, _ := app.NewController(nil)
+	controller.AddApp(&app.App{ID: "test", Key: "123", KeyBytes: []byte("123")})
+	ctx := controller.SaveIntoContext(context.Background())
+	queue := make(chan *protocol.Command, 3)
+	b := &BrokerImpl{
+		ctx:          ctx,
+		queues:       map[string]chan *protocol.Command{"tag": queue},
+		timeout:      10,
+		nonces:       NewNonceCache(0),
+		maxBatchSize: 4,
+	}
+	server := httptest.NewServer(b)
+	defer server.Close()
+	b.RequestURL = server.URL
+
+	cmds := []*protocol.Command{
+		{AppID: "test", Name: protocol.Push2User, Data: &protocol.Push2UserCommand{UserIDList: "u1"}, Payload: []byte("hello")},
+		{AppID: "test", Payload: []byte("no name")},
+		{AppID: "test", Name: "msg/foo", Data: &protocol.GatewayMessageCommand{UserID: "u2"}, Payload: []byte("world")},
+	}
+	resp, err := b.PublishBatch("tag", cmds)
+	if err != nil {
+		t.Fatal("PublishBatch() error:", err)
+	}
+	if resp.Accepted != 2 || resp.Rejected != 1 || len(resp.Results) != 3 ||
+		resp.Results[1].Code != CodeInvalidParameter || resp.Results[1].Detail != "name" {
+		t.Fatalf("PublishBatch() got: %+v\n", resp)
+	}
+	if got := <-queue; got.AppID != "test" || string(got.Payload) != "hello" ||
+		got.Data.(*protocol.Push2UserCommand).UserIDList != "u1" {
+		t.Errorf("queue[0] got: %s\n", got)
+	}
+	if got := <-queue; string(got.Payload) != "world" || got.Data.(*protocol.GatewayMessageCommand).UserID != "u2" {
+		t.Errorf("queue[1] got: %s\n", got)
+	}
+
+	// 队列已满的命令单独返回503
+	if resp, err = b.PublishBatch("tag", []*protocol.Command{cmds[0], cmds[0], cmds[0], cmds[0]}); err != nil ||
+		resp.Accepted != 3 || resp.Results[3].Code != CodeQueueFull {
+		t.Errorf("queue full got: %+v, %v\n", resp, err)
+	}
+	// 命令数超过上限
+	if _, err = b.PublishBatch("tag", []*protocol.Command{cmds[0], cmds[0], cmds[0], cmds[0], cmds[0]}); err == nil ||
+		err.(*Error).Code != CodeInvalidParameter {
+		t.Errorf("too many commands got: %v\n", err)
+	}
+	// 不同应用的命令不能合并
+	if _, err = ComposeBatch(ctx, "tag", make(http.Header), []*protocol.Command{cmds[0], {AppID: "other", Name: "msg"}}); err == nil {
+		t.Error("ComposeBatch() with different apps should fail")
+	}
+
+	// NDJSON，Data为JSON字符串
+	for len(queue) > 0 {
+		<-queue
+	}
+	ndjson := []byte(`{"name":"p2u","data":"{\"useridlist\":\"u3\"}","payload":"a"}` + "\n" +
+		`{"name":"p2u","data":"not json","payload":"b"}` + "\n")
+	req, _ := http.NewRequest("POST", server.URL+"/tag"+BatchPath, bytes.NewReader(ndjson))
+	if err = ComposeCommand(ctx, "tag"+BatchPath, req.Header, &protocol.Command{AppID: "test", Name: BatchName, Payload: ndjson}); err != nil {
+		t.Fatal("ComposeCommand() error:", err)
+	}
+	// 单个命令的签名不能用于批量请求
+	single, _ := http.NewRequest("POST", server.URL+"/tag"+BatchPath, bytes.NewReader(ndjson))
+	ComposeCommand(ctx, "tag", single.Header, &protocol.Command{AppID: "test", Name: BatchName, Payload: ndjson})
+	if httpResp, err := http.DefaultClient.Do(single); err != nil || httpResp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("single signature got: %v, %v\n", httpResp, err)
+	} else {
+		httpResp.Body.Close()
+	}
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil || httpResp.StatusCode != http.StatusOK {
+		t.Fatalf("NDJSON got: %v, %v\n", httpResp, err)
+	}
+	httpResp.Body.Close()
+	if len(queue) != 1 {
+		t.Fatalf("NDJSON queue length: %d\n", len(queue))
+	}
+	if got := <-queue; got.Data.(*protocol.Push2UserCommand).UserIDList != "u3" {
+		t.Errorf("NDJSON got: %s\n", got)
+	}
+}
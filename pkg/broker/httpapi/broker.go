@@ -4,6 +4,7 @@ package httpapi
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
 	"sync"
@@ -19,6 +20,8 @@ import (
 // BrokerImpl HTTP API实现的Broker
 type BrokerImpl struct {
 	sync.Mutex
+	// name 实例名
+	name string
 	// RequestURL 请求地址
 	RequestURL string
 	// BindAddress 绑定地址
@@ -37,6 +40,20 @@ type BrokerImpl struct {
 	ctx context.Context
 	// timeout 消息超时时间（单位：秒）
 	timeout int
+	// nonces 已使用的Nonce缓存
+	nonces *NonceCache
+	// maxBatchSize 批量请求的最大命令数
+	maxBatchSize int
+	// client 发布使用的HTTP客户端，所有请求共享连接池
+	client *http.Client
+	// requestTimeout 发布请求超时时间
+	requestTimeout time.Duration
+	// serverTLS 订阅HTTP服务的TLS配置，为nil时使用HTTP
+	serverTLS *tls.Config
+	// done Broker关闭时关闭，结束订阅
+	done chan struct{}
+	// closeOnce 只关闭一次done
+	closeOnce sync.Once
 }
 
 const (
@@ -66,14 +83,26 @@ const (
 	HeaderTimestamp = "Zim-Timestamp"
 	// HeaderCheckSum CheckSum
 	HeaderCheckSum = "Zim-Checksum"
+	// HeaderKeyID 计算CheckSum使用的密钥ID
+	HeaderKeyID = "Zim-Keyid"
+	// HeaderSignVersion 签名版本，不设置时为1
+	HeaderSignVersion = "Zim-Signversion"
+	// HeaderCode 错误码
+	HeaderCode = "Zim-Code"
+	// MaxNonceLength Nonce最大长度
+	MaxNonceLength = 128
 	// DefaultBindAddress 默认绑定地址
 	DefaultBindAddress = ":8771"
+	// NoBind 不启动订阅HTTP服务，命名实例没有设置subscribe-bind时的默认值
+	NoBind = "off"
 	// DefaultRequestURL 默认请求地址
 	DefaultRequestURL = "http://127.0.0.1:8880"
 )
 
 func init() {
 	register.Register(Name, NewHTTPAPIBroker)
+	// 绑定地址不能被多个实例共用，只用于发布的命名实例不启动订阅HTTP服务
+	register.SetInstanceDefault(Name, "subscribe-bind", NoBind)
 }
 
 // NewHTTPAPIBroker 新建服务
@@ -87,13 +116,47 @@ func NewHTTPAPIBroker(viperPerfix string) (broker.Broker, error) {
 	if timeout <= 0 {
 		timeout = DefaultTimeout
 	}
+	maxBatchSize := viper.GetInt(viperPerfix + ".httpapi.max-batch-size")
+	if maxBatchSize <= 0 {
+		maxBatchSize = DefaultMaxBatchSize
+	}
+	requestTimeout := time.Duration(viper.GetInt(viperPerfix+".httpapi.request-timeout")) * time.Millisecond
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeout
+	}
+	maxIdleConnsPerHost := viper.GetInt(viperPerfix + ".httpapi.max-idle-conns-per-host")
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	idleConnTimeout := time.Duration(viper.GetInt(viperPerfix+".httpapi.idle-conn-timeout")) * time.Millisecond
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = DefaultIdleConnTimeout
+	}
+	tlsConfig := NewTLSConfig(viperPerfix)
+	clientTLS, err := tlsConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	serverTLS, err := tlsConfig.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
 	b := &BrokerImpl{
-		RequestURL:  viper.GetString(viperPerfix + ".httpapi.request-url"),
-		BindAddress: viper.GetString(viperPerfix + ".httpapi.subscribe-bind"),
-		Debug:       viper.GetBool("debug"),
-		queues:      make(map[string]chan *protocol.Command),
-		queueSize:   queueSize,
-		timeout:     timeout,
+		name:         Name,
+		RequestURL:   viper.GetString(viperPerfix + ".httpapi.request-url"),
+		BindAddress:  viper.GetString(viperPerfix + ".httpapi.subscribe-bind"),
+		Debug:        viper.GetBool("debug"),
+		queues:       make(map[string]chan *protocol.Command),
+		queueSize:    queueSize,
+		timeout:      timeout,
+		nonces:       NewNonceCache(viper.GetInt(viperPerfix + ".httpapi.nonce-cache-size")),
+		maxBatchSize: maxBatchSize,
+		client: &http.Client{
+			Transport: NewTransport(clientTLS, maxIdleConnsPerHost, idleConnTimeout),
+		},
+		requestTimeout: requestTimeout,
+		serverTLS:      serverTLS,
+		done:           make(chan struct{}),
 	}
 	if len(b.BindAddress) == 0 {
 		b.BindAddress = DefaultBindAddress
@@ -101,7 +164,7 @@ func NewHTTPAPIBroker(viperPerfix string) (broker.Broker, error) {
 	if len(b.RequestURL) == 0 {
 		b.RequestURL = DefaultRequestURL
 	}
-	b.httpServer = &http.Server{Handler: b}
+	b.httpServer = &http.Server{Handler: b, TLSConfig: serverTLS}
 	if b.Debug {
 		glog.Warningln("httpapi broker in debug mode!!!")
 	}
@@ -111,8 +174,12 @@ func NewHTTPAPIBroker(viperPerfix string) (broker.Broker, error) {
 
 // Run 运行
 func (b *BrokerImpl) Run(ctx context.Context) (err error) {
-	glog.Infof("broker::httpapi::Run()\n")
+	glog.Infof("broker::httpapi::Run() %s\n", b)
 	b.ctx = ctx
+	if b.BindAddress == NoBind {
+		glog.Infof("broker::httpapi::Run() %s publish only\n", b)
+		return nil
+	}
 	b.listener, err = net.Listen("tcp4", b.BindAddress)
 	if err != nil {
 		glog.Errorf("broker::httpapi::Run() listen(%s) error: %s\n",
@@ -121,7 +188,12 @@ func (b *BrokerImpl) Run(ctx context.Context) (err error) {
 	}
 	var httpErr error
 	go func() {
-		httpErr = b.httpServer.Serve(b.listener)
+		if b.serverTLS != nil {
+			// 证书已经在TLSConfig中设置
+			httpErr = b.httpServer.ServeTLS(b.listener, "", "")
+		} else {
+			httpErr = b.httpServer.Serve(b.listener)
+		}
 	}()
 	time.Sleep(time.Second)
 	if httpErr != nil {
@@ -135,17 +207,27 @@ func (b *BrokerImpl) Run(ctx context.Context) (err error) {
 
 // Close 关闭
 func (b *BrokerImpl) Close(timeout time.Duration) (err error) {
-	glog.Warningln("broker::httpapi::Close()")
-	defer glog.Warningln("broker::httpapi::Close() Done")
+	glog.Warningf("broker::httpapi::Close() %s\n", b)
+	defer glog.Warningf("broker::httpapi::Close() %s Done\n", b)
+	b.closeOnce.Do(func() { close(b.done) })
 
 	// 关闭HTTP服务
 	if b.listener != nil {
 		err = b.listener.Close()
 	}
+	// 关闭连接池中的空闲连接
+	if b.client != nil {
+		b.client.CloseIdleConnections()
+	}
 	return err
 }
 
-// String 发布
+// SetInstanceName 设置实例名
+func (b *BrokerImpl) SetInstanceName(name string) {
+	b.name = name
+}
+
+// String 输出实例名
 func (b *BrokerImpl) String() string {
-	return Name
+	return b.name
 }
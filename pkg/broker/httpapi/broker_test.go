@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -62,6 +64,9 @@ func TestSuite(t *testing.T) {
 	if err = register.Init(viperPerfix); err != nil {
 		fmt.Println("broker.Init() error:", err)
 	}
+	if _, err = register.Get(Name); err != nil {
+		t.Fatal(`register.Get("httpapi") error:`, err)
+	}
 	broker.Run(globalContext)
 	t.Run("Producer", testProducer)
 	t.Run("Consumer", testConsumer)
@@ -87,7 +92,7 @@ func testProducer(t *testing.T) {
 				err)
 			w.WriteHeader(400)
 		} else {
-			if cmd, err := ParseCommand(globalContext, testtag, r.Header, payload, 10); err != nil {
+			if cmd, err := ParseCommand(globalContext, testtag, r.Header, payload, 10, nil); err != nil {
 				glog.Warningf("broker::httpapi::ServeHTTP() ParseCommand error: %s\n",
 					err)
 				w.WriteHeader(400)
@@ -96,7 +101,11 @@ func testProducer(t *testing.T) {
 			}
 		}
 	})
-	go http.ListenAndServe(fmt.Sprintf(":%d", httpport), nil)
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", httpport))
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	go http.Serve(listener, nil)
 
 	cmdPublish = &protocol.Command{
 		Version: "",
@@ -133,7 +142,8 @@ func testConsumer(t *testing.T) {
 	}
 
 	signal := make(chan *protocol.Command)
-	go b.Subscribe(testtag, func(tag string, cmd *protocol.Command) error {
+	// tag不区分大小写
+	go b.Subscribe(strings.ToUpper(testtag), func(tag string, cmd *protocol.Command) error {
 		signal <- cmd
 		return nil
 	})
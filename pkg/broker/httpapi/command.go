@@ -4,6 +4,7 @@ package httpapi
 
 import (
 	"context"
+	"crypto/hmac"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -13,14 +14,15 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/zhangpeihao/zim/pkg/app"
-	"github.com/zhangpeihao/zim/pkg/define"
 	"github.com/zhangpeihao/zim/pkg/protocol"
 	"github.com/zhangpeihao/zim/pkg/util"
 )
 
 // ParseCommand 解析出一个命令对象
+// Timestamp与当前时间相差超过timeout（单位：秒）时返回ErrTimestamp；
+// nonces不为nil时检查Nonce是否重复使用。返回的错误为*Error
 func ParseCommand(ctx context.Context, tag string, header http.Header, payload []byte,
-	timeout int) (cmd *protocol.Command, err error) {
+	timeout int, nonces *NonceCache) (cmd *protocol.Command, err error) {
 	// 从Header中取出参数
 	cmd = &protocol.Command{
 		Payload: payload,
@@ -30,70 +32,99 @@ func ParseCommand(ctx context.Context, tag string, header http.Header, payload [
 	)
 	if cmd.AppID = header.Get(HeaderAppID); len(cmd.AppID) == 0 {
 		glog.Warningln("broker::httpapi::ParseCommand() miss header ", HeaderAppID)
-		return nil, define.ErrInvalidParameter
+		return nil, missingHeader(HeaderAppID)
 	}
 	if cmd.Name = header.Get(HeaderName); len(cmd.Name) == 0 {
 		glog.Warningln("broker::httpapi::ParseCommand() miss header ", HeaderName)
-		return nil, define.ErrInvalidParameter
+		return nil, missingHeader(HeaderName)
 	}
 	if data = header.Get(HeaderData); len(data) == 0 {
 		glog.Infoln("broker::httpapi::ParseCommand() no data")
 	}
 	if payloadMD5 = header.Get(HeaderPayloadMD5); len(payloadMD5) == 0 {
 		glog.Warningln("broker::httpapi::ParseCommand() miss header ", HeaderPayloadMD5)
-		return nil, define.ErrInvalidParameter
+		return nil, missingHeader(HeaderPayloadMD5)
 	}
 	if nonce = header.Get(HeaderNonce); len(nonce) == 0 {
 		glog.Warningln("broker::httpapi::ParseCommand() miss header ", HeaderNonce)
-		return nil, define.ErrInvalidParameter
+		return nil, missingHeader(HeaderNonce)
 	}
 	if timestamp = header.Get(HeaderTimestamp); len(timestamp) == 0 {
 		glog.Warningln("broker::httpapi::ParseCommand() miss header ", HeaderTimestamp)
-		return nil, define.ErrInvalidParameter
+		return nil, missingHeader(HeaderTimestamp)
 	}
 	if checksum = header.Get(HeaderCheckSum); len(checksum) == 0 {
 		glog.Warningln("broker::httpapi::ParseCommand() miss header ", HeaderCheckSum)
-		return nil, define.ErrInvalidParameter
+		return nil, missingHeader(HeaderCheckSum)
+	}
+
+	if len(nonce) > MaxNonceLength {
+		glog.Warningf("broker::httpapi::ParseCommand() nonce too long(%d)\n", len(nonce))
+		return nil, ErrInvalidParameter
 	}
 
 	var ts int
 	if ts, err = strconv.Atoi(timestamp); err != nil {
 		glog.Warningln("broker::httpapi::ParseCommand() parse timstamp(", timestamp, ") error:", err)
-		return nil, define.ErrInvalidParameter
+		return nil, ErrInvalidParameter
 	}
-	now := int(time.Now().Unix())
-	if ts+timeout < now {
-		glog.Warningf("broker::httpapi::ParseCommand() request timeout!\ntimstamp:%s, timeout:%d, now:%d\n",
-			timestamp, timeout, now)
-		return nil, define.ErrInvalidParameter
+	now := time.Now()
+	if ts+timeout < int(now.Unix()) || ts-timeout > int(now.Unix()) {
+		glog.Warningf("broker::httpapi::ParseCommand() timestamp out of range!\ntimstamp:%s, timeout:%d, now:%d\n",
+			timestamp, timeout, now.Unix())
+		return nil, ErrTimestamp
 	}
 
 	a := app.GetAppFromContext(ctx, cmd.AppID)
 	if a == nil {
 		glog.Warningln("broker::httpapi::ParseCommand() no app(", cmd.AppID, ")")
-		return nil, define.ErrInvalidParameter
+		return nil, ErrUnknownApp
+	}
+	keyID := header.Get(HeaderKeyID)
+	key := a.FindKey(keyID, app.KeyUsageServer, now)
+	if key == nil {
+		glog.Warningf("broker::httpapi::ParseCommand() no valid server key(%s) for app(%s)\n",
+			keyID, cmd.AppID)
+		return nil, ErrInvalidKey
+	}
+	signVersion := header.Get(HeaderSignVersion)
+	if len(signVersion) == 0 {
+		signVersion = app.SignVersion1
 	}
-	checksumExpect := a.CheckSumSHA256([]byte(tag), []byte(cmd.AppID), []byte(cmd.Name),
+	if !a.AcceptSignVersion(signVersion) {
+		glog.Warningf("broker::httpapi::ParseCommand() app(%s) not accept sign version(%s)\n",
+			cmd.AppID, signVersion)
+		return nil, ErrSignVersion
+	}
+	checksumExpect, _ := key.Sign(signVersion, []byte(tag), []byte(cmd.AppID), []byte(cmd.Name),
 		[]byte(data), []byte(payloadMD5), []byte(nonce), []byte(timestamp))
-	if checksumExpect != checksum {
+	if !hmac.Equal([]byte(checksumExpect), []byte(strings.ToUpper(checksum))) {
 		glog.Warningf("broker::httpapi::ParseCommand() checksum error!\ngot: %s\nexpect: %s\n",
 			checksum, checksumExpect)
-		return nil, define.ErrInvalidParameter
+		return nil, ErrChecksum
 	}
 
-	expectPayloadMD5 := a.CheckSumMD5(cmd.Payload)
+	expectPayloadMD5, _ := key.PayloadDigest(signVersion, cmd.Payload)
 	gotPayloadMD5 := strings.ToUpper(payloadMD5)
 	if gotPayloadMD5 != expectPayloadMD5 {
 		glog.Warningf("broker::httpapi::ParseCommand() checksum unmatch!\ngot: %s\nexpect: %s\n",
 			gotPayloadMD5, expectPayloadMD5)
-		return nil, define.ErrInvalidParameter
+		return nil, ErrPayloadMD5
+	}
+
+	// CheckSum检验通过后再记录Nonce，Nonce在请求有效期结束后过期
+	if nonces != nil {
+		if err = nonces.Use(cmd.AppID, nonce, time.Unix(int64(ts+timeout), 0), now); err != nil {
+			glog.Warningf("broker::httpapi::ParseCommand() app(%s) nonce(%s) error: %s\n", cmd.AppID, nonce, err)
+			return nil, err
+		}
 	}
 
 	if len(data) > 0 {
 		if err = cmd.ParseData([]byte(data)); err != nil {
 			glog.Warningf("broker::httpapi::ParseCommand() ParseData(%s) error %s\n",
 				data, err)
-			return nil, define.ErrInvalidParameter
+			return nil, ErrInvalidParameter
 		}
 	}
 	return
@@ -110,7 +141,18 @@ func ComposeCommand(ctx context.Context, tag string, header http.Header,
 		return fmt.Errorf("no AppID %s", cmd.AppID)
 	}
 
-	payloadMD5 := a.CheckSumMD5(cmd.Payload)
+	key := a.SigningKey(app.KeyUsageServer, time.Now())
+	if key == nil {
+		glog.Warningln("broker::httpapi::Publish() no valid server key for app(", cmd.AppID, ")")
+		return fmt.Errorf("no server key for AppID %s", cmd.AppID)
+	}
+	signVersion := a.ComposeSignVersion()
+	payloadMD5, err := key.PayloadDigest(signVersion, cmd.Payload)
+	if err != nil {
+		glog.Warningf("broker::httpapi::Publish() app(%s) sign version(%s) error: %s\n",
+			cmd.AppID, signVersion, err)
+		return err
+	}
 	header.Set(HeaderAppID, cmd.AppID)
 	header.Set(HeaderName, cmd.Name)
 
@@ -132,13 +174,19 @@ func ComposeCommand(ctx context.Context, tag string, header http.Header,
 	}
 
 	timestamp = fmt.Sprintf("%d", time.Now().Unix())
-	checksum = a.CheckSumSHA256([]byte(tag), []byte(cmd.AppID), []byte(cmd.Name),
+	checksum, _ = key.Sign(signVersion, []byte(tag), []byte(cmd.AppID), []byte(cmd.Name),
 		data, []byte(payloadMD5), []byte(nonce), []byte(timestamp))
 
 	header.Set(HeaderPayloadMD5, payloadMD5)
 	header.Set(HeaderNonce, nonce)
 	header.Set(HeaderTimestamp, timestamp)
 	header.Set(HeaderCheckSum, checksum)
+	if len(key.ID) > 0 {
+		header.Set(HeaderKeyID, key.ID)
+	}
+	if signVersion != app.SignVersion1 {
+		header.Set(HeaderSignVersion, signVersion)
+	}
 
 	return nil
 }
@@ -0,0 +1,264 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Now().Unix()
+	controller, err := app.NewController(nil)
+	if err != nil {
+		t.Fatal("NewController() error:", err)
+	}
+	a := &app.App{
+		ID: "rotate",
+		Keys: []*app.Key{
+			{ID: "c1", Secret: "client", Usage: app.KeyUsageClient},
+			{ID: "s1", Secret: "old", Usage: app.KeyUsageServer, NotBefore: now - 100},
+			{ID: "s2", Secret: "new", Usage: app.KeyUsageServer, NotBefore: now - 10},
+			{ID: "s3", Secret: "expired", Usage: app.KeyUsageServer, NotAfter: now - 1},
+		},
+	}
+	controller.AddApp(a)
+	ctx := controller.SaveIntoContext(context.Background())
+	cmd := &protocol.Command{
+		AppID:   "rotate",
+		Name:    "msg/foo",
+		Payload: []byte("foo bar"),
+	}
+
+	// 使用最新生效的服务端密钥签名
+	header := make(http.Header)
+	if err = ComposeCommand(ctx, "tag", header, cmd); err != nil {
+		t.Fatal("ComposeCommand() error:", err)
+	}
+	if header.Get(HeaderKeyID) != "s2" {
+		t.Errorf("ComposeCommand() key id expect: s2, got: %s\n", header.Get(HeaderKeyID))
+	}
+	if _, err = ParseCommand(ctx, "tag", header, cmd.Payload, 10, nil); err != nil {
+		t.Error("ParseCommand() error:", err)
+	}
+
+	// 旧密钥在轮换期间仍然有效，客户端密钥和过期密钥不能用于服务端接口
+	for id, ok := range map[string]bool{"s1": true, "c1": false, "s3": false, "": false, "none": false} {
+		key := &app.Key{ID: id, Secret: map[string]string{"s1": "old", "c1": "client", "s3": "expired"}[id]}
+		if len(key.Secret) == 0 {
+			key.Secret = "forged"
+		}
+		header = make(http.Header)
+		signer, _ := app.NewController(nil)
+		signer.AddApp(&app.App{ID: "rotate", Keys: []*app.Key{key}})
+		if err = ComposeCommand(signer.SaveIntoContext(context.Background()), "tag", header, cmd); err != nil {
+			t.Fatal("ComposeCommand() error:", err)
+		}
+		header.Set(HeaderKeyID, id)
+		if _, err = ParseCommand(ctx, "tag", header, cmd.Payload, 10, nil); (err == nil) != ok {
+			t.Errorf("ParseCommand() with key(%s) expect ok: %t, got: %v\n", id, ok, err)
+		}
+	}
+}
+
+func TestReplayProtection(t *testing.T) {
+	controller, _ := app.NewController(nil)
+	controller.AddApp(&app.App{ID: "test", Key: "123", KeyBytes: []byte("123")})
+	ctx := controller.SaveIntoContext(context.Background())
+	cmd := &protocol.Command{AppID: "test", Name: "msg/foo", Payload: []byte("foo bar")}
+	b := &BrokerImpl{
+		ctx:     ctx,
+		queues:  map[string]chan *protocol.Command{"tag": make(chan *protocol.Command, 4)},
+		timeout: 10,
+		nonces:  NewNonceCache(0),
+	}
+	newRequest := func(modify func(header http.Header)) *http.Request {
+		req := httptest.NewRequest("POST", "/tag", bytes.NewReader(cmd.Payload))
+		if err := ComposeCommand(ctx, "tag", req.Header, cmd); err != nil {
+			t.Fatal("ComposeCommand() error:", err)
+		}
+		if modify != nil {
+			modify(req.Header)
+		}
+		return req
+	}
+	serve := func(req *http.Request) (int, string) {
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		return w.Code, w.Header().Get(HeaderCode)
+	}
+
+	req := newRequest(nil)
+	replay := newRequest(func(header http.Header) {
+		for name := range req.Header {
+			header.Set(name, req.Header.Get(name))
+		}
+	})
+	if status, _ := serve(req); status != http.StatusOK {
+		t.Fatalf("first request status: %d\n", status)
+	}
+	testCases := []struct {
+		req    *http.Request
+		status int
+		code   int
+	}{
+		{replay, http.StatusUnauthorized, CodeReplay},
+		{newRequest(func(header http.Header) {
+			header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Unix()+60, 10))
+		}), http.StatusUnauthorized, CodeTimestamp},
+		{newRequest(func(header http.Header) {
+			header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Unix()-60, 10))
+		}), http.StatusUnauthorized, CodeTimestamp},
+		{newRequest(func(header http.Header) { header.Set(HeaderCheckSum, "00") }), http.StatusUnauthorized, CodeChecksum},
+		{newRequest(func(header http.Header) { header.Set(HeaderAppID, "none") }), http.StatusForbidden, CodeUnknownApp},
+		{newRequest(func(header http.Header) { header.Del(HeaderNonce) }), http.StatusBadRequest, CodeMissingHeader},
+		{newRequest(func(header http.Header) { header.Set(HeaderKeyID, "none") }), http.StatusUnauthorized, CodeInvalidKey},
+	}
+	for index, testCase := range testCases {
+		if status, code := serve(testCase.req); status != testCase.status || code != strconv.Itoa(testCase.code) {
+			t.Errorf("Case(%d): expect: %d/%d, got: %d/%s\n", index+1, testCase.status, testCase.code, status, code)
+		}
+	}
+	if len(b.queues["tag"]) != 1 {
+		t.Errorf("queue length expect: 1, got: %d\n", len(b.queues["tag"]))
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	cache := NewNonceCache(2)
+	now := time.Unix(1000, 0)
+	expire := now.Add(time.Second * 10)
+	if cache.Use("foo", "n1", expire, now) != nil || cache.Use("foo", "n1", expire, now) != ErrReplay {
+		t.Error("Use(n1) should only succeed once")
+	}
+	// 不同应用相互独立
+	if err := cache.Use("bar", "n1", expire, now); err != nil {
+		t.Error("Use(bar, n1) error:", err)
+	}
+	// 过期后可以再次使用
+	if err := cache.Use("foo", "n1", expire, expire); err != nil {
+		t.Error("Use(n1) after expire error:", err)
+	}
+	// 缓存已满时拒绝新的Nonce，不淘汰有效期内的Nonce
+	if err := cache.Use("foo", "n2", expire, now); err != nil {
+		t.Error("Use(n2) error:", err)
+	}
+	if err := cache.Use("foo", "n3", expire, now); err != ErrNonceCacheFull {
+		t.Errorf("Use(n3) expect ErrNonceCacheFull, got: %v\n", err)
+	}
+	if err := cache.Use("foo", "n2", expire, now); err != ErrReplay {
+		t.Errorf("Use(n2) again expect ErrReplay, got: %v\n", err)
+	}
+	if cache.Len("foo") != 2 {
+		t.Errorf("Len(foo) expect: 2, got: %d\n", cache.Len("foo"))
+	}
+	// 过期后释放空间
+	if err := cache.Use("foo", "n3", expire.Add(time.Second*10), expire); err != nil {
+		t.Error("Use(n3) after expire error:", err)
+	}
+}
+
+func TestSignVersion(t *testing.T) {
+	cmd := &protocol.Command{AppID: "test", Name: "msg/foo", Payload: []byte("foo bar")}
+	newContext := func(signVersion, minSignVersion string) context.Context {
+		controller, _ := app.NewController(nil)
+		controller.AddApp(&app.App{ID: "test", Key: "123", KeyBytes: []byte("123"),
+			SignVersion: signVersion, MinSignVersion: minSignVersion})
+		return controller.SaveIntoContext(context.Background())
+	}
+	testCases := []struct {
+		compose string
+		min     string
+		header  string
+		expect  error
+	}{
+		{"", "", "", nil},
+		{app.SignVersion2, "", app.SignVersion2, nil},
+		{app.SignVersion2, app.SignVersion2, app.SignVersion2, nil},
+		{app.SignVersion1, app.SignVersion2, "", ErrSignVersion},
+	}
+	for index, testCase := range testCases {
+		header := make(http.Header)
+		if err := ComposeCommand(newContext(testCase.compose, ""), "tag", header, cmd); err != nil {
+			t.Fatal("ComposeCommand() error:", err)
+		}
+		if header.Get(HeaderSignVersion) != testCase.header {
+			t.Errorf("Case(%d): sign version header expect: %q, got: %q\n", index+1, testCase.header, header.Get(HeaderSignVersion))
+		}
+		if _, err := ParseCommand(newContext("", testCase.min), "tag", header, cmd.Payload, 10, nil); err != testCase.expect {
+			t.Errorf("Case(%d): ParseCommand() expect: %v, got: %v\n", index+1, testCase.expect, err)
+		}
+	}
+
+	// 版本2的签名不能作为版本1使用
+	header := make(http.Header)
+	ComposeCommand(newContext(app.SignVersion2, ""), "tag", header, cmd)
+	header.Del(HeaderSignVersion)
+	if _, err := ParseCommand(newContext("", ""), "tag", header, cmd.Payload, 10, nil); err != ErrChecksum {
+		t.Errorf("ParseCommand() without sign version expect ErrChecksum, got: %v\n", err)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	controller, _ := app.NewController(nil)
+	controller.AddApp(&app.App{ID: "test", Key: "123", KeyBytes: []byte("123")})
+	ctx := controller.SaveIntoContext(context.Background())
+	cmd := &protocol.Command{AppID: "test", Name: "msg/foo", Payload: []byte("foo bar")}
+	b := &BrokerImpl{
+		ctx:     ctx,
+		queues:  map[string]chan *protocol.Command{"tag": make(chan *protocol.Command, 1)},
+		timeout: 10,
+		nonces:  NewNonceCache(0),
+	}
+	serve := func(path string, modify func(header http.Header)) (*httptest.ResponseRecorder, *Error) {
+		req := httptest.NewRequest("POST", path, bytes.NewReader(cmd.Payload))
+		if err := ComposeCommand(ctx, "tag", req.Header, cmd); err != nil {
+			t.Fatal("ComposeCommand() error:", err)
+		}
+		if modify != nil {
+			modify(req.Header)
+		}
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code == http.StatusOK {
+			return w, nil
+		}
+		if w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("error response Content-Type: %s\n", w.Header().Get("Content-Type"))
+		}
+		return w, ReadError(w.Result())
+	}
+
+	if w, _ := serve("/tag", nil); w.Code != http.StatusOK {
+		t.Fatalf("first request status: %d\n", w.Code)
+	}
+	// 队列已满时不阻塞
+	if w, e := serve("/tag", nil); w.Code != http.StatusServiceUnavailable || e == nil || e.Code != CodeQueueFull {
+		t.Errorf("queue full got: %d, %v\n", w.Code, e)
+	}
+	if w, e := serve("/none", nil); w.Code != http.StatusNotFound || e == nil || e.Code != CodeNotFound {
+		t.Errorf("unknown tag got: %d, %v\n", w.Code, e)
+	}
+	if _, e := serve("/tag", func(header http.Header) { header.Del(HeaderTimestamp) }); e == nil ||
+		e.Code != CodeMissingHeader || e.Detail != HeaderTimestamp {
+		t.Errorf("missing header got: %v\n", e)
+	}
+
+	// Publish返回应用服务的错误
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, ErrUnknownApp)
+	}))
+	defer server.Close()
+	b.RequestURL = server.URL
+	if _, err := b.Publish("tag", cmd); err == nil || err.Error() != ErrUnknownApp.Error() {
+		t.Errorf("Publish() expect unknown app error, got: %v\n", err)
+	}
+}
@@ -11,11 +11,12 @@ import (
 	"github.com/zhangpeihao/shutdown"
 	"github.com/zhangpeihao/zim/pkg/broker"
 	"github.com/zhangpeihao/zim/pkg/protocol"
-	"github.com/zhangpeihao/zim/pkg/util"
 )
 
 // Subscribe 订阅
 func (b *BrokerImpl) Subscribe(tag string, handler broker.SubscribeHandler) error {
+	// 与ServeHTTP相同，tag不区分大小写
+	tag = strings.ToLower(tag)
 	glog.Infof("broker::httpapi::Subscribe(%s)\n", tag)
 	defer glog.Infof("broker::httpapi::Subscribe(%s) done\n", tag)
 	if err := shutdown.ExitWaitGroupAdd(b.ctx, 1); err != nil {
@@ -31,13 +32,13 @@ FOR_LOOP:
 	for {
 		select {
 		case cmd := <-queue:
-			func() {
-				util.RecoverFromPanic()
-				handler(tag, cmd)
-			}()
+			broker.Handle(handler, tag, cmd)
 		case <-b.ctx.Done():
 			glog.Infof("broker::httpapi::Subscribe(%s) break by context", tag)
 			break FOR_LOOP
+		case <-b.done:
+			glog.Infof("broker::httpapi::Subscribe(%s) break by close", tag)
+			break FOR_LOOP
 		}
 	}
 	return nil
@@ -55,31 +56,45 @@ func (b *BrokerImpl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			//			b.HandleDebug(w, r)
 		} else {
 			glog.Warningln("broker::httpapi::ServeHTTP() not in debug mode")
-			w.WriteHeader(404)
+			WriteError(w, ErrNotFound)
+			return
+		}
+	} else if strings.HasSuffix(tag, BatchPath) {
+		payload, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			glog.Warningf("broker::httpapi::ServeHTTP() Read payload error: %s\n",
+				err)
+			WriteError(w, ErrInvalidParameter)
 			return
 		}
+		b.serveBatch(w, strings.TrimSuffix(tag, BatchPath), r.Header, payload)
 	} else {
 		b.Lock()
 		queue, ok := b.queues[tag]
 		b.Unlock()
 		if !ok {
 			glog.Warningln("broker::httpapi::ServeHTTP() no tag(", tag, ")")
-			w.WriteHeader(404)
+			WriteError(w, ErrNotFound)
 			return
 		}
 
 		if payload, err := ioutil.ReadAll(r.Body); err != nil {
 			glog.Warningf("broker::httpapi::ServeHTTP() Read payload error: %s\n",
 				err)
-			w.WriteHeader(400)
+			WriteError(w, ErrInvalidParameter)
 		} else {
-			if cmd, err := ParseCommand(b.ctx, tag, r.Header, payload, b.timeout); err != nil {
+			if cmd, err := ParseCommand(b.ctx, tag, r.Header, payload, b.timeout, b.nonces); err != nil {
 				glog.Warningf("broker::httpapi::ServeHTTP() ParseCommand error: %s\n",
 					err)
-				w.WriteHeader(400)
+				WriteError(w, err)
 			} else {
-				queue <- cmd
-				w.WriteHeader(200)
+				select {
+				case queue <- cmd:
+					w.WriteHeader(200)
+				default:
+					glog.Warningf("broker::httpapi::ServeHTTP() tag(%s) queue full\n", tag)
+					WriteError(w, ErrQueueFull)
+				}
 			}
 		}
 	}
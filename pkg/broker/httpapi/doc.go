@@ -7,6 +7,10 @@ tag: 作为请求URL的path最后一段，例如：HTTP服务的请求地址是"
 
 Command.Payload: 作为POST内容发送
 
+订阅：网关在应用路由使用的每个Broker上订阅下行tag（gateway.push-tag，默认为gateway-push），
+下行tag不能与发布使用的tag（路由的tag和gateway.broker-tag）相同，否则网关启动失败。
+业务服务向网关subscribe-bind地址的"/<tag>"发送请求即可推送消息（p2u）、断开用户连接（close）或者发送其他信令给用户
+
 
 HTTP请求Header设置：
 
@@ -18,16 +22,124 @@ HTTP请求Header设置：
 
 * Zim-Data: <信令Data>，如果Command.Data == nil,则不设置
 
-* Zim-Payloadmd5: <MD5(Payload)>
+* Zim-Payloadmd5: 签名版本1为MD5(AppSecret + Payload)，签名版本2为SHA256(Payload)（头部名称保持不变）
 
 * Zim-Nonce: 随机数（最大长度128个字符）
 
 * Zim-Timestamp: 当前UTC时间戳，从1970年1月1日0点0 分0 秒开始到现在的秒数(String)
 
-* Zim-Checksum: SHA256(AppSecret + tag + AppID + Name + Data + PayloadMD5 + Nonce + Timestamp)，进行SHA256哈希计算，转化成16进制字符(String，大写)
+* Zim-Checksum: 签名版本1为SHA256(AppSecret + tag + AppID + Name + Data + PayloadMD5 + Nonce + Timestamp)，进行SHA256哈希计算，转化成16进制字符(String，大写)
+
+  签名版本2为HMAC-SHA256(AppSecret, F(tag) + F(AppID) + F(Name) + F(Data) + F(PayloadMD5) + F(Nonce) + F(Timestamp))，
+  F(x)为4字节（大端）长度加上x，转化成16进制字符(String，大写)
+
+* Zim-Signversion: 签名版本，不设置时为1。版本1存在长度扩展攻击和字段边界不明确的问题，建议使用版本2。
+
+  应用配置sign-version为发送请求使用的签名版本（默认为1），min-sign-version为接受的最低签名版本，
+  迁移时先让接收方同时接受两个版本，再将发送方切换到版本2，最后设置min-sign-version为2。
 
   CheckSum有效期：出于安全性考虑，每个checkSum的有效期为5分钟(用Timestamp计算)，建议每次请求都生成新的checkSum，同时请确认发起请求的服务器是与标准时间同步的，比如有NTP服务。
+  Timestamp早于或者晚于服务器时间超过有效期的请求都会被拒绝。有效期内同一个应用的Nonce只能使用一次，重复的请求会被拒绝。
   CheckSum检验失败时会返回414错误码，具体参看code状态表。
 
+* Zim-Keyid: 计算CheckSum使用的服务端密钥ID，使用应用的默认密钥（旧配置的key）时不设置
+
+  AppSecret为应用的服务端密钥（usage为server或者为空），客户端密钥（usage为client）不能用于计算CheckSum。
+  Payloadmd5同样使用该密钥计算。
+
+
+配置（viper参数前缀 + ".httpapi."）：
+
+* request-url: 应用服务地址，发布的命令发送到"<request-url>/<tag>"
+
+* subscribe-bind: 订阅HTTP服务绑定地址，默认为:8771。路由的命名实例（instance）不继承该参数，
+  没有设置时不启动订阅HTTP服务（off）；多个实例需要订阅时，每个实例设置不同的绑定地址
+
+* request-timeout: 发布请求超时时间（单位：毫秒，默认30000），包括建立连接、发送请求和读取响应
+
+* max-idle-conns-per-host: 每个应用服务地址保持的最大空闲连接数（默认64），发布请求复用连接，HTTPS时优先使用HTTP/2
+
+* idle-conn-timeout: 空闲连接超时时间（单位：毫秒，默认90000）
+
+* timeout: CheckSum有效期（单位：秒，默认300）
+
+* queue-size: 每个tag的消息队列长度
+
+* nonce-cache-size: 每个应用缓存的Nonce数（默认100000），应不小于每秒最大请求数乘以timeout，
+  缓存已满时拒绝请求（422），不会淘汰有效期内的Nonce
+
+* max-batch-size: 批量请求的最大命令数
+
+* tls.client-ca-file: 发布时验证应用服务证书的CA证书文件（PEM），为空时使用系统CA
+
+* tls.client-cert-file, tls.client-key-file: 发布时使用的客户端证书和密钥文件（PEM）
+
+* tls.server-name: 发布时验证应用服务证书使用的服务名（SNI），为空时使用request-url中的主机名
+
+* tls.server-cert-file, tls.server-key-file: 订阅HTTP服务的证书和密钥文件（PEM），设置后订阅使用HTTPS
+
+* tls.server-ca-file: 订阅时验证客户端证书的CA证书文件（PEM），设置后要求客户端证书（双向认证），
+  必须同时设置tls.server-cert-file
+
+  客户端和服务端都要求TLS 1.2及以上版本。业务服务与网关之间双向认证时，发布方设置tls.client-*，
+  订阅方设置tls.server-*，request-url使用https
+
+批量请求：
+
+向"/<tag>/batch"发送POST请求，一次请求发送多个命令（最多max-batch-size个，默认1000）。
+请求Header与单个命令相同，Zim-Name为batch，计算CheckSum使用的tag为"<tag>/batch"，
+Payload为命令的JSON数组或者NDJSON（每行一个命令），命令的AppID使用请求的Zim-Appid，例如：
+
+	[{"name":"p2u","data":{"useridlist":"u1"},"payload":"hello"},{"name":"p2u","data":{"useridlist":"u2"},"payload":"world"}]
+
+请求检查通过后，每个命令单独检查并放入消息队列，返回200和每个命令的处理结果，code与下面的code状态表相同：
+
+	{"accepted":1,"rejected":1,"results":[{"index":0,"code":200},{"index":1,"code":503,"message":"queue full"}]}
+
+
+code状态表：
+
+请求失败时，通过HTTP响应Header Zim-Code返回错误码，响应内容为JSON格式的错误信息，例如：
+
+	{"code":421,"message":"missing header","detail":"Zim-Nonce"}
+
+* 200: 成功
+
+* 400: 缺少Header或者参数格式错误（HTTP状态码400）
+
+* 404: tag不存在（HTTP状态码404）
+
+* 414: CheckSum检验失败（HTTP状态码401）
+
+* 415: Timestamp超出有效期（HTTP状态码401）
+
+* 416: Nonce重复使用，请求被重放（HTTP状态码401）
+
+* 417: 应用不存在（HTTP状态码403）
+
+* 418: 密钥ID无效或者已过期（HTTP状态码401）
+
+* 419: Payloadmd5不匹配（HTTP状态码400）
+
+* 420: 不接受的签名版本（HTTP状态码401）
+
+* 421: 缺少Header，detail为缺少的Header名（HTTP状态码400）
+
+* 422: Nonce缓存已满，请稍后重试（HTTP状态码503）
+
+* 503: 消息队列已满，请稍后重试（HTTP状态码503）
+
+* 500: 内部错误（HTTP状态码500）
+
+推送接口被网关出站拦截器拒绝时，Zim-Code为拦截器的错误码（参看interceptor包文档）：
+
+* 413: Payload过长（HTTP状态码400）
+
+* 451: Payload包含禁止的内容（HTTP状态码400）
+
+* 460: Payload格式错误（HTTP状态码400）
+
+* 500: 拦截器内部错误（HTTP状态码500）
+
 */
 package httpapi
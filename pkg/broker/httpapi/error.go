@@ -0,0 +1,135 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// 错误码，通过Zim-Code Header返回，参看包文档中的code状态表
+const (
+	// CodeOK 成功
+	CodeOK = 200
+	// CodeInvalidParameter 缺少Header或者参数格式错误
+	CodeInvalidParameter = 400
+	// CodeNotFound tag不存在
+	CodeNotFound = 404
+	// CodeChecksum CheckSum检验失败
+	CodeChecksum = 414
+	// CodeTimestamp Timestamp超出有效范围
+	CodeTimestamp = 415
+	// CodeReplay Nonce重复使用（重放请求）
+	CodeReplay = 416
+	// CodeUnknownApp 应用不存在
+	CodeUnknownApp = 417
+	// CodeInvalidKey 密钥ID无效或者已过期
+	CodeInvalidKey = 418
+	// CodePayloadMD5 Payload MD5不匹配
+	CodePayloadMD5 = 419
+	// CodeSignVersion 不接受的签名版本
+	CodeSignVersion = 420
+	// CodeMissingHeader 缺少Header
+	CodeMissingHeader = 421
+	// CodeNonceCacheFull Nonce缓存已满，稍后重试
+	CodeNonceCacheFull = 422
+	// CodeQueueFull 消息队列已满，稍后重试
+	CodeQueueFull = 503
+	// CodeInternal 内部错误
+	CodeInternal = 500
+)
+
+var (
+	// ErrInvalidParameter 缺少Header或者参数格式错误
+	ErrInvalidParameter = &Error{Code: CodeInvalidParameter, Message: "invalid parameter"}
+	// ErrNotFound tag不存在
+	ErrNotFound = &Error{Code: CodeNotFound, Message: "tag not found"}
+	// ErrChecksum CheckSum检验失败
+	ErrChecksum = &Error{Code: CodeChecksum, Message: "checksum unmatch"}
+	// ErrTimestamp Timestamp超出有效范围
+	ErrTimestamp = &Error{Code: CodeTimestamp, Message: "timestamp out of range"}
+	// ErrReplay Nonce重复使用
+	ErrReplay = &Error{Code: CodeReplay, Message: "nonce replayed"}
+	// ErrUnknownApp 应用不存在
+	ErrUnknownApp = &Error{Code: CodeUnknownApp, Message: "unknown app"}
+	// ErrInvalidKey 密钥ID无效或者已过期
+	ErrInvalidKey = &Error{Code: CodeInvalidKey, Message: "invalid key"}
+	// ErrPayloadMD5 Payload MD5不匹配
+	ErrPayloadMD5 = &Error{Code: CodePayloadMD5, Message: "payload md5 unmatch"}
+	// ErrSignVersion 不接受的签名版本
+	ErrSignVersion = &Error{Code: CodeSignVersion, Message: "sign version not accepted"}
+	// ErrNonceCacheFull Nonce缓存已满
+	ErrNonceCacheFull = &Error{Code: CodeNonceCacheFull, Message: "nonce cache full, retry later"}
+	// ErrQueueFull 消息队列已满
+	ErrQueueFull = &Error{Code: CodeQueueFull, Message: "queue full"}
+	// ErrInternal 内部错误
+	ErrInternal = &Error{Code: CodeInternal, Message: "internal error"}
+)
+
+// Error HTTP接口错误，以JSON格式作为错误响应的内容
+type Error struct {
+	// Code 错误码
+	Code int `json:"code"`
+	// Message 错误信息
+	Message string `json:"message"`
+	// Detail 详细信息，例如缺少的Header名
+	Detail string `json:"detail,omitempty"`
+	// HTTPStatus HTTP状态码，为0时由错误码决定，用于其他模块的错误码（例如拦截器的错误码）
+	HTTPStatus int `json:"-"`
+}
+
+// missingHeader 缺少Header错误
+func missingHeader(name string) *Error {
+	return &Error{Code: CodeMissingHeader, Message: "missing header", Detail: name}
+}
+
+// Error 错误信息
+func (e *Error) Error() string {
+	if len(e.Detail) > 0 {
+		return "httpapi " + strconv.Itoa(e.Code) + ": " + e.Message + " " + e.Detail
+	}
+	return "httpapi " + strconv.Itoa(e.Code) + ": " + e.Message
+}
+
+// Status HTTP状态码
+func (e *Error) Status() int {
+	if e.HTTPStatus != 0 {
+		return e.HTTPStatus
+	}
+	switch e.Code {
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeChecksum, CodeTimestamp, CodeReplay, CodeInvalidKey, CodeSignVersion:
+		return http.StatusUnauthorized
+	case CodeUnknownApp:
+		return http.StatusForbidden
+	case CodeQueueFull, CodeNonceCacheFull:
+		return http.StatusServiceUnavailable
+	case CodeInternal:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// WriteError 返回错误，HTTP状态码由错误码决定，错误码通过Zim-Code Header返回，
+// 响应内容为JSON格式的错误信息
+func WriteError(w http.ResponseWriter, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = ErrInternal
+	}
+	w.Header().Set(HeaderCode, strconv.Itoa(e.Code))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status())
+	json.NewEncoder(w).Encode(e)
+}
+
+// ReadError 从错误响应中解析错误，响应内容不是JSON格式的错误信息时返回nil
+func ReadError(resp *http.Response) *Error {
+	var e Error
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Code == 0 {
+		return nil
+	}
+	return &e
+}
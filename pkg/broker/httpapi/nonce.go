@@ -0,0 +1,96 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package httpapi
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultNonceCacheSize 默认每个应用缓存的Nonce数量，有效期为300秒时支持每秒约330个请求
+	DefaultNonceCacheSize = 100000
+)
+
+// nonceEntry 缓存的Nonce
+type nonceEntry struct {
+	nonce  string
+	expire time.Time
+}
+
+// appNonces 一个应用的Nonce缓存，按加入顺序排列
+type appNonces struct {
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// NonceCache 按应用缓存已使用的Nonce，用于检查重放请求
+//
+// Nonce在请求的有效期结束后过期。缓存已满时不淘汰未过期的Nonce（否则被淘汰的请求可以在有效期内重放），
+// 而是拒绝新的请求，调用方稍后重试。缓存大小应不小于每个应用每秒的最大请求数乘以有效期（秒）
+type NonceCache struct {
+	sync.Mutex
+	// Size 每个应用缓存的Nonce数量
+	Size int
+	apps map[string]*appNonces
+}
+
+// NewNonceCache 新建Nonce缓存，size小于等于0时使用默认值
+func NewNonceCache(size int) *NonceCache {
+	if size <= 0 {
+		size = DefaultNonceCacheSize
+	}
+	return &NonceCache{
+		Size: size,
+		apps: make(map[string]*appNonces),
+	}
+}
+
+// Use 记录Nonce，Nonce在有效期内已经使用过时返回ErrReplay，缓存已满时返回ErrNonceCacheFull
+func (cache *NonceCache) Use(appid, nonce string, expire, now time.Time) error {
+	cache.Lock()
+	defer cache.Unlock()
+	nonces, found := cache.apps[appid]
+	if !found {
+		nonces = &appNonces{
+			entries: make(map[string]*list.Element),
+			order:   list.New(),
+		}
+		cache.apps[appid] = nonces
+	}
+	if element, found := nonces.entries[nonce]; found {
+		if now.Before(element.Value.(*nonceEntry).expire) {
+			return ErrReplay
+		}
+		nonces.order.Remove(element)
+		delete(nonces.entries, nonce)
+	}
+	// 清理过期的Nonce
+	for element := nonces.order.Front(); element != nil; element = nonces.order.Front() {
+		entry := element.Value.(*nonceEntry)
+		if now.Before(entry.expire) {
+			break
+		}
+		nonces.order.Remove(element)
+		delete(nonces.entries, entry.nonce)
+	}
+	if nonces.order.Len() >= cache.Size {
+		glog.Warningf("broker::httpapi::NonceCache::Use() app(%s) nonce cache full\n", appid)
+		return ErrNonceCacheFull
+	}
+	nonces.entries[nonce] = nonces.order.PushBack(&nonceEntry{nonce: nonce, expire: expire})
+	return nil
+}
+
+// Len 应用缓存的Nonce数量
+func (cache *NonceCache) Len(appid string) int {
+	cache.Lock()
+	defer cache.Unlock()
+	if nonces, found := cache.apps[appid]; found {
+		return nonces.order.Len()
+	}
+	return 0
+}
@@ -4,9 +4,11 @@ package httpapi
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
-	//	"encoding/json"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -14,27 +16,80 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/zhangpeihao/zim/pkg/protocol"
-	//	"github.com/zhangpeihao/zim/pkg/util"
 )
 
 const (
-	// Timeout 请求超时
-	Timeout = time.Duration(30 * time.Second)
+	// DefaultRequestTimeout 默认请求超时时间，包括建立连接、发送请求和读取响应
+	DefaultRequestTimeout = 30 * time.Second
+	// DefaultDialTimeout 默认建立连接超时时间
+	DefaultDialTimeout = 10 * time.Second
+	// DefaultMaxIdleConnsPerHost 默认每个应用服务地址保持的最大空闲连接数
+	DefaultMaxIdleConnsPerHost = 64
+	// DefaultIdleConnTimeout 默认空闲连接超时时间
+	DefaultIdleConnTimeout = 90 * time.Second
 )
 
 var (
-	// Transport 默认HTTP传输对象
-	Transport http.RoundTripper = &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: false,
-		},
-		Dial: dialTimeout,
-	}
+	// Transport 默认HTTP传输对象，没有通过NewHTTPAPIBroker新建的Broker使用
+	Transport http.RoundTripper = NewTransport(nil, DefaultMaxIdleConnsPerHost, DefaultIdleConnTimeout)
+	// defaultClient 默认HTTP客户端
+	defaultClient = &http.Client{Transport: Transport}
 )
 
-// diaoTimeout 指定超时时间的连接器
-func dialTimeout(network, addr string) (net.Conn, error) {
-	return net.DialTimeout(network, addr, Timeout)
+// NewTransport 新建HTTP传输对象，复用连接（keep-alive），HTTPS时优先使用HTTP/2。
+// tlsConfig为nil时使用系统CA验证应用服务的证书
+func NewTransport(tlsConfig *tls.Config, maxIdleConnsPerHost int, idleConnTimeout time.Duration) *http.Transport {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   DefaultDialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConnsPerHost * 4,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   DefaultDialTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// httpClient Broker使用的HTTP客户端
+func (b *BrokerImpl) httpClient() *http.Client {
+	if b.client != nil {
+		return b.client
+	}
+	return defaultClient
+}
+
+// do 发送请求，请求超时由context控制。读取完成后必须调用closeBody以复用连接
+func (b *BrokerImpl) do(req *http.Request) (*http.Response, context.CancelFunc, error) {
+	ctx := b.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := b.requestTimeout
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	resp, err := b.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return resp, cancel, nil
+}
+
+// closeBody 读取剩余的响应内容并关闭，连接放回连接池
+func closeBody(resp *http.Response, cancel context.CancelFunc) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	cancel()
 }
 
 // Publish 发布
@@ -56,18 +111,20 @@ func (b *BrokerImpl) Publish(tag string, cmd *protocol.Command) (resp *protocol.
 		return
 	}
 	glog.Infof("req.Header: %+v\n", req.Header)
-	req.Close = true
 
-	client := &http.Client{Transport: Transport}
-	var httpResp *http.Response
-	httpResp, err = client.Do(req)
+	httpResp, cancel, err := b.do(req)
 	if err != nil {
 		glog.Errorf("broker::httpapi::Publish() http error: %s\n", err)
 		return
 	}
-	defer httpResp.Body.Close()
+	defer closeBody(httpResp, cancel)
 
 	if httpResp.StatusCode != http.StatusOK {
+		if e := ReadError(httpResp); e != nil {
+			glog.Errorf("broker::httpapi::Publish() http response status %d, error: %s\n",
+				httpResp.StatusCode, e)
+			return nil, e
+		}
 		glog.Errorf("broker::httpapi::Publish() http response status %d\n", httpResp.StatusCode)
 		return nil, fmt.Errorf("http response status %d", httpResp.StatusCode)
 	}
@@ -80,7 +137,54 @@ func (b *BrokerImpl) Publish(tag string, cmd *protocol.Command) (resp *protocol.
 		return nil, nil
 	}
 	glog.Infof("resp.Header: %+v\n", httpResp.Header)
-	resp, _ = ParseCommand(b.ctx, tag, httpResp.Header, respPayload, b.timeout)
+	// 响应是本次请求的应答，不检查Nonce
+	resp, _ = ParseCommand(b.ctx, tag, httpResp.Header, respPayload, b.timeout, nil)
+
+	return
+}
 
+// PublishBatch 批量发布，所有命令必须属于同一个应用，返回每个命令的处理结果
+func (b *BrokerImpl) PublishBatch(tag string, cmds []*protocol.Command) (resp *BatchResponse, err error) {
+	glog.Infof("broker::httpapi::PublishBatch(%s) %d commands\n", tag, len(cmds))
+	defer glog.Infof("broker::httpapi::PublishBatch() done\n")
+
+	header := make(http.Header)
+	var payload []byte
+	if payload, err = ComposeBatch(b.ctx, tag, header, cmds); err != nil {
+		glog.Errorf("broker::httpapi::PublishBatch() ComposeBatch error: %s\n", err)
+		return
+	}
+	var req *http.Request
+	req, err = http.NewRequest("POST", b.RequestURL+"/"+tag+BatchPath, bytes.NewBuffer(payload))
+	if err != nil {
+		glog.Errorf("broker::httpapi::PublishBatch() error: %s\n", err)
+		return
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpResp, cancel, err := b.do(req)
+	if err != nil {
+		glog.Errorf("broker::httpapi::PublishBatch() http error: %s\n", err)
+		return
+	}
+	defer closeBody(httpResp, cancel)
+
+	if httpResp.StatusCode != http.StatusOK {
+		if e := ReadError(httpResp); e != nil {
+			glog.Errorf("broker::httpapi::PublishBatch() http response status %d, error: %s\n",
+				httpResp.StatusCode, e)
+			return nil, e
+		}
+		glog.Errorf("broker::httpapi::PublishBatch() http response status %d\n", httpResp.StatusCode)
+		return nil, fmt.Errorf("http response status %d", httpResp.StatusCode)
+	}
+	resp = new(BatchResponse)
+	if err = json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		glog.Warningf("broker::httpapi::PublishBatch() decode response error: %s\n", err)
+		return nil, err
+	}
 	return
 }
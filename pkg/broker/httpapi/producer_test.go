@@ -0,0 +1,102 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package httpapi
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// newTestPublisher 新建发布到测试服务的Broker，返回新建连接数
+func newTestPublisher(client *http.Client, handler http.HandlerFunc) (*BrokerImpl, *int32, func()) {
+	controller, _ := app.NewController(nil)
+	controller.AddApp(&app.App{ID: "test", Key: "123", KeyBytes: []byte("123")})
+	var conns int32
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	b := &BrokerImpl{
+		RequestURL:     server.URL,
+		ctx:            controller.SaveIntoContext(context.Background()),
+		timeout:        10,
+		client:         client,
+		requestTimeout: time.Second,
+	}
+	return b, &conns, func() {
+		client.CloseIdleConnections()
+		server.Close()
+	}
+}
+
+func TestPublishKeepAlive(t *testing.T) {
+	client := &http.Client{Transport: NewTransport(nil, DefaultMaxIdleConnsPerHost, DefaultIdleConnTimeout)}
+	b, conns, closer := newTestPublisher(client, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(time.Millisecond * 300)
+		}
+		w.Write([]byte("ok"))
+	})
+	defer closer()
+	cmd := &protocol.Command{AppID: "test", Name: "msg/foo", Payload: []byte("foo bar")}
+	for i := 0; i < 10; i++ {
+		if _, err := b.Publish("tag", cmd); err != nil {
+			t.Fatal("Publish() error:", err)
+		}
+	}
+	if got := atomic.LoadInt32(conns); got != 1 {
+		t.Errorf("connections expect: 1, got: %d\n", got)
+	}
+	// 错误响应的连接同样复用
+	b.RequestURL += "/none"
+	b.Publish("tag", cmd)
+	if got := atomic.LoadInt32(conns); got != 1 {
+		t.Errorf("connections after error expect: 1, got: %d\n", got)
+	}
+
+	// 请求超时
+	b.requestTimeout = time.Millisecond * 100
+	b.RequestURL = b.RequestURL[:len(b.RequestURL)-len("/none")]
+	if _, err := b.Publish("slow", cmd); err == nil {
+		t.Error("Publish() should timeout")
+	}
+}
+
+func benchmarkPublish(b *testing.B, client *http.Client) {
+	broker, conns, closer := newTestPublisher(client, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	defer closer()
+	cmd := &protocol.Command{AppID: "test", Name: "msg/foo", Payload: []byte("foo bar")}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := broker.Publish("tag", cmd); err != nil {
+			b.Fatal("Publish() error:", err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt32(conns))/float64(b.N), "conns/op")
+}
+
+// BenchmarkPublish 复用连接
+func BenchmarkPublish(b *testing.B) {
+	benchmarkPublish(b, &http.Client{Transport: NewTransport(nil, DefaultMaxIdleConnsPerHost, DefaultIdleConnTimeout)})
+}
+
+// BenchmarkPublishNoKeepAlive 每个请求新建连接（原来的实现）
+func BenchmarkPublishNoKeepAlive(b *testing.B) {
+	transport := NewTransport(nil, DefaultMaxIdleConnsPerHost, DefaultIdleConnTimeout)
+	transport.DisableKeepAlives = true
+	benchmarkPublish(b, &http.Client{Transport: transport})
+}
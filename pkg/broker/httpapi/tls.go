@@ -0,0 +1,127 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package httpapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+)
+
+var (
+	// ErrTLSKeyPair 证书文件和密钥文件必须同时设置
+	ErrTLSKeyPair = errors.New("tls cert-file and key-file must be set together")
+	// ErrTLSServerCA 设置了服务端CA但没有设置服务端证书，无法验证客户端证书
+	ErrTLSServerCA = errors.New("tls server-ca-file requires server-cert-file and server-key-file")
+)
+
+// TLSConfig Broker的TLS配置，发布请求（客户端）和订阅HTTP服务（服务端）分别配置
+type TLSConfig struct {
+	// ClientCAFile 发布时验证应用服务证书的CA证书文件（PEM），为空时使用系统CA
+	ClientCAFile string
+	// ClientCertFile 发布时使用的客户端证书文件（PEM）
+	ClientCertFile string
+	// ClientKeyFile 客户端证书密钥文件（PEM）
+	ClientKeyFile string
+	// ServerName 发布时验证应用服务证书使用的服务名（SNI），为空时使用请求地址中的主机名
+	ServerName string
+	// ServerCAFile 订阅时验证客户端证书的CA证书文件（PEM），设置后要求客户端证书（双向认证）
+	ServerCAFile string
+	// ServerCertFile 订阅HTTP服务的证书文件（PEM），设置后启用HTTPS
+	ServerCertFile string
+	// ServerKeyFile 服务端证书密钥文件（PEM）
+	ServerKeyFile string
+}
+
+// NewTLSConfig 从viper参数读取TLS配置
+func NewTLSConfig(viperPerfix string) *TLSConfig {
+	perfix := viperPerfix + ".httpapi.tls."
+	return &TLSConfig{
+		ClientCAFile:   viper.GetString(perfix + "client-ca-file"),
+		ClientCertFile: viper.GetString(perfix + "client-cert-file"),
+		ClientKeyFile:  viper.GetString(perfix + "client-key-file"),
+		ServerName:     viper.GetString(perfix + "server-name"),
+		ServerCAFile:   viper.GetString(perfix + "server-ca-file"),
+		ServerCertFile: viper.GetString(perfix + "server-cert-file"),
+		ServerKeyFile:  viper.GetString(perfix + "server-key-file"),
+	}
+}
+
+// loadCertPool 加载CA证书
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// loadCertificates 加载证书，没有设置证书时返回nil
+func loadCertificates(certFile, keyFile string) ([]tls.Certificate, error) {
+	if len(certFile) == 0 && len(keyFile) == 0 {
+		return nil, nil
+	}
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil, ErrTLSKeyPair
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return []tls.Certificate{cert}, nil
+}
+
+// ClientConfig 发布请求使用的TLS配置
+func (c *TLSConfig) ClientConfig() (config *tls.Config, err error) {
+	config = &tls.Config{
+		ServerName: c.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(c.ClientCAFile) > 0 {
+		if config.RootCAs, err = loadCertPool(c.ClientCAFile); err != nil {
+			glog.Errorf("broker::httpapi::TLSConfig::ClientConfig() load ca(%s) error: %s\n", c.ClientCAFile, err)
+			return nil, err
+		}
+	}
+	if config.Certificates, err = loadCertificates(c.ClientCertFile, c.ClientKeyFile); err != nil {
+		glog.Errorf("broker::httpapi::TLSConfig::ClientConfig() load cert(%s) error: %s\n", c.ClientCertFile, err)
+		return nil, err
+	}
+	return config, nil
+}
+
+// ServerConfig 订阅HTTP服务使用的TLS配置，没有设置证书时返回nil（使用HTTP）
+func (c *TLSConfig) ServerConfig() (config *tls.Config, err error) {
+	certificates, err := loadCertificates(c.ServerCertFile, c.ServerKeyFile)
+	if err != nil {
+		glog.Errorf("broker::httpapi::TLSConfig::ServerConfig() load cert(%s) error: %s\n", c.ServerCertFile, err)
+		return nil, err
+	}
+	if certificates == nil {
+		if len(c.ServerCAFile) > 0 {
+			return nil, ErrTLSServerCA
+		}
+		return nil, nil
+	}
+	config = &tls.Config{
+		Certificates: certificates,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(c.ServerCAFile) > 0 {
+		if config.ClientCAs, err = loadCertPool(c.ServerCAFile); err != nil {
+			glog.Errorf("broker::httpapi::TLSConfig::ServerConfig() load ca(%s) error: %s\n", c.ServerCAFile, err)
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package httpapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/shutdown"
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// writeTestCerts 生成CA和同时用于服务端和客户端的证书（服务名zim.test）
+func writeTestCerts(t *testing.T, dir string) (caFile, certFile, keyFile string) {
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal("ecdsa.GenerateKey() error:", err)
+		}
+		return key
+	}
+	writePEM := func(name, typ string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: data}), 0600); err != nil {
+			t.Fatal("WriteFile() error:", err)
+		}
+		return path
+	}
+	caKey, key := newKey(), newKey()
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "zim test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("CreateCertificate(ca) error:", err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "zim.test"},
+		DNSNames:     []string{"zim.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("CreateCertificate(leaf) error:", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return writePEM("ca.pem", "CERTIFICATE", caDER), writePEM("cert.pem", "CERTIFICATE", leafDER),
+		writePEM("key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zim-tls")
+	if err != nil {
+		t.Fatal("TempDir() error:", err)
+	}
+	defer os.RemoveAll(dir)
+	caFile, certFile, keyFile := writeTestCerts(t, dir)
+	const perfix = "tlstest"
+	viper.Set(perfix+".httpapi.subscribe-bind", "127.0.0.1:0")
+	for _, side := range []string{"client", "server"} {
+		viper.Set(perfix+".httpapi.tls."+side+"-ca-file", caFile)
+		viper.Set(perfix+".httpapi.tls."+side+"-cert-file", certFile)
+		viper.Set(perfix+".httpapi.tls."+side+"-key-file", keyFile)
+	}
+	viper.Set(perfix+".httpapi.tls.server-name", "zim.test")
+
+	controller, _ := app.NewController(nil)
+	controller.AddApp(&app.App{ID: "test", Key: "123", KeyBytes: []byte("123")})
+	ctx := controller.SaveIntoContext(shutdown.NewContext())
+	subscriber, err := NewHTTPAPIBroker(perfix)
+	if err != nil {
+		t.Fatal("NewHTTPAPIBroker() error:", err)
+	}
+	if err = subscriber.Run(ctx); err != nil {
+		t.Fatal("Run() error:", err)
+	}
+	received := make(chan *protocol.Command, 1)
+	go subscriber.Subscribe("tag", func(tag string, cmd *protocol.Command) error {
+		received <- cmd
+		return nil
+	})
+	defer shutdown.Shutdown(ctx, time.Second, subscriber.Close)
+	url := "https://" + subscriber.(*BrokerImpl).listener.Addr().String()
+
+	publisher, err := NewHTTPAPIBroker(perfix)
+	if err != nil {
+		t.Fatal("NewHTTPAPIBroker() error:", err)
+	}
+	b := publisher.(*BrokerImpl)
+	b.ctx, b.RequestURL = ctx, url
+	cmd := &protocol.Command{AppID: "test", Name: "msg/foo", Payload: []byte("foo bar")}
+	for i := 0; i < 100; i++ {
+		if _, err = b.Publish("tag", cmd); err == nil {
+			break
+		}
+		// 等待Subscribe注册tag
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatal("Publish() error:", err)
+	}
+	select {
+	case got := <-received:
+		if !cmd.Equal(got) {
+			t.Errorf("received expect: %s, got: %s\n", cmd, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait command timeout")
+	}
+
+	// 没有客户端证书时握手失败
+	noCert, err := (&TLSConfig{ClientCAFile: caFile, ServerName: "zim.test"}).ClientConfig()
+	if err != nil {
+		t.Fatal("ClientConfig() error:", err)
+	}
+	client := &http.Client{Transport: NewTransport(noCert, 1, time.Second)}
+	if resp, err := client.Post(url+"/tag", "text/plain", strings.NewReader("foo")); err == nil {
+		resp.Body.Close()
+		t.Error("request without client certificate should fail")
+	}
+	// 服务名与证书不匹配
+	wrongName, _ := (&TLSConfig{ClientCAFile: caFile, ClientCertFile: certFile, ClientKeyFile: keyFile}).ClientConfig()
+	client = &http.Client{Transport: NewTransport(wrongName, 1, time.Second)}
+	if resp, err := client.Post(url+"/tag", "text/plain", strings.NewReader("foo")); err == nil {
+		resp.Body.Close()
+		t.Error("request with wrong server name should fail")
+	}
+
+	// TLS 1.1客户端握手失败
+	oldVersion, _ := (&TLSConfig{ClientCAFile: caFile, ClientCertFile: certFile, ClientKeyFile: keyFile,
+		ServerName: "zim.test"}).ClientConfig()
+	oldVersion.MinVersion, oldVersion.MaxVersion = tls.VersionTLS11, tls.VersionTLS11
+	client = &http.Client{Transport: NewTransport(oldVersion, 1, time.Second)}
+	if resp, err := client.Post(url+"/tag", "text/plain", strings.NewReader("foo")); err == nil {
+		resp.Body.Close()
+		t.Error("request with TLS 1.1 should fail")
+	}
+
+	if _, err = (&TLSConfig{ClientCertFile: certFile}).ClientConfig(); err != ErrTLSKeyPair {
+		t.Errorf("cert without key expect ErrTLSKeyPair, got: %v\n", err)
+	}
+	if _, err = (&TLSConfig{ClientCAFile: keyFile}).ClientConfig(); err == nil {
+		t.Error("invalid ca file should fail")
+	}
+	// 客户端证书不会作为服务端证书
+	if config, err := (&TLSConfig{ClientCertFile: certFile, ClientKeyFile: keyFile}).ServerConfig(); err != nil || config != nil {
+		t.Errorf("ServerConfig() with client cert only expect nil, got: %v, %v\n", config, err)
+	}
+	if _, err = (&TLSConfig{ServerCAFile: caFile}).ServerConfig(); err != ErrTLSServerCA {
+		t.Errorf("server ca without cert expect ErrTLSServerCA, got: %v\n", err)
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package kafka
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// partitionMetadata partition元数据
+type partitionMetadata struct {
+	// Err 错误码
+	Err KError
+	// ID partition编号
+	ID int32
+	// Leader leader节点编号
+	Leader int32
+}
+
+// topicMetadata topic元数据
+type topicMetadata struct {
+	// Err 错误码
+	Err KError
+	// Partitions partition列表
+	Partitions []partitionMetadata
+}
+
+// metadata 集群元数据
+type metadata struct {
+	// Brokers 节点编号对应的地址
+	Brokers map[int32]string
+	// Topics topic元数据
+	Topics map[string]*topicMetadata
+}
+
+// fetchPartition Fetch返回的partition数据
+type fetchPartition struct {
+	// Err 错误码
+	Err KError
+	// HighWatermark 最大已提交offset
+	HighWatermark int64
+	// Records RecordBatch列表
+	Records []byte
+}
+
+// requestMetadata Metadata v4，topic不存在时由broker自动创建
+func requestMetadata(c *conn, timeout time.Duration, topics ...string) (*metadata, error) {
+	e := new(encoder)
+	e.putArrayLen(len(topics))
+	for _, topic := range topics {
+		e.putString(topic)
+	}
+	e.putBool(true)
+	resp, err := c.Do(APIKeyMetadata, e.Bytes(), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{data: resp}
+	d.int32()
+	m := &metadata{
+		Brokers: make(map[int32]string),
+		Topics:  make(map[string]*topicMetadata),
+	}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		nodeID := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string()
+		m.Brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.string()
+	d.int32()
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		t := &topicMetadata{Err: KError(d.int16())}
+		name := d.string()
+		d.bool()
+		for j, pn := 0, d.arrayLen(); j < pn; j++ {
+			p := partitionMetadata{
+				Err:    KError(d.int16()),
+				ID:     d.int32(),
+				Leader: d.int32(),
+			}
+			for k, rn := 0, d.arrayLen(); k < rn; k++ {
+				d.int32()
+			}
+			for k, in := 0, d.arrayLen(); k < in; k++ {
+				d.int32()
+			}
+			t.Partitions = append(t.Partitions, p)
+		}
+		m.Topics[name] = t
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return m, nil
+}
+
+// requestProduce Produce v3，发送一个RecordBatch到指定partition，返回base offset
+// acks为0时broker不返回响应，offset返回-1
+func requestProduce(c *conn, acks int16, timeout time.Duration,
+	topic string, partition int32, batch []byte) (int64, error) {
+	e := new(encoder)
+	e.putNullableString("")
+	e.putInt16(acks)
+	e.putInt32(int32(timeout / time.Millisecond))
+	e.putArrayLen(1)
+	e.putString(topic)
+	e.putArrayLen(1)
+	e.putInt32(partition)
+	e.putBytes(batch)
+	if acks == 0 {
+		return -1, c.Send(APIKeyProduce, e.Bytes(), timeout)
+	}
+	resp, err := c.Do(APIKeyProduce, e.Bytes(), timeout*2)
+	if err != nil {
+		return -1, err
+	}
+
+	d := &decoder{data: resp}
+	offset := int64(-1)
+	kerr := ErrNone
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string()
+		for j, pn := 0, d.arrayLen(); j < pn; j++ {
+			d.int32()
+			kerr = KError(d.int16())
+			offset = d.int64()
+			d.int64()
+		}
+	}
+	if d.err != nil {
+		return -1, d.err
+	}
+	if kerr != ErrNone {
+		return -1, kerr
+	}
+	return offset, nil
+}
+
+// requestFetch Fetch v4（read_uncommitted）
+func requestFetch(c *conn, maxWait time.Duration, minBytes, maxBytes int32,
+	topic string, offsets map[int32]int64) (map[int32]*fetchPartition, error) {
+	e := new(encoder)
+	e.putInt32(-1)
+	e.putInt32(int32(maxWait / time.Millisecond))
+	e.putInt32(minBytes)
+	e.putInt32(maxBytes * int32(len(offsets)))
+	e.putInt8(0)
+	e.putArrayLen(1)
+	e.putString(topic)
+	e.putArrayLen(len(offsets))
+	for partition, offset := range offsets {
+		e.putInt32(partition)
+		e.putInt64(offset)
+		e.putInt32(maxBytes)
+	}
+	resp, err := c.Do(APIKeyFetch, e.Bytes(), maxWait+DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{data: resp}
+	d.int32()
+	result := make(map[int32]*fetchPartition)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string()
+		for j, pn := 0, d.arrayLen(); j < pn; j++ {
+			partition := d.int32()
+			p := &fetchPartition{
+				Err:           KError(d.int16()),
+				HighWatermark: d.int64(),
+			}
+			d.int64()
+			for k, an := 0, d.arrayLen(); k < an; k++ {
+				d.int64()
+				d.int64()
+			}
+			p.Records = d.bytes()
+			result[partition] = p
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return result, nil
+}
+
+// requestListOffsets ListOffsets v1，timestamp为OffsetEarliest或OffsetLatest
+func requestListOffsets(c *conn, timeout time.Duration, topic string,
+	partitions []int32, timestamp int64) (map[int32]int64, error) {
+	e := new(encoder)
+	e.putInt32(-1)
+	e.putArrayLen(1)
+	e.putString(topic)
+	e.putArrayLen(len(partitions))
+	for _, partition := range partitions {
+		e.putInt32(partition)
+		e.putInt64(timestamp)
+	}
+	resp, err := c.Do(APIKeyListOffsets, e.Bytes(), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{data: resp}
+	offsets := make(map[int32]int64)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string()
+		for j, pn := 0, d.arrayLen(); j < pn; j++ {
+			partition := d.int32()
+			kerr := KError(d.int16())
+			d.int64()
+			offset := d.int64()
+			if d.err == nil && kerr != ErrNone {
+				return nil, kerr
+			}
+			offsets[partition] = offset
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return offsets, nil
+}
+
+// requestFindCoordinator FindCoordinator v1，返回消费组coordinator的地址
+func requestFindCoordinator(c *conn, timeout time.Duration, group string) (string, error) {
+	e := new(encoder)
+	e.putString(group)
+	e.putInt8(0)
+	resp, err := c.Do(APIKeyFindCoordinator, e.Bytes(), timeout)
+	if err != nil {
+		return "", err
+	}
+
+	d := &decoder{data: resp}
+	d.int32()
+	kerr := KError(d.int16())
+	message := d.string()
+	d.int32()
+	host := d.string()
+	port := d.int32()
+	if d.err != nil {
+		return "", d.err
+	}
+	if kerr != ErrNone {
+		return "", fmt.Errorf("%s %s", kerr, message)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// requestOffsetCommit OffsetCommit v2，不加入消费组（generation为-1）直接提交offset
+func requestOffsetCommit(c *conn, timeout time.Duration, group, topic string,
+	offsets map[int32]int64) error {
+	e := new(encoder)
+	e.putString(group)
+	e.putInt32(-1)
+	e.putString("")
+	e.putInt64(-1)
+	e.putArrayLen(1)
+	e.putString(topic)
+	e.putArrayLen(len(offsets))
+	for partition, offset := range offsets {
+		e.putInt32(partition)
+		e.putInt64(offset)
+		e.putNullableString("")
+	}
+	resp, err := c.Do(APIKeyOffsetCommit, e.Bytes(), timeout)
+	if err != nil {
+		return err
+	}
+
+	d := &decoder{data: resp}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string()
+		for j, pn := 0, d.arrayLen(); j < pn; j++ {
+			d.int32()
+			if kerr := KError(d.int16()); d.err == nil && kerr != ErrNone {
+				return kerr
+			}
+		}
+	}
+	return d.err
+}
+
+// requestOffsetFetch OffsetFetch v1，没有提交过的partition返回-1
+func requestOffsetFetch(c *conn, timeout time.Duration, group, topic string,
+	partitions []int32) (map[int32]int64, error) {
+	e := new(encoder)
+	e.putString(group)
+	e.putArrayLen(1)
+	e.putString(topic)
+	e.putArrayLen(len(partitions))
+	for _, partition := range partitions {
+		e.putInt32(partition)
+	}
+	resp, err := c.Do(APIKeyOffsetFetch, e.Bytes(), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{data: resp}
+	offsets := make(map[int32]int64)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string()
+		for j, pn := 0, d.arrayLen(); j < pn; j++ {
+			partition := d.int32()
+			offset := d.int64()
+			d.string()
+			if kerr := KError(d.int16()); d.err == nil && kerr != ErrNone {
+				return nil, kerr
+			}
+			offsets[partition] = offset
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return offsets, nil
+}
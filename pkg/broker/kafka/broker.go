@@ -0,0 +1,274 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+	"github.com/zhangpeihao/zim/pkg/define"
+)
+
+const (
+	// Name 调用类型的名称
+	Name = "kafka"
+	// DefaultAddress 默认Kafka地址
+	DefaultAddress = "127.0.0.1:9092"
+	// DefaultClientID 默认client id
+	DefaultClientID = "zim"
+	// DefaultGroup 默认消费组名
+	DefaultGroup = "zim"
+	// DefaultAcks 默认acks
+	DefaultAcks = 1
+	// DefaultTimeout 默认请求超时（单位：毫秒）
+	DefaultTimeout = 5000
+	// DefaultMaxWait 默认Fetch最长等待时间（单位：毫秒）
+	DefaultMaxWait = 500
+	// DefaultMinBytes 默认Fetch最少返回字节数
+	DefaultMinBytes = 1
+	// DefaultMaxBytes 默认每个partition每次Fetch的最大字节数
+	DefaultMaxBytes = 1024 * 1024
+	// DefaultMaxRetry 默认处理失败的最大尝试次数
+	DefaultMaxRetry = 3
+	// DefaultBackoff 默认处理失败后重试的基础等待时间（单位：毫秒）
+	DefaultBackoff = 1000
+	// DefaultReconnectInterval 默认断线重连间隔（单位：毫秒）
+	DefaultReconnectInterval = 1000
+	// OffsetResetEarliest 没有提交过offset时从最早的消息开始消费
+	OffsetResetEarliest = "earliest"
+	// OffsetResetLatest 没有提交过offset时从最新的消息开始消费
+	OffsetResetLatest = "latest"
+	// DialTimeout 连接超时
+	DialTimeout = time.Second * 5
+	// MaxTopicLength Kafka topic的最大长度
+	MaxTopicLength = 249
+)
+
+var (
+	// ErrInvalidTopic tag转义后不是有效的Kafka topic
+	ErrInvalidTopic = errors.New("kafka invalid topic")
+)
+
+// BrokerImpl Kafka协议实现的Broker
+type BrokerImpl struct {
+	sync.Mutex
+	// Address bootstrap broker地址
+	Address string
+	// ClientID client id
+	ClientID string
+	// Group 提交offset使用的消费组名
+	Group string
+	// Acks 发布时需要的确认数：-1（所有ISR），0（不确认），1（leader）
+	Acks int16
+	// Timeout 请求超时
+	Timeout time.Duration
+	// OffsetReset 没有提交过offset时的起始位置
+	OffsetReset string
+	// MaxWait Fetch最长等待时间
+	MaxWait time.Duration
+	// MinBytes Fetch最少返回字节数
+	MinBytes int32
+	// MaxBytes 每个partition每次Fetch的最大字节数
+	MaxBytes int32
+	// MaxRetry 处理失败的最大尝试次数，超过后跳过消息，0表示不限制
+	MaxRetry int
+	// Backoff 处理失败后重试的基础等待时间
+	Backoff time.Duration
+	// ReconnectInterval 断线重连间隔
+	ReconnectInterval time.Duration
+	// producers 发布连接（地址->连接）
+	producers map[string]*conn
+	// brokers 节点编号对应的地址
+	brokers map[int32]string
+	// topics 发布使用的topic元数据
+	topics map[string]*topicMetadata
+	// counter 轮询选择partition的计数
+	counter uint32
+	// producerLocker 发布连接和元数据锁
+	producerLocker sync.Mutex
+	// consumers 订阅连接
+	consumers map[*conn]struct{}
+	// ctx 上下文接口
+	ctx context.Context
+	// closing 关闭信号
+	closing chan struct{}
+}
+
+func init() {
+	register.Register(Name, NewKafkaBroker)
+}
+
+// NewKafkaBroker 新建服务，连接在首次使用时建立
+func NewKafkaBroker(viperPerfix string) (broker.Broker, error) {
+	glog.Infoln("broker::kafka::NewKafkaBroker")
+	b := &BrokerImpl{
+		Address:           viper.GetString(viperPerfix + ".kafka.address"),
+		ClientID:          viper.GetString(viperPerfix + ".kafka.client-id"),
+		Group:             viper.GetString(viperPerfix + ".kafka.group"),
+		Acks:              int16(viper.GetInt(viperPerfix + ".kafka.acks")),
+		Timeout:           time.Duration(viper.GetInt(viperPerfix+".kafka.timeout")) * time.Millisecond,
+		OffsetReset:       viper.GetString(viperPerfix + ".kafka.offset-reset"),
+		MaxWait:           time.Duration(viper.GetInt(viperPerfix+".kafka.max-wait")) * time.Millisecond,
+		MinBytes:          int32(viper.GetInt(viperPerfix + ".kafka.min-bytes")),
+		MaxBytes:          int32(viper.GetInt(viperPerfix + ".kafka.max-bytes")),
+		MaxRetry:          viper.GetInt(viperPerfix + ".kafka.max-retry"),
+		Backoff:           time.Duration(viper.GetInt(viperPerfix+".kafka.backoff")) * time.Millisecond,
+		ReconnectInterval: time.Duration(viper.GetInt(viperPerfix+".kafka.reconnect-interval")) * time.Millisecond,
+		producers:         make(map[string]*conn),
+		brokers:           make(map[int32]string),
+		topics:            make(map[string]*topicMetadata),
+		consumers:         make(map[*conn]struct{}),
+		closing:           make(chan struct{}),
+	}
+	if len(b.Address) == 0 {
+		b.Address = DefaultAddress
+	}
+	if len(b.ClientID) == 0 {
+		b.ClientID = DefaultClientID
+	}
+	if len(b.Group) == 0 {
+		b.Group = DefaultGroup
+	}
+	if !viper.IsSet(viperPerfix + ".kafka.acks") {
+		b.Acks = DefaultAcks
+	}
+	if b.Acks < -1 || b.Acks > 1 {
+		glog.Errorf("broker::kafka::NewKafkaBroker() invalid acks: %d\n", b.Acks)
+		return nil, define.ErrInvalidParameter
+	}
+	if b.Timeout <= 0 {
+		b.Timeout = DefaultTimeout * time.Millisecond
+	}
+	switch b.OffsetReset {
+	case "":
+		b.OffsetReset = OffsetResetEarliest
+	case OffsetResetEarliest, OffsetResetLatest:
+	default:
+		glog.Errorf("broker::kafka::NewKafkaBroker() invalid offset-reset: %s\n", b.OffsetReset)
+		return nil, define.ErrInvalidParameter
+	}
+	if b.MaxWait <= 0 {
+		b.MaxWait = DefaultMaxWait * time.Millisecond
+	}
+	if b.MinBytes <= 0 {
+		b.MinBytes = DefaultMinBytes
+	}
+	if b.MaxBytes <= 0 {
+		b.MaxBytes = DefaultMaxBytes
+	}
+	if !viper.IsSet(viperPerfix + ".kafka.max-retry") {
+		b.MaxRetry = DefaultMaxRetry
+	}
+	if b.Backoff <= 0 {
+		b.Backoff = DefaultBackoff * time.Millisecond
+	}
+	if b.ReconnectInterval <= 0 {
+		b.ReconnectInterval = DefaultReconnectInterval * time.Millisecond
+	}
+	return b, nil
+}
+
+// Run 运行
+func (b *BrokerImpl) Run(ctx context.Context) error {
+	glog.Infof("broker::kafka::Run() address: %s\n", b.Address)
+	b.ctx = ctx
+	if ctx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				b.Close(0)
+			case <-b.closing:
+			}
+		}()
+	}
+	return nil
+}
+
+// Close 关闭所有连接
+func (b *BrokerImpl) Close(timeout time.Duration) error {
+	glog.Warningln("broker::kafka::Close()")
+	defer glog.Warningln("broker::kafka::Close() Done")
+	b.Lock()
+	defer b.Unlock()
+	select {
+	case <-b.closing:
+		return nil
+	default:
+		close(b.closing)
+	}
+	b.producerLocker.Lock()
+	for address, c := range b.producers {
+		c.Close()
+		delete(b.producers, address)
+	}
+	b.producerLocker.Unlock()
+	for c := range b.consumers {
+		c.Close()
+	}
+	return nil
+}
+
+// String 发布
+func (b *BrokerImpl) String() string {
+	return Name
+}
+
+// isClosing 是否已关闭
+func (b *BrokerImpl) isClosing() bool {
+	select {
+	case <-b.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// dialConsumer 建立订阅连接，Broker已关闭时返回错误
+func (b *BrokerImpl) dialConsumer(address string) (*conn, error) {
+	c, err := dial(address, b.ClientID, DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.isClosing() {
+		c.Close()
+		return nil, define.ErrConnectionClosed
+	}
+	b.consumers[c] = struct{}{}
+	return c, nil
+}
+
+// closeConsumer 关闭订阅连接
+func (b *BrokerImpl) closeConsumer(c *conn) {
+	b.Lock()
+	delete(b.consumers, c)
+	b.Unlock()
+	c.Close()
+}
+
+// Topic 将tag转换为Kafka的topic。Kafka的topic只能包含字母、数字、'.'、'_'和'-'，
+// 其他字符（包括'/'和'.'）转义为'.'加两位十六进制，转义后为空或者超过MaxTopicLength时返回ErrInvalidTopic
+func Topic(tag string) (string, error) {
+	var topic bytes.Buffer
+	for i := 0; i < len(tag); i++ {
+		c := tag[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '-' {
+			topic.WriteByte(c)
+		} else {
+			fmt.Fprintf(&topic, ".%02x", c)
+		}
+	}
+	if topic.Len() == 0 || topic.Len() > MaxTopicLength {
+		return "", ErrInvalidTopic
+	}
+	return topic.String(), nil
+}
@@ -0,0 +1,231 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package kafka
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/brokertest"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	viperPerfix = "test"
+)
+
+func init() {
+	flag.Set("v", "4")
+	flag.Set("logtostderr", "true")
+
+	viper.Set(viperPerfix+".kafka.max-wait", 50)
+	viper.Set(viperPerfix+".kafka.max-retry", 2)
+	viper.Set(viperPerfix+".kafka.backoff", 10)
+	viper.Set(viperPerfix+".kafka.reconnect-interval", 50)
+}
+
+func newTestKafka(t *testing.T) *fakeKafka {
+	k, err := newFakeKafka(2)
+	if err != nil {
+		t.Fatal("newFakeKafka() error:", err)
+	}
+	return k
+}
+
+func newTestBroker(t *testing.T, k *fakeKafka) broker.Broker {
+	viper.Set(viperPerfix+".kafka.address", k.Address())
+	b, err := NewKafkaBroker(viperPerfix)
+	if err != nil {
+		t.Fatal("NewKafkaBroker() error:", err)
+	}
+	if err = b.Run(nil); err != nil {
+		t.Fatal("b.Run() error:", err)
+	}
+	return b
+}
+
+// receiveAll 接收n条消息，不同partition之间的消息顺序不确定
+func receiveAll(t *testing.T, signal chan *protocol.Command, n int) map[string]*protocol.Command {
+	cmds := make(map[string]*protocol.Command)
+	for i := 0; i < n; i++ {
+		cmd := brokertest.Receive(t, signal)
+		cmds[string(cmd.Payload)] = cmd
+	}
+	return cmds
+}
+
+func TestContract(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) (broker.Broker, func()) {
+		k := newTestKafka(t)
+		return newTestBroker(t, k), k.Close
+	})
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	k := newTestKafka(t)
+	defer k.Close()
+	b := newTestBroker(t, k)
+	defer b.Close(time.Second)
+
+	if b.String() != Name {
+		t.Errorf("b.String: %s\n", b.String())
+	}
+
+	// 不同的信令版本使用对应的串行化格式
+	var cmds []*protocol.Command
+	for i := 0; i < 4; i++ {
+		cmd := brokertest.NewCommand(i)
+		if i%2 == 1 {
+			cmd.Version = "j1"
+		}
+		if resp, err := b.Publish("push", cmd); err != nil || resp != nil {
+			t.Fatalf("b.Publish() got: %v, %v\n", resp, err)
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	signal := make(chan *protocol.Command, 16)
+	go b.Subscribe("push", func(tag string, cmd *protocol.Command) error {
+		signal <- cmd
+		return nil
+	})
+	got := receiveAll(t, signal, len(cmds))
+	for _, cmd := range cmds {
+		if !cmd.Equal(got[string(cmd.Payload)]) {
+			t.Errorf("Subscribe expect: %s, got: %s\n", cmd, got[string(cmd.Payload)])
+		}
+	}
+
+	cmd := brokertest.NewCommand(4)
+	b.Publish("push", cmd)
+	if got := brokertest.Receive(t, signal); !cmd.Equal(got) {
+		t.Errorf("Subscribe expect: %s, got: %s\n", cmd, got)
+	}
+
+	// 消息轮询发布到两个partition，处理后提交offset
+	time.Sleep(time.Millisecond * 100)
+	committed := k.Committed(DefaultGroup, "push")
+	if committed[0]+committed[1] != 5 {
+		t.Errorf("committed offsets: %v\n", committed)
+	}
+}
+
+func TestCommittedOffset(t *testing.T) {
+	k := newTestKafka(t)
+	defer k.Close()
+	b := newTestBroker(t, k)
+	for i := 0; i < 2; i++ {
+		b.Publish("offset", brokertest.NewCommand(i))
+	}
+	signal := make(chan *protocol.Command, 16)
+	go b.Subscribe("offset", func(tag string, cmd *protocol.Command) error {
+		signal <- cmd
+		return nil
+	})
+	receiveAll(t, signal, 2)
+	time.Sleep(time.Millisecond * 100)
+	b.Close(time.Second)
+
+	// 重启后从已提交的offset继续消费
+	b = newTestBroker(t, k)
+	defer b.Close(time.Second)
+	cmd := brokertest.NewCommand(2)
+	b.Publish("offset", cmd)
+	go b.Subscribe("offset", func(tag string, cmd *protocol.Command) error {
+		signal <- cmd
+		return nil
+	})
+	if got := brokertest.Receive(t, signal); !cmd.Equal(got) {
+		t.Errorf("Subscribe expect: %s, got: %s\n", cmd, got)
+	}
+	select {
+	case got := <-signal:
+		t.Errorf("unexpected command: %s\n", got)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestRetryAndSkip(t *testing.T) {
+	k, err := newFakeKafka(1)
+	if err != nil {
+		t.Fatal("newFakeKafka() error:", err)
+	}
+	defer k.Close()
+	b := newTestBroker(t, k)
+	defer b.Close(time.Second)
+
+	b.Publish("retry", brokertest.NewCommand(1))
+	b.Publish("retry", brokertest.NewCommand(2))
+
+	signal := make(chan *protocol.Command, 16)
+	go b.Subscribe("retry", func(tag string, cmd *protocol.Command) error {
+		signal <- cmd
+		if string(cmd.Payload) == "foo bar 1" {
+			return errors.New("poison")
+		}
+		return nil
+	})
+	// 第一条消息尝试max-retry次后跳过
+	for _, expect := range []string{"foo bar 1", "foo bar 1", "foo bar 2"} {
+		if got := brokertest.Receive(t, signal); string(got.Payload) != expect {
+			t.Fatalf("expect %s, got: %s\n", expect, got.Payload)
+		}
+	}
+}
+
+func TestTopic(t *testing.T) {
+	testCases := []struct {
+		tag    string
+		expect string
+		err    error
+	}{
+		{"tag", "tag", nil},
+		{"msg/order", "msg.2forder", nil},
+		{"msg.2forder", "msg.2e2forder", nil},
+		{"a_b-c", "a_b-c", nil},
+		{"", "", ErrInvalidTopic},
+		{strings.Repeat("a/", 70), "", ErrInvalidTopic},
+	}
+	for index, testCase := range testCases {
+		if topic, err := Topic(testCase.tag); topic != testCase.expect || err != testCase.err {
+			t.Errorf("Case(%d): Topic(%s) expect: %s, %v, got: %s, %v\n",
+				index+1, testCase.tag, testCase.expect, testCase.err, topic, err)
+		}
+	}
+
+	// 包含'/'的tag可以发布和订阅
+	k := newTestKafka(t)
+	defer k.Close()
+	b := newTestBroker(t, k)
+	defer b.Close(time.Second)
+	cmd := brokertest.NewCommand(1)
+	if _, err := b.Publish("msg/order", cmd); err != nil {
+		t.Fatal("b.Publish() error:", err)
+	}
+	signal := make(chan *protocol.Command, 1)
+	go b.Subscribe("msg/order", func(tag string, got *protocol.Command) error {
+		if tag != "msg/order" {
+			t.Errorf("handler tag: %s\n", tag)
+		}
+		signal <- got
+		return nil
+	})
+	if got := brokertest.Receive(t, signal); !cmd.Equal(got) {
+		t.Errorf("Subscribe expect: %s, got: %s\n", cmd, got)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if committed := k.Committed(DefaultGroup, "msg.2forder"); len(committed) == 0 {
+		t.Error("offsets should be committed to the escaped topic")
+	}
+	if _, err := b.Publish("", cmd); err != ErrInvalidTopic {
+		t.Errorf("b.Publish() empty tag expect ErrInvalidTopic, got: %v\n", err)
+	}
+	if err := b.Subscribe("", nil); err != ErrInvalidTopic {
+		t.Errorf("b.Subscribe() empty tag expect ErrInvalidTopic, got: %v\n", err)
+	}
+}
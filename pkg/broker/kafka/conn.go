@@ -0,0 +1,105 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxResponseSize 最大响应长度
+	MaxResponseSize = 64 * 1024 * 1024
+)
+
+var (
+	// ErrResponseTooLarge 响应长度超过限制
+	ErrResponseTooLarge = errors.New("kafka response too large")
+	// ErrCorrelationID 响应的correlation id与请求不一致
+	ErrCorrelationID = errors.New("kafka correlation id mismatch")
+)
+
+// conn Kafka TCP连接，同一时间只有一个请求
+type conn struct {
+	sync.Mutex
+	c             net.Conn
+	br            *bufio.Reader
+	clientID      string
+	correlationID int32
+}
+
+// dial 建立连接
+func dial(address, clientID string, timeout time.Duration) (*conn, error) {
+	c, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{
+		c:        c,
+		br:       bufio.NewReader(c),
+		clientID: clientID,
+	}, nil
+}
+
+// write 发送请求，返回correlation id
+func (c *conn) write(apiKey int16, body []byte) (int32, error) {
+	c.correlationID++
+	header := new(encoder)
+	header.putInt32(0)
+	header.putInt16(apiKey)
+	header.putInt16(apiVersions[apiKey])
+	header.putInt32(c.correlationID)
+	header.putString(c.clientID)
+	header.Write(body)
+	data := header.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	_, err := c.c.Write(data)
+	return c.correlationID, err
+}
+
+// Do 发送请求并读取响应（不包含correlation id）
+func (c *conn) Do(apiKey int16, body []byte, timeout time.Duration) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.c.SetDeadline(time.Now().Add(timeout))
+	defer c.c.SetDeadline(time.Time{})
+	correlationID, err := c.write(apiKey, body)
+	if err != nil {
+		return nil, err
+	}
+	var size int32
+	if err = binary.Read(c.br, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 || size > MaxResponseSize {
+		return nil, ErrResponseTooLarge
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(c.br, data); err != nil {
+		return nil, err
+	}
+	if int32(binary.BigEndian.Uint32(data)) != correlationID {
+		return nil, ErrCorrelationID
+	}
+	return data[4:], nil
+}
+
+// Send 发送不需要响应的请求（acks为0的Produce）
+func (c *conn) Send(apiKey int16, body []byte, timeout time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+	c.c.SetWriteDeadline(time.Now().Add(timeout))
+	defer c.c.SetWriteDeadline(time.Time{})
+	_, err := c.write(apiKey, body)
+	return err
+}
+
+// Close 关闭连接
+func (c *conn) Close() error {
+	return c.c.Close()
+}
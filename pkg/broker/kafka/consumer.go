@@ -0,0 +1,230 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package kafka
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/shutdown"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/define"
+)
+
+// Subscribe 订阅tag转义后的topic的所有partition，断线自动重连，阻塞直到Broker关闭
+func (b *BrokerImpl) Subscribe(tag string, handler broker.SubscribeHandler) error {
+	glog.Infof("broker::kafka::Subscribe(%s)\n", tag)
+	defer glog.Infof("broker::kafka::Subscribe(%s) done\n", tag)
+	topic, err := Topic(tag)
+	if err != nil {
+		glog.Errorf("broker::kafka::Subscribe(%s) error: %s\n", tag, err)
+		return err
+	}
+	if b.ctx != nil {
+		if err := shutdown.ExitWaitGroupAdd(b.ctx, 1); err != nil {
+			glog.Errorf("broker::kafka::Subscribe(%s) ExitWaitGroupAdd error: %s\n", tag, err)
+			return err
+		}
+		defer shutdown.ExitWaitGroupDone(b.ctx)
+	}
+	for {
+		err := b.consume(tag, topic, handler)
+		if b.isClosing() {
+			return nil
+		}
+		glog.Warningf("broker::kafka::Subscribe(%s) error: %s, reconnect after %s\n",
+			tag, err, b.ReconnectInterval)
+		select {
+		case <-time.After(b.ReconnectInterval):
+		case <-b.closing:
+			return nil
+		}
+	}
+}
+
+// consume 获取元数据和已提交的offset，循环Fetch并处理消息，直到出错
+func (b *BrokerImpl) consume(tag, topic string, handler broker.SubscribeHandler) error {
+	conns := make(map[string]*conn)
+	defer func() {
+		for _, c := range conns {
+			b.closeConsumer(c)
+		}
+	}()
+	connect := func(address string) (*conn, error) {
+		if c, found := conns[address]; found {
+			return c, nil
+		}
+		c, err := b.dialConsumer(address)
+		if err == nil {
+			conns[address] = c
+		}
+		return c, err
+	}
+
+	bootstrap, err := connect(b.Address)
+	if err != nil {
+		return err
+	}
+	m, err := requestMetadata(bootstrap, b.Timeout, topic)
+	if err != nil {
+		return err
+	}
+	t, found := m.Topics[topic]
+	if !found {
+		return ErrUnknownTopicOrPartition
+	}
+	if t.Err != ErrNone {
+		return t.Err
+	}
+	// 按leader分组partition
+	leaders := make(map[string][]int32)
+	var partitions []int32
+	for _, p := range t.Partitions {
+		address, found := m.Brokers[p.Leader]
+		if p.Err != ErrNone || !found {
+			return ErrLeaderNotAvailable
+		}
+		leaders[address] = append(leaders[address], p.ID)
+		partitions = append(partitions, p.ID)
+	}
+	if len(partitions) == 0 {
+		return ErrLeaderNotAvailable
+	}
+
+	address, err := requestFindCoordinator(bootstrap, b.Timeout, b.Group)
+	if err != nil {
+		return err
+	}
+	coordinator, err := connect(address)
+	if err != nil {
+		return err
+	}
+	offsets, err := requestOffsetFetch(coordinator, b.Timeout, b.Group, topic, partitions)
+	if err != nil {
+		return err
+	}
+	for address, ids := range leaders {
+		c, err := connect(address)
+		if err != nil {
+			return err
+		}
+		if err = b.resetOffsets(c, topic, ids, offsets); err != nil {
+			return err
+		}
+	}
+	glog.Infof("broker::kafka::consume(%s) offsets: %v\n", tag, offsets)
+
+	for {
+		for address, ids := range leaders {
+			if b.isClosing() {
+				return define.ErrConnectionClosed
+			}
+			c, err := connect(address)
+			if err != nil {
+				return err
+			}
+			fetchOffsets := make(map[int32]int64)
+			for _, id := range ids {
+				fetchOffsets[id] = offsets[id]
+			}
+			result, err := requestFetch(c, b.MaxWait, b.MinBytes, b.MaxBytes, topic, fetchOffsets)
+			if err != nil {
+				return err
+			}
+			committed := make(map[int32]int64)
+			for partition, p := range result {
+				switch p.Err {
+				case ErrNone:
+				case ErrOffsetOutOfRange:
+					glog.Warningf("broker::kafka::consume(%s) partition %d offset %d out of range, reset to %s\n",
+						tag, partition, offsets[partition], b.OffsetReset)
+					delete(offsets, partition)
+					if err = b.resetOffsets(c, topic, []int32{partition}, offsets); err != nil {
+						return err
+					}
+					continue
+				default:
+					return p.Err
+				}
+				records, next, err := DecodeRecords(p.Records)
+				if err != nil {
+					glog.Warningf("broker::kafka::consume(%s) partition %d decode error: %s\n", tag, partition, err)
+				}
+				for _, r := range records {
+					// 返回的batch可能包含请求offset之前的消息
+					if r.Offset < offsets[partition] {
+						continue
+					}
+					if err = b.handleRecord(tag, partition, r, handler); err != nil {
+						return err
+					}
+					offsets[partition] = r.Offset + 1
+					committed[partition] = offsets[partition]
+				}
+				if next > offsets[partition] {
+					offsets[partition] = next
+					committed[partition] = next
+				}
+			}
+			if len(committed) > 0 {
+				if err = requestOffsetCommit(coordinator, b.Timeout, b.Group, topic, committed); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// resetOffsets 没有提交过offset（-1）的partition，根据OffsetReset查询起始offset
+func (b *BrokerImpl) resetOffsets(c *conn, topic string, partitions []int32, offsets map[int32]int64) error {
+	var reset []int32
+	for _, partition := range partitions {
+		if offset, found := offsets[partition]; !found || offset < 0 {
+			reset = append(reset, partition)
+		}
+	}
+	if len(reset) == 0 {
+		return nil
+	}
+	timestamp := OffsetEarliest
+	if b.OffsetReset == OffsetResetLatest {
+		timestamp = OffsetLatest
+	}
+	result, err := requestListOffsets(c, b.Timeout, topic, reset, timestamp)
+	if err != nil {
+		return err
+	}
+	for partition, offset := range result {
+		offsets[partition] = offset
+	}
+	return nil
+}
+
+// handleRecord 处理一条消息，失败时等待后重试，超过MaxRetry后跳过
+// Broker关闭时返回错误，消息不会被提交
+func (b *BrokerImpl) handleRecord(tag string, partition int32, r *Record,
+	handler broker.SubscribeHandler) error {
+	cmd, err := broker.Unmarshal(r.Value)
+	if err != nil {
+		glog.Errorf("broker::kafka::handleRecord(%s) partition %d offset %d unmarshal error: %s, skip\n",
+			tag, partition, r.Offset, err)
+		return nil
+	}
+	for attempt := 1; ; attempt++ {
+		if err = broker.Handle(handler, tag, cmd); err == nil {
+			return nil
+		}
+		if b.MaxRetry > 0 && attempt >= b.MaxRetry {
+			glog.Warningf("broker::kafka::handleRecord(%s) partition %d offset %d failed %d times, skip: %s\n",
+				tag, partition, r.Offset, attempt, err)
+			return nil
+		}
+		glog.Warningf("broker::kafka::handleRecord(%s) partition %d offset %d attempt %d error: %s\n",
+			tag, partition, r.Offset, attempt, err)
+		select {
+		case <-time.After(b.Backoff * time.Duration(attempt)):
+		case <-b.closing:
+			return define.ErrConnectionClosed
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+/*
+Package kafka Kafka协议实现的Broker
+
+直接实现Kafka TCP协议（Kafka 0.11以上版本），不依赖第三方客户端库。
+
+tag: 转义后作为topic名，topic不存在时由broker自动创建（需要开启auto.create.topics.enable）。
+Kafka的topic只能包含字母、数字、'.'、'_'和'-'，tag中的其他字符（包括'/'和'.'）转义为'.'加两位十六进制，
+例如：tag为"msg/order"，对应的topic为"msg.2forder"。转义后为空或者超过249个字符时返回ErrInvalidTopic
+
+Command: 使用信令串行化格式（plaintext或alljson）编码为消息体，信令版本没有对应的
+串行化格式时使用plaintext，并在消息体前加"#<信令版本>\n"保留信令版本。外部服务直接发布到topic的消息也需要使用这两种格式。
+
+Publish: 轮询选择partition，每条消息作为一个不压缩的RecordBatch发送到partition的leader，
+没有响应信令
+
+Subscribe: 消费topic的所有partition，按leader分组Fetch；处理后的offset提交到group。
+不使用消费组成员协议（JoinGroup），多个订阅者使用同一个group时会重复消费。
+SubscribeHandler返回error时等待后重试，尝试max-retry次后跳过该消息。
+不支持压缩的消息，收到时记录日志并跳过。
+
+配置（viper参数前缀 + ".kafka."）：
+
+* address: bootstrap broker地址，默认"127.0.0.1:9092"
+
+* client-id: client id，默认"zim"
+
+* group: 提交offset使用的消费组名，默认"zim"
+
+* acks: 发布时需要的确认数，-1（所有ISR），0（不确认），1（leader），默认1
+
+* timeout: 请求超时（单位：毫秒），默认5000
+
+* offset-reset: 没有提交过offset时的起始位置，"earliest"或"latest"，默认"earliest"
+
+* max-wait: Fetch最长等待时间（单位：毫秒），默认500
+
+* min-bytes: Fetch最少返回字节数，默认1
+
+* max-bytes: 每个partition每次Fetch的最大字节数，默认1048576
+
+* max-retry: 处理失败的最大尝试次数，0表示不限制，默认3
+
+* backoff: 处理失败后重试的基础等待时间（单位：毫秒），默认1000
+
+* reconnect-interval: 断线重连间隔（单位：毫秒），默认1000
+*/
+package kafka
@@ -0,0 +1,124 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package kafka
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// Publish 发布到tag转义后的topic，轮询选择partition，没有响应信令
+func (b *BrokerImpl) Publish(tag string, cmd *protocol.Command) (*protocol.Command, error) {
+	glog.Infof("broker::kafka::Publish(%s)%s\n", tag, cmd)
+	topic, err := Topic(tag)
+	if err != nil {
+		glog.Warningf("broker::kafka::Publish(%s) error: %s\n", tag, err)
+		return nil, err
+	}
+	value, err := broker.Marshal(cmd)
+	if err != nil {
+		glog.Warningf("broker::kafka::Publish(%s) marshal error: %s\n", tag, err)
+		return nil, err
+	}
+	batch := EncodeRecordBatch([]*Record{{
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Value:     value,
+	}})
+
+	b.producerLocker.Lock()
+	defer b.producerLocker.Unlock()
+	// leader变化或连接断开时刷新元数据并重试一次
+	for retry := 0; retry < 2; retry++ {
+		if b.isClosing() {
+			return nil, define.ErrConnectionClosed
+		}
+		var (
+			partition int32
+			c         *conn
+		)
+		if partition, c, err = b.leader(topic); err == nil {
+			if _, err = requestProduce(c, b.Acks, b.Timeout, topic, partition, batch); err == nil {
+				return nil, nil
+			}
+		}
+		switch err {
+		case ErrUnknownTopicOrPartition, ErrLeaderNotAvailable, ErrNotLeaderForPartition, ErrRequestTimedOut:
+		default:
+			if _, ok := err.(KError); ok {
+				glog.Warningf("broker::kafka::Publish(%s) error: %s\n", tag, err)
+				return nil, err
+			}
+			if c != nil {
+				b.closeProducer(c)
+			}
+		}
+		glog.Warningf("broker::kafka::Publish(%s) error: %s, refresh metadata\n", tag, err)
+		delete(b.topics, topic)
+	}
+	return nil, err
+}
+
+// leader 选择partition，返回partition编号和leader连接，调用者需持有producerLocker
+func (b *BrokerImpl) leader(topic string) (int32, *conn, error) {
+	t, found := b.topics[topic]
+	if !found {
+		c, err := b.producer(b.Address)
+		if err != nil {
+			return 0, nil, err
+		}
+		m, err := requestMetadata(c, b.Timeout, topic)
+		if err != nil {
+			b.closeProducer(c)
+			return 0, nil, err
+		}
+		for nodeID, address := range m.Brokers {
+			b.brokers[nodeID] = address
+		}
+		if t, found = m.Topics[topic]; !found {
+			return 0, nil, ErrUnknownTopicOrPartition
+		}
+		if t.Err != ErrNone {
+			return 0, nil, t.Err
+		}
+		if len(t.Partitions) == 0 {
+			return 0, nil, ErrLeaderNotAvailable
+		}
+		b.topics[topic] = t
+	}
+	b.counter++
+	p := t.Partitions[b.counter%uint32(len(t.Partitions))]
+	address, found := b.brokers[p.Leader]
+	if p.Err != ErrNone || !found {
+		return 0, nil, ErrLeaderNotAvailable
+	}
+	c, err := b.producer(address)
+	return p.ID, c, err
+}
+
+// producer 获取发布连接，调用者需持有producerLocker
+func (b *BrokerImpl) producer(address string) (*conn, error) {
+	if c, found := b.producers[address]; found {
+		return c, nil
+	}
+	c, err := dial(address, b.ClientID, DialTimeout)
+	if err != nil {
+		glog.Errorf("broker::kafka::producer() dial(%s) error: %s\n", address, err)
+		return nil, err
+	}
+	b.producers[address] = c
+	return c, nil
+}
+
+// closeProducer 关闭发布连接，调用者需持有producerLocker
+func (b *BrokerImpl) closeProducer(c *conn) {
+	for address, pc := range b.producers {
+		if pc == c {
+			delete(b.producers, address)
+		}
+	}
+	c.Close()
+}
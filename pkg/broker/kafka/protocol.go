@@ -0,0 +1,283 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// APIKeyProduce Produce
+	APIKeyProduce int16 = 0
+	// APIKeyFetch Fetch
+	APIKeyFetch int16 = 1
+	// APIKeyListOffsets ListOffsets
+	APIKeyListOffsets int16 = 2
+	// APIKeyMetadata Metadata
+	APIKeyMetadata int16 = 3
+	// APIKeyOffsetCommit OffsetCommit
+	APIKeyOffsetCommit int16 = 8
+	// APIKeyOffsetFetch OffsetFetch
+	APIKeyOffsetFetch int16 = 9
+	// APIKeyFindCoordinator FindCoordinator
+	APIKeyFindCoordinator int16 = 10
+
+	// OffsetEarliest ListOffsets查询最早的offset
+	OffsetEarliest int64 = -2
+	// OffsetLatest ListOffsets查询最新的offset
+	OffsetLatest int64 = -1
+)
+
+// apiVersions 使用的请求版本（Kafka 0.11以上支持，均为非flexible版本）
+var apiVersions = map[int16]int16{
+	APIKeyProduce:         3,
+	APIKeyFetch:           4,
+	APIKeyListOffsets:     1,
+	APIKeyMetadata:        4,
+	APIKeyOffsetCommit:    2,
+	APIKeyOffsetFetch:     1,
+	APIKeyFindCoordinator: 1,
+}
+
+// KError Kafka错误码
+type KError int16
+
+const (
+	// ErrNone 没有错误
+	ErrNone KError = 0
+	// ErrOffsetOutOfRange offset超出范围
+	ErrOffsetOutOfRange KError = 1
+	// ErrCorruptMessage 消息校验失败
+	ErrCorruptMessage KError = 2
+	// ErrUnknownTopicOrPartition topic或partition不存在
+	ErrUnknownTopicOrPartition KError = 3
+	// ErrLeaderNotAvailable 没有leader
+	ErrLeaderNotAvailable KError = 5
+	// ErrNotLeaderForPartition 不是partition的leader
+	ErrNotLeaderForPartition KError = 6
+	// ErrRequestTimedOut 请求超时
+	ErrRequestTimedOut KError = 7
+	// ErrCoordinatorLoadInProgress coordinator正在加载
+	ErrCoordinatorLoadInProgress KError = 14
+	// ErrCoordinatorNotAvailable coordinator不可用
+	ErrCoordinatorNotAvailable KError = 15
+	// ErrNotCoordinator 不是coordinator
+	ErrNotCoordinator KError = 16
+	// ErrInvalidTopicException topic名无效
+	ErrInvalidTopicException KError = 17
+)
+
+var kerrorNames = map[KError]string{
+	ErrOffsetOutOfRange:          "OFFSET_OUT_OF_RANGE",
+	ErrCorruptMessage:            "CORRUPT_MESSAGE",
+	ErrUnknownTopicOrPartition:   "UNKNOWN_TOPIC_OR_PARTITION",
+	ErrLeaderNotAvailable:        "LEADER_NOT_AVAILABLE",
+	ErrNotLeaderForPartition:     "NOT_LEADER_FOR_PARTITION",
+	ErrRequestTimedOut:           "REQUEST_TIMED_OUT",
+	ErrCoordinatorLoadInProgress: "COORDINATOR_LOAD_IN_PROGRESS",
+	ErrCoordinatorNotAvailable:   "COORDINATOR_NOT_AVAILABLE",
+	ErrNotCoordinator:            "NOT_COORDINATOR",
+	ErrInvalidTopicException:     "INVALID_TOPIC_EXCEPTION",
+}
+
+// Error 错误信息
+func (e KError) Error() string {
+	if name, found := kerrorNames[e]; found {
+		return "kafka error: " + name
+	}
+	return fmt.Sprintf("kafka error: %d", int16(e))
+}
+
+var (
+	// ErrMalformed 响应格式错误
+	ErrMalformed = errors.New("kafka malformed response")
+)
+
+// encoder 请求编码
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) putInt8(v int8) {
+	e.WriteByte(byte(v))
+}
+
+func (e *encoder) putInt16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) putInt32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) putInt64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) putBool(v bool) {
+	if v {
+		e.putInt8(1)
+	} else {
+		e.putInt8(0)
+	}
+}
+
+func (e *encoder) putString(s string) {
+	e.putInt16(int16(len(s)))
+	e.WriteString(s)
+}
+
+// putNullableString 空字符串编码为null
+func (e *encoder) putNullableString(s string) {
+	if len(s) == 0 {
+		e.putInt16(-1)
+		return
+	}
+	e.putString(s)
+}
+
+// putBytes nil编码为null
+func (e *encoder) putBytes(b []byte) {
+	if b == nil {
+		e.putInt32(-1)
+		return
+	}
+	e.putInt32(int32(len(b)))
+	e.Write(b)
+}
+
+func (e *encoder) putArrayLen(n int) {
+	e.putInt32(int32(n))
+}
+
+func (e *encoder) putVarint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	e.Write(b[:n])
+}
+
+func (e *encoder) putVarBytes(b []byte) {
+	if b == nil {
+		e.putVarint(-1)
+		return
+	}
+	e.putVarint(int64(len(b)))
+	e.Write(b)
+}
+
+// decoder 响应解码，出错后所有读取返回零值，错误保存在err中
+type decoder struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (d *decoder) remaining() int {
+	return len(d.data) - d.off
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.remaining() < n {
+		d.err = ErrMalformed
+		return nil
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) bool() bool {
+	return d.int8() != 0
+}
+
+// string 同时支持nullable string，null返回空字符串
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// bytes null返回nil
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// arrayLen 数组长度，null数组返回0
+func (d *decoder) arrayLen() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > d.remaining() {
+		d.err = ErrMalformed
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.off:])
+	if n <= 0 {
+		d.err = ErrMalformed
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *decoder) varBytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
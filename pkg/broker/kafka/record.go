@@ -0,0 +1,146 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	// RecordBatchMagic RecordBatch格式版本
+	RecordBatchMagic int8 = 2
+	// recordBatchHeaderSize RecordBatch头长度（到records count为止）
+	recordBatchHeaderSize = 61
+	// crcOffset CRC字段在RecordBatch中的位置
+	crcOffset = 17
+	// attributesOffset attributes字段在RecordBatch中的位置，CRC从这里开始计算
+	attributesOffset = 21
+	// compressionMask 压缩类型掩码
+	compressionMask = 0x07
+	// controlFlag 事务控制batch标记
+	controlFlag = 0x20
+)
+
+var (
+	// ErrCorruptRecord RecordBatch校验失败
+	ErrCorruptRecord = errors.New("kafka corrupt record batch")
+	// ErrUnsupportedRecord 不支持的消息格式（magic小于2）
+	ErrUnsupportedRecord = errors.New("kafka unsupported record format")
+
+	crc32c = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Record Kafka消息
+type Record struct {
+	// Offset 消息offset
+	Offset int64
+	// Timestamp 时间戳（单位：毫秒）
+	Timestamp int64
+	// Key 消息key
+	Key []byte
+	// Value 消息体
+	Value []byte
+}
+
+// EncodeRecordBatch 编码为一个不压缩的RecordBatch（magic 2），offset从0开始，由broker分配实际offset
+func EncodeRecordBatch(records []*Record) []byte {
+	var baseTimestamp, maxTimestamp int64
+	for i, r := range records {
+		if i == 0 || r.Timestamp < baseTimestamp {
+			baseTimestamp = r.Timestamp
+		}
+		if r.Timestamp > maxTimestamp {
+			maxTimestamp = r.Timestamp
+		}
+	}
+
+	body := new(encoder)
+	for i, r := range records {
+		record := new(encoder)
+		record.putInt8(0)
+		record.putVarint(r.Timestamp - baseTimestamp)
+		record.putVarint(int64(i))
+		record.putVarBytes(r.Key)
+		record.putVarBytes(r.Value)
+		record.putVarint(0)
+		body.putVarint(int64(record.Len()))
+		body.Write(record.Bytes())
+	}
+
+	e := new(encoder)
+	e.putInt64(0)
+	e.putInt32(int32(recordBatchHeaderSize - 12 + body.Len()))
+	e.putInt32(-1)
+	e.putInt8(RecordBatchMagic)
+	e.putInt32(0)
+	e.putInt16(0)
+	e.putInt32(int32(len(records) - 1))
+	e.putInt64(baseTimestamp)
+	e.putInt64(maxTimestamp)
+	e.putInt64(-1)
+	e.putInt16(-1)
+	e.putInt32(-1)
+	e.putInt32(int32(len(records)))
+	e.Write(body.Bytes())
+
+	data := e.Bytes()
+	binary.BigEndian.PutUint32(data[crcOffset:], crc32.Checksum(data[attributesOffset:], crc32c))
+	return data
+}
+
+// DecodeRecords 解析Fetch返回的RecordBatch列表
+// 末尾不完整的batch将被忽略；next为已解析batch之后的下一个offset，
+// 压缩和事务控制batch不返回消息，但next会跳过它们
+func DecodeRecords(data []byte) (records []*Record, next int64, err error) {
+	next = -1
+	for len(data) >= 12 {
+		baseOffset := int64(binary.BigEndian.Uint64(data[:8]))
+		size := int(int32(binary.BigEndian.Uint32(data[8:12]))) + 12
+		if size < recordBatchHeaderSize || size > len(data) {
+			break
+		}
+		batch := data[:size]
+		data = data[size:]
+		if int8(batch[16]) != RecordBatchMagic {
+			return records, next, ErrUnsupportedRecord
+		}
+		if binary.BigEndian.Uint32(batch[crcOffset:]) != crc32.Checksum(batch[attributesOffset:], crc32c) {
+			return records, next, ErrCorruptRecord
+		}
+		d := &decoder{data: batch, off: attributesOffset}
+		attributes := d.int16()
+		lastOffsetDelta := d.int32()
+		baseTimestamp := d.int64()
+		d.next(8 + 8 + 2 + 4)
+		count := d.int32()
+		next = baseOffset + int64(lastOffsetDelta) + 1
+		if attributes&(compressionMask|controlFlag) != 0 {
+			if attributes&compressionMask != 0 {
+				err = ErrUnsupportedRecord
+			}
+			continue
+		}
+		for i := 0; i < int(count) && d.err == nil; i++ {
+			length := d.varint()
+			rd := &decoder{data: d.next(int(length))}
+			if d.err != nil {
+				break
+			}
+			rd.int8()
+			r := &Record{Timestamp: baseTimestamp + rd.varint()}
+			r.Offset = baseOffset + rd.varint()
+			r.Key = rd.varBytes()
+			r.Value = rd.varBytes()
+			if rd.err != nil {
+				return records, next, ErrCorruptRecord
+			}
+			records = append(records, r)
+		}
+		if d.err != nil {
+			return records, next, ErrCorruptRecord
+		}
+	}
+	return records, next, err
+}
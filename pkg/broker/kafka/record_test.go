@@ -0,0 +1,44 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestRecordBatch(t *testing.T) {
+	records := []*Record{
+		{Timestamp: 1000, Value: []byte("foo")},
+		{Timestamp: 1002, Key: []byte("key"), Value: []byte("bar")},
+		{Timestamp: 1001},
+	}
+	data := EncodeRecordBatch(records)
+	// 模拟broker分配的offset
+	binary.BigEndian.PutUint64(data, 10)
+	// 连续两个batch，最后一个不完整
+	stream := append(append([]byte(nil), data...), data...)
+	stream = append(stream, data[:len(data)-1]...)
+	binary.BigEndian.PutUint64(stream[len(data):], 13)
+
+	got, next, err := DecodeRecords(stream)
+	if err != nil {
+		t.Fatal("DecodeRecords() error:", err)
+	}
+	if next != 16 || len(got) != 6 {
+		t.Fatalf("DecodeRecords() got next: %d, records: %d\n", next, len(got))
+	}
+	for i, r := range got {
+		expect := records[i%3]
+		if r.Offset != int64(10+i) || r.Timestamp != expect.Timestamp ||
+			!bytes.Equal(r.Key, expect.Key) || !bytes.Equal(r.Value, expect.Value) {
+			t.Errorf("record %d got: %+v\n", i, r)
+		}
+	}
+
+	data[len(data)-1] ^= 0xff
+	if _, _, err = DecodeRecords(data); err != ErrCorruptRecord {
+		t.Errorf("DecodeRecords() corrupt data got: %v\n", err)
+	}
+}
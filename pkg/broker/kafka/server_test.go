@@ -0,0 +1,403 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// fakeNodeID 测试用Kafka节点编号
+	fakeNodeID = 1
+)
+
+// validTopic 与Kafka相同的topic名检查
+var validTopic = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// fakeBatch 保存的RecordBatch
+type fakeBatch struct {
+	base int64
+	next int64
+	data []byte
+}
+
+// fakeKafka 测试用单节点Kafka，实现Metadata/Produce/Fetch/ListOffsets/FindCoordinator/OffsetCommit/OffsetFetch
+type fakeKafka struct {
+	sync.Mutex
+	listener net.Listener
+	// partitions 自动创建topic的partition数
+	partitions int
+	// logs topic -> partition -> batch列表
+	logs map[string][][]*fakeBatch
+	// offsets 已提交的offset：group/topic -> partition -> offset
+	offsets map[string]map[int32]int64
+}
+
+func newFakeKafka(partitions int) (*fakeKafka, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	k := &fakeKafka{
+		listener:   listener,
+		partitions: partitions,
+		logs:       make(map[string][][]*fakeBatch),
+		offsets:    make(map[string]map[int32]int64),
+	}
+	go k.serve()
+	return k, nil
+}
+
+func (k *fakeKafka) Address() string {
+	return k.listener.Addr().String()
+}
+
+func (k *fakeKafka) Close() {
+	k.listener.Close()
+}
+
+// Committed 返回已提交的offset
+func (k *fakeKafka) Committed(group, topic string) map[int32]int64 {
+	k.Lock()
+	defer k.Unlock()
+	offsets := make(map[int32]int64)
+	for partition, offset := range k.offsets[group+"/"+topic] {
+		offsets[partition] = offset
+	}
+	return offsets
+}
+
+// log 获取topic的日志，不存在时自动创建，调用者需持有锁
+func (k *fakeKafka) log(topic string) [][]*fakeBatch {
+	log, found := k.logs[topic]
+	if !found {
+		log = make([][]*fakeBatch, k.partitions)
+		k.logs[topic] = log
+	}
+	return log
+}
+
+// end 返回partition的下一个offset，调用者需持有锁
+func end(batches []*fakeBatch) int64 {
+	if len(batches) == 0 {
+		return 0
+	}
+	return batches[len(batches)-1].next
+}
+
+func (k *fakeKafka) serve() {
+	for {
+		c, err := k.listener.Accept()
+		if err != nil {
+			return
+		}
+		go k.handle(c)
+	}
+}
+
+func (k *fakeKafka) handle(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for {
+		var size int32
+		if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return
+		}
+		d := &decoder{data: data}
+		apiKey := d.int16()
+		apiVersion := d.int16()
+		correlationID := d.int32()
+		d.string()
+		if d.err != nil || apiVersions[apiKey] != apiVersion {
+			return
+		}
+		e := new(encoder)
+		e.putInt32(0)
+		e.putInt32(correlationID)
+		switch apiKey {
+		case APIKeyMetadata:
+			k.metadata(d, e)
+		case APIKeyProduce:
+			if !k.produce(d, e) {
+				continue
+			}
+		case APIKeyFetch:
+			k.fetch(d, e)
+		case APIKeyListOffsets:
+			k.listOffsets(d, e)
+		case APIKeyFindCoordinator:
+			k.findCoordinator(d, e)
+		case APIKeyOffsetCommit:
+			k.offsetCommit(d, e)
+		case APIKeyOffsetFetch:
+			k.offsetFetch(d, e)
+		default:
+			return
+		}
+		resp := e.Bytes()
+		binary.BigEndian.PutUint32(resp, uint32(len(resp)-4))
+		if _, err := c.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (k *fakeKafka) metadata(d *decoder, e *encoder) {
+	var topics []string
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		topics = append(topics, d.string())
+	}
+	host, port, _ := net.SplitHostPort(k.Address())
+	portNumber, _ := strconv.Atoi(port)
+
+	k.Lock()
+	defer k.Unlock()
+	e.putInt32(0)
+	e.putArrayLen(1)
+	e.putInt32(fakeNodeID)
+	e.putString(host)
+	e.putInt32(int32(portNumber))
+	e.putNullableString("")
+	e.putNullableString("")
+	e.putInt32(fakeNodeID)
+	e.putArrayLen(len(topics))
+	for _, topic := range topics {
+		if !validTopic.MatchString(topic) {
+			e.putInt16(int16(ErrInvalidTopicException))
+			e.putString(topic)
+			e.putBool(false)
+			e.putArrayLen(0)
+			continue
+		}
+		log := k.log(topic)
+		e.putInt16(0)
+		e.putString(topic)
+		e.putBool(false)
+		e.putArrayLen(len(log))
+		for partition := range log {
+			e.putInt16(0)
+			e.putInt32(int32(partition))
+			e.putInt32(fakeNodeID)
+			e.putArrayLen(1)
+			e.putInt32(fakeNodeID)
+			e.putArrayLen(1)
+			e.putInt32(fakeNodeID)
+		}
+	}
+}
+
+// produce 返回false表示acks为0，不需要响应
+func (k *fakeKafka) produce(d *decoder, e *encoder) bool {
+	d.string()
+	acks := d.int16()
+	d.int32()
+	k.Lock()
+	defer k.Unlock()
+	n := d.arrayLen()
+	e.putArrayLen(n)
+	for i := 0; i < n; i++ {
+		topic := d.string()
+		log := k.log(topic)
+		e.putString(topic)
+		pn := d.arrayLen()
+		e.putArrayLen(pn)
+		for j := 0; j < pn; j++ {
+			partition := d.int32()
+			data := d.bytes()
+			e.putInt32(partition)
+			_, next, err := DecodeRecords(data)
+			if err != nil || next <= 0 || int(partition) >= len(log) {
+				e.putInt16(int16(ErrCorruptMessage))
+				e.putInt64(-1)
+				e.putInt64(-1)
+				continue
+			}
+			batch := &fakeBatch{
+				base: end(log[partition]),
+				data: append([]byte(nil), data...),
+			}
+			batch.next = batch.base + next
+			binary.BigEndian.PutUint64(batch.data, uint64(batch.base))
+			log[partition] = append(log[partition], batch)
+			e.putInt16(0)
+			e.putInt64(batch.base)
+			e.putInt64(-1)
+		}
+	}
+	e.putInt32(0)
+	return acks != 0
+}
+
+func (k *fakeKafka) fetch(d *decoder, e *encoder) {
+	d.int32()
+	maxWait := time.Duration(d.int32()) * time.Millisecond
+	d.int32()
+	d.int32()
+	d.int8()
+	d.arrayLen()
+	topic := d.string()
+	offsets := make(map[int32]int64)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		partition := d.int32()
+		offsets[partition] = d.int64()
+		d.int32()
+	}
+
+	deadline := time.Now().Add(maxWait)
+	for {
+		k.Lock()
+		log := k.log(topic)
+		available := false
+		for partition, offset := range offsets {
+			if int(partition) < len(log) && offset < end(log[partition]) {
+				available = true
+			}
+		}
+		if available || time.Now().After(deadline) {
+			break
+		}
+		k.Unlock()
+		time.Sleep(time.Millisecond * 5)
+	}
+	defer k.Unlock()
+
+	log := k.log(topic)
+	e.putInt32(0)
+	e.putArrayLen(1)
+	e.putString(topic)
+	e.putArrayLen(len(offsets))
+	for partition, offset := range offsets {
+		e.putInt32(partition)
+		if int(partition) >= len(log) {
+			e.putInt16(int16(ErrUnknownTopicOrPartition))
+			e.putInt64(-1)
+			e.putInt64(-1)
+			e.putArrayLen(0)
+			e.putBytes(nil)
+			continue
+		}
+		batches := log[partition]
+		if offset < 0 || offset > end(batches) {
+			e.putInt16(int16(ErrOffsetOutOfRange))
+			e.putInt64(end(batches))
+			e.putInt64(end(batches))
+			e.putArrayLen(0)
+			e.putBytes(nil)
+			continue
+		}
+		var records []byte
+		for _, batch := range batches {
+			if batch.next > offset {
+				records = append(records, batch.data...)
+			}
+		}
+		e.putInt16(0)
+		e.putInt64(end(batches))
+		e.putInt64(end(batches))
+		e.putArrayLen(0)
+		e.putBytes(records)
+	}
+}
+
+func (k *fakeKafka) listOffsets(d *decoder, e *encoder) {
+	d.int32()
+	k.Lock()
+	defer k.Unlock()
+	n := d.arrayLen()
+	e.putArrayLen(n)
+	for i := 0; i < n; i++ {
+		topic := d.string()
+		log := k.log(topic)
+		e.putString(topic)
+		pn := d.arrayLen()
+		e.putArrayLen(pn)
+		for j := 0; j < pn; j++ {
+			partition := d.int32()
+			timestamp := d.int64()
+			e.putInt32(partition)
+			e.putInt16(0)
+			e.putInt64(-1)
+			if timestamp == OffsetLatest && int(partition) < len(log) {
+				e.putInt64(end(log[partition]))
+			} else {
+				e.putInt64(0)
+			}
+		}
+	}
+}
+
+func (k *fakeKafka) findCoordinator(d *decoder, e *encoder) {
+	host, port, _ := net.SplitHostPort(k.Address())
+	portNumber, _ := strconv.Atoi(port)
+	e.putInt32(0)
+	e.putInt16(0)
+	e.putNullableString("")
+	e.putInt32(fakeNodeID)
+	e.putString(host)
+	e.putInt32(int32(portNumber))
+}
+
+func (k *fakeKafka) offsetCommit(d *decoder, e *encoder) {
+	group := d.string()
+	d.int32()
+	d.string()
+	d.int64()
+	k.Lock()
+	defer k.Unlock()
+	n := d.arrayLen()
+	e.putArrayLen(n)
+	for i := 0; i < n; i++ {
+		topic := d.string()
+		offsets, found := k.offsets[group+"/"+topic]
+		if !found {
+			offsets = make(map[int32]int64)
+			k.offsets[group+"/"+topic] = offsets
+		}
+		e.putString(topic)
+		pn := d.arrayLen()
+		e.putArrayLen(pn)
+		for j := 0; j < pn; j++ {
+			partition := d.int32()
+			offsets[partition] = d.int64()
+			d.string()
+			e.putInt32(partition)
+			e.putInt16(0)
+		}
+	}
+}
+
+func (k *fakeKafka) offsetFetch(d *decoder, e *encoder) {
+	group := d.string()
+	k.Lock()
+	defer k.Unlock()
+	n := d.arrayLen()
+	e.putArrayLen(n)
+	for i := 0; i < n; i++ {
+		topic := d.string()
+		e.putString(topic)
+		pn := d.arrayLen()
+		e.putArrayLen(pn)
+		for j := 0; j < pn; j++ {
+			partition := d.int32()
+			offset, found := k.offsets[group+"/"+topic][partition]
+			if !found {
+				offset = -1
+			}
+			e.putInt32(partition)
+			e.putInt64(offset)
+			e.putNullableString("")
+			e.putInt16(0)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+/*
+Package memory 进程内消息队列Broker，用于单进程部署和集成测试
+
+每个tag对应一个有界队列，多个订阅者竞争消费同一个队列，每条消息只会被一个订阅者处理。
+
+SubscribeHandler返回nil表示确认（ack）消息；返回error表示拒绝（nack），
+消息重新放回队列尾部，等待再次投递，订阅者在重新拉取消息前会等待retry-interval。
+Broker关闭时队列中尚未投递的消息将被丢弃。
+
+配置（viper参数前缀 + ".memory."）：
+
+* queue-size: 每个tag的队列长度，包括正在处理中的消息
+
+* retry-interval: 消息处理失败后重新拉取的等待时间（单位：毫秒）
+*/
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/shutdown"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	// Name 调用类型的名称
+	Name = "memory"
+	// DefaultQueueSize 默认队列长度，如果设置的队列长度小于最小队列长度，则使用默认队列长度
+	DefaultQueueSize = 1000
+	// MinQueueSize 最小队列长度
+	MinQueueSize = 1
+	// DefaultRetryInterval 默认重试等待时间（单位：毫秒）
+	DefaultRetryInterval = 100
+)
+
+// BrokerImpl 进程内消息队列Broker
+type BrokerImpl struct {
+	sync.Mutex
+	// queues 消息队列
+	queues map[string]*queue
+	// queueSize 消息队列长度
+	queueSize int
+	// retryInterval 消息处理失败后的重试等待时间
+	retryInterval time.Duration
+	// ctx 上下文接口
+	ctx context.Context
+	// closed 是否已关闭
+	closed bool
+}
+
+// queue 有界消息队列
+type queue struct {
+	sync.Mutex
+	cond *sync.Cond
+	// items 等待投递的消息
+	items []*protocol.Command
+	// inflight 正在处理中的消息数
+	inflight int
+	// size 队列长度
+	size int
+	// closed 是否已关闭
+	closed bool
+}
+
+func init() {
+	register.Register(Name, NewMemoryBroker)
+}
+
+// NewMemoryBroker 新建进程内Broker
+func NewMemoryBroker(viperPerfix string) (broker.Broker, error) {
+	glog.Infoln("broker::memory::NewMemoryBroker")
+	queueSize := viper.GetInt(viperPerfix + ".memory.queue-size")
+	if queueSize < MinQueueSize {
+		queueSize = DefaultQueueSize
+	}
+	retryInterval := viper.GetInt(viperPerfix + ".memory.retry-interval")
+	if retryInterval <= 0 {
+		retryInterval = DefaultRetryInterval
+	}
+	return &BrokerImpl{
+		queues:        make(map[string]*queue),
+		queueSize:     queueSize,
+		retryInterval: time.Duration(retryInterval) * time.Millisecond,
+	}, nil
+}
+
+// Publish 发布，消息进入队列后立即返回，没有响应信令
+func (b *BrokerImpl) Publish(tag string, cmd *protocol.Command) (*protocol.Command, error) {
+	glog.Infof("broker::memory::Publish(%s)%s\n", tag, cmd)
+	q := b.queue(tag)
+	if q == nil {
+		return nil, define.ErrConnectionClosed
+	}
+	if err := q.push(cmd.Copy()); err != nil {
+		glog.Warningf("broker::memory::Publish(%s) error: %s\n", tag, err)
+		return nil, err
+	}
+	return nil, nil
+}
+
+// Subscribe 订阅，阻塞直到Broker关闭
+func (b *BrokerImpl) Subscribe(tag string, handler broker.SubscribeHandler) error {
+	glog.Infof("broker::memory::Subscribe(%s)\n", tag)
+	defer glog.Infof("broker::memory::Subscribe(%s) done\n", tag)
+	if b.ctx != nil {
+		if err := shutdown.ExitWaitGroupAdd(b.ctx, 1); err != nil {
+			glog.Errorf("broker::memory::Subscribe(%s) ExitWaitGroupAdd error: %s\n", tag, err)
+			return err
+		}
+		defer shutdown.ExitWaitGroupDone(b.ctx)
+	}
+	q := b.queue(tag)
+	if q == nil {
+		return define.ErrConnectionClosed
+	}
+	for {
+		cmd, ok := q.pop()
+		if !ok {
+			return nil
+		}
+		if err := broker.Handle(handler, tag, cmd.Copy()); err != nil {
+			glog.Warningf("broker::memory::Subscribe(%s) handler error: %s, requeue\n", tag, err)
+			q.nack(cmd)
+			time.Sleep(b.retryInterval)
+			continue
+		}
+		q.ack()
+	}
+}
+
+// Len 队列中等待投递和正在处理的消息数
+func (b *BrokerImpl) Len(tag string) int {
+	b.Lock()
+	q, found := b.queues[tag]
+	b.Unlock()
+	if !found {
+		return 0
+	}
+	q.Lock()
+	defer q.Unlock()
+	return len(q.items) + q.inflight
+}
+
+// Run 运行
+func (b *BrokerImpl) Run(ctx context.Context) error {
+	glog.Infoln("broker::memory::Run()")
+	b.ctx = ctx
+	if ctx != nil {
+		go func() {
+			<-ctx.Done()
+			b.closeQueues()
+		}()
+	}
+	return nil
+}
+
+// Close 关闭，唤醒所有订阅者
+func (b *BrokerImpl) Close(timeout time.Duration) error {
+	glog.Warningln("broker::memory::Close()")
+	defer glog.Warningln("broker::memory::Close() Done")
+	b.closeQueues()
+	return nil
+}
+
+// String 发布
+func (b *BrokerImpl) String() string {
+	return Name
+}
+
+// queue 获取tag对应的队列，不存在则新建
+func (b *BrokerImpl) queue(tag string) *queue {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return nil
+	}
+	q, found := b.queues[tag]
+	if !found {
+		q = &queue{
+			items: make([]*protocol.Command, 0, b.queueSize),
+			size:  b.queueSize,
+		}
+		q.cond = sync.NewCond(q)
+		b.queues[tag] = q
+	}
+	return q
+}
+
+func (b *BrokerImpl) closeQueues() {
+	b.Lock()
+	b.closed = true
+	queues := b.queues
+	b.Unlock()
+	for _, q := range queues {
+		q.close()
+	}
+}
+
+// push 放入队列，队列已满返回ErrQueueFull
+func (q *queue) push(cmd *protocol.Command) error {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return define.ErrConnectionClosed
+	}
+	if len(q.items)+q.inflight >= q.size {
+		return define.ErrQueueFull
+	}
+	q.items = append(q.items, cmd)
+	q.cond.Signal()
+	return nil
+}
+
+// pop 取出一条消息，队列为空时阻塞，队列关闭时返回false
+func (q *queue) pop() (*protocol.Command, bool) {
+	q.Lock()
+	defer q.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	cmd := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	q.inflight++
+	return cmd, true
+}
+
+// ack 确认消息处理完成
+func (q *queue) ack() {
+	q.Lock()
+	q.inflight--
+	q.Unlock()
+}
+
+// nack 消息处理失败，重新放回队列尾部
+func (q *queue) nack(cmd *protocol.Command) {
+	q.Lock()
+	defer q.Unlock()
+	q.inflight--
+	if q.closed {
+		return
+	}
+	q.items = append(q.items, cmd)
+	q.cond.Signal()
+}
+
+func (q *queue) close() {
+	q.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.Unlock()
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package memory
+
+import (
+	"flag"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/shutdown"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/brokertest"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	viperPerfix = "test"
+)
+
+func init() {
+	flag.Set("v", "4")
+	flag.Set("logtostderr", "true")
+
+	viper.Set(viperPerfix+".memory.queue-size", 8)
+	viper.Set(viperPerfix+".memory.retry-interval", 10)
+}
+
+func TestRegister(t *testing.T) {
+	if err := register.Init(viperPerfix); err != nil {
+		t.Fatal("register.Init() error:", err)
+	}
+	b, err := register.Get(Name)
+	if err != nil {
+		t.Fatal(`register.Get("memory") error:`, err)
+	}
+	if broker.Get(Name) != b {
+		t.Error(`broker.Get("memory") should return the registered broker`)
+	}
+	if b.String() != Name {
+		t.Errorf("b.String: %s\n", b.String())
+	}
+}
+
+func TestContract(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) (broker.Broker, func()) {
+		b, err := NewMemoryBroker(viperPerfix)
+		if err != nil {
+			t.Fatal("NewMemoryBroker() error:", err)
+		}
+		return b, nil
+	})
+}
+
+func TestClose(t *testing.T) {
+	b, _ := NewMemoryBroker(viperPerfix)
+	ctx := shutdown.NewContext()
+	b.Run(ctx)
+	if err := shutdown.Shutdown(ctx, time.Second, b.Close); err != nil {
+		t.Error("shutdown error:", err)
+	}
+	if _, err := b.Publish("tag", brokertest.NewCommand(1)); err == nil {
+		t.Error("b.Publish() should fail after close")
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	b, _ := NewMemoryBroker(viperPerfix)
+	defer b.Close(time.Second)
+
+	size := viper.GetInt(viperPerfix + ".memory.queue-size")
+	for i := 0; i < size; i++ {
+		if _, err := b.Publish("full", brokertest.NewCommand(i)); err != nil {
+			t.Fatalf("b.Publish(%d) error: %s\n", i, err)
+		}
+	}
+	if _, err := b.Publish("full", brokertest.NewCommand(size)); err != define.ErrQueueFull {
+		t.Errorf("b.Publish() expect ErrQueueFull, got: %v\n", err)
+	}
+	if n := b.(*BrokerImpl).Len("full"); n != size {
+		t.Errorf("Len() expect: %d, got: %d\n", size, n)
+	}
+}
+
+func TestCompetingSubscribers(t *testing.T) {
+	b, _ := NewMemoryBroker(viperPerfix)
+	defer b.Close(time.Second)
+
+	const total = 100
+	var (
+		locker   sync.Mutex
+		received = make(map[string]int)
+		done     sync.WaitGroup
+	)
+	done.Add(total)
+	for i := 0; i < 4; i++ {
+		go b.Subscribe("compete", func(tag string, cmd *protocol.Command) error {
+			locker.Lock()
+			received[string(cmd.Payload)]++
+			locker.Unlock()
+			done.Done()
+			return nil
+		})
+	}
+	for i := 0; i < total; {
+		if _, err := b.Publish("compete", brokertest.NewCommand(i)); err == define.ErrQueueFull {
+			time.Sleep(time.Millisecond)
+			continue
+		} else if err != nil {
+			t.Fatalf("b.Publish(%d) error: %s\n", i, err)
+		}
+		i++
+	}
+
+	waitGroup(t, &done)
+	locker.Lock()
+	defer locker.Unlock()
+	if len(received) != total {
+		t.Errorf("received %d distinct commands, expect %d\n", len(received), total)
+	}
+	for payload, count := range received {
+		if count != 1 {
+			t.Errorf("command %s delivered %d times\n", payload, count)
+		}
+	}
+}
+
+func waitGroup(t *testing.T, wg *sync.WaitGroup) {
+	signal := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(signal)
+	}()
+	select {
+	case <-signal:
+	case <-time.After(time.Second * 4):
+		t.Fatal("Test timeout")
+	}
+}
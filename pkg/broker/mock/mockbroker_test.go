@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/zhangpeihao/zim/pkg/broker"
 	"github.com/zhangpeihao/zim/pkg/broker/register"
 	"github.com/zhangpeihao/zim/pkg/protocol"
 )
@@ -51,9 +50,9 @@ func TestMockBroker(t *testing.T) {
 		t.Fatal("register.Init() error:", err)
 	}
 
-	b := broker.Get("mock")
-	if b == nil {
-		t.Fatal(`broker.Get("mock") return nil`)
+	b, err := register.Get("mock")
+	if err != nil {
+		t.Fatal(`register.Get("mock") error:`, err)
 	}
 
 	b.Run(nil)
@@ -63,7 +62,7 @@ func TestMockBroker(t *testing.T) {
 	}
 
 	SubscribeMockHandler = func(tag string) (cmd *protocol.Command, err error) {
-		glog.Infof("SubscribeMockHandler(%s)%s\n", tag)
+		glog.Infof("SubscribeMockHandler(%s)\n", tag)
 		for {
 			locker.Lock()
 			if cmdSubscribe == nil {
@@ -0,0 +1,196 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package nsq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+	"github.com/zhangpeihao/zim/pkg/define"
+)
+
+const (
+	// Name 调用类型的名称
+	Name = "nsq"
+	// DefaultAddress 默认nsqd地址
+	DefaultAddress = "127.0.0.1:4150"
+	// DefaultChannel 默认channel
+	DefaultChannel = "zim"
+	// DefaultMaxInFlight 默认RDY数量
+	DefaultMaxInFlight = 1
+	// DefaultRequeueDelay 默认重新投递等待时间（单位：毫秒）
+	DefaultRequeueDelay = 1000
+	// DefaultReconnectInterval 默认断线重连间隔（单位：毫秒）
+	DefaultReconnectInterval = 1000
+	// DialTimeout 连接超时
+	DialTimeout = time.Second * 5
+	// MaxTopicLength NSQ topic的最大长度
+	MaxTopicLength = 64
+)
+
+var (
+	// ErrInvalidTopic tag转义后不是有效的NSQ topic
+	ErrInvalidTopic = errors.New("nsq invalid topic")
+)
+
+// BrokerImpl NSQ实现的Broker
+type BrokerImpl struct {
+	sync.Mutex
+	// Address nsqd TCP地址
+	Address string
+	// Channel 订阅使用的channel
+	Channel string
+	// MaxInFlight RDY数量
+	MaxInFlight int
+	// MaxAttempts 最大投递次数，0表示不限制
+	MaxAttempts int
+	// RequeueDelay 重新投递的基础等待时间
+	RequeueDelay time.Duration
+	// ReconnectInterval 断线重连间隔
+	ReconnectInterval time.Duration
+	// producer 发布连接
+	producer *conn
+	// producerLocker 发布连接锁
+	producerLocker sync.Mutex
+	// consumers 订阅连接
+	consumers map[*conn]struct{}
+	// ctx 上下文接口
+	ctx context.Context
+	// closing 关闭信号
+	closing chan struct{}
+}
+
+func init() {
+	register.Register(Name, NewNSQBroker)
+}
+
+// NewNSQBroker 新建服务，连接在首次使用时建立
+func NewNSQBroker(viperPerfix string) (broker.Broker, error) {
+	glog.Infoln("broker::nsq::NewNSQBroker")
+	b := &BrokerImpl{
+		Address:           viper.GetString(viperPerfix + ".nsq.address"),
+		Channel:           viper.GetString(viperPerfix + ".nsq.channel"),
+		MaxInFlight:       viper.GetInt(viperPerfix + ".nsq.max-in-flight"),
+		MaxAttempts:       viper.GetInt(viperPerfix + ".nsq.max-attempts"),
+		RequeueDelay:      time.Duration(viper.GetInt(viperPerfix+".nsq.requeue-delay")) * time.Millisecond,
+		ReconnectInterval: time.Duration(viper.GetInt(viperPerfix+".nsq.reconnect-interval")) * time.Millisecond,
+		consumers:         make(map[*conn]struct{}),
+		closing:           make(chan struct{}),
+	}
+	if len(b.Address) == 0 {
+		b.Address = DefaultAddress
+	}
+	if len(b.Channel) == 0 {
+		b.Channel = DefaultChannel
+	}
+	if b.MaxInFlight <= 0 {
+		b.MaxInFlight = DefaultMaxInFlight
+	}
+	if b.RequeueDelay <= 0 {
+		b.RequeueDelay = DefaultRequeueDelay * time.Millisecond
+	}
+	if b.ReconnectInterval <= 0 {
+		b.ReconnectInterval = DefaultReconnectInterval * time.Millisecond
+	}
+	return b, nil
+}
+
+// Run 运行
+func (b *BrokerImpl) Run(ctx context.Context) error {
+	glog.Infof("broker::nsq::Run() address: %s\n", b.Address)
+	b.ctx = ctx
+	if ctx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				b.Close(0)
+			case <-b.closing:
+			}
+		}()
+	}
+	return nil
+}
+
+// Close 关闭所有连接
+func (b *BrokerImpl) Close(timeout time.Duration) error {
+	glog.Warningln("broker::nsq::Close()")
+	defer glog.Warningln("broker::nsq::Close() Done")
+	b.Lock()
+	defer b.Unlock()
+	select {
+	case <-b.closing:
+		return nil
+	default:
+		close(b.closing)
+	}
+	b.producerLocker.Lock()
+	if b.producer != nil {
+		b.producer.Close()
+		b.producer = nil
+	}
+	b.producerLocker.Unlock()
+	for c := range b.consumers {
+		c.WriteCommand("CLS", nil, nil)
+		c.Close()
+	}
+	return nil
+}
+
+// String 发布
+func (b *BrokerImpl) String() string {
+	return Name
+}
+
+// isClosing 是否已关闭
+func (b *BrokerImpl) isClosing() bool {
+	select {
+	case <-b.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// addConsumer 记录订阅连接，Broker已关闭时返回错误
+func (b *BrokerImpl) addConsumer(c *conn) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.isClosing() {
+		return define.ErrConnectionClosed
+	}
+	b.consumers[c] = struct{}{}
+	return nil
+}
+
+// removeConsumer 删除订阅连接
+func (b *BrokerImpl) removeConsumer(c *conn) {
+	b.Lock()
+	delete(b.consumers, c)
+	b.Unlock()
+}
+
+// Topic 将tag转换为NSQ的topic。NSQ的topic只能包含字母、数字、'.'、'_'和'-'，
+// 其他字符（包括'/'和'.'）转义为'.'加两位十六进制，转义后为空或者超过MaxTopicLength时返回ErrInvalidTopic
+func Topic(tag string) (string, error) {
+	var topic bytes.Buffer
+	for i := 0; i < len(tag); i++ {
+		c := tag[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '-' {
+			topic.WriteByte(c)
+		} else {
+			fmt.Fprintf(&topic, ".%02x", c)
+		}
+	}
+	if topic.Len() == 0 || topic.Len() > MaxTopicLength {
+		return "", ErrInvalidTopic
+	}
+	return topic.String(), nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package nsq
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/shutdown"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/brokertest"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	viperPerfix = "test"
+)
+
+func init() {
+	flag.Set("v", "4")
+	flag.Set("logtostderr", "true")
+
+	viper.Set(viperPerfix+".nsq.requeue-delay", 10)
+	viper.Set(viperPerfix+".nsq.reconnect-interval", 10)
+}
+
+func newTestBroker(t *testing.T, d *fakeNSQD, maxAttempts int) broker.Broker {
+	viper.Set(viperPerfix+".nsq.address", d.Address())
+	viper.Set(viperPerfix+".nsq.max-attempts", maxAttempts)
+	b, err := NewNSQBroker(viperPerfix)
+	if err != nil {
+		t.Fatal("NewNSQBroker() error:", err)
+	}
+	return b
+}
+
+func TestContract(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) (broker.Broker, func()) {
+		d, err := newFakeNSQD()
+		if err != nil {
+			t.Fatal("newFakeNSQD() error:", err)
+		}
+		return newTestBroker(t, d, 0), d.Close
+	})
+}
+
+func TestClose(t *testing.T) {
+	d, err := newFakeNSQD()
+	if err != nil {
+		t.Fatal("newFakeNSQD() error:", err)
+	}
+	defer d.Close()
+	b := newTestBroker(t, d, 0)
+	ctx := shutdown.NewContext()
+	b.Run(ctx)
+
+	if b.String() != Name {
+		t.Errorf("b.String: %s\n", b.String())
+	}
+	if err = shutdown.Shutdown(ctx, time.Second, b.Close); err != nil {
+		t.Error("shutdown error:", err)
+	}
+	if _, err = b.Publish("tag", brokertest.NewCommand(1)); err == nil {
+		t.Error("b.Publish() should fail after close")
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	d, err := newFakeNSQD()
+	if err != nil {
+		t.Fatal("newFakeNSQD() error:", err)
+	}
+	defer d.Close()
+	b := newTestBroker(t, d, 0)
+	defer b.Close(time.Second)
+
+	b.Publish("requeue", brokertest.NewCommand(1))
+	signal := make(chan *protocol.Command, 16)
+	attempts := 0
+	go b.Subscribe("requeue", func(tag string, cmd *protocol.Command) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("retry later")
+		}
+		signal <- cmd
+		return nil
+	})
+	brokertest.Receive(t, signal)
+	time.Sleep(time.Millisecond * 100)
+	if finished, requeued := d.Stats(); finished != 1 || requeued != 2 {
+		t.Errorf("expect finished: 1, requeued: 2, got: %d, %d\n", finished, requeued)
+	}
+}
+
+func TestMaxAttempts(t *testing.T) {
+	d, err := newFakeNSQD()
+	if err != nil {
+		t.Fatal("newFakeNSQD() error:", err)
+	}
+	defer d.Close()
+	b := newTestBroker(t, d, 2)
+	defer b.Close(time.Second)
+
+	b.Publish("drop", brokertest.NewCommand(1))
+	b.Publish("drop", brokertest.NewCommand(2))
+	signal := make(chan *protocol.Command, 16)
+	go b.Subscribe("drop", func(tag string, cmd *protocol.Command) error {
+		if string(cmd.Payload) == "foo bar 1" {
+			panic("poison")
+		}
+		signal <- cmd
+		return nil
+	})
+	if got := brokertest.Receive(t, signal); string(got.Payload) != "foo bar 2" {
+		t.Errorf("expect foo bar 2, got: %s\n", got.Payload)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if finished, requeued := d.Stats(); finished != 2 || requeued != 1 {
+		t.Errorf("expect finished: 2, requeued: 1, got: %d, %d\n", finished, requeued)
+	}
+}
+
+func TestTopic(t *testing.T) {
+	testCases := []struct {
+		tag    string
+		expect string
+		err    error
+	}{
+		{"tag", "tag", nil},
+		{"msg/foo", "msg.2ffoo", nil},
+		{"msg.2ffoo", "msg.2e2ffoo", nil},
+		{"a_b-c", "a_b-c", nil},
+		{"", "", ErrInvalidTopic},
+		{strings.Repeat("a/", 30), "", ErrInvalidTopic},
+	}
+	for index, testCase := range testCases {
+		if topic, err := Topic(testCase.tag); topic != testCase.expect || err != testCase.err {
+			t.Errorf("Case(%d): Topic(%s) expect: %s, %v, got: %s, %v\n",
+				index+1, testCase.tag, testCase.expect, testCase.err, topic, err)
+		}
+	}
+
+	// 包含'/'的tag可以发布和订阅
+	d, err := newFakeNSQD()
+	if err != nil {
+		t.Fatal("newFakeNSQD() error:", err)
+	}
+	defer d.Close()
+	b := newTestBroker(t, d, 0)
+	defer b.Close(time.Second)
+	cmd := brokertest.NewCommand(1)
+	if _, err = b.Publish("msg/foo", cmd); err != nil {
+		t.Fatal("b.Publish() error:", err)
+	}
+	signal := make(chan *protocol.Command, 1)
+	go b.Subscribe("msg/foo", func(tag string, got *protocol.Command) error {
+		signal <- got
+		return nil
+	})
+	if got := brokertest.Receive(t, signal); !cmd.Equal(got) {
+		t.Errorf("Subscribe expect: %s, got: %s\n", cmd, got)
+	}
+	if _, err = b.Publish("", cmd); err != ErrInvalidTopic {
+		t.Errorf("b.Publish() empty tag expect ErrInvalidTopic, got: %v\n", err)
+	}
+	if err = b.Subscribe("", nil); err != ErrInvalidTopic {
+		t.Errorf("b.Subscribe() empty tag expect ErrInvalidTopic, got: %v\n", err)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package nsq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// FrameTypeResponse 响应帧
+	FrameTypeResponse int32 = 0
+	// FrameTypeError 错误帧
+	FrameTypeError int32 = 1
+	// FrameTypeMessage 消息帧
+	FrameTypeMessage int32 = 2
+	// MessageIDLength 消息ID长度
+	MessageIDLength = 16
+	// MaxFrameSize 最大帧长度
+	MaxFrameSize = 16 * 1024 * 1024
+)
+
+var (
+	// MagicV2 协议版本
+	MagicV2 = []byte("  V2")
+	// ResponseOK 成功响应
+	ResponseOK = []byte("OK")
+	// ResponseHeartbeat 心跳
+	ResponseHeartbeat = []byte("_heartbeat_")
+	// ResponseCloseWait 关闭响应
+	ResponseCloseWait = []byte("CLOSE_WAIT")
+
+	// ErrFrameTooLarge 帧长度超过限制
+	ErrFrameTooLarge = errors.New("nsq frame too large")
+	// ErrInvalidMessage 消息格式错误
+	ErrInvalidMessage = errors.New("nsq invalid message")
+)
+
+// Error nsqd返回的错误帧
+type Error struct {
+	// Message 错误信息
+	Message string
+}
+
+// Error 错误信息
+func (e *Error) Error() string {
+	return "nsq error: " + e.Message
+}
+
+// Message NSQ消息
+type Message struct {
+	// ID 消息ID
+	ID [MessageIDLength]byte
+	// Timestamp 发布时间（单位：纳秒）
+	Timestamp int64
+	// Attempts 投递次数
+	Attempts uint16
+	// Body 消息体
+	Body []byte
+}
+
+// conn NSQ TCP连接
+type conn struct {
+	sync.Mutex
+	c  net.Conn
+	br *bufio.Reader
+}
+
+// dial 建立连接并发送协议版本
+func dial(address string, timeout time.Duration) (*conn, error) {
+	c, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = c.Write(MagicV2); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &conn{
+		c:  c,
+		br: bufio.NewReader(c),
+	}, nil
+}
+
+// WriteCommand 发送命令，body不为nil时附带4字节长度和消息体
+func (c *conn) WriteCommand(name string, params []string, body []byte) error {
+	buf := new(bytes.Buffer)
+	buf.WriteString(name)
+	for _, param := range params {
+		buf.WriteByte(' ')
+		buf.WriteString(param)
+	}
+	buf.WriteByte('\n')
+	if body != nil {
+		binary.Write(buf, binary.BigEndian, int32(len(body)))
+		buf.Write(body)
+	}
+	c.Lock()
+	defer c.Unlock()
+	_, err := c.c.Write(buf.Bytes())
+	return err
+}
+
+// ReadFrame 读取一帧
+func (c *conn) ReadFrame() (frameType int32, data []byte, err error) {
+	var size int32
+	if err = binary.Read(c.br, binary.BigEndian, &size); err != nil {
+		return
+	}
+	if size < 4 || size > MaxFrameSize {
+		return 0, nil, ErrFrameTooLarge
+	}
+	if err = binary.Read(c.br, binary.BigEndian, &frameType); err != nil {
+		return
+	}
+	data = make([]byte, size-4)
+	_, err = io.ReadFull(c.br, data)
+	return
+}
+
+// ReadResponse 读取响应，自动回复心跳
+func (c *conn) ReadResponse() ([]byte, error) {
+	for {
+		frameType, data, err := c.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch frameType {
+		case FrameTypeResponse:
+			if bytes.Equal(data, ResponseHeartbeat) {
+				if err = c.WriteCommand("NOP", nil, nil); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return data, nil
+		case FrameTypeError:
+			return nil, &Error{Message: string(data)}
+		default:
+			return nil, fmt.Errorf("nsq unexpected frame type %d", frameType)
+		}
+	}
+}
+
+// Close 关闭连接
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+// DecodeMessage 解析消息帧
+func DecodeMessage(data []byte) (*Message, error) {
+	if len(data) < 10+MessageIDLength {
+		return nil, ErrInvalidMessage
+	}
+	msg := &Message{
+		Timestamp: int64(binary.BigEndian.Uint64(data[:8])),
+		Attempts:  binary.BigEndian.Uint16(data[8:10]),
+		Body:      data[10+MessageIDLength:],
+	}
+	copy(msg.ID[:], data[10:10+MessageIDLength])
+	return msg, nil
+}
+
+// EncodeMessage 编码消息帧
+func EncodeMessage(msg *Message) []byte {
+	buf := make([]byte, 10+MessageIDLength+len(msg.Body))
+	binary.BigEndian.PutUint64(buf[:8], uint64(msg.Timestamp))
+	binary.BigEndian.PutUint16(buf[8:10], msg.Attempts)
+	copy(buf[10:10+MessageIDLength], msg.ID[:])
+	copy(buf[10+MessageIDLength:], msg.Body)
+	return buf
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package nsq
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/shutdown"
+	"github.com/zhangpeihao/zim/pkg/broker"
+)
+
+// Subscribe 订阅，断线自动重连，阻塞直到Broker关闭
+func (b *BrokerImpl) Subscribe(tag string, handler broker.SubscribeHandler) error {
+	glog.Infof("broker::nsq::Subscribe(%s)\n", tag)
+	defer glog.Infof("broker::nsq::Subscribe(%s) done\n", tag)
+	topic, err := Topic(tag)
+	if err != nil {
+		glog.Errorf("broker::nsq::Subscribe(%s) error: %s\n", tag, err)
+		return err
+	}
+	if b.ctx != nil {
+		if err := shutdown.ExitWaitGroupAdd(b.ctx, 1); err != nil {
+			glog.Errorf("broker::nsq::Subscribe(%s) ExitWaitGroupAdd error: %s\n", tag, err)
+			return err
+		}
+		defer shutdown.ExitWaitGroupDone(b.ctx)
+	}
+	for {
+		err := b.consume(tag, topic, handler)
+		if b.isClosing() {
+			return nil
+		}
+		glog.Warningf("broker::nsq::Subscribe(%s) connection error: %s, reconnect after %s\n",
+			tag, err, b.ReconnectInterval)
+		select {
+		case <-time.After(b.ReconnectInterval):
+		case <-b.closing:
+			return nil
+		}
+	}
+}
+
+// consume 建立订阅连接并处理消息，直到连接断开
+func (b *BrokerImpl) consume(tag, topic string, handler broker.SubscribeHandler) error {
+	c, err := dial(b.Address, DialTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err = b.addConsumer(c); err != nil {
+		return err
+	}
+	defer b.removeConsumer(c)
+
+	if err = c.WriteCommand("SUB", []string{topic, b.Channel}, nil); err != nil {
+		return err
+	}
+	resp, err := c.ReadResponse()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(resp, ResponseOK) {
+		return fmt.Errorf("nsq unexpected response: %s", resp)
+	}
+	if err = c.WriteCommand("RDY", []string{strconv.Itoa(b.MaxInFlight)}, nil); err != nil {
+		return err
+	}
+
+	for {
+		frameType, data, err := c.ReadFrame()
+		if err != nil {
+			return err
+		}
+		switch frameType {
+		case FrameTypeResponse:
+			if bytes.Equal(data, ResponseHeartbeat) {
+				if err = c.WriteCommand("NOP", nil, nil); err != nil {
+					return err
+				}
+			} else if bytes.Equal(data, ResponseCloseWait) {
+				return nil
+			}
+		case FrameTypeError:
+			glog.Warningf("broker::nsq::consume(%s) nsq error: %s\n", tag, data)
+		case FrameTypeMessage:
+			msg, err := DecodeMessage(data)
+			if err != nil {
+				return err
+			}
+			if err = b.handleMessage(c, tag, msg, handler); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleMessage 处理一条消息，并回复FIN或REQ
+func (b *BrokerImpl) handleMessage(c *conn, tag string, msg *Message,
+	handler broker.SubscribeHandler) error {
+	id := string(msg.ID[:])
+	cmd, err := broker.Unmarshal(msg.Body)
+	if err != nil {
+		glog.Errorf("broker::nsq::handleMessage(%s) message %s unmarshal error: %s, drop\n", tag, id, err)
+		return c.WriteCommand("FIN", []string{id}, nil)
+	}
+	if err = broker.Handle(handler, tag, cmd); err == nil {
+		return c.WriteCommand("FIN", []string{id}, nil)
+	}
+	if b.MaxAttempts > 0 && int(msg.Attempts) >= b.MaxAttempts {
+		glog.Warningf("broker::nsq::handleMessage(%s) message %s failed %d times, drop: %s\n",
+			tag, id, msg.Attempts, err)
+		return c.WriteCommand("FIN", []string{id}, nil)
+	}
+	glog.Warningf("broker::nsq::handleMessage(%s) message %s attempt %d error: %s, requeue\n",
+		tag, id, msg.Attempts, err)
+	delay := b.RequeueDelay * time.Duration(msg.Attempts)
+	return c.WriteCommand("REQ", []string{id, strconv.FormatInt(int64(delay/time.Millisecond), 10)}, nil)
+}
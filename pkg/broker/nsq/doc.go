@@ -0,0 +1,31 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+/*
+Package nsq NSQ TCP协议实现的Broker
+
+tag: 转义后作为NSQ的topic，字母、数字、'_'和'-'不变，其他字符转义为'.'加两位十六进制，
+例如"msg/foo"的topic为"msg.2ffoo"，转义后超过64个字符的tag不能使用
+
+Command: 使用信令串行化格式编码为消息体（参看broker.Marshal）
+
+Publish: 发送PUB命令，等待nsqd返回OK，没有响应信令
+
+Subscribe: 发送SUB <topic> <channel>和RDY命令，SubscribeHandler返回nil时发送FIN，
+返回error时发送REQ，nsqd将在requeue-delay * attempts毫秒后重新投递。
+连接断开时自动重连。
+
+配置（viper参数前缀 + ".nsq."）：
+
+* address: nsqd TCP地址，默认"127.0.0.1:4150"
+
+* channel: 订阅使用的channel，默认"zim"
+
+* max-in-flight: RDY数量，默认1
+
+* max-attempts: 最大投递次数，超过后丢弃消息，默认0（不限制）
+
+* requeue-delay: 重新投递的基础等待时间（单位：毫秒），默认1000
+
+* reconnect-interval: 断线重连间隔（单位：毫秒），默认1000
+*/
+package nsq
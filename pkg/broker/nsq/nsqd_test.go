@@ -0,0 +1,236 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package nsq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// validTopic nsqd检查topic的规则
+var validTopic = regexp.MustCompile(`^[\.a-zA-Z0-9_-]{1,64}$`)
+
+// fakeNSQD 测试用nsqd，实现PUB/SUB/RDY/FIN/REQ/NOP/CLS
+type fakeNSQD struct {
+	sync.Mutex
+	listener net.Listener
+	// topics 每个topic的消息队列（不区分channel）
+	topics map[string]chan *Message
+	// sequence 消息ID序号
+	sequence int
+	// finished 已完成的消息数
+	finished int
+	// requeued 重新投递的消息数
+	requeued int
+}
+
+func newFakeNSQD() (*fakeNSQD, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	d := &fakeNSQD{
+		listener: listener,
+		topics:   make(map[string]chan *Message),
+	}
+	go d.serve()
+	return d, nil
+}
+
+func (d *fakeNSQD) Address() string {
+	return d.listener.Addr().String()
+}
+
+func (d *fakeNSQD) Close() {
+	d.listener.Close()
+}
+
+func (d *fakeNSQD) Stats() (finished, requeued int) {
+	d.Lock()
+	defer d.Unlock()
+	return d.finished, d.requeued
+}
+
+func (d *fakeNSQD) topic(name string) chan *Message {
+	d.Lock()
+	defer d.Unlock()
+	topic, found := d.topics[name]
+	if !found {
+		topic = make(chan *Message, 1024)
+		d.topics[name] = topic
+	}
+	return topic
+}
+
+func (d *fakeNSQD) serve() {
+	for {
+		c, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(c)
+	}
+}
+
+// fakeClient nsqd端的客户端连接
+type fakeClient struct {
+	sync.Mutex
+	c net.Conn
+	// inflight 已投递未完成的消息
+	inflight map[string]*Message
+	// rdy 可投递的消息数
+	rdy chan struct{}
+	// topic 订阅的消息队列
+	topic chan *Message
+}
+
+func (client *fakeClient) writeFrame(frameType int32, data []byte) error {
+	client.Lock()
+	defer client.Unlock()
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, int32(len(data)+4))
+	binary.Write(buf, binary.BigEndian, frameType)
+	buf.Write(data)
+	_, err := client.c.Write(buf.Bytes())
+	return err
+}
+
+func (d *fakeNSQD) handle(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	magic := make([]byte, len(MagicV2))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, MagicV2) {
+		return
+	}
+	client := &fakeClient{
+		c:        c,
+		inflight: make(map[string]*Message),
+		rdy:      make(chan struct{}, 1024),
+	}
+	closing := make(chan struct{})
+	defer func() {
+		close(closing)
+		// 连接断开，未完成的消息重新投递
+		client.Lock()
+		for _, msg := range client.inflight {
+			client.topic <- msg
+		}
+		client.Unlock()
+	}()
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		params := strings.Split(strings.TrimSpace(line), " ")
+		switch params[0] {
+		case "PUB":
+			if !validTopic.MatchString(params[1]) {
+				client.writeFrame(FrameTypeError, []byte("E_BAD_TOPIC"))
+				return
+			}
+			var size int32
+			if err = binary.Read(br, binary.BigEndian, &size); err != nil {
+				return
+			}
+			body := make([]byte, size)
+			if _, err = io.ReadFull(br, body); err != nil {
+				return
+			}
+			d.Lock()
+			d.sequence++
+			msg := &Message{
+				Timestamp: time.Now().UnixNano(),
+				Body:      body,
+			}
+			copy(msg.ID[:], fmt.Sprintf("%016x", d.sequence))
+			d.Unlock()
+			d.topic(params[1]) <- msg
+			client.writeFrame(FrameTypeResponse, ResponseOK)
+		case "SUB":
+			if !validTopic.MatchString(params[1]) {
+				client.writeFrame(FrameTypeError, []byte("E_BAD_TOPIC"))
+				return
+			}
+			// 先发送心跳，检查客户端是否回复NOP
+			client.writeFrame(FrameTypeResponse, ResponseHeartbeat)
+			client.writeFrame(FrameTypeResponse, ResponseOK)
+			client.topic = d.topic(params[1])
+			go d.deliver(client, client.topic, closing)
+		case "RDY":
+			n, _ := strconv.Atoi(params[1])
+			for i := 0; i < n; i++ {
+				client.rdy <- struct{}{}
+			}
+		case "FIN":
+			client.Lock()
+			_, found := client.inflight[params[1]]
+			delete(client.inflight, params[1])
+			client.Unlock()
+			if !found {
+				client.writeFrame(FrameTypeError, []byte("E_FIN_FAILED"))
+				continue
+			}
+			d.Lock()
+			d.finished++
+			d.Unlock()
+			client.rdy <- struct{}{}
+		case "REQ":
+			client.Lock()
+			msg, found := client.inflight[params[1]]
+			delete(client.inflight, params[1])
+			client.Unlock()
+			if !found {
+				client.writeFrame(FrameTypeError, []byte("E_REQ_FAILED"))
+				continue
+			}
+			d.Lock()
+			d.requeued++
+			d.Unlock()
+			client.topic <- msg
+			client.rdy <- struct{}{}
+		case "NOP":
+		case "CLS":
+			client.writeFrame(FrameTypeResponse, ResponseCloseWait)
+			return
+		default:
+			client.writeFrame(FrameTypeError, []byte("E_INVALID"))
+		}
+	}
+}
+
+// deliver 按RDY数量投递消息
+func (d *fakeNSQD) deliver(client *fakeClient, topic chan *Message, closing chan struct{}) {
+	for {
+		select {
+		case <-client.rdy:
+		case <-closing:
+			return
+		}
+		select {
+		case msg := <-topic:
+			msg.Attempts++
+			client.Lock()
+			client.inflight[string(msg.ID[:])] = msg
+			client.Unlock()
+			if err := client.writeFrame(FrameTypeMessage, EncodeMessage(msg)); err != nil {
+				client.Lock()
+				delete(client.inflight, string(msg.ID[:]))
+				client.Unlock()
+				topic <- msg
+				return
+			}
+		case <-closing:
+			return
+		}
+	}
+}
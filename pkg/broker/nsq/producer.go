@@ -0,0 +1,72 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package nsq
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// Publish 发布，nsqd确认后返回，没有响应信令
+func (b *BrokerImpl) Publish(tag string, cmd *protocol.Command) (*protocol.Command, error) {
+	glog.Infof("broker::nsq::Publish(%s)%s\n", tag, cmd)
+	topic, err := Topic(tag)
+	if err != nil {
+		glog.Warningf("broker::nsq::Publish(%s) error: %s\n", tag, err)
+		return nil, err
+	}
+	body, err := broker.Marshal(cmd)
+	if err != nil {
+		glog.Warningf("broker::nsq::Publish(%s) marshal error: %s\n", tag, err)
+		return nil, err
+	}
+
+	b.producerLocker.Lock()
+	defer b.producerLocker.Unlock()
+	// 连接断开时重连一次
+	for retry := 0; retry < 2; retry++ {
+		if b.isClosing() {
+			return nil, define.ErrConnectionClosed
+		}
+		if b.producer == nil {
+			if b.producer, err = dial(b.Address, DialTimeout); err != nil {
+				glog.Errorf("broker::nsq::Publish(%s) dial(%s) error: %s\n", tag, b.Address, err)
+				return nil, err
+			}
+		}
+		if err = publish(b.producer, topic, body); err == nil {
+			return nil, nil
+		}
+		if _, ok := err.(*Error); ok {
+			glog.Warningf("broker::nsq::Publish(%s) error: %s\n", tag, err)
+			return nil, err
+		}
+		glog.Warningf("broker::nsq::Publish(%s) connection error: %s\n", tag, err)
+		b.producer.Close()
+		b.producer = nil
+	}
+	return nil, err
+}
+
+// publish 发送PUB命令，等待确认
+func publish(c *conn, topic string, body []byte) error {
+	c.c.SetDeadline(time.Now().Add(DialTimeout))
+	defer c.c.SetDeadline(time.Time{})
+	if err := c.WriteCommand("PUB", []string{topic}, body); err != nil {
+		return err
+	}
+	resp, err := c.ReadResponse()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(resp, ResponseOK) {
+		return fmt.Errorf("nsq unexpected response: %s", resp)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	// Name 调用类型的名称
+	Name = "redis"
+	// DefaultAddress 默认Redis地址
+	DefaultAddress = "127.0.0.1:6379"
+	// DefaultStreamPrefix 默认Stream key前缀
+	DefaultStreamPrefix = "zim:"
+	// DefaultGroup 默认消费组名
+	DefaultGroup = "zim"
+	// DefaultCount 默认每次读取的最大消息数
+	DefaultCount = 16
+	// DefaultBlock 默认XREADGROUP阻塞时间（单位：毫秒）
+	DefaultBlock = 1000
+	// DefaultClaimIdle 默认Pending消息重新投递的空闲时间（单位：毫秒）
+	DefaultClaimIdle = 30000
+	// DefaultReconnectInterval 默认断线重连间隔（单位：毫秒）
+	DefaultReconnectInterval = 1000
+	// DefaultMaxRetry 默认最大投递次数
+	DefaultMaxRetry = 5
+	// DefaultTimeout 默认请求超时（单位：毫秒）
+	DefaultTimeout = 5000
+	// DeadLetterPrefix 死信Stream key前缀（在stream-prefix之后），
+	// 以tag不能包含的DeadLetterSeparator结尾，不会与其他tag的Stream key相同
+	DeadLetterPrefix = "dead" + DeadLetterSeparator
+	// DeadLetterSeparator 死信Stream key的分隔符，tag不能包含
+	DeadLetterSeparator = "|"
+	// DialTimeout 连接超时
+	DialTimeout = time.Second * 5
+
+	// FieldVersion 信令版本字段
+	FieldVersion = "version"
+	// FieldAppID AppID字段
+	FieldAppID = "appid"
+	// FieldName 信令名字段
+	FieldName = "name"
+	// FieldData 信令数据字段（JSON）
+	FieldData = "data"
+	// FieldPayload 业务数据字段
+	FieldPayload = "payload"
+)
+
+var (
+	// ErrInvalidTag tag为空或者包含DeadLetterSeparator
+	ErrInvalidTag = errors.New("redis invalid tag")
+)
+
+// BrokerImpl Redis Streams实现的Broker
+type BrokerImpl struct {
+	sync.Mutex
+	// Address Redis地址
+	Address string
+	// Password 密码
+	Password string
+	// DB 数据库编号
+	DB int
+	// StreamPrefix Stream key前缀
+	StreamPrefix string
+	// MaxLen Stream最大长度，0表示不限制
+	MaxLen int
+	// Group 消费组名
+	Group string
+	// Consumer 消费者名
+	Consumer string
+	// Count 每次读取的最大消息数
+	Count int
+	// Block XREADGROUP阻塞时间
+	Block time.Duration
+	// ClaimIdle Pending消息重新投递的空闲时间
+	ClaimIdle time.Duration
+	// MaxRetry 最大投递次数，超过后移入死信Stream
+	MaxRetry int
+	// ReconnectInterval 断线重连间隔
+	ReconnectInterval time.Duration
+	// Timeout 请求超时，XREADGROUP为Block加上Timeout
+	Timeout time.Duration
+	// producer 发布连接
+	producer *conn
+	// producerLocker 发布连接锁
+	producerLocker sync.Mutex
+	// consumers 订阅连接
+	consumers map[*conn]struct{}
+	// ctx 上下文接口
+	ctx context.Context
+	// closing 关闭信号
+	closing chan struct{}
+}
+
+// entry Stream消息
+type entry struct {
+	// ID 消息ID
+	ID string
+	// Fields 消息字段
+	Fields map[string][]byte
+}
+
+func init() {
+	register.Register(Name, NewRedisBroker)
+}
+
+// NewRedisBroker 新建服务，连接在首次使用时建立
+func NewRedisBroker(viperPerfix string) (broker.Broker, error) {
+	glog.Infoln("broker::redis::NewRedisBroker")
+	b := &BrokerImpl{
+		Address:           viper.GetString(viperPerfix + ".redis.address"),
+		Password:          viper.GetString(viperPerfix + ".redis.password"),
+		DB:                viper.GetInt(viperPerfix + ".redis.db"),
+		StreamPrefix:      viper.GetString(viperPerfix + ".redis.stream-prefix"),
+		MaxLen:            viper.GetInt(viperPerfix + ".redis.max-len"),
+		Group:             viper.GetString(viperPerfix + ".redis.group"),
+		Consumer:          viper.GetString(viperPerfix + ".redis.consumer"),
+		Count:             viper.GetInt(viperPerfix + ".redis.count"),
+		Block:             time.Duration(viper.GetInt(viperPerfix+".redis.block")) * time.Millisecond,
+		ClaimIdle:         time.Duration(viper.GetInt(viperPerfix+".redis.claim-idle")) * time.Millisecond,
+		MaxRetry:          viper.GetInt(viperPerfix + ".redis.max-retry"),
+		ReconnectInterval: time.Duration(viper.GetInt(viperPerfix+".redis.reconnect-interval")) * time.Millisecond,
+		Timeout:           time.Duration(viper.GetInt(viperPerfix+".redis.timeout")) * time.Millisecond,
+		consumers:         make(map[*conn]struct{}),
+		closing:           make(chan struct{}),
+	}
+	if len(b.Address) == 0 {
+		b.Address = DefaultAddress
+	}
+	if !viper.IsSet(viperPerfix + ".redis.stream-prefix") {
+		b.StreamPrefix = DefaultStreamPrefix
+	}
+	if len(b.Group) == 0 {
+		b.Group = DefaultGroup
+	}
+	if len(b.Consumer) == 0 {
+		hostname, _ := os.Hostname()
+		b.Consumer = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if b.Count <= 0 {
+		b.Count = DefaultCount
+	}
+	if b.Block <= 0 {
+		b.Block = DefaultBlock * time.Millisecond
+	}
+	if b.ClaimIdle <= 0 {
+		b.ClaimIdle = DefaultClaimIdle * time.Millisecond
+	}
+	if b.MaxRetry <= 0 {
+		b.MaxRetry = DefaultMaxRetry
+	}
+	if b.ReconnectInterval <= 0 {
+		b.ReconnectInterval = DefaultReconnectInterval * time.Millisecond
+	}
+	if b.Timeout <= 0 {
+		b.Timeout = DefaultTimeout * time.Millisecond
+	}
+	return b, nil
+}
+
+// Run 运行
+func (b *BrokerImpl) Run(ctx context.Context) error {
+	glog.Infof("broker::redis::Run() address: %s\n", b.Address)
+	b.ctx = ctx
+	if ctx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				b.Close(0)
+			case <-b.closing:
+			}
+		}()
+	}
+	return nil
+}
+
+// Close 关闭所有连接
+func (b *BrokerImpl) Close(timeout time.Duration) error {
+	glog.Warningln("broker::redis::Close()")
+	defer glog.Warningln("broker::redis::Close() Done")
+	b.Lock()
+	defer b.Unlock()
+	select {
+	case <-b.closing:
+		return nil
+	default:
+		close(b.closing)
+	}
+	b.producerLocker.Lock()
+	if b.producer != nil {
+		b.producer.Close()
+		b.producer = nil
+	}
+	b.producerLocker.Unlock()
+	for c := range b.consumers {
+		c.Close()
+	}
+	return nil
+}
+
+// String 发布
+func (b *BrokerImpl) String() string {
+	return Name
+}
+
+// key tag对应的Stream key
+func (b *BrokerImpl) key(tag string) string {
+	return b.StreamPrefix + tag
+}
+
+// validTag 检查tag，tag不能为空，也不能包含DeadLetterSeparator
+func validTag(tag string) bool {
+	return len(tag) > 0 && !strings.Contains(tag, DeadLetterSeparator)
+}
+
+// deadKey tag对应的死信Stream key
+func (b *BrokerImpl) deadKey(tag string) string {
+	return b.StreamPrefix + DeadLetterPrefix + tag
+}
+
+// isClosing 是否已关闭
+func (b *BrokerImpl) isClosing() bool {
+	select {
+	case <-b.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// addConsumer 记录订阅连接，Broker已关闭时返回错误
+func (b *BrokerImpl) addConsumer(c *conn) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.isClosing() {
+		return define.ErrConnectionClosed
+	}
+	b.consumers[c] = struct{}{}
+	return nil
+}
+
+// removeConsumer 删除订阅连接
+func (b *BrokerImpl) removeConsumer(c *conn) {
+	b.Lock()
+	delete(b.consumers, c)
+	b.Unlock()
+}
+
+// encodeFields 将命令编码为Stream消息字段
+func encodeFields(cmd *protocol.Command) ([]string, error) {
+	fields := []string{
+		FieldVersion, cmd.Version,
+		FieldAppID, cmd.AppID,
+		FieldName, cmd.Name,
+	}
+	if cmd.Data != nil {
+		data, err := json.Marshal(cmd.Data)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, FieldData, string(data))
+	}
+	if cmd.Payload != nil {
+		fields = append(fields, FieldPayload, string(cmd.Payload))
+	}
+	return fields, nil
+}
+
+// decodeFields 将Stream消息字段解析为命令
+func decodeFields(fields map[string][]byte) (*protocol.Command, error) {
+	cmd := &protocol.Command{
+		Version: string(fields[FieldVersion]),
+		AppID:   string(fields[FieldAppID]),
+		Name:    string(fields[FieldName]),
+		Payload: fields[FieldPayload],
+	}
+	if len(cmd.AppID) == 0 || len(cmd.Name) == 0 {
+		return nil, define.ErrInvalidParameter
+	}
+	if data := fields[FieldData]; len(data) > 0 {
+		if err := cmd.ParseData(data); err != nil {
+			return nil, err
+		}
+	}
+	return cmd, nil
+}
+
+// parseEntries 解析Stream消息列表：[[id, [field, value, ...]], ...]
+// 已被删除的消息（nil）将被忽略
+func parseEntries(reply interface{}) ([]entry, error) {
+	if reply == nil {
+		return nil, nil
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, ErrProtocol
+	}
+	entries := make([]entry, 0, len(items))
+	for _, item := range items {
+		pair, ok := item.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		id, ok := pair[0].([]byte)
+		if !ok {
+			return nil, ErrProtocol
+		}
+		values, _ := pair[1].([]interface{})
+		e := entry{
+			ID:     string(id),
+			Fields: make(map[string][]byte),
+		}
+		for i := 0; i+1 < len(values); i += 2 {
+			field, _ := values[i].([]byte)
+			value, _ := values[i+1].([]byte)
+			e.Fields[string(field)] = value
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
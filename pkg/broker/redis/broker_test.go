@@ -0,0 +1,301 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package redis
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/brokertest"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	viperPerfix = "test"
+)
+
+func init() {
+	flag.Set("v", "4")
+	flag.Set("logtostderr", "true")
+
+	viper.Set(viperPerfix+".redis.consumer", "test")
+	viper.Set(viperPerfix+".redis.block", 50)
+	viper.Set(viperPerfix+".redis.claim-idle", 100)
+	viper.Set(viperPerfix+".redis.reconnect-interval", 50)
+}
+
+func newTestBroker(t *testing.T, r *fakeRedis) broker.Broker {
+	viper.Set(viperPerfix+".redis.address", r.Address())
+	b, err := NewRedisBroker(viperPerfix)
+	if err != nil {
+		t.Fatal("NewRedisBroker() error:", err)
+	}
+	if err = b.Run(nil); err != nil {
+		t.Fatal("b.Run() error:", err)
+	}
+	return b
+}
+
+func newTestRedis(t *testing.T) *fakeRedis {
+	r, err := newFakeRedis()
+	if err != nil {
+		t.Fatal("newFakeRedis() error:", err)
+	}
+	return r
+}
+
+func TestContract(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) (broker.Broker, func()) {
+		r := newTestRedis(t)
+		return newTestBroker(t, r), r.Close
+	})
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.Close()
+	b := newTestBroker(t, r)
+	defer b.Close(time.Second)
+
+	if b.String() != Name {
+		t.Errorf("b.String: %s\n", b.String())
+	}
+
+	// 消费组从Stream开头消费，订阅前发布的消息也能收到
+	cmd := brokertest.NewCommand(1)
+	if resp, err := b.Publish("tag", cmd); err != nil || resp != nil {
+		t.Fatalf("b.Publish() got: %v, %v\n", resp, err)
+	}
+	signal := make(chan *protocol.Command, 1)
+	go b.Subscribe("tag", func(tag string, got *protocol.Command) error {
+		signal <- got
+		return nil
+	})
+	if got := brokertest.Receive(t, signal); !cmd.Equal(got) {
+		t.Errorf("Subscribe expect: %s, got: %s\n", cmd, got)
+	}
+
+	cmd = brokertest.NewCommand(2)
+	b.Publish("tag", cmd)
+	if got := brokertest.Receive(t, signal); !cmd.Equal(got) {
+		t.Errorf("Subscribe expect: %s, got: %s\n", cmd, got)
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	if length, pending, acked := r.Stats(DefaultStreamPrefix+"tag", DefaultGroup); length != 2 || pending != 0 || acked != 2 {
+		t.Errorf("Stats() got length: %d, pending: %d, acked: %d\n", length, pending, acked)
+	}
+}
+
+func TestRedeliverPending(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.Close()
+	b := newTestBroker(t, r)
+	defer b.Close(time.Second)
+
+	b.Publish("retry", brokertest.NewCommand(1))
+	b.Publish("retry", brokertest.NewCommand(2))
+
+	signal := make(chan *protocol.Command, 16)
+	failed := false
+	go b.Subscribe("retry", func(tag string, cmd *protocol.Command) error {
+		signal <- cmd
+		if string(cmd.Payload) == "foo bar 1" && !failed {
+			failed = true
+			return errors.New("retry")
+		}
+		return nil
+	})
+
+	// 第一条消息处理失败后保留在Pending列表中，空闲超过claim-idle后重新投递
+	for _, expect := range []string{"foo bar 1", "foo bar 2", "foo bar 1"} {
+		if got := brokertest.Receive(t, signal); string(got.Payload) != expect {
+			t.Fatalf("expect %s, got: %s\n", expect, got.Payload)
+		}
+	}
+	time.Sleep(time.Millisecond * 50)
+	if _, pending, acked := r.Stats(DefaultStreamPrefix+"retry", DefaultGroup); pending != 0 || acked != 2 {
+		t.Errorf("Stats() got pending: %d, acked: %d\n", pending, acked)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	viper.Set(viperPerfix+".redis.max-retry", 3)
+	defer viper.Set(viperPerfix+".redis.max-retry", nil)
+	r := newTestRedis(t)
+	defer r.Close()
+	b := newTestBroker(t, r)
+	defer b.Close(time.Second)
+
+	b.Publish("dead", brokertest.NewCommand(1))
+	signal := make(chan *protocol.Command, 16)
+	go b.Subscribe("dead", func(tag string, cmd *protocol.Command) error {
+		signal <- cmd
+		return errors.New("poison")
+	})
+
+	// 投递max-retry次后移入死信Stream，不再投递
+	for i := 0; i < 3; i++ {
+		brokertest.Receive(t, signal)
+	}
+	key := DefaultStreamPrefix + "dead"
+	for i := 0; i < 100; i++ {
+		if _, pending, _ := r.Stats(key, DefaultGroup); pending == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	select {
+	case cmd := <-signal:
+		t.Errorf("dead letter should not be delivered again: %s\n", cmd)
+	case <-time.After(time.Millisecond * 300):
+	}
+	if _, pending, acked := r.Stats(key, DefaultGroup); pending != 0 || acked != 1 {
+		t.Errorf("Stats() got pending: %d, acked: %d\n", pending, acked)
+	}
+	if length, _, _ := r.Stats(DefaultStreamPrefix+DeadLetterPrefix+"dead", DefaultGroup); length != 1 {
+		t.Errorf("dead letter stream length: %d\n", length)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.Close()
+	b := newTestBroker(t, r)
+	defer b.Close(time.Second)
+
+	// 缺少appid字段的消息无法解析
+	c, err := dial(r.Address(), "", 0, DialTimeout)
+	if err != nil {
+		t.Fatal("dial() error:", err)
+	}
+	defer c.Close()
+	if _, err = c.Do(DialTimeout, "XADD", DefaultStreamPrefix+"bad", "*", FieldName, "msg"); err != nil {
+		t.Fatal("XADD error:", err)
+	}
+	signal := make(chan *protocol.Command, 1)
+	go b.Subscribe("bad", func(tag string, cmd *protocol.Command) error {
+		signal <- cmd
+		return nil
+	})
+	// 移入死信Stream，不投递
+	deadKey := DefaultStreamPrefix + DeadLetterPrefix + "bad"
+	for i := 0; i < 100; i++ {
+		if length, _, _ := r.Stats(deadKey, DefaultGroup); length == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	select {
+	case cmd := <-signal:
+		t.Errorf("undecodable message should not be delivered: %s\n", cmd)
+	case <-time.After(time.Millisecond * 100):
+	}
+	if length, _, _ := r.Stats(deadKey, DefaultGroup); length != 1 {
+		t.Errorf("dead letter stream length: %d\n", length)
+	}
+	if _, pending, acked := r.Stats(DefaultStreamPrefix+"bad", DefaultGroup); pending != 0 || acked != 1 {
+		t.Errorf("Stats() got pending: %d, acked: %d\n", pending, acked)
+	}
+}
+
+func TestInvalidTag(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.Close()
+	b := newTestBroker(t, r)
+	defer b.Close(time.Second)
+
+	// 包含分隔符的tag可能与死信Stream key相同
+	for _, tag := range []string{"", "dead|push", "a|b"} {
+		if _, err := b.Publish(tag, brokertest.NewCommand(1)); err != ErrInvalidTag {
+			t.Errorf("b.Publish(%q) expect ErrInvalidTag, got: %v\n", tag, err)
+		}
+		if err := b.Subscribe(tag, nil); err != ErrInvalidTag {
+			t.Errorf("b.Subscribe(%q) expect ErrInvalidTag, got: %v\n", tag, err)
+		}
+	}
+	// 名为"dead:push"的tag与push的死信Stream不同
+	if _, err := b.Publish("dead:push", brokertest.NewCommand(1)); err != nil {
+		t.Fatal("b.Publish() error:", err)
+	}
+	if length, _, _ := r.Stats(DefaultStreamPrefix+DeadLetterPrefix+"push", DefaultGroup); length != 0 {
+		t.Errorf("dead letter stream length: %d\n", length)
+	}
+}
+
+func TestClaimCrashedConsumer(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.Close()
+	b := newTestBroker(t, r)
+	defer b.Close(time.Second)
+
+	cmd := brokertest.NewCommand(1)
+	b.Publish("claim", cmd)
+
+	// 另一个消费者读取消息后崩溃，没有确认
+	c, err := dial(r.Address(), "", 0, DialTimeout)
+	if err != nil {
+		t.Fatal("dial() error:", err)
+	}
+	key := DefaultStreamPrefix + "claim"
+	if _, err = c.Do(DialTimeout, "XGROUP", "CREATE", key, DefaultGroup, "0", "MKSTREAM"); err != nil {
+		t.Fatal("XGROUP error:", err)
+	}
+	if _, err = c.Do(DialTimeout, "XREADGROUP", "GROUP", DefaultGroup, "crashed",
+		"COUNT", "1", "BLOCK", "0", "STREAMS", key, ">"); err != nil {
+		t.Fatal("XREADGROUP error:", err)
+	}
+	c.Close()
+
+	signal := make(chan *protocol.Command, 1)
+	go b.Subscribe("claim", func(tag string, got *protocol.Command) error {
+		signal <- got
+		return nil
+	})
+	if got := brokertest.Receive(t, signal); !cmd.Equal(got) {
+		t.Errorf("Subscribe expect: %s, got: %s\n", cmd, got)
+	}
+}
+
+func TestPublishTimeout(t *testing.T) {
+	// 接受连接但是不响应的Redis
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("net.Listen() error:", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	viper.Set(viperPerfix+".redis.address", listener.Addr().String())
+	viper.Set(viperPerfix+".redis.timeout", 100)
+	defer viper.Set(viperPerfix+".redis.timeout", nil)
+	b, err := NewRedisBroker(viperPerfix)
+	if err != nil {
+		t.Fatal("NewRedisBroker() error:", err)
+	}
+	defer b.Close(time.Second)
+
+	// 超时后丢弃连接，重连一次后返回错误
+	start := time.Now()
+	if _, err = b.Publish("timeout", brokertest.NewCommand(1)); err == nil {
+		t.Error("Publish() should timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Publish() elapsed: %s\n", elapsed)
+	}
+	if b.(*BrokerImpl).producer != nil {
+		t.Error("timeout connection should be dropped")
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package redis
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/shutdown"
+	"github.com/zhangpeihao/zim/pkg/broker"
+)
+
+// Subscribe 订阅，断线自动重连，阻塞直到Broker关闭
+func (b *BrokerImpl) Subscribe(tag string, handler broker.SubscribeHandler) error {
+	glog.Infof("broker::redis::Subscribe(%s)\n", tag)
+	if !validTag(tag) {
+		glog.Warningf("broker::redis::Subscribe(%s) invalid tag\n", tag)
+		return ErrInvalidTag
+	}
+	defer glog.Infof("broker::redis::Subscribe(%s) done\n", tag)
+	if b.ctx != nil {
+		if err := shutdown.ExitWaitGroupAdd(b.ctx, 1); err != nil {
+			glog.Errorf("broker::redis::Subscribe(%s) ExitWaitGroupAdd error: %s\n", tag, err)
+			return err
+		}
+		defer shutdown.ExitWaitGroupDone(b.ctx)
+	}
+	for {
+		err := b.consume(tag, handler)
+		if b.isClosing() {
+			return nil
+		}
+		glog.Warningf("broker::redis::Subscribe(%s) connection error: %s, reconnect after %s\n",
+			tag, err, b.ReconnectInterval)
+		select {
+		case <-time.After(b.ReconnectInterval):
+		case <-b.closing:
+			return nil
+		}
+	}
+}
+
+// consume 建立订阅连接并处理消息，直到连接断开
+func (b *BrokerImpl) consume(tag string, handler broker.SubscribeHandler) error {
+	c, err := dial(b.Address, b.Password, b.DB, DialTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err = b.addConsumer(c); err != nil {
+		return err
+	}
+	defer b.removeConsumer(c)
+
+	key := b.key(tag)
+	if _, err = c.Do(b.Timeout, "XGROUP", "CREATE", key, b.Group, "0", "MKSTREAM"); err != nil {
+		if e, ok := err.(Error); !ok || !strings.HasPrefix(string(e), "BUSYGROUP") {
+			return err
+		}
+	}
+
+	var lastClaim time.Time
+	for {
+		if time.Since(lastClaim) >= b.ClaimIdle {
+			if err = b.claim(c, tag, handler); err != nil {
+				return err
+			}
+			lastClaim = time.Now()
+		}
+		reply, err := c.Do(b.Block+b.Timeout, "XREADGROUP", "GROUP", b.Group, b.Consumer,
+			"COUNT", strconv.Itoa(b.Count),
+			"BLOCK", strconv.FormatInt(int64(b.Block/time.Millisecond), 10),
+			"STREAMS", key, ">")
+		if err != nil {
+			return err
+		}
+		// 响应：[[key, [entry, ...]]]，超时返回nil
+		streams, _ := reply.([]interface{})
+		for _, stream := range streams {
+			pair, ok := stream.([]interface{})
+			if !ok || len(pair) != 2 {
+				return ErrProtocol
+			}
+			entries, err := parseEntries(pair[1])
+			if err != nil {
+				return err
+			}
+			if err = b.handleEntries(c, tag, entries, handler); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// claim 接管空闲超过ClaimIdle的Pending消息（包括本消费者处理失败的消息）并重新投递
+func (b *BrokerImpl) claim(c *conn, tag string, handler broker.SubscribeHandler) error {
+	key := b.key(tag)
+	cursor := "0-0"
+	for {
+		reply, err := c.Do(b.Timeout, "XAUTOCLAIM", key, b.Group, b.Consumer,
+			strconv.FormatInt(int64(b.ClaimIdle/time.Millisecond), 10), cursor,
+			"COUNT", strconv.Itoa(b.Count))
+		if err != nil {
+			return err
+		}
+		// 响应：[cursor, [entry, ...]]，Redis 7增加第三个元素：已删除的消息ID
+		items, ok := reply.([]interface{})
+		if !ok || len(items) < 2 {
+			return ErrProtocol
+		}
+		next, ok := items[0].([]byte)
+		if !ok {
+			return ErrProtocol
+		}
+		entries, err := parseEntries(items[1])
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			glog.Infof("broker::redis::claim(%s) claimed %d messages\n", tag, len(entries))
+		}
+		if entries, err = b.retryEntries(c, tag, entries); err != nil {
+			return err
+		}
+		if err = b.handleEntries(c, tag, entries, handler); err != nil {
+			return err
+		}
+		cursor = string(next)
+		if cursor == "0-0" || b.isClosing() {
+			return nil
+		}
+	}
+}
+
+// retryEntries 返回投递次数没有超过MaxRetry的消息，超过的消息移入死信Stream
+func (b *BrokerImpl) retryEntries(c *conn, tag string, entries []entry) ([]entry, error) {
+	retry := entries[:0]
+	for _, e := range entries {
+		deliveries, err := b.deliveries(c, tag, e.ID)
+		if err != nil {
+			return nil, err
+		}
+		if deliveries <= b.MaxRetry {
+			retry = append(retry, e)
+			continue
+		}
+		glog.Warningf("broker::redis::retryEntries(%s) message %s delivered %d times, move to dead letter\n",
+			tag, e.ID, deliveries-1)
+		if err = b.moveToDead(c, tag, e); err != nil {
+			return nil, err
+		}
+	}
+	return retry, nil
+}
+
+// deliveries 通过XPENDING取得消息的投递次数（包括XAUTOCLAIM这次），消息不在Pending列表中时返回0
+func (b *BrokerImpl) deliveries(c *conn, tag, id string) (int, error) {
+	reply, err := c.Do(b.Timeout, "XPENDING", b.key(tag), b.Group, id, id, "1")
+	if err != nil {
+		return 0, err
+	}
+	// 响应：[[id, consumer, idle, deliveries]]
+	items, _ := reply.([]interface{})
+	if len(items) == 0 {
+		return 0, nil
+	}
+	item, ok := items[0].([]interface{})
+	if !ok || len(item) != 4 {
+		return 0, ErrProtocol
+	}
+	deliveries, ok := item[3].(int64)
+	if !ok {
+		return 0, ErrProtocol
+	}
+	return int(deliveries), nil
+}
+
+// moveToDead 将消息添加到死信Stream，并XACK确认原消息
+func (b *BrokerImpl) moveToDead(c *conn, tag string, e entry) error {
+	args := []string{"XADD", b.deadKey(tag), "*"}
+	for field, value := range e.Fields {
+		args = append(args, field, string(value))
+	}
+	if _, err := c.Do(b.Timeout, args...); err != nil {
+		return err
+	}
+	_, err := c.Do(b.Timeout, "XACK", b.key(tag), b.Group, e.ID)
+	return err
+}
+
+// handleEntries 处理消息，成功时XACK确认，失败时保留在Pending列表中等待重新投递，
+// 无法解析的消息移入死信Stream
+func (b *BrokerImpl) handleEntries(c *conn, tag string, entries []entry,
+	handler broker.SubscribeHandler) error {
+	key := b.key(tag)
+	for _, e := range entries {
+		cmd, err := decodeFields(e.Fields)
+		if err != nil {
+			glog.Errorf("broker::redis::handleEntries(%s) message %s decode error: %s, move to dead letter\n",
+				tag, e.ID, err)
+			if err = b.moveToDead(c, tag, e); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = broker.Handle(handler, tag, cmd); err != nil {
+			glog.Warningf("broker::redis::handleEntries(%s) message %s error: %s, pending\n", tag, e.ID, err)
+			continue
+		}
+		if _, err = c.Do(b.Timeout, "XACK", key, b.Group, e.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
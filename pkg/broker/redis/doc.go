@@ -0,0 +1,49 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+/*
+Package redis Redis Streams实现的Broker
+
+tag: 加上stream-prefix前缀作为Stream的key，例如：tag为"push"，对应的key为"zim:push"。
+tag不能为空，也不能包含"|"（死信Stream key的分隔符），否则返回ErrInvalidTag
+
+Command: 保存为Stream消息的字段：version, appid, name, data(JSON), payload
+
+Publish: XADD <key> [MAXLEN ~ <max-len>] * <fields>，没有响应信令
+
+Subscribe: 使用消费组（XGROUP CREATE ... MKSTREAM，从Stream开头消费），
+XREADGROUP读取新消息，SubscribeHandler返回nil时XACK确认；返回error时消息保留在
+Pending Entries List中，空闲超过claim-idle后通过XAUTOCLAIM重新投递，
+同样可以接管已崩溃的消费者未确认的消息。需要Redis 6.2以上版本。
+重新投递前通过XPENDING检查投递次数，已经投递max-retry次的消息添加到死信Stream
+（key为"<stream-prefix>dead|<tag>"，字段与原消息相同）并XACK确认，可以使用XRANGE查看。
+无法解析的消息不投递，直接移入死信Stream。
+
+配置（viper参数前缀 + ".redis."）：
+
+* address: Redis地址，默认"127.0.0.1:6379"
+
+* password: 密码
+
+* db: 数据库编号
+
+* stream-prefix: Stream key前缀，默认"zim:"
+
+* max-len: Stream最大长度（近似值），默认0（不限制）
+
+* group: 消费组名，默认"zim"
+
+* consumer: 消费者名，默认"<hostname>-<pid>"
+
+* count: 每次读取的最大消息数，默认16
+
+* block: XREADGROUP阻塞时间（单位：毫秒），默认1000
+
+* claim-idle: Pending消息空闲多久后重新投递（单位：毫秒），默认30000
+
+* max-retry: 最大投递次数，默认5
+
+* reconnect-interval: 断线重连间隔（单位：毫秒），默认1000
+
+* timeout: 请求超时（单位：毫秒），默认5000，XREADGROUP为block加上timeout。超时后关闭连接并重连
+*/
+package redis
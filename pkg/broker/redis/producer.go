@@ -0,0 +1,58 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package redis
+
+import (
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// Publish 发布，XADD成功后返回，没有响应信令
+func (b *BrokerImpl) Publish(tag string, cmd *protocol.Command) (*protocol.Command, error) {
+	glog.Infof("broker::redis::Publish(%s)%s\n", tag, cmd)
+	if !validTag(tag) {
+		glog.Warningf("broker::redis::Publish(%s) invalid tag\n", tag)
+		return nil, ErrInvalidTag
+	}
+	fields, err := encodeFields(cmd)
+	if err != nil {
+		glog.Warningf("broker::redis::Publish(%s) encode error: %s\n", tag, err)
+		return nil, err
+	}
+	args := []string{"XADD", b.key(tag)}
+	if b.MaxLen > 0 {
+		args = append(args, "MAXLEN", "~", strconv.Itoa(b.MaxLen))
+	}
+	args = append(args, "*")
+	args = append(args, fields...)
+
+	b.producerLocker.Lock()
+	defer b.producerLocker.Unlock()
+	// 连接断开时重连一次
+	for retry := 0; retry < 2; retry++ {
+		if b.isClosing() {
+			return nil, define.ErrConnectionClosed
+		}
+		if b.producer == nil {
+			if b.producer, err = dial(b.Address, b.Password, b.DB, DialTimeout); err != nil {
+				glog.Errorf("broker::redis::Publish(%s) dial(%s) error: %s\n", tag, b.Address, err)
+				return nil, err
+			}
+		}
+		if _, err = b.producer.Do(b.Timeout, args...); err == nil {
+			return nil, nil
+		}
+		if _, ok := err.(Error); ok {
+			glog.Warningf("broker::redis::Publish(%s) error: %s\n", tag, err)
+			return nil, err
+		}
+		// 连接错误或者超时，丢弃连接
+		glog.Warningf("broker::redis::Publish(%s) connection error: %s\n", tag, err)
+		b.producer.Close()
+		b.producer = nil
+	}
+	return nil, err
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrProtocol RESP协议错误
+	ErrProtocol = errors.New("redis protocol error")
+)
+
+// Error Redis返回的错误
+type Error string
+
+// Error 错误信息
+func (e Error) Error() string {
+	return string(e)
+}
+
+// conn RESP协议连接
+type conn struct {
+	sync.Mutex
+	c  net.Conn
+	br *bufio.Reader
+}
+
+// dial 建立连接，并完成认证和选择数据库
+func dial(address, password string, db int, timeout time.Duration) (*conn, error) {
+	c, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	rc := &conn{
+		c:  c,
+		br: bufio.NewReader(c),
+	}
+	if len(password) > 0 {
+		if _, err = rc.Do(timeout, "AUTH", password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if db > 0 {
+		if _, err = rc.Do(timeout, "SELECT", strconv.Itoa(db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// Do 发送命令并在timeout内读取响应
+// 响应类型：string（简单字符串），int64，[]byte（批量字符串），[]interface{}（数组），nil
+// 返回Error以外的错误（包括超时）时连接状态未知，需要关闭连接
+func (c *conn) Do(timeout time.Duration, args ...string) (interface{}, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.c.SetDeadline(time.Now().Add(timeout))
+	defer c.c.SetDeadline(time.Time{})
+	if _, err := c.c.Write(EncodeCommand(args...)); err != nil {
+		return nil, err
+	}
+	return ReadReply(c.br)
+}
+
+// Close 关闭连接
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+// EncodeCommand 编码命令为RESP数组
+func EncodeCommand(args ...string) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return buf.Bytes()
+}
+
+// ReadReply 读取一个响应
+func ReadReply(br *bufio.Reader) (interface{}, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, ErrProtocol
+	}
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = ReadReply(br); err != nil {
+				if _, ok := err.(Error); !ok {
+					return nil, err
+				}
+				array[i] = err
+			}
+		}
+		return array, nil
+	}
+	return nil, ErrProtocol
+}
+
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, ErrProtocol
+	}
+	return line[:len(line)-2], nil
+}
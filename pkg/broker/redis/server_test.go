@@ -0,0 +1,340 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeEntry Stream消息
+type fakeEntry struct {
+	id     string
+	fields []interface{}
+}
+
+// fakePending 已投递未确认的消息
+type fakePending struct {
+	consumer  string
+	delivered time.Time
+	count     int
+}
+
+// fakeGroup 消费组
+type fakeGroup struct {
+	// last 已投递的最后一条消息序号
+	last int
+	// pending Pending Entries List
+	pending map[string]*fakePending
+}
+
+// fakeStream Stream
+type fakeStream struct {
+	entries []*fakeEntry
+	groups  map[string]*fakeGroup
+}
+
+// fakeRedis 测试用Redis，实现PING/XADD/XGROUP/XREADGROUP/XACK/XAUTOCLAIM/XPENDING
+type fakeRedis struct {
+	sync.Mutex
+	listener net.Listener
+	streams  map[string]*fakeStream
+	// sequence 消息ID序号
+	sequence int
+	// acked 已确认的消息数
+	acked int
+}
+
+func newFakeRedis() (*fakeRedis, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &fakeRedis{
+		listener: listener,
+		streams:  make(map[string]*fakeStream),
+	}
+	go r.serve()
+	return r, nil
+}
+
+func (r *fakeRedis) Address() string {
+	return r.listener.Addr().String()
+}
+
+func (r *fakeRedis) Close() {
+	r.listener.Close()
+}
+
+// Stats 返回Stream长度、组内Pending数和已确认数
+func (r *fakeRedis) Stats(key, group string) (length, pending, acked int) {
+	r.Lock()
+	defer r.Unlock()
+	if s, found := r.streams[key]; found {
+		length = len(s.entries)
+		if g, found := s.groups[group]; found {
+			pending = len(g.pending)
+		}
+	}
+	return length, pending, r.acked
+}
+
+func (r *fakeRedis) serve() {
+	for {
+		c, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(c)
+	}
+}
+
+func (r *fakeRedis) handle(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for {
+		reply, err := ReadReply(br)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			data, _ := item.([]byte)
+			args[i] = string(data)
+		}
+		if len(args) == 0 {
+			return
+		}
+		var resp interface{}
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			resp = "PONG"
+		case "AUTH", "SELECT":
+			resp = "OK"
+		case "XADD":
+			resp = r.xadd(args[1:])
+		case "XGROUP":
+			resp = r.xgroup(args[1:])
+		case "XREADGROUP":
+			resp = r.xreadgroup(args[1:])
+		case "XACK":
+			resp = r.xack(args[1:])
+		case "XAUTOCLAIM":
+			resp = r.xautoclaim(args[1:])
+		case "XPENDING":
+			resp = r.xpending(args[1:])
+		default:
+			resp = Error("ERR unknown command '" + args[0] + "'")
+		}
+		buf := new(bytes.Buffer)
+		writeReply(buf, resp)
+		if _, err = c.Write(buf.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func writeReply(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("*-1\r\n")
+	case string:
+		fmt.Fprintf(buf, "+%s\r\n", v)
+	case Error:
+		fmt.Fprintf(buf, "-%s\r\n", v)
+	case int:
+		fmt.Fprintf(buf, ":%d\r\n", v)
+	case int64:
+		fmt.Fprintf(buf, ":%d\r\n", v)
+	case []byte:
+		fmt.Fprintf(buf, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(buf, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(buf, item)
+		}
+	}
+}
+
+func (e *fakeEntry) reply() interface{} {
+	return []interface{}{[]byte(e.id), e.fields}
+}
+
+// xadd XADD key [MAXLEN ~ n] * field value ...
+func (r *fakeRedis) xadd(args []string) interface{} {
+	r.Lock()
+	defer r.Unlock()
+	key, args := args[0], args[1:]
+	maxLen := 0
+	if strings.ToUpper(args[0]) == "MAXLEN" {
+		maxLen, _ = strconv.Atoi(args[2])
+		args = args[3:]
+	}
+	if args[0] != "*" || len(args)%2 != 1 {
+		return Error("ERR syntax error")
+	}
+	s, found := r.streams[key]
+	if !found {
+		s = &fakeStream{groups: make(map[string]*fakeGroup)}
+		r.streams[key] = s
+	}
+	r.sequence++
+	e := &fakeEntry{id: fmt.Sprintf("%d-0", r.sequence)}
+	for _, arg := range args[1:] {
+		e.fields = append(e.fields, []byte(arg))
+	}
+	s.entries = append(s.entries, e)
+	if maxLen > 0 && len(s.entries) > maxLen {
+		trim := len(s.entries) - maxLen
+		s.entries = s.entries[trim:]
+		for _, g := range s.groups {
+			g.last -= trim
+			if g.last < 0 {
+				g.last = 0
+			}
+		}
+	}
+	return []byte(e.id)
+}
+
+// xgroup XGROUP CREATE key group 0 MKSTREAM
+func (r *fakeRedis) xgroup(args []string) interface{} {
+	r.Lock()
+	defer r.Unlock()
+	if strings.ToUpper(args[0]) != "CREATE" {
+		return Error("ERR syntax error")
+	}
+	s, found := r.streams[args[1]]
+	if !found {
+		s = &fakeStream{groups: make(map[string]*fakeGroup)}
+		r.streams[args[1]] = s
+	}
+	if _, found = s.groups[args[2]]; found {
+		return Error("BUSYGROUP Consumer Group name already exists")
+	}
+	s.groups[args[2]] = &fakeGroup{pending: make(map[string]*fakePending)}
+	return "OK"
+}
+
+// xreadgroup XREADGROUP GROUP group consumer COUNT n BLOCK ms STREAMS key >
+func (r *fakeRedis) xreadgroup(args []string) interface{} {
+	if len(args) != 10 || args[9] != ">" {
+		return Error("ERR syntax error")
+	}
+	group, consumer, key := args[1], args[2], args[8]
+	count, _ := strconv.Atoi(args[4])
+	block, _ := strconv.Atoi(args[6])
+	deadline := time.Now().Add(time.Duration(block) * time.Millisecond)
+	for {
+		r.Lock()
+		s, found := r.streams[key]
+		if !found {
+			r.Unlock()
+			return Error("NOGROUP No such key")
+		}
+		g, found := s.groups[group]
+		if !found {
+			r.Unlock()
+			return Error("NOGROUP No such consumer group")
+		}
+		var entries []interface{}
+		for g.last < len(s.entries) && len(entries) < count {
+			e := s.entries[g.last]
+			g.last++
+			g.pending[e.id] = &fakePending{
+				consumer:  consumer,
+				delivered: time.Now(),
+				count:     1,
+			}
+			entries = append(entries, e.reply())
+		}
+		r.Unlock()
+		if len(entries) > 0 {
+			return []interface{}{[]interface{}{[]byte(key), entries}}
+		}
+		if time.Now().After(deadline) {
+			return nil
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+// xack XACK key group id ...
+func (r *fakeRedis) xack(args []string) interface{} {
+	r.Lock()
+	defer r.Unlock()
+	s, found := r.streams[args[0]]
+	if !found {
+		return 0
+	}
+	g, found := s.groups[args[1]]
+	if !found {
+		return 0
+	}
+	n := 0
+	for _, id := range args[2:] {
+		if _, found = g.pending[id]; found {
+			delete(g.pending, id)
+			n++
+		}
+	}
+	r.acked += n
+	return n
+}
+
+// xautoclaim XAUTOCLAIM key group consumer min-idle start COUNT n，一次返回所有符合条件的消息
+func (r *fakeRedis) xautoclaim(args []string) interface{} {
+	r.Lock()
+	defer r.Unlock()
+	s, found := r.streams[args[0]]
+	if !found {
+		return Error("NOGROUP No such key")
+	}
+	g, found := s.groups[args[1]]
+	if !found {
+		return Error("NOGROUP No such consumer group")
+	}
+	minIdle, _ := strconv.Atoi(args[3])
+	entries := []interface{}{}
+	for _, e := range s.entries {
+		p, found := g.pending[e.id]
+		if !found || time.Since(p.delivered) < time.Duration(minIdle)*time.Millisecond {
+			continue
+		}
+		p.consumer = args[2]
+		p.delivered = time.Now()
+		p.count++
+		entries = append(entries, e.reply())
+	}
+	return []interface{}{[]byte("0-0"), entries, []interface{}{}}
+}
+
+// xpending XPENDING key group start end count，start和end为同一个消息ID
+func (r *fakeRedis) xpending(args []string) interface{} {
+	r.Lock()
+	defer r.Unlock()
+	if len(args) != 5 || args[2] != args[3] {
+		return Error("ERR syntax error")
+	}
+	s, found := r.streams[args[0]]
+	if !found {
+		return Error("NOGROUP No such key")
+	}
+	g, found := s.groups[args[1]]
+	if !found {
+		return Error("NOGROUP No such consumer group")
+	}
+	p, found := g.pending[args[2]]
+	if !found {
+		return []interface{}{}
+	}
+	return []interface{}{[]interface{}{[]byte(args[2]), []byte(p.consumer),
+		int64(time.Since(p.delivered) / time.Millisecond), int64(p.count)}}
+}
@@ -4,35 +4,147 @@
 package register
 
 import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
 	"github.com/golang/glog"
+	"github.com/spf13/viper"
 	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/define"
 )
 
 // NewBrokerHandler 新建Broker函数，参数：viper参数perfix
 type NewBrokerHandler func(string) (broker.Broker, error)
 
+// Named 需要知道实例名的Broker，新建实例后设置实例名
+type Named interface {
+	SetInstanceName(name string)
+}
+
+var (
+	// ErrInstanceConflict 同名实例已经使用不同的options新建
+	ErrInstanceConflict = errors.New("broker instance exists with different options")
+)
+
 var (
 	brokerHandlers = make(map[string]NewBrokerHandler)
+	// instanceDefaults 实例参数的默认值，覆盖从默认实例继承的参数
+	instanceDefaults = make(map[string]map[string]interface{})
+	// instanceOptions 实例新建时使用的options
+	instanceOptions = make(map[string]map[string]interface{})
+	// viperPerfix Init设置的viper参数前缀
+	viperPerfix string
+	locker      sync.Mutex
 )
 
 // Register 注册Broker
 func Register(name string, handler NewBrokerHandler) {
 	if _, found := brokerHandlers[name]; found {
-		glog.Warningf("broker::Register() Broker[%s] existed\n")
+		glog.Warningf("broker::Register() Broker[%s] existed\n", name)
 	}
 	brokerHandlers[name] = handler
 }
 
-// Init 初始化
-func Init(viperPerfix string) error {
-	for name, brokerHandler := range brokerHandlers {
-		glog.Infof("broker::Init() Init broker[%s]\n", name)
-		b, err := brokerHandler(viperPerfix)
-		if err != nil {
-			glog.Errorf("broker::Init() init broker[%s] init error: %s", name, err)
-			return err
-		}
-		broker.Set(name, b)
+// SetInstanceDefault 设置实例参数的默认值，实例不再继承默认实例的该参数，
+// 例如：绑定地址等不能被多个实例共用的参数
+func SetInstanceDefault(name, key string, value interface{}) {
+	locker.Lock()
+	defer locker.Unlock()
+	if instanceDefaults[name] == nil {
+		instanceDefaults[name] = make(map[string]interface{})
 	}
+	instanceDefaults[name][key] = value
+}
+
+// Init 初始化，设置viper参数前缀。Broker在路由第一次使用时创建
+func Init(perfix string) error {
+	locker.Lock()
+	defer locker.Unlock()
+	viperPerfix = perfix
 	return nil
 }
+
+// Get 获取默认Broker实例，不存在时新建
+func Get(name string) (broker.Broker, error) {
+	return NewInstance(name, "", nil)
+}
+
+// InstanceName Broker实例名，默认实例使用Broker名
+func InstanceName(name, instance string) string {
+	if len(instance) == 0 {
+		return name
+	}
+	return name + ":" + instance
+}
+
+// NewInstance 获取Broker实例，不存在时新建
+//
+// instance为空时使用默认实例，viper参数前缀为Init设置的前缀；
+// 否则viper参数前缀为<前缀>.instances.<instance>，实例参数默认使用默认实例的参数
+// （SetInstanceDefault设置的参数除外），options中的参数覆盖配置文件中的参数。
+// 实例名在进程内全局唯一，同名实例只新建一次，之后不会关闭或者重新新建。
+// 已有实例的options不同时返回ErrInstanceConflict，修改参数需要使用新的实例名
+func NewInstance(name, instance string, options map[string]interface{}) (broker.Broker, error) {
+	locker.Lock()
+	defer locker.Unlock()
+	brokerHandler, found := brokerHandlers[name]
+	if !found {
+		glog.Errorf("broker::NewInstance() unsupport broker: %s\n", name)
+		return nil, define.ErrUnsupportProtocol
+	}
+	if len(instance) == 0 && len(options) > 0 {
+		glog.Errorf("broker::NewInstance() broker[%s] options need an instance name\n", name)
+		return nil, define.ErrInvalidParameter
+	}
+	instanceName := InstanceName(name, instance)
+	if old := broker.Get(instanceName); old != nil {
+		if sameOptions(instanceOptions[instanceName], options) {
+			return old, nil
+		}
+		glog.Errorf("broker::NewInstance() broker[%s] exists with different options\n", instanceName)
+		return nil, ErrInstanceConflict
+	}
+
+	perfix := viperPerfix
+	if len(instance) > 0 {
+		if strings.Contains(instance, ".") {
+			glog.Errorf("broker::NewInstance() invalid instance name: %s\n", instance)
+			return nil, define.ErrInvalidParameter
+		}
+		perfix = viperPerfix + ".instances." + instance
+		for key, value := range viper.GetStringMap(viperPerfix + "." + name) {
+			viper.SetDefault(perfix+"."+name+"."+key, value)
+		}
+		for key, value := range instanceDefaults[name] {
+			viper.SetDefault(perfix+"."+name+"."+key, value)
+		}
+		for key, value := range options {
+			viper.Set(perfix+"."+name+"."+key, value)
+		}
+	}
+
+	glog.Infof("broker::NewInstance() Init broker[%s]\n", instanceName)
+	b, err := brokerHandler(perfix)
+	if err != nil {
+		glog.Errorf("broker::NewInstance() init broker[%s] error: %s\n", instanceName, err)
+		return nil, err
+	}
+	if named, ok := b.(Named); ok {
+		named.SetInstanceName(instanceName)
+	}
+	if err = broker.Add(instanceName, b); err != nil {
+		return nil, err
+	}
+	instanceOptions[instanceName] = options
+	return b, nil
+}
+
+// sameOptions 比较实例参数，nil与空参数相同
+func sameOptions(a, b map[string]interface{}) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package broker
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+	"github.com/zhangpeihao/zim/pkg/protocol/serialize"
+	"github.com/zhangpeihao/zim/pkg/protocol/serialize/plaintext"
+
+	// 注册所有信令串行化格式
+	_ "github.com/zhangpeihao/zim/pkg/protocol/serialize/register"
+)
+
+const (
+	// DefaultSerializeVersion 命令版本没有对应的串行化格式时，使用的默认串行化格式
+	DefaultSerializeVersion = plaintext.Version
+	// VersionProbeByte 使用默认串行化格式时，消息体以"#<命令版本>\n"开头，解析时恢复命令版本
+	VersionProbeByte byte = '#'
+)
+
+// Marshal 使用信令串行化格式将命令编码为外部消息队列的消息体
+func Marshal(cmd *protocol.Command) ([]byte, error) {
+	c := cmd.Copy()
+	data, err := serialize.Compose(c)
+	if err != define.ErrUnsupportProtocol {
+		return data, err
+	}
+	if strings.ContainsAny(cmd.Version, "\r\n") {
+		return nil, define.ErrInvalidParameter
+	}
+	c.Version = DefaultSerializeVersion
+	if data, err = serialize.Compose(c); err != nil {
+		return nil, err
+	}
+	body := append([]byte{VersionProbeByte}, cmd.Version...)
+	body = append(body, '\n')
+	return append(body, data...), nil
+}
+
+// Unmarshal 解析外部消息队列的消息体，根据首字节自动识别串行化格式
+func Unmarshal(body []byte) (*protocol.Command, error) {
+	if len(body) == 0 {
+		return nil, define.ErrInvalidParameter
+	}
+	if body[0] != VersionProbeByte {
+		return serialize.Parse(body)
+	}
+	index := bytes.IndexByte(body, '\n')
+	if index < 0 {
+		return nil, define.ErrInvalidParameter
+	}
+	cmd, err := serialize.Parse(body[index+1:])
+	if err != nil {
+		return nil, err
+	}
+	cmd.Version = string(body[1:index])
+	return cmd, nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package broker
+
+import (
+	"testing"
+
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+	"github.com/zhangpeihao/zim/pkg/protocol/serialize"
+)
+
+func TestMarshal(t *testing.T) {
+	for _, version := range []string{"t1", "j1", "x9", ""} {
+		cmd := &protocol.Command{
+			Version: version,
+			AppID:   "test",
+			Name:    "msg/foo/bar",
+			Data:    &protocol.GatewayMessageCommand{UserID: "1"},
+			Payload: []byte("foo bar"),
+		}
+		body, err := Marshal(cmd)
+		if err != nil {
+			t.Fatalf("Marshal(%q) error: %s\n", version, err)
+		}
+		got, err := Unmarshal(body)
+		if err != nil {
+			t.Fatalf("Unmarshal(%q) error: %s\n", version, err)
+		}
+		if !cmd.Equal(got) {
+			t.Errorf("Unmarshal(%q) expect: %s, got: %s\n", version, cmd, got)
+		}
+	}
+
+	// 没有版本前缀的旧消息
+	cmd := &protocol.Command{Version: "t1", AppID: "test", Name: "msg/foo",
+		Data: &protocol.GatewayMessageCommand{UserID: "1"}, Payload: []byte("foo bar")}
+	body, _ := serialize.Compose(cmd)
+	if got, err := Unmarshal(body); err != nil || !cmd.Equal(got) {
+		t.Errorf("Unmarshal() old body got: %v, %v\n", got, err)
+	}
+	if _, err := Marshal(&protocol.Command{Version: "x\n9", AppID: "test", Name: "msg"}); err != define.ErrInvalidParameter {
+		t.Errorf("Marshal() version with newline expect ErrInvalidParameter, got: %v\n", err)
+	}
+	if _, err := Unmarshal([]byte("#x9")); err != define.ErrInvalidParameter {
+		t.Errorf("Unmarshal() without newline expect ErrInvalidParameter, got: %v\n", err)
+	}
+}
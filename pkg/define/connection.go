@@ -11,6 +11,8 @@ type Connection interface {
 	UserID() string
 	DeviceID() string
 	LoginSuccess(appid, userid, device, version string)
+	Tags() []string
+	SetTags(tags []string)
 	IsLogin() bool
 	Close(force bool) error
 	String() string
@@ -21,3 +23,8 @@ type Connection interface {
 func ConnectionID(appid, userid string) string {
 	return appid + "#" + userid
 }
+
+// DeviceKey 通过ConnectionID和DeviceID组合成设备连接的标识，限流和管理接口使用同一格式
+func DeviceKey(conn Connection) string {
+	return conn.ID() + "/" + conn.DeviceID()
+}
@@ -19,4 +19,6 @@ var (
 	ErrNeedAuth = errors.New("need auth")
 	// ErrNoMoreMessage 没有消息
 	ErrNoMoreMessage = errors.New("no more message")
+	// ErrQueueFull 队列已满
+	ErrQueueFull = errors.New("queue full")
 )
@@ -0,0 +1,276 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/define"
+)
+
+const (
+	// AdminAuthorizationPrefix 管理接口认证Header前缀，Authorization: Bearer <admin-token>
+	AdminAuthorizationPrefix = "Bearer "
+	// AdminRedacted 管理接口返回的路由实例参数值，参数中可能包含密码
+	AdminRedacted = "******"
+)
+
+// AdminApp 管理接口返回的应用信息（不包含Key，路由实例参数只返回参数名）
+type AdminApp struct {
+	ID          string      `json:"id"`
+	TokenCheck  string      `json:"token-check"`
+	Routes      app.InfoMap `json:"router"`
+	Connections int         `json:"connections"`
+}
+
+// AdminConnection 管理接口返回的连接信息
+type AdminConnection struct {
+	// Key 设备连接标识（define.DeviceKey）
+	Key      string   `json:"key"`
+	ID       string   `json:"id"`
+	AppID    string   `json:"appid"`
+	UserID   string   `json:"userid"`
+	DeviceID string   `json:"deviceid"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// AdminCount 管理接口返回的连接统计
+type AdminCount struct {
+	// Total 连接数
+	Total int `json:"total"`
+	// Users 用户数
+	Users int `json:"users"`
+	// Apps 每个应用的连接数
+	Apps map[string]int `json:"apps"`
+}
+
+// runAdmin 启动管理HTTP服务
+func (srv *Server) runAdmin() error {
+	if len(srv.AdminToken) == 0 {
+		glog.Errorln("gateway::Server::runAdmin() admin-token is required")
+		return define.ErrInvalidParameter
+	}
+	listener, err := net.Listen("tcp", srv.AdminBind)
+	if err != nil {
+		glog.Errorf("gateway::Server::runAdmin() listen(%s) error: %s\n", srv.AdminBind, err)
+		return err
+	}
+	srv.adminServer = &http.Server{Handler: srv.AdminHandler()}
+	go srv.adminServer.Serve(listener)
+	glog.Infof("gateway::Server::runAdmin() admin server listen on %s\n", srv.AdminBind)
+	return nil
+}
+
+// AdminHandler 管理接口
+//
+// * GET /apps: 应用列表
+//
+// * GET /apps/<appid>: 应用信息
+//
+// * PUT /apps/<appid>: 添加或更新应用，内容为应用配置JSON
+//
+// * DELETE /apps/<appid>: 删除应用，并关闭应用的所有连接
+//
+// * GET /connections?appid=<appid>&userid=<userid>: 连接列表，参数可选
+//
+// * GET /connections/count?appid=<appid>: 连接统计，参数可选
+//
+// * POST /kick?appid=<appid>&userid=<userid>&deviceid=<deviceid>: 断开用户连接，deviceid可选
+//
+// * GET /metrics: expvar计数，包括限流计数ratelimit
+func (srv *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps", srv.adminApps)
+	mux.HandleFunc("/apps/", srv.adminApp)
+	mux.HandleFunc("/connections", srv.adminConnections)
+	mux.HandleFunc("/connections/count", srv.adminCount)
+	mux.HandleFunc("/kick", srv.adminKick)
+	mux.Handle("/metrics", expvar.Handler())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := []byte(AdminAuthorizationPrefix + srv.AdminToken)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+			glog.Warningf("gateway::Server::AdminHandler() unauthorized request %s %s from %s\n",
+				r.Method, r.URL.Path, r.RemoteAddr)
+			writeAdminError(w, http.StatusUnauthorized, define.ErrNeedAuth)
+			return
+		}
+		glog.Infof("gateway::Server::AdminHandler() %s %s\n", r.Method, r.URL)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// connectionsSnapshot 复制满足条件的连接，参数为空表示不过滤
+func (srv *Server) connectionsSnapshot(appid, userid, deviceid string) []define.Connection {
+	srv.Lock()
+	defer srv.Unlock()
+	var result []define.Connection
+	for _, connections := range srv.connections {
+		for _, conn := range connections {
+			if (len(appid) == 0 || conn.AppID() == appid) &&
+				(len(userid) == 0 || conn.UserID() == userid) &&
+				(len(deviceid) == 0 || conn.DeviceID() == deviceid) {
+				result = append(result, conn)
+			}
+		}
+	}
+	return result
+}
+
+func (srv *Server) adminAppInfo(a *app.App) *AdminApp {
+	return &AdminApp{
+		ID:          a.ID,
+		TokenCheck:  a.TokenCheck,
+		Routes:      redactRoutes(a.RouteMap),
+		Connections: len(srv.connectionsSnapshot(a.ID, "", "")),
+	}
+}
+
+// redactRoutes 复制路由信息，隐藏实例参数的值
+func redactRoutes(routes app.InfoMap) app.InfoMap {
+	redacted := make(app.InfoMap, len(routes))
+	for key, info := range routes {
+		if len(info.Options) > 0 {
+			options := make(map[string]interface{}, len(info.Options))
+			for name := range info.Options {
+				options[name] = AdminRedacted
+			}
+			info.Options = options
+		}
+		redacted[key] = info
+	}
+	return redacted
+}
+
+func (srv *Server) adminApps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, define.ErrUnsupportProtocol)
+		return
+	}
+	apps := []*AdminApp{}
+	for _, a := range srv.appController.Apps() {
+		apps = append(apps, srv.adminAppInfo(a))
+	}
+	writeAdminJSON(w, http.StatusOK, apps)
+}
+
+func (srv *Server) adminApp(w http.ResponseWriter, r *http.Request) {
+	appid := strings.TrimPrefix(r.URL.Path, "/apps/")
+	if len(appid) == 0 || strings.Contains(appid, "/") {
+		writeAdminError(w, http.StatusNotFound, define.ErrKnownApp)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		a := srv.appController.GetApp(appid)
+		if a == nil {
+			writeAdminError(w, http.StatusNotFound, define.ErrKnownApp)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, srv.adminAppInfo(a))
+	case http.MethodPut:
+		a, err := app.ParseApp(r.Body)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		if a.ID != appid {
+			writeAdminError(w, http.StatusBadRequest, define.ErrInvalidParameter)
+			return
+		}
+		if err = srv.checkPushTag(a); err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		status := http.StatusCreated
+		if srv.appController.GetApp(appid) != nil {
+			status = http.StatusOK
+		}
+		srv.appController.AddApp(a)
+		glog.Warningf("gateway::Server::adminApp() app %s updated\n", appid)
+		writeAdminJSON(w, status, srv.adminAppInfo(a))
+	case http.MethodDelete:
+		a := srv.appController.RemoveApp(appid)
+		if a == nil {
+			writeAdminError(w, http.StatusNotFound, define.ErrKnownApp)
+			return
+		}
+		glog.Warningf("gateway::Server::adminApp() app %s removed\n", appid)
+		writeAdminJSON(w, http.StatusOK, &AdminApp{ID: a.ID, TokenCheck: a.TokenCheck, Routes: redactRoutes(a.RouteMap)})
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, define.ErrUnsupportProtocol)
+	}
+}
+
+func (srv *Server) adminConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, define.ErrUnsupportProtocol)
+		return
+	}
+	query := r.URL.Query()
+	connections := []*AdminConnection{}
+	for _, conn := range srv.connectionsSnapshot(query.Get("appid"), query.Get("userid"), "") {
+		connections = append(connections, &AdminConnection{
+			Key:      define.DeviceKey(conn),
+			ID:       conn.ID(),
+			AppID:    conn.AppID(),
+			UserID:   conn.UserID(),
+			DeviceID: conn.DeviceID(),
+			Tags:     conn.Tags(),
+		})
+	}
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].Key < connections[j].Key
+	})
+	writeAdminJSON(w, http.StatusOK, connections)
+}
+
+func (srv *Server) adminCount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, define.ErrUnsupportProtocol)
+		return
+	}
+	count := &AdminCount{
+		Apps: make(map[string]int),
+	}
+	users := make(map[string]bool)
+	for _, conn := range srv.connectionsSnapshot(r.URL.Query().Get("appid"), "", "") {
+		count.Total++
+		count.Apps[conn.AppID()]++
+		users[conn.ID()] = true
+	}
+	count.Users = len(users)
+	writeAdminJSON(w, http.StatusOK, count)
+}
+
+func (srv *Server) adminKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, define.ErrUnsupportProtocol)
+		return
+	}
+	query := r.URL.Query()
+	appid, userid := query.Get("appid"), query.Get("userid")
+	if len(appid) == 0 || len(userid) == 0 {
+		writeAdminError(w, http.StatusBadRequest, define.ErrInvalidParameter)
+		return
+	}
+	kicked := srv.Kick(appid, userid, query.Get("deviceid"))
+	writeAdminJSON(w, http.StatusOK, map[string]int{"kicked": kicked})
+}
@@ -0,0 +1,229 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package gateway
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	testAdminToken = "secret"
+)
+
+func init() {
+	flag.Set("v", "4")
+	flag.Set("logtostderr", "true")
+}
+
+// testConnection 测试用连接
+type testConnection struct {
+	sync.Mutex
+	appID, userID, deviceID string
+	tags                    []string
+	closed                  bool
+	failSend                bool
+	sent                    []*protocol.Command
+}
+
+func (conn *testConnection) ID() string {
+	return define.ConnectionID(conn.appID, conn.userID)
+}
+func (conn *testConnection) AppID() string    { return conn.appID }
+func (conn *testConnection) UserID() string   { return conn.userID }
+func (conn *testConnection) DeviceID() string { return conn.deviceID }
+func (conn *testConnection) LoginSuccess(appid, userid, device, version string) {
+	conn.appID, conn.userID, conn.deviceID = appid, userid, device
+}
+func (conn *testConnection) Tags() []string        { return conn.tags }
+func (conn *testConnection) SetTags(tags []string) { conn.tags = tags }
+func (conn *testConnection) IsLogin() bool         { return len(conn.appID) > 0 }
+func (conn *testConnection) Close(force bool) error {
+	conn.Lock()
+	defer conn.Unlock()
+	conn.closed = true
+	return nil
+}
+func (conn *testConnection) String() string { return conn.ID() + "/" + conn.deviceID }
+func (conn *testConnection) Send(cmd *protocol.Command) error {
+	conn.Lock()
+	defer conn.Unlock()
+	if conn.failSend {
+		return define.ErrConnectionClosed
+	}
+	conn.sent = append(conn.sent, cmd)
+	return nil
+}
+func (conn *testConnection) isClosed() bool {
+	conn.Lock()
+	defer conn.Unlock()
+	return conn.closed
+}
+
+// newTestServer 新建不启动网络服务的网关，用于测试
+func newTestServer(t *testing.T, connections ...*testConnection) *Server {
+	if err := register.Init("test"); err != nil {
+		t.Fatal("register.Init() error:", err)
+	}
+	controller, err := app.NewController(nil)
+	if err != nil {
+		t.Fatal("NewController() error:", err)
+	}
+	for _, id := range []string{"foo", "bar"} {
+		a, err := app.ParseApp(strings.NewReader(`{"id": "` + id + `", "key": "123", "router": {"*": {"broker": "mock"}}}`))
+		if err != nil {
+			t.Fatal("ParseApp() error:", err)
+		}
+		controller.AddApp(a)
+	}
+	srv := &Server{
+		ServerParameter: ServerParameter{
+			AdminToken: testAdminToken,
+		},
+		connections:   make(map[string][]define.Connection),
+		appController: controller,
+		tag:           ServerName,
+		pushTag:       DefaultPushTag,
+		subscriptions: make(map[subscription]bool),
+	}
+	controller.OnAppRemoved(srv.OnAppRemoved)
+	for _, conn := range connections {
+		srv.connections[conn.ID()] = append(srv.connections[conn.ID()], conn)
+	}
+	return srv
+}
+
+func adminRequest(t *testing.T, server *httptest.Server, method, path string, body io.Reader, v interface{}) int {
+	req, err := http.NewRequest(method, server.URL+path, body)
+	if err != nil {
+		t.Fatal("NewRequest() error:", err)
+	}
+	req.Header.Set("Authorization", AdminAuthorizationPrefix+testAdminToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Do() error:", err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s decode response error: %s\n", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdmin(t *testing.T) {
+	conns := []*testConnection{
+		{appID: "foo", userID: "u1", deviceID: "d1"},
+		{appID: "foo", userID: "u1", deviceID: "d2"},
+		{appID: "foo", userID: "u2", deviceID: "d1"},
+		{appID: "bar", userID: "u1", deviceID: "d1"},
+	}
+	srv := newTestServer(t, conns...)
+	server := httptest.NewServer(srv.AdminHandler())
+	defer server.Close()
+
+	// 认证
+	resp, err := http.Get(server.URL + "/apps")
+	if err != nil {
+		t.Fatal("Get() error:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthorized request got status: %d\n", resp.StatusCode)
+	}
+
+	var apps []*AdminApp
+	if status := adminRequest(t, server, "GET", "/apps", nil, &apps); status != http.StatusOK ||
+		len(apps) != 2 || apps[0].ID != "bar" || apps[1].ID != "foo" || apps[1].Connections != 3 {
+		t.Errorf("GET /apps got: %d, %v\n", status, apps)
+	}
+
+	var count AdminCount
+	if adminRequest(t, server, "GET", "/connections/count", nil, &count); count.Total != 4 ||
+		count.Users != 3 || count.Apps["foo"] != 3 || count.Apps["bar"] != 1 {
+		t.Errorf("GET /connections/count got: %+v\n", count)
+	}
+
+	var connections []*AdminConnection
+	if adminRequest(t, server, "GET", "/connections?appid=foo&userid=u1", nil, &connections); len(connections) != 2 ||
+		connections[0].DeviceID != "d1" || connections[1].DeviceID != "d2" ||
+		connections[0].Key != define.ConnectionID("foo", "u1")+"/d1" {
+		t.Errorf("GET /connections got: %v\n", connections)
+	}
+
+	// 断开单个设备
+	var kicked map[string]int
+	if adminRequest(t, server, "POST", "/kick?appid=foo&userid=u1&deviceid=d2", nil, &kicked); kicked["kicked"] != 1 ||
+		conns[0].isClosed() || !conns[1].isClosed() {
+		t.Errorf("POST /kick got: %v\n", kicked)
+	}
+	if status := adminRequest(t, server, "POST", "/kick?appid=foo", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("POST /kick without userid got status: %d\n", status)
+	}
+
+	// 添加、更新应用，通知应用变化，不返回路由实例参数的值
+	var updated []string
+	srv.appController.OnAppUpdated(func(a *app.App) { updated = append(updated, a.ID) })
+	var a AdminApp
+	body := `{"id": "baz", "key": "456", "token-check": "yes", "router": {"msg/*": {"broker": "mock", "tag": "chat",
+		"instance": "admin", "options": {"password": "secret"}}}}`
+	if status := adminRequest(t, server, "PUT", "/apps/baz", strings.NewReader(body), &a); status != http.StatusCreated ||
+		a.ID != "baz" || a.Routes["msg/*"].Tag != "chat" || a.Routes["msg/*"].Options["password"] != AdminRedacted {
+		t.Errorf("PUT /apps/baz got: %d, %+v\n", status, a)
+	}
+	if route := srv.appController.GetApp("baz").Router.Find("msg/foo"); route == nil || route.Tag != "chat" {
+		t.Errorf("new app route got: %v\n", route)
+	}
+	if status := adminRequest(t, server, "PUT", "/apps/baz", strings.NewReader(body), nil); status != http.StatusOK {
+		t.Errorf("PUT /apps/baz update got status: %d\n", status)
+	}
+	body = strings.Replace(body, "chat", "talk", 1)
+	if status := adminRequest(t, server, "PUT", "/apps/baz", strings.NewReader(body), nil); status != http.StatusOK {
+		t.Errorf("PUT /apps/baz update got status: %d\n", status)
+	}
+	if strings.Join(updated, ",") != "baz,baz" {
+		t.Errorf("updated handlers got: %v\n", updated)
+	}
+	if srv.appController.GetApp("baz").RouteMap["msg/*"].Options["password"] != "secret" {
+		t.Error("redact should not modify the app config")
+	}
+	body = `{"id": "baz", "router": {"*": {"broker": "mock", "tag": "` + DefaultPushTag + `"}}}`
+	if status := adminRequest(t, server, "PUT", "/apps/baz", strings.NewReader(body), nil); status != http.StatusBadRequest {
+		t.Errorf("PUT /apps/baz with push tag got status: %d\n", status)
+	}
+	if status := adminRequest(t, server, "PUT", "/apps/xxx", strings.NewReader(body), nil); status != http.StatusBadRequest {
+		t.Errorf("PUT /apps/xxx with unmatched id got status: %d\n", status)
+	}
+	body = `{"id": "baz", "router": {"*": {"broker": "unknown"}}}`
+	if status := adminRequest(t, server, "PUT", "/apps/baz", strings.NewReader(body), nil); status != http.StatusBadRequest {
+		t.Errorf("PUT /apps/baz with unknown broker got status: %d\n", status)
+	}
+
+	// 删除应用，关闭应用的所有连接
+	if status := adminRequest(t, server, "DELETE", "/apps/bar", nil, &a); status != http.StatusOK || a.ID != "bar" {
+		t.Errorf("DELETE /apps/bar got: %d, %+v\n", status, a)
+	}
+	if !conns[3].isClosed() || conns[2].isClosed() {
+		t.Error("DELETE /apps/bar should close the connections of bar only")
+	}
+	if status := adminRequest(t, server, "GET", "/apps/bar", nil, nil); status != http.StatusNotFound {
+		t.Errorf("GET /apps/bar after delete got status: %d\n", status)
+	}
+}
+func (conn *testConnection) sentCount() int {
+	conn.Lock()
+	defer conn.Unlock()
+	return len(conn.sent)
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/mock"
+	"github.com/zhangpeihao/zim/pkg/interceptor"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+func TestInterceptor(t *testing.T) {
+	conn := &testConnection{appID: "filter", userID: "u1", deviceID: "d1"}
+	srv := newTestServer(t, conn)
+	a, err := app.ParseApp(strings.NewReader(`{
+		"id": "filter",
+		"key": "123",
+		"router": {"*": {"broker": "mock"}},
+		"interceptors": [
+			{"type": "max-payload", "options": {"size": 8}},
+			{"type": "blocklist", "commands": ["msg"], "options": {"words": ["spam"], "action": "drop"}},
+			{"type": "blocklist", "direction": "both", "options": {"words": ["foo"], "action": "mask"}},
+			{"type": "blocklist", "direction": "outbound", "options": {"words": ["secret"]}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	srv.appController.AddApp(a)
+	srv.ctx = srv.appController.SaveIntoContext(context.Background())
+
+	var published []*protocol.Command
+	mock.PublishMockHandler[ServerName] = func(tag string, cmd *protocol.Command) (*protocol.Command, error) {
+		published = append(published, cmd)
+		return nil, nil
+	}
+	defer delete(mock.PublishMockHandler, ServerName)
+	receive := func(name, payload string) {
+		if err := srv.OnReceivedCommand(conn, &protocol.Command{
+			Version: "t1", AppID: "filter", Name: name, Payload: []byte(payload),
+		}); err != nil {
+			t.Errorf("OnReceivedCommand(%s, %s) error: %s\n", name, payload, err)
+		}
+	}
+
+	// 修改后发布
+	receive("msg/chat", "hi foo")
+	if len(published) != 1 || string(published[0].Payload) != "hi ***" {
+		t.Fatalf("published: %v\n", published)
+	}
+	// 丢弃，不通知客户端
+	receive("msg/chat", "spam")
+	// 拒绝，发送err信令给客户端
+	receive("msg/chat", "too long payload")
+	if len(published) != 1 || conn.sentCount() != 1 {
+		t.Fatalf("published: %d, sent: %d\n", len(published), conn.sentCount())
+	}
+	if errCmd, ok := conn.sent[0].Data.(*protocol.ErrorCommand); !ok || conn.sent[0].Name != protocol.Error ||
+		errCmd.Code != interceptor.CodePayloadTooLarge || errCmd.Name != "msg/chat" {
+		t.Errorf("err command: %s\n", conn.sent[0])
+	}
+
+	// 出站拦截
+	push := func(payload string) (*PushReport, error) {
+		return srv.Push(&protocol.Command{AppID: "filter", Name: protocol.Push2User,
+			Data: &protocol.Push2UserCommand{UserIDList: "u1"}, Payload: []byte(payload)})
+	}
+	if report, err := push("foo bar"); err != nil || report.Delivered != 1 ||
+		string(conn.sent[1].Payload) != "*** bar" {
+		t.Errorf("push masked got: %+v, %v\n", report, err)
+	}
+	if _, err := push("secret"); err == nil {
+		t.Error("push secret should be rejected")
+	}
+	if conn.sentCount() != 2 {
+		t.Errorf("sent: %d\n", conn.sentCount())
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/mock"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/jwt"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+func TestLoginJWT(t *testing.T) {
+	srv := newTestServer(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("ecdsa.GenerateKey() error:", err)
+	}
+	pkix, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	publicKey, _ := json.Marshal(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix})))
+	a, err := app.ParseApp(strings.NewReader(`{
+		"id": "jwt",
+		"token-check": "jwt",
+		"jwt": {"audience": "zim", "device-claim": "did"},
+		"keys": [
+			{"id": "hs", "secret": "secret", "usage": "client"},
+			{"id": "es", "public-key": ` + string(publicKey) + `, "usage": "client"},
+			{"id": "server", "secret": "server", "usage": "server"}
+		],
+		"router": {"*": {"broker": "mock"}}
+	}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	srv.appController.AddApp(a)
+	srv.ctx = srv.appController.SaveIntoContext(context.Background())
+
+	published := make(chan *protocol.GatewayLoginCommand, 1)
+	mock.PublishMockHandler[ServerName] = func(tag string, cmd *protocol.Command) (*protocol.Command, error) {
+		published <- cmd.Data.(*protocol.GatewayLoginCommand)
+		return nil, nil
+	}
+	defer delete(mock.PublishMockHandler, ServerName)
+
+	exp := time.Now().Unix() + 60
+	sign := func(algorithm, kid string, claims jwt.Claims, key interface{}) string {
+		token, err := jwt.Sign(algorithm, kid, claims, key)
+		if err != nil {
+			t.Fatal("jwt.Sign() error:", err)
+		}
+		return token
+	}
+	login := func(userID, token string) (*testConnection, error) {
+		conn := &testConnection{}
+		return conn, srv.OnReceivedCommand(conn, &protocol.Command{
+			Version: "t1",
+			AppID:   "jwt",
+			Name:    protocol.Login,
+			Data:    &protocol.GatewayLoginCommand{UserID: userID, Token: token},
+		})
+	}
+
+	claims := jwt.Claims{"sub": "u1", "did": "web", "aud": "zim", "tags": []string{"vip"}, "exp": exp}
+	for _, token := range []string{
+		sign(jwt.HS256, "hs", claims, []byte("secret")),
+		sign(jwt.ES256, "es", claims, ecKey),
+	} {
+		conn, err := login("", token)
+		if err != nil {
+			t.Fatal("login error:", err)
+		}
+		if conn.UserID() != "u1" || conn.DeviceID() != "web" || strings.Join(conn.Tags(), ",") != "vip" {
+			t.Errorf("login connection: %+v\n", conn)
+		}
+		if loginCmd := <-published; loginCmd.UserID != "u1" || strings.Join(loginCmd.Tags, ",") != "vip" {
+			t.Errorf("published login command: %+v\n", loginCmd)
+		}
+	}
+
+	for name, token := range map[string]string{
+		"expired":      sign(jwt.HS256, "hs", jwt.Claims{"sub": "u1", "aud": "zim", "exp": 1}, []byte("secret")),
+		"audience":     sign(jwt.HS256, "hs", jwt.Claims{"sub": "u1", "aud": "other", "exp": exp}, []byte("secret")),
+		"no exp":       sign(jwt.HS256, "hs", jwt.Claims{"sub": "u1", "aud": "zim"}, []byte("secret")),
+		"server key":   sign(jwt.HS256, "server", claims, []byte("server")),
+		"unknown key":  sign(jwt.HS256, "none", claims, []byte("secret")),
+		"wrong secret": sign(jwt.HS256, "hs", claims, []byte("other")),
+		"no subject":   sign(jwt.HS256, "hs", jwt.Claims{"aud": "zim", "exp": exp}, []byte("secret")),
+		"garbage":      "foo",
+	} {
+		if conn, err := login("", token); err != define.ErrNeedAuth || conn.IsLogin() {
+			t.Errorf("%s token expect ErrNeedAuth, got: %v\n", name, err)
+		}
+	}
+	if _, err = login("u2", sign(jwt.HS256, "hs", claims, []byte("secret"))); err != define.ErrNeedAuth {
+		t.Errorf("unmatched user id expect ErrNeedAuth, got: %v\n", err)
+	}
+	select {
+	case loginCmd := <-published:
+		t.Errorf("failed login should not publish: %+v\n", loginCmd)
+	default:
+	}
+}
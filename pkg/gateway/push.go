@@ -0,0 +1,272 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package gateway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/broker/httpapi"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/interceptor"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+	"github.com/zhangpeihao/zim/pkg/webhook"
+)
+
+const (
+	// PushTag 推送接口计算CheckSum使用的tag
+	PushTag = "push"
+	// PushAll 推送给应用的所有用户
+	PushAll = "*"
+)
+
+// PushUserReport 单个用户的推送结果
+type PushUserReport struct {
+	// Online 是否在线
+	Online bool `json:"online"`
+	// Delivered 发送成功的连接数
+	Delivered int `json:"delivered"`
+	// Failed 发送失败的连接数
+	Failed int `json:"failed"`
+}
+
+// PushReport 推送结果
+type PushReport struct {
+	// Async 异步推送，Delivered和Failed为0，Users中只有在线状态
+	Async bool `json:"async,omitempty"`
+	// Dropped 被出站拦截器丢弃，没有推送
+	Dropped bool `json:"dropped,omitempty"`
+	// Online 在线用户数
+	Online int `json:"online"`
+	// Offline 离线用户数
+	Offline int `json:"offline"`
+	// Delivered 发送成功的连接数
+	Delivered int `json:"delivered"`
+	// Failed 发送失败的连接数
+	Failed int `json:"failed"`
+	// Users 每个用户的推送结果
+	Users map[string]*PushUserReport `json:"users"`
+}
+
+// pushTarget 推送目标
+type pushTarget struct {
+	userID      string
+	connections []define.Connection
+}
+
+// pushTargets 查找推送目标，只查找命令所属应用的用户
+// Tags为*时推送给所有用户，否则推送给UserIDList中的用户和标签匹配的用户
+func (srv *Server) pushTargets(appid string, pushCmd *protocol.Push2UserCommand) (targets []*pushTarget, report *PushReport) {
+	report = &PushReport{Users: make(map[string]*PushUserReport)}
+	tags := make(map[string]bool)
+	for _, tag := range strings.Split(pushCmd.Tags, ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			tags[tag] = true
+		}
+	}
+	add := func(userID string, connections []define.Connection) {
+		if _, found := report.Users[userID]; found {
+			return
+		}
+		report.Users[userID] = &PushUserReport{Online: len(connections) > 0}
+		if len(connections) == 0 {
+			report.Offline++
+			return
+		}
+		report.Online++
+		targets = append(targets, &pushTarget{
+			userID:      userID,
+			connections: append([]define.Connection(nil), connections...),
+		})
+	}
+
+	srv.Lock()
+	defer srv.Unlock()
+	for _, id := range strings.Split(pushCmd.UserIDList, ",") {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			add(id, srv.connections[define.ConnectionID(appid, id)])
+		}
+	}
+	if len(tags) > 0 {
+		for _, connections := range srv.connections {
+			if len(connections) == 0 || connections[0].AppID() != appid {
+				continue
+			}
+			if tags[PushAll] || matchTags(connections, tags) {
+				add(connections[0].UserID(), connections)
+			}
+		}
+	}
+	return
+}
+
+// matchTags 用户的任意一个连接包含任意一个标签
+func matchTags(connections []define.Connection, tags map[string]bool) bool {
+	for _, conn := range connections {
+		for _, tag := range conn.Tags() {
+			if tags[tag] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deliver 发送给所有目标连接，并记录结果，发送失败时通知push-dropped事件
+func (srv *Server) deliver(cmd *protocol.Command, targets []*pushTarget, report *PushReport) {
+	touser := cmd.Copy()
+	touser.Data = nil
+	for _, target := range targets {
+		userReport := report.Users[target.userID]
+		for _, conn := range target.connections {
+			if err := conn.Send(touser); err != nil {
+				glog.Warningf("gateway::Server::deliver() send to %s error: %s\n", define.DeviceKey(conn), err)
+				userReport.Failed++
+				report.Failed++
+				srv.notify(cmd.AppID, connectionEvent(webhook.EventPushDropped, conn, err.Error()))
+			} else {
+				userReport.Delivered++
+				report.Delivered++
+			}
+		}
+	}
+}
+
+// outbound 执行应用的出站拦截器
+func (srv *Server) outbound(cmd *protocol.Command) (*protocol.Command, error) {
+	a := srv.appController.GetApp(cmd.AppID)
+	if a == nil {
+		return cmd, nil
+	}
+	return a.Chain.Outbound(cmd)
+}
+
+// Push 推送消息给用户，返回推送结果
+func (srv *Server) Push(cmd *protocol.Command) (*PushReport, error) {
+	pushCmd, ok := cmd.Data.(*protocol.Push2UserCommand)
+	if !ok {
+		glog.Warningln("gateway::Server::Push() parse result error")
+		return nil, define.ErrInvalidParameter
+	}
+	cmd, err := srv.outbound(cmd)
+	if err == interceptor.ErrDrop {
+		return &PushReport{Dropped: true, Users: make(map[string]*PushUserReport)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	targets, report := srv.pushTargets(cmd.AppID, pushCmd)
+	srv.deliver(cmd, targets, report)
+	glog.Infof("gateway::Server::Push(%s) online: %d, offline: %d, delivered: %d, failed: %d\n",
+		cmd.AppID, report.Online, report.Offline, report.Delivered, report.Failed)
+	return report, nil
+}
+
+// PushAsync 异步推送消息给用户，查找推送目标后立即返回
+func (srv *Server) PushAsync(cmd *protocol.Command) (*PushReport, error) {
+	pushCmd, ok := cmd.Data.(*protocol.Push2UserCommand)
+	if !ok {
+		glog.Warningln("gateway::Server::PushAsync() parse result error")
+		return nil, define.ErrInvalidParameter
+	}
+	cmd, err := srv.outbound(cmd)
+	if err == interceptor.ErrDrop {
+		return &PushReport{Dropped: true, Users: make(map[string]*PushUserReport)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	targets, report := srv.pushTargets(cmd.AppID, pushCmd)
+	report.Async = true
+	// 发送结果不返回，使用副本记录
+	result := &PushReport{Users: make(map[string]*PushUserReport)}
+	for userID, userReport := range report.Users {
+		copied := *userReport
+		result.Users[userID] = &copied
+	}
+	go func() {
+		srv.deliver(cmd, targets, result)
+		glog.Infof("gateway::Server::PushAsync(%s) online: %d, offline: %d, delivered: %d, failed: %d\n",
+			cmd.AppID, report.Online, report.Offline, result.Delivered, result.Failed)
+	}()
+	return report, nil
+}
+
+// rejectionError 出站拦截器拒绝推送时的错误，使用拦截器的错误码。
+// HTTP状态码不由错误码决定：拦截器内部错误为500，其他为400（重试不会成功）
+func rejectionError(rejection *interceptor.Rejection) *httpapi.Error {
+	status := http.StatusBadRequest
+	if rejection.Code == interceptor.CodeInternal {
+		status = http.StatusInternalServerError
+	}
+	return &httpapi.Error{Code: rejection.Code, Message: rejection.Message, HTTPStatus: status}
+}
+
+// runPush 启动推送HTTP服务
+func (srv *Server) runPush() error {
+	listener, err := net.Listen("tcp", srv.PushBind)
+	if err != nil {
+		glog.Errorf("gateway::Server::runPush() listen(%s) error: %s\n", srv.PushBind, err)
+		return err
+	}
+	srv.pushServer = &http.Server{Handler: srv.PushHandler()}
+	go srv.pushServer.Serve(listener)
+	glog.Infof("gateway::Server::runPush() push server listen on %s\n", srv.PushBind)
+	return nil
+}
+
+// PushHandler 推送接口
+//
+// POST /push?async=true
+//
+// 请求Header与httpapi Broker相同（tag为push），Zim-Name为p2u，Zim-Data为推送目标，Payload为推送内容。
+// 同步推送返回200和推送结果（PushReport），async=true时查找推送目标后返回202，在后台发送。
+// 请求失败时返回httpapi的错误响应
+func (srv *Server) PushHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+PushTag, func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if r.Method != http.MethodPost {
+			httpapi.WriteError(w, httpapi.ErrInvalidParameter)
+			return
+		}
+		payload, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			glog.Warningf("gateway::Server::PushHandler() read payload error: %s\n", err)
+			httpapi.WriteError(w, httpapi.ErrInvalidParameter)
+			return
+		}
+		cmd, err := httpapi.ParseCommand(srv.ctx, PushTag, r.Header, payload, srv.PushTimeout, srv.pushNonces)
+		if err != nil {
+			glog.Warningf("gateway::Server::PushHandler() ParseCommand error: %s\n", err)
+			httpapi.WriteError(w, err)
+			return
+		}
+		if cmd.Name != protocol.Push2User {
+			glog.Warningf("gateway::Server::PushHandler() unsupport command %s\n", cmd.Name)
+			httpapi.WriteError(w, httpapi.ErrInvalidParameter)
+			return
+		}
+		var report *PushReport
+		status := http.StatusOK
+		if async := r.URL.Query().Get("async"); async == "true" || async == "1" {
+			report, err = srv.PushAsync(cmd)
+			status = http.StatusAccepted
+		} else {
+			report, err = srv.Push(cmd)
+		}
+		if rejection, ok := err.(*interceptor.Rejection); ok {
+			httpapi.WriteError(w, rejectionError(rejection))
+			return
+		} else if err != nil {
+			httpapi.WriteError(w, httpapi.ErrInvalidParameter)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	})
+	return mux
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/httpapi"
+	"github.com/zhangpeihao/zim/pkg/interceptor"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+func TestPush(t *testing.T) {
+	u1d1 := &testConnection{appID: "foo", userID: "u1", deviceID: "d1", tags: []string{"vip"}}
+	u1d2 := &testConnection{appID: "foo", userID: "u1", deviceID: "d2", failSend: true}
+	u2 := &testConnection{appID: "foo", userID: "u2", deviceID: "d1"}
+	bar := &testConnection{appID: "bar", userID: "u1", deviceID: "d1", tags: []string{"vip"}}
+	srv := newTestServer(t, u1d1, u1d2, u2, bar)
+	srv.ctx = srv.appController.SaveIntoContext(context.Background())
+	srv.PushTimeout = httpapi.DefaultTimeout
+	srv.pushNonces = httpapi.NewNonceCache(0)
+	server := httptest.NewServer(srv.PushHandler())
+	defer server.Close()
+
+	push := func(query string, data *protocol.Push2UserCommand) (int, *PushReport) {
+		cmd := &protocol.Command{AppID: "foo", Name: protocol.Push2User, Data: data, Payload: []byte("hello")}
+		req, err := http.NewRequest("POST", server.URL+"/"+PushTag+query, bytes.NewReader(cmd.Payload))
+		if err != nil {
+			t.Fatal("NewRequest() error:", err)
+		}
+		if err = httpapi.ComposeCommand(srv.ctx, PushTag, req.Header, cmd); err != nil {
+			t.Fatal("ComposeCommand() error:", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Do() error:", err)
+		}
+		defer resp.Body.Close()
+		var report PushReport
+		json.NewDecoder(resp.Body).Decode(&report)
+		return resp.StatusCode, &report
+	}
+
+	status, report := push("", &protocol.Push2UserCommand{UserIDList: "u1,u3"})
+	if status != http.StatusOK || report.Online != 1 || report.Offline != 1 ||
+		report.Delivered != 1 || report.Failed != 1 {
+		t.Errorf("push to u1,u3 got: %d, %+v\n", status, report)
+	}
+	if user := report.Users["u1"]; user == nil || !user.Online || user.Delivered != 1 || user.Failed != 1 {
+		t.Errorf("u1 report: %+v\n", user)
+	}
+	if user := report.Users["u3"]; user == nil || user.Online {
+		t.Errorf("u3 report: %+v\n", user)
+	}
+
+	// 按标签推送，不推送给其他应用的用户
+	if status, report = push("", &protocol.Push2UserCommand{Tags: "vip"}); status != http.StatusOK ||
+		report.Online != 1 || report.Users["u1"] == nil || bar.sentCount() != 0 {
+		t.Errorf("push to tag vip got: %d, %+v\n", status, report)
+	}
+	if status, report = push("", &protocol.Push2UserCommand{Tags: PushAll}); report.Online != 2 || report.Delivered != 2 {
+		t.Errorf("push to all got: %d, %+v\n", status, report)
+	}
+
+	// 异步推送
+	status, report = push("?async=true", &protocol.Push2UserCommand{UserIDList: "u2"})
+	if status != http.StatusAccepted || !report.Async || report.Online != 1 || report.Delivered != 0 {
+		t.Errorf("async push got: %d, %+v\n", status, report)
+	}
+	for i := 0; i < 100 && u2.sentCount() < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if u2.sentCount() != 2 || bar.sentCount() != 0 {
+		t.Errorf("u2 sent: %d, bar sent: %d\n", u2.sentCount(), bar.sentCount())
+	}
+
+	// 未签名的请求
+	resp, err := http.Post(server.URL+"/"+PushTag, "text/plain", bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatal("Post() error:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get(httpapi.HeaderCode) != "421" {
+		t.Errorf("unsigned push got: %d, %s\n", resp.StatusCode, resp.Header.Get(httpapi.HeaderCode))
+	}
+}
+
+func TestPushRejected(t *testing.T) {
+	srv := newTestServer(t)
+	a, err := app.ParseApp(strings.NewReader(`{
+		"id": "schema",
+		"key": "123",
+		"router": {"*": {"broker": "mock"}},
+		"interceptors": [
+			{"type": "json-schema", "direction": "outbound", "options": {"schema": {"type": "object"}}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	srv.appController.AddApp(a)
+	srv.ctx = srv.appController.SaveIntoContext(context.Background())
+	srv.PushTimeout = httpapi.DefaultTimeout
+	srv.pushNonces = httpapi.NewNonceCache(0)
+	server := httptest.NewServer(srv.PushHandler())
+	defer server.Close()
+
+	cmd := &protocol.Command{AppID: "schema", Name: protocol.Push2User,
+		Data: &protocol.Push2UserCommand{UserIDList: "u1"}, Payload: []byte("not json")}
+	req, _ := http.NewRequest("POST", server.URL+"/"+PushTag, bytes.NewReader(cmd.Payload))
+	if err = httpapi.ComposeCommand(srv.ctx, PushTag, req.Header, cmd); err != nil {
+		t.Fatal("ComposeCommand() error:", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Do() error:", err)
+	}
+	resp.Body.Close()
+	// 格式错误的推送不能与Nonce缓存已满（稍后重试）混淆
+	if resp.StatusCode != http.StatusBadRequest ||
+		resp.Header.Get(httpapi.HeaderCode) != strconv.Itoa(interceptor.CodeInvalidPayload) {
+		t.Errorf("rejected push got: %d, %s\n", resp.StatusCode, resp.Header.Get(httpapi.HeaderCode))
+	}
+}
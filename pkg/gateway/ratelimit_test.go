@@ -0,0 +1,158 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/mock"
+	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+	"github.com/zhangpeihao/zim/pkg/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	conn := &testConnection{appID: "limit", userID: "u1", deviceID: "d1"}
+	srv := newTestServer(t, conn)
+	a, err := app.ParseApp(strings.NewReader(`{
+		"id": "limit",
+		"key": "123",
+		"router": {"*": {"broker": "mock"}},
+		"rate-limits": [
+			{"commands": ["msg"], "rate": 20, "burst": 1, "action": "delay", "max-delay": 100},
+			{"commands": ["msg"], "scope": "user", "rate": 0.1, "burst": 2},
+			{"scope": "app", "rate": 0.1, "burst": 3, "action": "disconnect"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	srv.appController.AddApp(a)
+	srv.ctx = srv.appController.SaveIntoContext(context.Background())
+
+	published := 0
+	mock.PublishMockHandler[ServerName] = func(tag string, cmd *protocol.Command) (*protocol.Command, error) {
+		published++
+		return nil, nil
+	}
+	defer delete(mock.PublishMockHandler, ServerName)
+	receive := func(name string) error {
+		return srv.OnReceivedCommand(conn, &protocol.Command{
+			Version: "t1", AppID: "limit", Name: name, Payload: []byte("hi"),
+		})
+	}
+
+	// 第二个信令等待连接的令牌
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := receive("msg/chat"); err != nil {
+			t.Fatal("OnReceivedCommand() error:", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("second command should be delayed, elapsed: %s\n", elapsed)
+	}
+	// 用户的令牌用完，拒绝并发送err信令，连接保持
+	if err := receive("msg/chat"); err != nil {
+		t.Fatal("OnReceivedCommand() error:", err)
+	}
+	if published != 2 || conn.sentCount() != 1 || conn.isClosed() {
+		t.Fatalf("published: %d, sent: %d, closed: %v\n", published, conn.sentCount(), conn.isClosed())
+	}
+	if errCmd, ok := conn.sent[0].Data.(*protocol.ErrorCommand); !ok || conn.sent[0].Name != protocol.Error ||
+		errCmd.Code != ratelimit.CodeRateLimited || errCmd.Name != "msg/chat" {
+		t.Errorf("err command: %s\n", conn.sent[0])
+	}
+	// 应用的令牌用完，断开连接
+	if err := receive("ping"); err != nil {
+		t.Fatal("OnReceivedCommand() error:", err)
+	}
+	if err := receive("ping"); err != ratelimit.ErrRateLimited || !conn.isClosed() {
+		t.Errorf("got %v, closed: %v\n", err, conn.isClosed())
+	}
+	if published != 3 {
+		t.Errorf("published: %d\n", published)
+	}
+}
+
+func TestRateLimitMaxDelay(t *testing.T) {
+	conn := &testConnection{appID: "slow", userID: "u1", deviceID: "d1"}
+	srv := newTestServer(t, conn)
+	a, err := app.ParseApp(strings.NewReader(`{
+		"id": "slow",
+		"key": "123",
+		"router": {"*": {"broker": "mock"}},
+		"rate-limits": [
+			{"rate": 0.1, "burst": 1, "action": "delay", "max-delay": 60000}
+		]
+	}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	srv.appController.AddApp(a)
+	srv.ctx = srv.appController.SaveIntoContext(context.Background())
+
+	mock.PublishMockHandler[ServerName] = func(tag string, cmd *protocol.Command) (*protocol.Command, error) {
+		return nil, nil
+	}
+	defer delete(mock.PublishMockHandler, ServerName)
+
+	// 等待时间超过MaxLimitDelay时拒绝，不阻塞连接
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := srv.OnReceivedCommand(conn, &protocol.Command{
+			Version: "t1", AppID: "slow", Name: "msg/chat", Payload: []byte("hi"),
+		}); err != nil {
+			t.Fatal("OnReceivedCommand() error:", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= MaxLimitDelay {
+		t.Errorf("command should not be delayed over %s, elapsed: %s\n", MaxLimitDelay, elapsed)
+	}
+	if conn.sentCount() != 1 || conn.sent[0].Name != protocol.Error || conn.isClosed() {
+		t.Errorf("sent: %d, closed: %v\n", conn.sentCount(), conn.isClosed())
+	}
+}
+
+func TestRateLimitAppID(t *testing.T) {
+	conn := &testConnection{appID: "strict", userID: "u1", deviceID: "d1"}
+	srv := newTestServer(t, conn)
+	for _, config := range []string{
+		`{"id": "strict", "key": "123", "router": {"*": {"broker": "mock"}},
+			"rate-limits": [{"rate": 0.1, "burst": 1}]}`,
+		`{"id": "loose", "key": "456", "router": {"*": {"broker": "mock"}}}`,
+	} {
+		a, err := app.ParseApp(strings.NewReader(config))
+		if err != nil {
+			t.Fatal("ParseApp() error:", err)
+		}
+		srv.appController.AddApp(a)
+	}
+	srv.ctx = srv.appController.SaveIntoContext(context.Background())
+
+	published := 0
+	mock.PublishMockHandler[ServerName] = func(tag string, cmd *protocol.Command) (*protocol.Command, error) {
+		published++
+		return nil, nil
+	}
+	defer delete(mock.PublishMockHandler, ServerName)
+
+	if err := srv.OnReceivedCommand(conn, &protocol.Command{
+		Version: "t1", AppID: "strict", Name: "msg/chat", Payload: []byte("hi"),
+	}); err != nil {
+		t.Fatal("OnReceivedCommand() error:", err)
+	}
+	// 使用其他应用的AppID绕过限流，断开连接
+	if err := srv.OnReceivedCommand(conn, &protocol.Command{
+		Version: "t1", AppID: "loose", Name: "msg/chat", Payload: []byte("hi"),
+	}); err != define.ErrAuthFailed || !conn.isClosed() {
+		t.Errorf("got %v, closed: %v\n", err, conn.isClosed())
+	}
+	if published != 1 {
+		t.Errorf("published: %d\n", published)
+	}
+}
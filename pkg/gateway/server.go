@@ -4,29 +4,42 @@ package gateway
 
 import (
 	"context"
-	"strings"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/spf13/viper"
 	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/auth"
 	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/httpapi"
 	"github.com/zhangpeihao/zim/pkg/broker/register"
 	"github.com/zhangpeihao/zim/pkg/define"
+	"github.com/zhangpeihao/zim/pkg/interceptor"
 	"github.com/zhangpeihao/zim/pkg/protocol"
+	"github.com/zhangpeihao/zim/pkg/ratelimit"
+	"github.com/zhangpeihao/zim/pkg/webhook"
 	"github.com/zhangpeihao/zim/pkg/websocket"
 
 	// 加载Broker
-	_ "github.com/zhangpeihao/zim/pkg/broker/httpapi"
+	_ "github.com/zhangpeihao/zim/pkg/broker/boltdb"
+	_ "github.com/zhangpeihao/zim/pkg/broker/kafka"
+	_ "github.com/zhangpeihao/zim/pkg/broker/memory"
 	_ "github.com/zhangpeihao/zim/pkg/broker/mock"
+	_ "github.com/zhangpeihao/zim/pkg/broker/nsq"
+	_ "github.com/zhangpeihao/zim/pkg/broker/redis"
 )
 
 const (
 	// ServerName 服务名
 	ServerName = "gateway"
 	// LoginTimeout 登入超时时间（单位：秒）
-	LoginTimeout = 3600
+	LoginTimeout = auth.LoginTimeout
+	// DefaultPushTag 默认的下行tag，业务服务通过该tag向网关发送推送命令
+	DefaultPushTag = ServerName + "-push"
+	// MaxLimitDelay 限流等待的最长时间，超过时拒绝信令，防止长时间阻塞连接
+	MaxLimitDelay = 5 * time.Second
 )
 
 // ServerParameter 网关服务参数
@@ -34,6 +47,22 @@ type ServerParameter struct {
 	websocket.WSParameter
 	// AppConfigs 应用配置
 	AppConfigs []string
+	// AppDir 应用配置目录，目录中每个.json文件为一个应用配置
+	AppDir string
+	// AppURL 应用配置HTTP接口，返回应用配置的JSON数组
+	AppURL string
+	// AppURLInterval 应用配置HTTP接口轮询间隔
+	AppURLInterval time.Duration
+	// AppWatch 监控应用配置变化并自动重新加载
+	AppWatch bool
+	// PushBind 推送接口绑定地址，为空时不启动推送接口
+	PushBind string
+	// PushTimeout 推送请求有效期（单位：秒）
+	PushTimeout int
+	// AdminBind 管理接口绑定地址，为空时不启动管理接口
+	AdminBind string
+	// AdminToken 管理接口认证Token
+	AdminToken string
 }
 
 // Server 网关服务
@@ -50,8 +79,20 @@ type Server struct {
 	connections map[string][]define.Connection
 	// appController 应用Conttroller
 	appController *app.Controller
-	// tag 消息队列tag
+	// tag 消息队列tag，路由没有设置tag时发布上行命令使用
 	tag string
+	// pushTag 下行tag，网关在路由使用的Broker上订阅该tag，不能与任何发布使用的tag相同
+	pushTag string
+	// adminServer 管理接口HTTP服务
+	adminServer *http.Server
+	// pushServer 推送接口HTTP服务
+	pushServer *http.Server
+	// pushNonces 推送接口已使用的Nonce
+	pushNonces *httpapi.NonceCache
+	// subscriptions 正在进行的订阅
+	subscriptions map[subscription]bool
+	// webhook 事件通知
+	webhook *webhook.Notifier
 }
 
 // NewServer 新建服务
@@ -59,9 +100,23 @@ func NewServer() (srv *Server, err error) {
 	glog.Infoln("gateway::NewServer()")
 	srv = &Server{
 		ServerParameter: ServerParameter{
-			AppConfigs: viper.GetStringSlice("gateway.app-config"),
+			AppConfigs:     viper.GetStringSlice("gateway.app-config"),
+			AppDir:         viper.GetString("gateway.app-dir"),
+			AppURL:         viper.GetString("gateway.app-url"),
+			AppURLInterval: time.Duration(viper.GetInt("gateway.app-url-interval")) * time.Millisecond,
+			AppWatch:       !viper.IsSet("gateway.app-watch") || viper.GetBool("gateway.app-watch"),
+			PushBind:       viper.GetString("gateway.push-bind"),
+			PushTimeout:    viper.GetInt("gateway.push-timeout"),
+			AdminBind:      viper.GetString("gateway.admin-bind"),
+			AdminToken:     viper.GetString("gateway.admin-token"),
 		},
-		connections: make(map[string][]define.Connection),
+		connections:   make(map[string][]define.Connection),
+		pushNonces:    httpapi.NewNonceCache(viper.GetInt("gateway.push-nonce-cache-size")),
+		subscriptions: make(map[subscription]bool),
+		webhook:       webhook.NewNotifier("gateway"),
+	}
+	if srv.PushTimeout <= 0 {
+		srv.PushTimeout = httpapi.DefaultTimeout
 	}
 	tag := viper.GetString("gateway.broker-tag")
 	if len(tag) == 0 {
@@ -69,6 +124,10 @@ func NewServer() (srv *Server, err error) {
 	} else {
 		srv.tag = tag
 	}
+	srv.pushTag = viper.GetString("gateway.push-tag")
+	if len(srv.pushTag) == 0 {
+		srv.pushTag = DefaultPushTag
+	}
 	srv.wsServer, err = websocket.NewServer(srv)
 	if err != nil {
 		return nil, err
@@ -82,6 +141,23 @@ func NewServer() (srv *Server, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(srv.AppDir) > 0 {
+		if err = srv.appController.AddSource(app.NewDirSource(srv.AppDir)); err != nil {
+			return nil, err
+		}
+	}
+	if len(srv.AppURL) > 0 {
+		if err = srv.appController.AddSource(app.NewHTTPSource(srv.AppURL, srv.AppURLInterval)); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range srv.appController.Apps() {
+		if err = srv.checkPushTag(a); err != nil {
+			glog.Errorf("gateway::NewServer() app %s error: %s\n", a.ID, err)
+			return nil, err
+		}
+	}
+	srv.appController.OnAppRemoved(srv.OnAppRemoved)
 	return
 }
 
@@ -93,10 +169,27 @@ func (srv *Server) Run(ctx context.Context) (err error) {
 		glog.Errorln("gateway::Server::Run() brocker.Run() error:", err)
 		return err
 	}
+	srv.webhook.Run(srv.ctx)
+	// 订阅业务服务发送给网关的命令，应用配置变化时订阅新使用的Broker
+	srv.appController.OnAppUpdated(srv.OnAppUpdated)
+	srv.subscribeAll()
 	if err = srv.wsServer.Run(srv.ctx); err != nil {
 		glog.Errorln("gateway::Server::Run() wsServer error:", err)
 		return err
 	}
+	if len(srv.PushBind) > 0 {
+		if err = srv.runPush(); err != nil {
+			return err
+		}
+	}
+	if len(srv.AdminBind) > 0 {
+		if err = srv.runAdmin(); err != nil {
+			return err
+		}
+	}
+	if srv.AppWatch {
+		go srv.appController.Watch(srv.ctx)
+	}
 	return
 }
 
@@ -111,15 +204,67 @@ func (srv *Server) Close(timeout time.Duration) (err error) {
 		connections = append(connections, conn...)
 	}
 	srv.Unlock()
+	if srv.pushServer != nil {
+		srv.pushServer.Close()
+	}
+	if srv.adminServer != nil {
+		srv.adminServer.Close()
+	}
 	glog.Infoln("gateway::Server::Close() close wsServer")
 	srv.wsServer.Close(timeout)
 	glog.Infoln("gateway::Server::Close() close all connections")
 	for _, conn := range connections {
 		conn.Close(true)
 	}
+	srv.webhook.Close(timeout)
 	return err
 }
 
+// OnAppRemoved 应用被删除，关闭应用的所有连接
+func (srv *Server) OnAppRemoved(a *app.App) {
+	glog.Warningf("gateway::Server::OnAppRemoved(%s)\n", a.ID)
+	srv.Lock()
+	var connections []define.Connection
+	for _, conns := range srv.connections {
+		for _, conn := range conns {
+			if conn.AppID() == a.ID {
+				connections = append(connections, conn)
+			}
+		}
+	}
+	srv.Unlock()
+	// 在锁外关闭链接，防止死锁
+	for _, conn := range connections {
+		conn.Close(false)
+	}
+}
+
+// Kick 断开用户的连接，deviceid为空时断开用户的所有连接，返回断开的连接数
+func (srv *Server) Kick(appid, userid, deviceid string) int {
+	connections := srv.connectionsSnapshot(appid, userid, deviceid)
+	for _, conn := range connections {
+		glog.Warningf("gateway::Server::Kick() kick %s\n", conn)
+		conn.Close(true)
+		srv.notify(conn.AppID(), connectionEvent(webhook.EventKick, conn, ""))
+	}
+	return len(connections)
+}
+
+// notify 通过应用的webhooks通知事件
+func (srv *Server) notify(appid string, event *webhook.Event) {
+	srv.webhook.Notify(srv.appController.GetApp(appid), event)
+}
+
+// connectionEvent 连接事件
+func connectionEvent(eventType string, conn define.Connection, reason string) *webhook.Event {
+	return &webhook.Event{
+		Type:     eventType,
+		UserID:   conn.UserID(),
+		DeviceID: conn.DeviceID(),
+		Reason:   reason,
+	}
+}
+
 // OnNewConnection 连接新建处理
 func (srv *Server) OnNewConnection(conn define.Connection) {
 	glog.Infoln("gateway::Server::OnNewConnection()")
@@ -129,9 +274,24 @@ func (srv *Server) OnNewConnection(conn define.Connection) {
 // OnCloseConnection 连接关闭处理
 func (srv *Server) OnCloseConnection(conn define.Connection) {
 	glog.Infoln("gateway::Server::OnCloseConnection()")
+	if conn.IsLogin() {
+		srv.notify(conn.AppID(), connectionEvent(webhook.EventDisconnect, conn, ""))
+	}
 	srv.Lock()
 	defer srv.Unlock()
-	delete(srv.connections, conn.ID())
+	// 只删除关闭的连接，同一用户的其他设备连接保留
+	connections := srv.connections[conn.ID()]
+	for index, c := range connections {
+		if c == conn {
+			connections = append(connections[:index], connections[index+1:]...)
+			break
+		}
+	}
+	if len(connections) == 0 {
+		delete(srv.connections, conn.ID())
+	} else {
+		srv.connections[conn.ID()] = connections
+	}
 }
 
 // OnReceivedCommand 收到命令
@@ -142,6 +302,13 @@ func (srv *Server) OnReceivedCommand(conn define.Connection, command *protocol.C
 		ok       bool
 		resp     *protocol.Command
 	)
+	// 登入后的信令必须属于登入的应用，否则可以使用其他应用的限流和拦截器
+	if conn.IsLogin() && command.AppID != conn.AppID() {
+		glog.Warningf("gateway::Server::OnReceivedCommand() %s sent command of app %s\n",
+			conn, command.AppID)
+		conn.Close(false)
+		return define.ErrAuthFailed
+	}
 	a := app.GetAppFromContext(srv.ctx, command.AppID)
 	if a == nil {
 		glog.Warningln("gateway::Server::OnReceivedCommand() No application found",
@@ -150,12 +317,28 @@ func (srv *Server) OnReceivedCommand(conn define.Connection, command *protocol.C
 		return define.ErrKnownApp
 	}
 
+	// 限流和拦截器，登入信令不限流也不拦截
+	if conn.IsLogin() {
+		if pass, err := srv.limit(conn, a, command); !pass {
+			return err
+		}
+		var intercepted *protocol.Command
+		if intercepted, err = a.Chain.Inbound(command); err != nil {
+			return srv.onIntercepted(conn, command, err)
+		}
+		command = intercepted
+	}
+
 	// Route
-	broker := a.Router.Find(command.Name)
-	if broker == nil {
+	route := a.Router.Find(command.Name)
+	if route == nil {
 		glog.Warningf("gateway::Server::OnReceivedCommand() no route to %s\n", command.Name)
 		return define.ErrAuthFailed
 	}
+	tag := route.Tag
+	if len(tag) == 0 {
+		tag = srv.tag
+	}
 
 	// 检查登入
 	if !conn.IsLogin() {
@@ -172,24 +355,52 @@ func (srv *Server) OnReceivedCommand(conn define.Connection, command *protocol.C
 				command.Name, err)
 			return define.ErrNeedAuth
 		}
-		if strings.ToLower(a.TokenCheck) == "yes" {
-			now := time.Now().Unix()
-			if loginCmd.Timestamp+LoginTimeout < now {
-				glog.Warningf("gateway::Server::OnReceivedCommand() login timeout! loginCmd.Timestamp: %d, LoginTimeout: %d, now: %d\n",
-					loginCmd.Timestamp, LoginTimeout, now)
-				return define.ErrNeedAuth
-			}
-			token := loginCmd.CalToken(a.KeyBytes)
-			if token != strings.ToUpper(loginCmd.Token) {
-				glog.Warningf("gateway::Server::OnReceivedCommand() token unmatch! loginCmd.Token: %s, token: %s\n",
-					loginCmd.Token, token)
-				return define.ErrNeedAuth
-			}
+		// 标签和附加信息只能由登入认证设置
+		loginCmd.Tags, loginCmd.Metadata = nil, nil
+		var result *auth.Result
+		authenticator := auth.ForApp(a)
+		if authenticator == nil {
+			glog.Warningf("gateway::OnReceivedCommand() app %s unknown token-check: %s\n", a.ID, a.TokenCheck)
+			conn.Close(false)
+			return define.ErrAuthFailed
 		}
+		result, err = authenticator.Authenticate(&auth.Request{
+			App:     a,
+			Command: command,
+			Login:   loginCmd,
+			Now:     time.Now(),
+			Publish: func(cmd *protocol.Command) (*protocol.Command, error) {
+				return route.Broker.Publish(tag, cmd)
+			},
+		})
+		if err != nil {
+			srv.notify(command.AppID, &webhook.Event{
+				Type:     webhook.EventLoginFailed,
+				UserID:   loginCmd.UserID,
+				DeviceID: loginCmd.DeviceID,
+				Reason:   err.Error(),
+			})
+		}
+		if err == auth.ErrRejected {
+			glog.Warningln("gateway::Server::OnReceivedCommand() invoke response close")
+			conn.Close(false)
+			return define.ErrAuthFailed
+		}
+		if err != nil {
+			glog.Warningf("gateway::Server::OnReceivedCommand() %s authenticate error %s\n",
+				authenticator, err)
+			return err
+		}
+		loginCmd.UserID, loginCmd.DeviceID = result.UserID, result.DeviceID
+		loginCmd.Tags, loginCmd.Metadata = result.Tags, result.Metadata
 		glog.Infof("gateway::Server::OnReceivedCommand() login: %+v\n", loginCmd)
-		resp, err = broker.Publish(srv.tag, command)
+		if result.Published {
+			resp = result.Response
+		} else {
+			resp, err = route.Broker.Publish(tag, command)
+		}
 	} else {
-		resp, err = broker.Publish(srv.tag, command)
+		resp, err = route.Broker.Publish(tag, command)
 	}
 
 	if err != nil {
@@ -205,6 +416,7 @@ func (srv *Server) OnReceivedCommand(conn define.Connection, command *protocol.C
 
 	if !conn.IsLogin() {
 		conn.LoginSuccess(command.AppID, loginCmd.UserID, loginCmd.DeviceID, command.Version)
+		conn.SetTags(loginCmd.Tags)
 		connid := conn.ID()
 		srv.Lock()
 		connections, find := srv.connections[connid]
@@ -212,7 +424,7 @@ func (srv *Server) OnReceivedCommand(conn define.Connection, command *protocol.C
 		if find {
 			var index int
 			for index, oldConn = range connections {
-				if oldConn.DeviceID() == conn.DeviceID() {
+				if define.DeviceKey(oldConn) == define.DeviceKey(conn) {
 					glog.Warningf("gateway::Server::OnReceivedCommand() replace connection ID: %s\n", connid)
 					connections[index] = conn
 				} else {
@@ -229,54 +441,72 @@ func (srv *Server) OnReceivedCommand(conn define.Connection, command *protocol.C
 			// 在锁外关闭链接，防止死锁
 			oldConn.Close(false)
 		}
+		srv.notify(command.AppID, connectionEvent(webhook.EventConnect, conn, ""))
 	}
 
 	glog.Infof("gateway::Server::OnReceivedCommand() invoke(%s) response %s",
 		command.Name, resp)
-	go srv.OnPushToUser(resp)
+	if resp != nil {
+		go srv.OnPushToUser(resp)
+	}
 	return
 }
 
-// OnPushToUser 推送消息给用户
-func (srv *Server) OnPushToUser(cmd *protocol.Command) {
-	glog.Infof("gateway::Server::OnPushToUser()\n")
-	var (
-		pushCmd *protocol.Push2UserCommand
-		ok      bool
-	)
-	pushCmd, ok = cmd.Data.(*protocol.Push2UserCommand)
+// onIntercepted 信令被拦截器拒绝时向客户端发送err信令，丢弃时忽略，连接保持
+func (srv *Server) onIntercepted(conn define.Connection, command *protocol.Command, err error) error {
+	rejection, ok := err.(*interceptor.Rejection)
 	if !ok {
-		glog.Warningln("gateway::Server::OnPushToUser() parse result error")
-		return
+		glog.Infof("gateway::Server::onIntercepted() drop %s from %s\n", command.Name, conn)
+		return nil
 	}
+	glog.Warningf("gateway::Server::onIntercepted() reject %s from %s: %s\n", command.Name, conn, err)
+	srv.sendError(conn, command, rejection.Code, rejection.Message)
+	return nil
+}
 
-	touser := cmd.Copy()
-	touser.Data = nil
+// limit 检查连接的信令是否被限流，返回是否继续处理。拒绝时向客户端发送err信令，连接保持；
+// 等待时阻塞当前连接的信令处理；断开时关闭连接并返回ErrRateLimited
+func (srv *Server) limit(conn define.Connection, a *app.App, command *protocol.Command) (bool, error) {
+	decision := a.Limiter.Check(define.DeviceKey(conn), conn.UserID(), command.Name, time.Now())
+	if decision.Action == ratelimit.ActionDelay && decision.Delay > MaxLimitDelay {
+		glog.Warningf("gateway::Server::limit() delay %s of %s exceeds %s\n", decision.Delay, command.Name, MaxLimitDelay)
+		decision.Action = ratelimit.ActionReject
+	}
+	switch decision.Action {
+	case ratelimit.ActionDelay:
+		time.Sleep(decision.Delay)
+	case ratelimit.ActionReject:
+		glog.Warningf("gateway::Server::limit() reject %s from %s\n", command.Name, conn)
+		srv.sendError(conn, command, ratelimit.CodeRateLimited, ratelimit.ErrRateLimited.Error())
+		return false, nil
+	case ratelimit.ActionDisconnect:
+		glog.Warningf("gateway::Server::limit() disconnect %s\n", conn)
+		conn.Close(false)
+		return false, ratelimit.ErrRateLimited
+	}
+	return true, nil
+}
 
-	// Todo: Find user by id and tag
-	// Todo: Lockfree
-	srv.Lock()
-	if pushCmd.Tags == "*" {
-		glog.Infof("Push message to all\n")
-		// Push to all users
-		for _, connections := range srv.connections {
-			for _, conn := range connections {
-				glog.Infof("Push to user %s%s", conn.ID(), touser)
-				conn.Send(touser)
-			}
-		}
-	} else {
-		glog.Infof("Push message to %+v\n", pushCmd.UserIDList)
-		for _, id := range strings.Split(pushCmd.UserIDList, ",") {
-			connections, ok := srv.connections[define.ConnectionID(cmd.AppID, id)]
-			if ok {
-				for _, conn := range connections {
-					conn.Send(touser)
-				}
-			} else {
-				glog.Warningf("gateway::Server::OnPushToUser() not find connection")
-			}
-		}
+// sendError 向客户端发送err信令
+func (srv *Server) sendError(conn define.Connection, command *protocol.Command, code int, message string) {
+	if err := conn.Send(&protocol.Command{
+		Version: command.Version,
+		AppID:   command.AppID,
+		Name:    protocol.Error,
+		Data: &protocol.ErrorCommand{
+			Code:    code,
+			Message: message,
+			Name:    command.Name,
+		},
+	}); err != nil {
+		glog.Warningf("gateway::Server::sendError() send to %s error: %s\n", conn, err)
+	}
+}
+
+// OnPushToUser 推送消息给用户
+func (srv *Server) OnPushToUser(cmd *protocol.Command) {
+	glog.Infof("gateway::Server::OnPushToUser()\n")
+	if _, err := srv.Push(cmd); err != nil {
+		glog.Warningln("gateway::Server::OnPushToUser() push error:", err)
 	}
-	srv.Unlock()
 }
@@ -0,0 +1,115 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// subscription Broker上的订阅
+type subscription struct {
+	broker broker.Broker
+	tag    string
+}
+
+// subscribeAll 订阅所有应用使用的Broker
+func (srv *Server) subscribeAll() {
+	for _, a := range srv.appController.Apps() {
+		srv.subscribeApp(a)
+	}
+}
+
+// checkPushTag 检查下行tag与应用发布使用的tag不同，否则网关会消费客户端发布的上行命令
+func (srv *Server) checkPushTag(a *app.App) error {
+	if a.Router == nil {
+		return nil
+	}
+	tags := append(a.Router.Tags(), srv.tag)
+	for _, tag := range tags {
+		if strings.EqualFold(tag, srv.pushTag) {
+			return fmt.Errorf("push tag %s is used to publish", srv.pushTag)
+		}
+	}
+	return nil
+}
+
+// subscribeApp 在应用路由使用的每个Broker上订阅下行tag，同一个Broker只订阅一次
+func (srv *Server) subscribeApp(a *app.App) {
+	if a.Router == nil {
+		return
+	}
+	if err := srv.checkPushTag(a); err != nil {
+		glog.Errorf("gateway::Server::subscribeApp(%s) error: %s\n", a.ID, err)
+		return
+	}
+	for _, b := range a.Router.Brokers() {
+		key := subscription{broker: b, tag: srv.pushTag}
+		srv.Lock()
+		if srv.subscriptions[key] {
+			srv.Unlock()
+			continue
+		}
+		srv.subscriptions[key] = true
+		srv.Unlock()
+		go srv.subscribe(key)
+	}
+}
+
+// subscribe 订阅，阻塞直到订阅结束。订阅结束后删除记录，应用配置变化时重新订阅
+func (srv *Server) subscribe(key subscription) {
+	glog.Infof("gateway::Server::subscribe() %s(%s)\n", key.broker, key.tag)
+	if err := key.broker.Subscribe(key.tag, srv.OnServiceCommand); err != nil {
+		glog.Warningf("gateway::Server::subscribe() %s(%s) error: %s\n", key.broker, key.tag, err)
+	} else {
+		glog.Infof("gateway::Server::subscribe() %s(%s) done\n", key.broker, key.tag)
+	}
+	srv.Lock()
+	delete(srv.subscriptions, key)
+	srv.Unlock()
+}
+
+// OnAppUpdated 应用被添加或配置变化，订阅应用新使用的Broker
+func (srv *Server) OnAppUpdated(a *app.App) {
+	glog.Infof("gateway::Server::OnAppUpdated(%s)\n", a.ID)
+	srv.subscribeApp(a)
+}
+
+// OnServiceCommand 处理业务服务通过Broker发送给网关的命令
+//
+// * p2u: 推送给用户，与推送接口相同
+//
+// * close: 断开用户的所有连接，与管理接口的kick相同
+//
+// * 其他命令: Data中有用户ID时（例如msg）发送给该用户的所有连接
+//
+// 无法处理的命令记录日志后丢弃，总是返回nil，防止Broker重复投递
+func (srv *Server) OnServiceCommand(tag string, cmd *protocol.Command) error {
+	glog.Infof("gateway::Server::OnServiceCommand(%s) %s\n", tag, cmd)
+	if a := app.GetAppFromContext(srv.ctx, cmd.AppID); a == nil {
+		glog.Warningf("gateway::Server::OnServiceCommand(%s) no application found %s\n", tag, cmd.AppID)
+		return nil
+	}
+	switch data := cmd.Data.(type) {
+	case *protocol.Push2UserCommand:
+		srv.OnPushToUser(cmd)
+	case *protocol.GatewayCloseCommand:
+		if len(data.UserID) == 0 {
+			glog.Warningf("gateway::Server::OnServiceCommand(%s) close without userid\n", tag)
+			return nil
+		}
+		srv.Kick(cmd.AppID, data.UserID, "")
+	case *protocol.GatewayMessageCommand:
+		touser := cmd.Copy()
+		touser.Data = &protocol.Push2UserCommand{UserIDList: data.UserID}
+		srv.OnPushToUser(touser)
+	default:
+		glog.Warningf("gateway::Server::OnServiceCommand(%s) unsupport command %s\n", tag, cmd.Name)
+	}
+	return nil
+}
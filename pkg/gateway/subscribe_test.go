@@ -0,0 +1,140 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker"
+	"github.com/zhangpeihao/zim/pkg/broker/memory"
+	"github.com/zhangpeihao/zim/pkg/broker/mock"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+func TestServiceCommand(t *testing.T) {
+	u1 := &testConnection{appID: "foo", userID: "u1", deviceID: "d1"}
+	u2 := &testConnection{appID: "foo", userID: "u2", deviceID: "d1"}
+	bar := &testConnection{appID: "bar", userID: "u2", deviceID: "d1"}
+	srv := newTestServer(t, u1, u2, bar)
+	srv.ctx = srv.appController.SaveIntoContext(context.Background())
+
+	commands := make(chan *protocol.Command)
+	handled := make(chan bool)
+	mock.SubscribeMockHandler = func(tag string) (*protocol.Command, error) {
+		if tag != DefaultPushTag {
+			t.Errorf("subscribe tag expect: %s, got: %s\n", DefaultPushTag, tag)
+		}
+		// 上一个命令处理完成后才取下一个命令
+		handled <- true
+		cmd, ok := <-commands
+		if !ok {
+			return nil, errors.New("closed")
+		}
+		return cmd, nil
+	}
+	defer func() { mock.SubscribeMockHandler = nil }()
+
+	// foo和bar使用同一个mock Broker，只订阅一次
+	srv.subscribeAll()
+	srv.subscribeAll()
+	srv.Lock()
+	count := len(srv.subscriptions)
+	srv.Unlock()
+	if count != 1 {
+		t.Fatalf("subscriptions expect: 1, got: %d\n", count)
+	}
+	send := func(cmd *protocol.Command) {
+		<-handled
+		commands <- cmd
+	}
+
+	send(&protocol.Command{AppID: "foo", Name: protocol.Push2User,
+		Data: &protocol.Push2UserCommand{UserIDList: "u1"}, Payload: []byte("hello")})
+	send(&protocol.Command{AppID: "foo", Name: "msg/foo",
+		Data: &protocol.GatewayMessageCommand{UserID: "u1"}, Payload: []byte("world")})
+	send(&protocol.Command{AppID: "foo", Name: protocol.Close, Data: &protocol.GatewayCloseCommand{}})
+	send(&protocol.Command{AppID: "unknown", Name: protocol.Close, Data: &protocol.GatewayCloseCommand{UserID: "u2"}})
+	send(&protocol.Command{AppID: "foo", Name: protocol.Close, Data: &protocol.GatewayCloseCommand{UserID: "u2"}})
+	<-handled
+	close(commands)
+
+	if u1.sentCount() != 2 || string(u1.sent[1].Payload) != "world" {
+		t.Errorf("u1 sent: %d\n", u1.sentCount())
+	}
+	if !u2.isClosed() || u1.isClosed() || bar.isClosed() {
+		t.Errorf("closed u1: %t, u2: %t, bar: %t\n", u1.isClosed(), u2.isClosed(), bar.isClosed())
+	}
+
+	// 订阅结束后删除记录
+	for i := 0; i < 100 && count > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+		srv.Lock()
+		count = len(srv.subscriptions)
+		srv.Unlock()
+	}
+	if count != 0 {
+		t.Errorf("subscriptions after closed: %d\n", count)
+	}
+}
+
+func TestPushTagNotPublishTag(t *testing.T) {
+	u1 := &testConnection{appID: "queue", userID: "u1", deviceID: "d1"}
+	u2 := &testConnection{appID: "queue", userID: "u2", deviceID: "d1"}
+	srv := newTestServer(t, u1, u2)
+	mb, err := memory.NewMemoryBroker("test")
+	if err != nil {
+		t.Fatal("NewMemoryBroker() error:", err)
+	}
+	broker.Set("memory:pushtag", mb)
+	a, err := app.ParseApp(strings.NewReader(`{"id": "queue", "key": "123",
+		"router": {"*": {"broker": "memory", "instance": "pushtag"}}}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	srv.appController.AddApp(a)
+	srv.ctx = srv.appController.SaveIntoContext(context.Background())
+	b := mb.(*memory.BrokerImpl)
+	defer b.Close(time.Second)
+	srv.subscribeAll()
+
+	// 客户端发布的信令不能作为推送投递给其他用户
+	if err = srv.OnReceivedCommand(u1, &protocol.Command{AppID: "queue", Name: "msg/foo",
+		Data: &protocol.GatewayMessageCommand{UserID: "u2"}, Payload: []byte("fake")}); err != nil {
+		t.Fatal("OnReceivedCommand() error:", err)
+	}
+	// 业务服务通过下行tag推送
+	if _, err = b.Publish(DefaultPushTag, &protocol.Command{AppID: "queue", Name: "msg/foo",
+		Data: &protocol.GatewayMessageCommand{UserID: "u2"}, Payload: []byte("real")}); err != nil {
+		t.Fatal("Publish() error:", err)
+	}
+	for i := 0; i < 100 && u2.sentCount() == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if u2.sentCount() != 1 || string(u2.sent[0].Payload) != "real" {
+		t.Errorf("u2 sent: %d\n", u2.sentCount())
+	}
+	if b.Len(ServerName) != 1 {
+		t.Errorf("upstream queue length expect: 1, got: %d\n", b.Len(ServerName))
+	}
+
+	// 下行tag与发布使用的tag相同时拒绝配置
+	srv.pushTag = ServerName
+	if err = srv.checkPushTag(a); err == nil {
+		t.Error("push tag equals publish tag expect error")
+	}
+	srv.pushTag = "push"
+	tagged, err := app.ParseApp(strings.NewReader(`{"id": "tagged", "key": "123",
+		"router": {"*": {"broker": "mock", "tag": "Push"}}}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	if err = srv.checkPushTag(tagged); err == nil {
+		t.Error("push tag equals route tag expect error")
+	}
+}
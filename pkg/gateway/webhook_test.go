@@ -0,0 +1,68 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+	"github.com/zhangpeihao/zim/pkg/webhook"
+)
+
+func TestWebhook(t *testing.T) {
+	events := make(chan *webhook.Event, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhook.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- &event
+	}))
+	defer server.Close()
+
+	u1 := &testConnection{appID: "hook", userID: "u1", deviceID: "d1"}
+	u2 := &testConnection{appID: "hook", userID: "u2", deviceID: "d1", failSend: true}
+	srv := newTestServer(t, u1, u2)
+	a, err := app.ParseApp(strings.NewReader(`{
+		"id": "hook",
+		"key": "123",
+		"router": {"*": {"broker": "mock"}},
+		"webhooks": [{"url": "` + server.URL + `", "events": ["kick", "disconnect", "push-dropped"]}]
+	}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	srv.appController.AddApp(a)
+	ctx, cancel := context.WithCancel(srv.appController.SaveIntoContext(context.Background()))
+	defer cancel()
+	srv.ctx = ctx
+	srv.webhook = webhook.NewNotifier("test")
+	srv.webhook.Run(ctx)
+
+	expect := func(eventType, userID string) {
+		select {
+		case event := <-events:
+			if event.Type != eventType || event.AppID != "hook" || event.UserID != userID {
+				t.Errorf("expect %s event of %s, got: %+v\n", eventType, userID, event)
+			}
+		case <-time.After(time.Second * 2):
+			t.Errorf("wait %s event of %s timeout\n", eventType, userID)
+		}
+	}
+
+	srv.Push(&protocol.Command{AppID: "hook", Name: protocol.Push2User,
+		Data: &protocol.Push2UserCommand{UserIDList: "u1,u2"}})
+	expect(webhook.EventPushDropped, "u2")
+
+	if kicked := srv.Kick("hook", "u1", ""); kicked != 1 {
+		t.Errorf("Kick() got: %d\n", kicked)
+	}
+	expect(webhook.EventKick, "u1")
+	srv.OnCloseConnection(u1)
+	expect(webhook.EventDisconnect, "u1")
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package interceptor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// blocklist处理方式
+const (
+	// BlocklistReject 拒绝
+	BlocklistReject = "reject"
+	// BlocklistDrop 丢弃
+	BlocklistDrop = "drop"
+	// BlocklistMask 替换匹配的内容
+	BlocklistMask = "mask"
+)
+
+// Blocklist 关键字和正则表达式过滤
+type Blocklist struct {
+	// Words 关键字，不区分大小写
+	Words []string `json:"words"`
+	// Patterns 正则表达式
+	Patterns []string `json:"patterns"`
+	// Action 处理方式：reject（默认）、drop或者mask
+	Action string `json:"action"`
+	// Mask 替换字符，默认为"*"
+	Mask string `json:"mask"`
+	// re 合并的正则表达式
+	re *regexp.Regexp
+}
+
+func init() {
+	Register("blocklist", NewBlocklist)
+}
+
+// NewBlocklist 新建关键字过滤
+func NewBlocklist(options json.RawMessage) (Interceptor, error) {
+	interceptor := new(Blocklist)
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, interceptor); err != nil {
+			return nil, err
+		}
+	}
+	switch interceptor.Action {
+	case "":
+		interceptor.Action = BlocklistReject
+	case BlocklistReject, BlocklistDrop, BlocklistMask:
+	default:
+		return nil, fmt.Errorf("invalid action %s", interceptor.Action)
+	}
+	if len(interceptor.Mask) == 0 {
+		interceptor.Mask = "*"
+	}
+	var exprs []string
+	for _, word := range interceptor.Words {
+		if len(word) > 0 {
+			exprs = append(exprs, "(?i:"+regexp.QuoteMeta(word)+")")
+		}
+	}
+	for _, pattern := range interceptor.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, "(?:"+pattern+")")
+	}
+	if len(exprs) == 0 {
+		return nil, errors.New("words or patterns required")
+	}
+	interceptor.re = regexp.MustCompile(strings.Join(exprs, "|"))
+	return interceptor, nil
+}
+
+// Intercept 拦截
+func (interceptor *Blocklist) Intercept(cmd *protocol.Command) (*protocol.Command, error) {
+	if !interceptor.re.Match(cmd.Payload) {
+		return cmd, nil
+	}
+	switch interceptor.Action {
+	case BlocklistDrop:
+		return nil, ErrDrop
+	case BlocklistMask:
+		masked := cmd.Copy()
+		masked.Payload = interceptor.re.ReplaceAllFunc(cmd.Payload, func(match []byte) []byte {
+			return []byte(strings.Repeat(interceptor.Mask, utf8.RuneCount(match)))
+		})
+		return masked, nil
+	}
+	return nil, Reject(CodeBlocked, "payload blocked")
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+/*
+Package interceptor 网关信令拦截器
+
+每个应用通过interceptors配置有序的拦截器链，入站拦截器在客户端信令路由之前执行（登入信令除外），
+出站拦截器在推送给客户端之前执行：
+
+	"interceptors": [
+		{"type": "max-payload", "options": {"size": 4096}},
+		{"type": "blocklist", "commands": ["msg"], "options": {"words": ["foo"], "action": "mask"}},
+		{"type": "json-schema", "direction": "both", "commands": ["msg/chat"], "options": {"schema": {"type": "object", "required": ["text"]}}}
+	]
+
+* type: 拦截器类型
+
+* direction: inbound（默认）、outbound或者both
+
+* commands: 拦截的信令名，同时匹配以它为前缀的信令名（例如："msg"匹配"msg/foo"），为空或者包含"*"时拦截所有信令
+
+* options: 拦截器参数
+
+拦截器可以放行、修改（返回新的信令）、拒绝（返回*Rejection，网关向客户端发送err信令）或者静默丢弃（返回ErrDrop）。
+
+错误码：413（Payload过长）、451（包含禁止的内容）、460（Payload格式错误）、500（拦截器内部错误）。
+推送接口被出站拦截器拒绝时，Zim-Code为拦截器的错误码，HTTP状态码为400（内部错误为500），重试不会成功。
+
+内置拦截器：
+
+* max-payload: Payload长度超过size（单位：字节）时拒绝，错误码413
+
+* blocklist: Payload包含words中的关键字（不区分大小写）或者匹配patterns中的正则表达式时，
+按action处理：reject（默认，错误码451）、drop或者mask（用mask字符替换，默认为"*"）
+
+* json-schema: Payload必须是符合schema的JSON，错误码460。支持的关键字：type、properties、required、
+additionalProperties、items、enum、minLength、maxLength、pattern、minimum、maximum、minItems、maxItems
+*/
+package interceptor
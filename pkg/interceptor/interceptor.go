@@ -0,0 +1,199 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package interceptor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+const (
+	// Inbound 入站，客户端发送给网关的信令
+	Inbound = "inbound"
+	// Outbound 出站，网关推送给客户端的信令
+	Outbound = "outbound"
+	// Both 入站和出站
+	Both = "both"
+	// AllCommands 拦截所有信令
+	AllCommands = "*"
+)
+
+// 错误码，通过err信令返回给客户端，推送接口拒绝时通过Zim-Code返回。
+// 除CodeInternal外不与httpapi的错误码重复
+const (
+	// CodePayloadTooLarge Payload过长
+	CodePayloadTooLarge = 413
+	// CodeInvalidPayload Payload格式错误
+	CodeInvalidPayload = 460
+	// CodeBlocked Payload包含禁止的内容
+	CodeBlocked = 451
+	// CodeInternal 拦截器内部错误
+	CodeInternal = 500
+)
+
+var (
+	// ErrDrop 静默丢弃信令
+	ErrDrop = errors.New("interceptor drop")
+)
+
+// Rejection 拒绝信令
+type Rejection struct {
+	// Code 错误码
+	Code int
+	// Message 错误信息
+	Message string
+}
+
+// Error 错误信息
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("interceptor reject %d: %s", r.Code, r.Message)
+}
+
+// Reject 新建拒绝
+func Reject(code int, message string) *Rejection {
+	return &Rejection{Code: code, Message: message}
+}
+
+// Interceptor 拦截器
+type Interceptor interface {
+	// Intercept 处理信令，返回信令（需要修改时返回副本，不能修改参数）继续处理；
+	// 返回ErrDrop丢弃；返回*Rejection拒绝
+	Intercept(cmd *protocol.Command) (*protocol.Command, error)
+}
+
+// Factory 根据参数新建拦截器
+type Factory func(options json.RawMessage) (Interceptor, error)
+
+var (
+	factories = make(map[string]Factory)
+	locker    sync.Mutex
+)
+
+// Register 注册拦截器
+func Register(name string, factory Factory) {
+	locker.Lock()
+	defer locker.Unlock()
+	if _, found := factories[name]; found {
+		glog.Warningf("interceptor::Register() interceptor[%s] existed\n", name)
+	}
+	factories[name] = factory
+}
+
+// Config 拦截器配置
+type Config struct {
+	// Type 拦截器类型
+	Type string `json:"type"`
+	// Direction 拦截方向：inbound（默认）、outbound或者both
+	Direction string `json:"direction,omitempty"`
+	// Commands 拦截的信令名，为空时拦截所有信令
+	Commands []string `json:"commands,omitempty"`
+	// Options 拦截器参数
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// entry 拦截器链中的拦截器
+type entry struct {
+	name        string
+	commands    []string
+	interceptor Interceptor
+}
+
+// match 是否拦截信令
+func (e *entry) match(name string) bool {
+	if len(e.commands) == 0 {
+		return true
+	}
+	for _, command := range e.commands {
+		if command == AllCommands || command == name || strings.HasPrefix(name, command+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Chain 拦截器链，nil Chain放行所有信令
+type Chain struct {
+	inbound  []*entry
+	outbound []*entry
+}
+
+// NewChain 根据配置新建拦截器链，没有配置时返回nil
+func NewChain(configs []*Config) (*Chain, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	chain := new(Chain)
+	for index, config := range configs {
+		locker.Lock()
+		factory, found := factories[config.Type]
+		locker.Unlock()
+		if !found {
+			glog.Errorf("interceptor::NewChain() unsupport interceptor[%d]: %s\n", index, config.Type)
+			return nil, fmt.Errorf("unsupport interceptor %s", config.Type)
+		}
+		interceptor, err := factory(config.Options)
+		if err != nil {
+			glog.Errorf("interceptor::NewChain() interceptor[%d] %s error: %s\n", index, config.Type, err)
+			return nil, fmt.Errorf("interceptor %s: %s", config.Type, err)
+		}
+		e := &entry{name: config.Type, commands: config.Commands, interceptor: interceptor}
+		switch config.Direction {
+		case "", Inbound:
+			chain.inbound = append(chain.inbound, e)
+		case Outbound:
+			chain.outbound = append(chain.outbound, e)
+		case Both:
+			chain.inbound = append(chain.inbound, e)
+			chain.outbound = append(chain.outbound, e)
+		default:
+			glog.Errorf("interceptor::NewChain() interceptor[%d] invalid direction: %s\n", index, config.Direction)
+			return nil, fmt.Errorf("interceptor %s: invalid direction %s", config.Type, config.Direction)
+		}
+	}
+	return chain, nil
+}
+
+// Inbound 执行入站拦截器
+func (chain *Chain) Inbound(cmd *protocol.Command) (*protocol.Command, error) {
+	if chain == nil {
+		return cmd, nil
+	}
+	return run(chain.inbound, cmd)
+}
+
+// Outbound 执行出站拦截器
+func (chain *Chain) Outbound(cmd *protocol.Command) (*protocol.Command, error) {
+	if chain == nil {
+		return cmd, nil
+	}
+	return run(chain.outbound, cmd)
+}
+
+// run 按顺序执行拦截器，返回的错误为ErrDrop或者*Rejection
+func run(entries []*entry, cmd *protocol.Command) (*protocol.Command, error) {
+	for _, e := range entries {
+		if !e.match(cmd.Name) {
+			continue
+		}
+		result, err := e.interceptor.Intercept(cmd)
+		if err == ErrDrop {
+			glog.Infof("interceptor::run() %s drop %s of app(%s)\n", e.name, cmd.Name, cmd.AppID)
+			return nil, err
+		}
+		if err != nil {
+			glog.Warningf("interceptor::run() %s reject %s of app(%s): %s\n", e.name, cmd.Name, cmd.AppID, err)
+			if _, ok := err.(*Rejection); !ok {
+				err = Reject(CodeInternal, err.Error())
+			}
+			return nil, err
+		}
+		cmd = result
+	}
+	return cmd, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package interceptor
+
+import (
+	"encoding/json"
+	"flag"
+	"testing"
+
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+func init() {
+	flag.Set("v", "4")
+	flag.Set("logtostderr", "true")
+}
+
+func newTestChain(t *testing.T, config string) *Chain {
+	var configs []*Config
+	if err := json.Unmarshal([]byte(config), &configs); err != nil {
+		t.Fatal("json.Unmarshal() error:", err)
+	}
+	chain, err := NewChain(configs)
+	if err != nil {
+		t.Fatal("NewChain() error:", err)
+	}
+	return chain
+}
+
+func expectCode(t *testing.T, err error, code int) {
+	if rejection, ok := err.(*Rejection); !ok || rejection.Code != code {
+		t.Errorf("expect rejection %d, got: %v\n", code, err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain := newTestChain(t, `[
+		{"type": "max-payload", "options": {"size": 16}},
+		{"type": "blocklist", "commands": ["msg"], "options": {"words": ["Foo"], "patterns": ["b[a-z]r"], "action": "mask"}},
+		{"type": "blocklist", "commands": ["msg/secret"], "options": {"words": ["drop"], "action": "drop"}},
+		{"type": "blocklist", "direction": "outbound", "options": {"words": ["bad"]}}
+	]`)
+	cmd := &protocol.Command{AppID: "foo", Name: "msg/chat", Payload: []byte("FOO bar ok")}
+	result, err := chain.Inbound(cmd)
+	if err != nil || string(result.Payload) != "*** *** ok" {
+		t.Errorf("mask got: %v, %v\n", result, err)
+	}
+	if string(cmd.Payload) != "FOO bar ok" {
+		t.Errorf("mask should not modify the original command: %s\n", cmd.Payload)
+	}
+	// 只拦截匹配的信令
+	if result, err = chain.Inbound(&protocol.Command{Name: "message", Payload: []byte("foo")}); err != nil ||
+		string(result.Payload) != "foo" {
+		t.Errorf("unmatched command got: %v, %v\n", result, err)
+	}
+	if _, err = chain.Inbound(&protocol.Command{Name: "msg", Payload: []byte("0123456789abcdefg")}); err == nil {
+		t.Error("oversize payload should be rejected")
+	} else {
+		expectCode(t, err, CodePayloadTooLarge)
+	}
+	if _, err = chain.Inbound(&protocol.Command{Name: "msg/secret/x", Payload: []byte("drop me")}); err != ErrDrop {
+		t.Errorf("drop got: %v\n", err)
+	}
+	// 出站
+	if _, err = chain.Outbound(&protocol.Command{Name: "p2u", Payload: []byte("bad")}); err == nil {
+		t.Error("outbound should be rejected")
+	} else {
+		expectCode(t, err, CodeBlocked)
+	}
+	if result, err = chain.Outbound(&protocol.Command{Name: "p2u", Payload: []byte("0123456789abcdefg")}); err != nil || result == nil {
+		t.Errorf("inbound interceptor should not apply to outbound: %v\n", err)
+	}
+	// nil Chain放行
+	if result, err = (*Chain)(nil).Inbound(cmd); err != nil || result != cmd {
+		t.Errorf("nil chain got: %v, %v\n", result, err)
+	}
+
+	for _, config := range []string{
+		`[{"type": "unknown"}]`,
+		`[{"type": "max-payload"}]`,
+		`[{"type": "max-payload", "direction": "up", "options": {"size": 1}}]`,
+		`[{"type": "blocklist", "options": {}}]`,
+		`[{"type": "blocklist", "options": {"patterns": ["("]}}]`,
+		`[{"type": "blocklist", "options": {"words": ["a"], "action": "log"}}]`,
+		`[{"type": "json-schema", "options": {"schema": {"type": "map"}}}]`,
+	} {
+		var configs []*Config
+		json.Unmarshal([]byte(config), &configs)
+		if _, err := NewChain(configs); err == nil {
+			t.Errorf("NewChain(%s) should fail\n", config)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	chain := newTestChain(t, `[{"type": "json-schema", "options": {"schema": {
+		"type": "object",
+		"required": ["text"],
+		"additionalProperties": false,
+		"properties": {
+			"text": {"type": "string", "minLength": 1, "maxLength": 5},
+			"level": {"type": "integer", "minimum": 0, "maximum": 3},
+			"kind": {"enum": ["a", "b"]},
+			"id": {"type": ["string", "null"], "pattern": "^[0-9]+$"},
+			"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}}
+		}
+	}}}]`)
+	testCases := []struct {
+		payload string
+		ok      bool
+	}{
+		{`{"text": "hi"}`, true},
+		{`{"text": "你好", "level": 3, "kind": "a", "id": "12", "tags": ["x", "y"]}`, true},
+		{`{"text": "hi", "id": null}`, true},
+		{`not json`, false},
+		{`[]`, false},
+		{`{}`, false},
+		{`{"text": ""}`, false},
+		{`{"text": "toolong"}`, false},
+		{`{"text": "hi", "level": 1.5}`, false},
+		{`{"text": "hi", "level": 4}`, false},
+		{`{"text": "hi", "kind": "c"}`, false},
+		{`{"text": "hi", "id": "abc"}`, false},
+		{`{"text": "hi", "tags": ["x", 1]}`, false},
+		{`{"text": "hi", "tags": ["x", "y", "z"]}`, false},
+		{`{"text": "hi", "other": 1}`, false},
+	}
+	for index, testCase := range testCases {
+		_, err := chain.Inbound(&protocol.Command{Name: "msg", Payload: []byte(testCase.payload)})
+		if testCase.ok && err != nil {
+			t.Errorf("case %d %s got: %v\n", index, testCase.payload, err)
+		} else if !testCase.ok {
+			expectCode(t, err, CodeInvalidPayload)
+		}
+	}
+}
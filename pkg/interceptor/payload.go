@@ -0,0 +1,44 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package interceptor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// MaxPayload Payload长度限制
+type MaxPayload struct {
+	// Size 最大长度（单位：字节）
+	Size int `json:"size"`
+}
+
+func init() {
+	Register("max-payload", NewMaxPayload)
+}
+
+// NewMaxPayload 新建Payload长度限制
+func NewMaxPayload(options json.RawMessage) (Interceptor, error) {
+	interceptor := new(MaxPayload)
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, interceptor); err != nil {
+			return nil, err
+		}
+	}
+	if interceptor.Size <= 0 {
+		return nil, errors.New("size must be positive")
+	}
+	return interceptor, nil
+}
+
+// Intercept 拦截
+func (interceptor *MaxPayload) Intercept(cmd *protocol.Command) (*protocol.Command, error) {
+	if len(cmd.Payload) > interceptor.Size {
+		return nil, Reject(CodePayloadTooLarge,
+			fmt.Sprintf("payload size %d exceeds %d", len(cmd.Payload), interceptor.Size))
+	}
+	return cmd, nil
+}
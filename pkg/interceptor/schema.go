@@ -0,0 +1,244 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package interceptor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// Schema JSON Schema的子集
+type Schema struct {
+	// Type 类型：object、array、string、number、integer、boolean、null，可以是字符串或者字符串数组
+	Type interface{} `json:"type"`
+	// Properties 对象属性
+	Properties map[string]*Schema `json:"properties"`
+	// Required 对象必须的属性
+	Required []string `json:"required"`
+	// AdditionalProperties 为false时不允许Properties以外的属性
+	AdditionalProperties *bool `json:"additionalProperties"`
+	// Items 数组元素
+	Items *Schema `json:"items"`
+	// Enum 可选值
+	Enum []interface{} `json:"enum"`
+	// MinLength 字符串最小长度（字符数）
+	MinLength *int `json:"minLength"`
+	// MaxLength 字符串最大长度（字符数）
+	MaxLength *int `json:"maxLength"`
+	// Pattern 字符串正则表达式
+	Pattern string `json:"pattern"`
+	// Minimum 最小值
+	Minimum *float64 `json:"minimum"`
+	// Maximum 最大值
+	Maximum *float64 `json:"maximum"`
+	// MinItems 数组最小长度
+	MinItems *int `json:"minItems"`
+	// MaxItems 数组最大长度
+	MaxItems *int `json:"maxItems"`
+	// types 类型列表
+	types []string
+	// pattern 编译后的Pattern
+	pattern *regexp.Regexp
+}
+
+// JSONSchema Payload JSON Schema检查
+type JSONSchema struct {
+	// Schema 检查的Schema
+	Schema *Schema `json:"schema"`
+}
+
+func init() {
+	Register("json-schema", NewJSONSchema)
+}
+
+// NewJSONSchema 新建JSON Schema检查
+func NewJSONSchema(options json.RawMessage) (Interceptor, error) {
+	interceptor := new(JSONSchema)
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, interceptor); err != nil {
+			return nil, err
+		}
+	}
+	if interceptor.Schema == nil {
+		return nil, errors.New("schema required")
+	}
+	if err := interceptor.Schema.compile(); err != nil {
+		return nil, err
+	}
+	return interceptor, nil
+}
+
+// Intercept 拦截
+func (interceptor *JSONSchema) Intercept(cmd *protocol.Command) (*protocol.Command, error) {
+	var value interface{}
+	if err := json.Unmarshal(cmd.Payload, &value); err != nil {
+		return nil, Reject(CodeInvalidPayload, "payload is not json")
+	}
+	if err := interceptor.Schema.Validate(value); err != nil {
+		return nil, Reject(CodeInvalidPayload, err.Error())
+	}
+	return cmd, nil
+}
+
+// compile 检查Schema并编译正则表达式
+func (schema *Schema) compile() (err error) {
+	switch t := schema.Type.(type) {
+	case nil:
+	case string:
+		schema.types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("invalid type %v", t)
+			}
+			schema.types = append(schema.types, s)
+		}
+	default:
+		return fmt.Errorf("invalid type %v", t)
+	}
+	for _, t := range schema.types {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("invalid type %s", t)
+		}
+	}
+	if len(schema.Pattern) > 0 {
+		if schema.pattern, err = regexp.Compile(schema.Pattern); err != nil {
+			return err
+		}
+	}
+	for _, property := range schema.Properties {
+		if err = property.compile(); err != nil {
+			return err
+		}
+	}
+	if schema.Items != nil {
+		return schema.Items.compile()
+	}
+	return nil
+}
+
+// typeOf JSON值的类型
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// matchType 值是否符合类型
+func (schema *Schema) matchType(value interface{}) bool {
+	if len(schema.types) == 0 {
+		return true
+	}
+	actual := typeOf(value)
+	for _, t := range schema.types {
+		if t == actual {
+			return true
+		}
+		if n, ok := value.(float64); ok && t == "integer" && n == math.Trunc(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 检查JSON值（json.Unmarshal到interface{}的结果）
+func (schema *Schema) Validate(value interface{}) error {
+	return schema.validate("$", value)
+}
+
+func (schema *Schema) validate(path string, value interface{}) error {
+	if !schema.matchType(value) {
+		return fmt.Errorf("%s: expect type %v, got %s", path, schema.types, typeOf(value))
+	}
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, e := range schema.Enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: not in enum", path)
+		}
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, found := v[name]; !found {
+				return fmt.Errorf("%s: missing property %s", path, name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, found := schema.Properties[name]
+			if !found {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					return fmt.Errorf("%s: additional property %s", path, name)
+				}
+				continue
+			}
+			if err := property.validate(path+"."+name, v[name]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if schema.MinItems != nil && len(v) < *schema.MinItems {
+			return fmt.Errorf("%s: items less than %d", path, *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(v) > *schema.MaxItems {
+			return fmt.Errorf("%s: items more than %d", path, *schema.MaxItems)
+		}
+		if schema.Items != nil {
+			for index, item := range v {
+				if err := schema.Items.validate(fmt.Sprintf("%s[%d]", path, index), item); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			return fmt.Errorf("%s: length less than %d", path, *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			return fmt.Errorf("%s: length more than %d", path, *schema.MaxLength)
+		}
+		if schema.pattern != nil && !schema.pattern.MatchString(v) {
+			return fmt.Errorf("%s: not match pattern", path)
+		}
+	case float64:
+		if schema.Minimum != nil && v < *schema.Minimum {
+			return fmt.Errorf("%s: less than %v", path, *schema.Minimum)
+		}
+		if schema.Maximum != nil && v > *schema.Maximum {
+			return fmt.Errorf("%s: more than %v", path, *schema.Maximum)
+		}
+	}
+	return nil
+}
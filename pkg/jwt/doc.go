@@ -0,0 +1,15 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+/*
+Package jwt JSON Web Token（RFC 7519）的解析、验证和签名
+
+只支持JWS Compact格式，签名算法：
+
+	HS256 HMAC SHA256，密钥为[]byte
+	RS256 RSASSA-PKCS1-v1_5 SHA256，验证密钥为*rsa.PublicKey，签名密钥为*rsa.PrivateKey
+	ES256 ECDSA P-256 SHA256，验证密钥为*ecdsa.PublicKey，签名密钥为*ecdsa.PrivateKey
+
+验证时由KeyFunc根据Header（alg、kid）返回密钥，KeyFunc应检查alg与密钥类型是否匹配，
+避免使用公钥作为HS256密钥的攻击。
+*/
+package jwt
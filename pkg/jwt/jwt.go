@@ -0,0 +1,295 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// HS256 HMAC SHA256
+	HS256 = "HS256"
+	// RS256 RSASSA-PKCS1-v1_5 SHA256
+	RS256 = "RS256"
+	// ES256 ECDSA P-256 SHA256
+	ES256 = "ES256"
+)
+
+var (
+	// ErrMalformed Token格式错误
+	ErrMalformed = errors.New("jwt malformed")
+	// ErrUnsupportedAlgorithm 不支持的签名算法
+	ErrUnsupportedAlgorithm = errors.New("jwt unsupported algorithm")
+	// ErrInvalidKey 密钥类型与签名算法不匹配
+	ErrInvalidKey = errors.New("jwt invalid key")
+	// ErrSignature 签名错误
+	ErrSignature = errors.New("jwt signature invalid")
+	// ErrExpired Token已过期
+	ErrExpired = errors.New("jwt expired")
+	// ErrNoExpiration Token没有exp，不会过期的Token不被接受
+	ErrNoExpiration = errors.New("jwt without expiration")
+	// ErrNotValidYet Token未生效
+	ErrNotValidYet = errors.New("jwt not valid yet")
+	// ErrAudience aud不匹配
+	ErrAudience = errors.New("jwt audience invalid")
+	// ErrIssuer iss不匹配
+	ErrIssuer = errors.New("jwt issuer invalid")
+)
+
+// Header JOSE Header
+type Header struct {
+	// Algorithm 签名算法
+	Algorithm string `json:"alg"`
+	// Type 类型
+	Type string `json:"typ,omitempty"`
+	// KeyID 密钥ID
+	KeyID string `json:"kid,omitempty"`
+}
+
+// Claims JWT Claims
+type Claims map[string]interface{}
+
+// Token 解析后的Token
+type Token struct {
+	// Header JOSE Header
+	Header Header
+	// Claims JWT Claims
+	Claims Claims
+}
+
+// KeyFunc 根据Header返回验证签名的密钥
+type KeyFunc func(header *Header) (interface{}, error)
+
+// Parse 解析Token并验证签名（不验证exp、nbf等Claims）
+func Parse(token string, keyFunc KeyFunc) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var t Token
+	if err := decodeSegment(parts[0], &t.Header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	key, err := keyFunc(&t.Header)
+	if err != nil {
+		return nil, err
+	}
+	if err = verify(t.Header.Algorithm, []byte(parts[0]+"."+parts[1]), signature, key); err != nil {
+		return nil, err
+	}
+	if err = decodeSegment(parts[1], &t.Claims); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Sign 使用key签名，生成Token
+func Sign(algorithm, kid string, claims Claims, key interface{}) (string, error) {
+	header, err := json.Marshal(&Header{Algorithm: algorithm, Type: "JWT", KeyID: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch algorithm {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return "", ErrInvalidKey
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case RS256:
+		privateKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", ErrInvalidKey
+		}
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case ES256:
+		privateKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", ErrInvalidKey
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// R和S各32字节，不足时高位补0
+		signature = make([]byte, 64)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rBytes):32], rBytes)
+		copy(signature[64-len(sBytes):], sBytes)
+	default:
+		return "", ErrUnsupportedAlgorithm
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verify 验证签名
+func verify(algorithm string, input, signature []byte, key interface{}) error {
+	digest := sha256.Sum256(input)
+	switch algorithm {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return ErrInvalidKey
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrSignature
+		}
+	case RS256:
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidKey
+		}
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return ErrSignature
+		}
+	case ES256:
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrInvalidKey
+		}
+		if len(signature) != 64 {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, digest[:], r, s) {
+			return ErrSignature
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return nil
+}
+
+// decodeSegment 解析base64url编码的JSON
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformed
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err = dec.Decode(v); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// ParsePublicKey 解析PEM格式的公钥（PKIX或者PKCS1 RSA公钥），返回*rsa.PublicKey或者*ecdsa.PublicKey
+func ParsePublicKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// Time 取得时间类型的Claim（NumericDate）
+func (claims Claims) Time(name string) (t time.Time, ok bool) {
+	number, ok := claims[name].(json.Number)
+	if !ok {
+		return t, false
+	}
+	value, err := number.Float64()
+	if err != nil {
+		return t, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+// String 取得字符串类型的Claim
+func (claims Claims) String(name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// Strings 取得字符串数组类型的Claim，单个字符串作为只有一个元素的数组
+func (claims Claims) Strings(name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Validate 验证exp（必须设置）、nbf，以及audience、issuer不为空时验证aud、iss
+func (claims Claims) Validate(now time.Time, leeway time.Duration, audience, issuer string) error {
+	if exp, ok := claims.Time("exp"); ok && !now.Before(exp.Add(leeway)) {
+		return ErrExpired
+	} else if !ok && claims["exp"] != nil {
+		return ErrMalformed
+	} else if !ok {
+		return ErrNoExpiration
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return ErrNotValidYet
+	} else if !ok && claims["nbf"] != nil {
+		return ErrMalformed
+	}
+	if len(audience) > 0 {
+		found := false
+		for _, aud := range claims.Strings("aud") {
+			if aud == audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrAudience
+		}
+	}
+	if len(issuer) > 0 && claims.String("iss") != issuer {
+		return ErrIssuer
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignParse(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("rsa.GenerateKey() error:", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("ecdsa.GenerateKey() error:", err)
+	}
+	secret := []byte("secret")
+	testCases := []struct {
+		algorithm string
+		signKey   interface{}
+		verifyKey interface{}
+	}{
+		{HS256, secret, secret},
+		{RS256, rsaKey, &rsaKey.PublicKey},
+		{ES256, ecKey, &ecKey.PublicKey},
+	}
+	claims := Claims{"sub": "foo", "tags": []string{"a", "b"}, "exp": time.Now().Unix() + 60}
+	for _, testCase := range testCases {
+		token, err := Sign(testCase.algorithm, "k1", claims, testCase.signKey)
+		if err != nil {
+			t.Fatalf("%s Sign() error: %s\n", testCase.algorithm, err)
+		}
+		parsed, err := Parse(token, func(header *Header) (interface{}, error) {
+			if header.KeyID != "k1" || header.Algorithm != testCase.algorithm {
+				t.Errorf("%s header: %+v\n", testCase.algorithm, header)
+			}
+			return testCase.verifyKey, nil
+		})
+		if err != nil {
+			t.Fatalf("%s Parse() error: %s\n", testCase.algorithm, err)
+		}
+		if parsed.Claims.String("sub") != "foo" || strings.Join(parsed.Claims.Strings("tags"), ",") != "a,b" {
+			t.Errorf("%s claims: %v\n", testCase.algorithm, parsed.Claims)
+		}
+		if err = parsed.Claims.Validate(time.Now(), 0, "", ""); err != nil {
+			t.Errorf("%s Validate() error: %s\n", testCase.algorithm, err)
+		}
+
+		// 篡改Claims
+		parts := strings.Split(token, ".")
+		forged, _ := Sign(testCase.algorithm, "k1", Claims{"sub": "bar"}, testCase.signKey)
+		parts[1] = strings.Split(forged, ".")[1]
+		if _, err = Parse(strings.Join(parts, "."), func(*Header) (interface{}, error) {
+			return testCase.verifyKey, nil
+		}); err != ErrSignature {
+			t.Errorf("%s forged token expect ErrSignature, got: %v\n", testCase.algorithm, err)
+		}
+	}
+
+	// 公钥不能作为HS256密钥
+	token, _ := Sign(HS256, "", claims, secret)
+	if _, err = Parse(token, func(*Header) (interface{}, error) {
+		return &rsaKey.PublicKey, nil
+	}); err != ErrInvalidKey {
+		t.Errorf("HS256 with public key expect ErrInvalidKey, got: %v\n", err)
+	}
+	if _, err = Parse("a.b", nil); err != ErrMalformed {
+		t.Errorf("Parse(a.b) expect ErrMalformed, got: %v\n", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	testCases := []struct {
+		claims   string
+		audience string
+		issuer   string
+		expect   error
+	}{
+		{`{"exp": 1001}`, "", "", nil},
+		{`{"exp": 1000}`, "", "", ErrExpired},
+		{`{"exp": "1001"}`, "", "", ErrMalformed},
+		{`{}`, "", "", ErrNoExpiration},
+		{`{"sub": "foo", "aud": "zim"}`, "zim", "", ErrNoExpiration},
+		{`{"exp": 2000, "nbf": 1001}`, "", "", ErrNotValidYet},
+		{`{"exp": 2000, "nbf": 1000}`, "", "", nil},
+		{`{"exp": 2000, "aud": "zim"}`, "zim", "", nil},
+		{`{"exp": 2000, "aud": ["other", "zim"]}`, "zim", "", nil},
+		{`{"exp": 2000, "aud": "other"}`, "zim", "", ErrAudience},
+		{`{"exp": 2000}`, "zim", "", ErrAudience},
+		{`{"exp": 2000, "iss": "idp"}`, "", "idp", nil},
+		{`{"exp": 2000, "iss": "other"}`, "", "idp", ErrIssuer},
+	}
+	for index, testCase := range testCases {
+		var claims Claims
+		if err := decodeSegment(encodeSegment(testCase.claims), &claims); err != nil {
+			t.Fatal("decodeSegment() error:", err)
+		}
+		if err := claims.Validate(now, 0, testCase.audience, testCase.issuer); err != testCase.expect {
+			t.Errorf("Case(%d): Validate(%s) expect: %v, got: %v\n", index+1, testCase.claims, testCase.expect, err)
+		}
+	}
+	// 允许时钟误差
+	claims := Claims{}
+	decodeSegment(encodeSegment(`{"exp": 1000}`), &claims)
+	if err := claims.Validate(now, time.Second*5, "", ""); err != nil {
+		t.Error("Validate() with leeway error:", err)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pkix, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	for _, block := range []*pem.Block{
+		{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)},
+		{Type: "PUBLIC KEY", Bytes: pkix},
+	} {
+		if _, err := ParsePublicKey(pem.EncodeToMemory(block)); err != nil {
+			t.Errorf("ParsePublicKey(%s) error: %s\n", block.Type, err)
+		}
+	}
+	if _, err := ParsePublicKey([]byte("foo")); err != ErrInvalidKey {
+		t.Errorf("ParsePublicKey(foo) expect ErrInvalidKey, got: %v\n", err)
+	}
+}
+
+func encodeSegment(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
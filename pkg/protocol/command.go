@@ -27,6 +27,8 @@ const (
 	Push2User = "p2u"
 	// Push2Service 转发消息给服务
 	Push2Service = "p2s"
+	// Error 错误，网关拒绝客户端的信令时发送给客户端
+	Error = "err"
 )
 
 // Command 信令
@@ -133,6 +135,13 @@ func (cmd *Command) ParseData(data []byte) (err error) {
 				break
 			}
 			cmd.Data = &pushCmd
+		case Error:
+			var errCmd ErrorCommand
+			if err = json.Unmarshal(data, &errCmd); err != nil {
+				glog.Warningln("protocol::Command::Parse() json.Unmarshal Error error:", err)
+				break
+			}
+			cmd.Data = &errCmd
 		}
 	}
 	return err
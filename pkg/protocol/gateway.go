@@ -8,6 +8,13 @@ import (
 	"github.com/zhangpeihao/zim/pkg/util"
 )
 
+const (
+	// HMACTokenVersion HMAC Token格式版本，Token为"<版本>.<HMAC>"
+	HMACTokenVersion = "v2"
+	// HMACTokenSeparator HMAC Token中版本与HMAC的分隔符
+	HMACTokenSeparator = "."
+)
+
 // GatewayLoginCommand 网关登入信令
 type GatewayLoginCommand struct {
 	// UserID 用户ID
@@ -18,6 +25,13 @@ type GatewayLoginCommand struct {
 	Timestamp int64 `json:"timestamp"`
 	// Token 认证字=md5(<app key>,UserID,DeviceID,Timestamp)
 	Token string `json:"token"`
+	// KeyID 计算Token使用的密钥ID，为空时使用应用的默认密钥
+	KeyID string `json:"keyid,omitempty"`
+	// Tags 用户标签，使用JWT登入时从Claims中取得，或者由应用服务在登入响应中设置。
+	// 客户端设置的标签会被忽略
+	Tags []string `json:"tags,omitempty"`
+	// Metadata 用户附加信息，由登入认证设置
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // GatewayCloseCommand 网关关闭信令
@@ -32,9 +46,28 @@ type GatewayMessageCommand struct {
 	UserID string `json:"userid"`
 }
 
+// ErrorCommand 错误信令，网关拒绝客户端的信令时发送给客户端
+type ErrorCommand struct {
+	// Code 错误码
+	Code int `json:"code"`
+	// Message 错误信息
+	Message string `json:"message"`
+	// Name 被拒绝的信令名
+	Name string `json:"name"`
+}
+
 // CalToken 计算Token
 func (cmd *GatewayLoginCommand) CalToken(key []byte) string {
 	return util.CheckSumMD5(key, []byte(cmd.UserID),
 		[]byte(cmd.DeviceID),
 		[]byte(strconv.Itoa(int(cmd.Timestamp))))
 }
+
+// CalHMACToken 计算HMAC-SHA256 Token，字段加长度前缀后计算，避免字段边界不明确：
+// "<HMACTokenVersion>.HMAC-SHA256(key, F(HMACTokenVersion) + F(UserID) + F(DeviceID) + F(Timestamp))"
+func (cmd *GatewayLoginCommand) CalHMACToken(key []byte) string {
+	return HMACTokenVersion + HMACTokenSeparator + util.SignHMACSHA256(key, []byte(HMACTokenVersion),
+		[]byte(cmd.UserID),
+		[]byte(cmd.DeviceID),
+		[]byte(strconv.FormatInt(cmd.Timestamp, 10)))
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket 令牌桶
+type Bucket struct {
+	sync.Mutex
+	// rate 每秒生成的令牌数
+	rate float64
+	// burst 容量
+	burst float64
+	// tokens 当前令牌数，预约等待时可以为负数
+	tokens float64
+	// last 上次计算令牌的时间
+	last time.Time
+}
+
+// NewBucket 新建令牌桶，新建时令牌是满的
+func NewBucket(rate float64, burst int, now time.Time) *Bucket {
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// refill 根据经过的时间生成令牌
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// Allow 有令牌时消耗一个令牌并返回true
+func (b *Bucket) Allow(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// Reserve 预约一个令牌，返回需要等待的时间。等待时间超过maxDelay时不预约，返回false
+func (b *Bucket) Reserve(now time.Time, maxDelay time.Duration) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if delay > maxDelay {
+		return 0, false
+	}
+	b.tokens--
+	return delay, true
+}
+
+// refund 退回Allow或者Reserve消耗的令牌
+func (b *Bucket) refund() {
+	b.Lock()
+	defer b.Unlock()
+	if b.tokens++; b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// idle 令牌已满，与新建的令牌桶相同，可以删除
+func (b *Bucket) idle(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
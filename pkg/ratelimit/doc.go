@@ -0,0 +1,33 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+/*
+Package ratelimit 客户端信令限流（令牌桶）
+
+每个应用通过rate-limits配置限流规则，网关对已登入连接的信令按顺序检查所有匹配的规则：
+
+	"rate-limits": [
+		{"commands": ["msg"], "scope": "connection", "rate": 5, "burst": 10, "action": "delay", "max-delay": 500},
+		{"commands": ["msg"], "scope": "user", "rate": 10, "action": "reject"},
+		{"scope": "app", "rate": 10000, "burst": 20000, "action": "reject"},
+		{"scope": "connection", "rate": 50, "action": "disconnect"}
+	]
+
+* commands: 限流的信令名，同时匹配以它为前缀的信令名，为空或者包含"*"时匹配所有信令
+
+* scope: 令牌桶范围，connection（默认，每个连接）、user（同一用户的所有连接）或者app（应用的所有连接）
+
+* rate: 每秒生成的令牌数，每个信令消耗一个令牌
+
+* burst: 令牌桶容量，默认为rate（至少为1）
+
+* action: 没有令牌时的处理方式：reject（默认，向客户端发送err信令，错误码429）、
+delay（等待令牌生成后再处理，等待时间超过max-delay时拒绝）或者disconnect（断开连接）
+
+* max-delay: delay的最长等待时间（单位：毫秒，默认1000），网关最长等待5秒，超过时拒绝
+
+应用配置重新加载后令牌桶重新计算。长时间没有使用（令牌已满）的令牌桶会被自动清理。
+
+限流计数通过expvar发布（变量名ratelimit），键为"<appid>.<action>"，例如"foo.reject"，
+可以通过调试服务的/debug/vars或者网关管理接口的/metrics查看。
+*/
+package ratelimit
@@ -0,0 +1,248 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package ratelimit
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// 令牌桶范围
+const (
+	// ScopeConnection 每个连接
+	ScopeConnection = "connection"
+	// ScopeUser 同一用户的所有连接
+	ScopeUser = "user"
+	// ScopeApp 应用的所有连接
+	ScopeApp = "app"
+)
+
+// 没有令牌时的处理方式
+const (
+	// ActionAllow 放行
+	ActionAllow = ""
+	// ActionReject 拒绝
+	ActionReject = "reject"
+	// ActionDelay 等待令牌
+	ActionDelay = "delay"
+	// ActionDisconnect 断开连接
+	ActionDisconnect = "disconnect"
+)
+
+const (
+	// CodeRateLimited 被限流的错误码，通过err信令返回给客户端
+	CodeRateLimited = 429
+	// DefaultMaxDelay 默认最长等待时间
+	DefaultMaxDelay = time.Second
+	// SweepInterval 清理令牌桶的间隔
+	SweepInterval = time.Minute
+	// allCommands 匹配所有信令
+	allCommands = "*"
+)
+
+var (
+	// ErrRateLimited 被限流
+	ErrRateLimited = errors.New("rate limited")
+	// metrics 限流计数
+	metrics = expvar.NewMap("ratelimit")
+)
+
+// Metrics 限流计数，键为"<appid>.<action>"
+func Metrics() *expvar.Map {
+	return metrics
+}
+
+// Rule 限流规则
+type Rule struct {
+	// Commands 限流的信令名，为空时匹配所有信令
+	Commands []string `json:"commands,omitempty"`
+	// Scope 令牌桶范围：connection（默认）、user或者app
+	Scope string `json:"scope,omitempty"`
+	// Rate 每秒生成的令牌数
+	Rate float64 `json:"rate"`
+	// Burst 令牌桶容量，默认为Rate（至少为1）
+	Burst int `json:"burst,omitempty"`
+	// Action 没有令牌时的处理方式：reject（默认）、delay或者disconnect
+	Action string `json:"action,omitempty"`
+	// MaxDelay delay的最长等待时间（单位：毫秒），默认1000，网关最长等待gateway.MaxLimitDelay
+	MaxDelay int `json:"max-delay,omitempty"`
+}
+
+// match 是否匹配信令
+func (rule *Rule) match(name string) bool {
+	if len(rule.Commands) == 0 {
+		return true
+	}
+	for _, command := range rule.Commands {
+		if command == allCommands || command == name || strings.HasPrefix(name, command+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Decision 限流结果
+type Decision struct {
+	// Action 处理方式，ActionAllow为放行
+	Action string
+	// Delay ActionDelay时需要等待的时间
+	Delay time.Duration
+}
+
+// limiterRule 规则和规则的令牌桶
+type limiterRule struct {
+	*Rule
+	maxDelay time.Duration
+	buckets  map[string]*Bucket
+}
+
+// Limiter 应用的限流，nil Limiter放行所有信令
+type Limiter struct {
+	sync.Mutex
+	appID     string
+	rules     []*limiterRule
+	lastSweep time.Time
+}
+
+// NewLimiter 新建应用的限流，没有规则时返回nil。使用规则的副本，不修改rules
+func NewLimiter(appID string, rules []*Rule) (*Limiter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	limiter := &Limiter{appID: appID}
+	for index, r := range rules {
+		rule := *r
+		if rule.Rate <= 0 {
+			return nil, fmt.Errorf("rate limit %d: rate must be positive", index)
+		}
+		if rule.Burst <= 0 {
+			rule.Burst = int(math.Max(1, math.Ceil(rule.Rate)))
+		}
+		switch rule.Scope {
+		case "":
+			rule.Scope = ScopeConnection
+		case ScopeConnection, ScopeUser, ScopeApp:
+		default:
+			return nil, fmt.Errorf("rate limit %d: invalid scope %s", index, rule.Scope)
+		}
+		switch rule.Action {
+		case "":
+			rule.Action = ActionReject
+		case ActionReject, ActionDelay, ActionDisconnect:
+		default:
+			return nil, fmt.Errorf("rate limit %d: invalid action %s", index, rule.Action)
+		}
+		maxDelay := time.Duration(rule.MaxDelay) * time.Millisecond
+		if maxDelay <= 0 {
+			maxDelay = DefaultMaxDelay
+		}
+		limiter.rules = append(limiter.rules, &limiterRule{
+			Rule:     &rule,
+			maxDelay: maxDelay,
+			buckets:  make(map[string]*Bucket),
+		})
+	}
+	return limiter, nil
+}
+
+// bucket 取得规则的令牌桶，不存在时新建
+func (limiter *Limiter) bucket(rule *limiterRule, connID, userID string, now time.Time) *Bucket {
+	var key string
+	switch rule.Scope {
+	case ScopeConnection:
+		key = connID
+	case ScopeUser:
+		key = userID
+	}
+	limiter.Lock()
+	defer limiter.Unlock()
+	b, found := rule.buckets[key]
+	if !found {
+		b = NewBucket(rule.Rate, rule.Burst, now)
+		rule.buckets[key] = b
+	}
+	return b
+}
+
+// Check 检查连接的信令，按顺序检查所有匹配的规则。
+// 规则没有令牌时返回规则的处理方式；delay规则返回最长的等待时间，等待超过max-delay时拒绝。
+// 没有放行（或者等待）时退回前面规则已经消耗的令牌
+func (limiter *Limiter) Check(connID, userID, name string, now time.Time) (decision Decision) {
+	if limiter == nil {
+		return
+	}
+	limiter.sweep(now)
+	var taken []*Bucket
+	for _, rule := range limiter.rules {
+		if !rule.match(name) {
+			continue
+		}
+		b := limiter.bucket(rule, connID, userID, now)
+		if rule.Action == ActionDelay {
+			delay, ok := b.Reserve(now, rule.maxDelay)
+			if !ok {
+				decision = Decision{Action: ActionReject}
+				break
+			}
+			taken = append(taken, b)
+			if delay > decision.Delay {
+				decision = Decision{Action: ActionDelay, Delay: delay}
+			}
+			continue
+		}
+		if !b.Allow(now) {
+			decision = Decision{Action: rule.Action}
+			break
+		}
+		taken = append(taken, b)
+	}
+	if decision.Action != ActionAllow && decision.Action != ActionDelay {
+		for _, b := range taken {
+			b.refund()
+		}
+	}
+	if decision.Action != ActionAllow {
+		metrics.Add(limiter.appID+"."+decision.Action, 1)
+		glog.Infof("ratelimit::Limiter::Check(%s) %s %s: %s %s\n",
+			limiter.appID, connID, name, decision.Action, decision.Delay)
+	}
+	return
+}
+
+// sweep 定期删除令牌已满的令牌桶
+func (limiter *Limiter) sweep(now time.Time) {
+	limiter.Lock()
+	defer limiter.Unlock()
+	if now.Sub(limiter.lastSweep) < SweepInterval {
+		return
+	}
+	limiter.lastSweep = now
+	for _, rule := range limiter.rules {
+		for key, b := range rule.buckets {
+			if b.idle(now) {
+				delete(rule.buckets, key)
+			}
+		}
+	}
+}
+
+// Len 令牌桶数量
+func (limiter *Limiter) Len() int {
+	if limiter == nil {
+		return 0
+	}
+	limiter.Lock()
+	defer limiter.Unlock()
+	count := 0
+	for _, rule := range limiter.rules {
+		count += len(rule.buckets)
+	}
+	return count
+}
@@ -0,0 +1,160 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package ratelimit
+
+import (
+	"encoding/json"
+	"expvar"
+	"flag"
+	"testing"
+	"time"
+)
+
+func init() {
+	flag.Set("v", "4")
+	flag.Set("logtostderr", "true")
+}
+
+func newTestLimiter(t *testing.T, appID, config string) *Limiter {
+	var rules []*Rule
+	if err := json.Unmarshal([]byte(config), &rules); err != nil {
+		t.Fatal("json.Unmarshal() error:", err)
+	}
+	limiter, err := NewLimiter(appID, rules)
+	if err != nil {
+		t.Fatal("NewLimiter() error:", err)
+	}
+	return limiter
+}
+
+func metric(key string) int64 {
+	if v, ok := metrics.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(2, 2, now)
+	if !b.Allow(now) || !b.Allow(now) || b.Allow(now) {
+		t.Error("burst 2 should allow 2 commands")
+	}
+	// 0.5秒生成一个令牌
+	if b.Allow(now.Add(400*time.Millisecond)) || !b.Allow(now.Add(500*time.Millisecond)) {
+		t.Error("rate 2 should allow one command after 500ms")
+	}
+
+	b = NewBucket(10, 1, now)
+	if delay, ok := b.Reserve(now, time.Second); !ok || delay != 0 {
+		t.Errorf("first reserve got %s, %v\n", delay, ok)
+	}
+	if delay, ok := b.Reserve(now, time.Second); !ok || delay != 100*time.Millisecond {
+		t.Errorf("second reserve got %s, %v\n", delay, ok)
+	}
+	if delay, ok := b.Reserve(now, time.Second); !ok || delay != 200*time.Millisecond {
+		t.Errorf("third reserve got %s, %v\n", delay, ok)
+	}
+	if _, ok := b.Reserve(now, 250*time.Millisecond); ok {
+		t.Error("reserve over max delay should fail")
+	}
+	if b.idle(now.Add(200*time.Millisecond)) || !b.idle(now.Add(300*time.Millisecond)) {
+		t.Error("bucket should be idle when full")
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	if limiter, err := NewLimiter("empty", nil); limiter != nil || err != nil {
+		t.Errorf("no rules got %v, %v\n", limiter, err)
+	}
+	var limiter *Limiter
+	if decision := limiter.Check("c1", "u1", "msg", time.Now()); decision.Action != ActionAllow {
+		t.Errorf("nil limiter got %+v\n", decision)
+	}
+	for _, rule := range []*Rule{
+		{Rate: 0},
+		{Rate: 1, Scope: "device"},
+		{Rate: 1, Action: "ignore"},
+	} {
+		if _, err := NewLimiter("invalid", []*Rule{rule}); err == nil {
+			t.Errorf("rule %+v should be invalid\n", rule)
+		}
+	}
+	// 默认值只设置在副本上，不修改应用配置中的规则
+	rule := &Rule{Rate: 0.5}
+	limiter, err := NewLimiter("default", []*Rule{rule})
+	if err != nil {
+		t.Fatal("NewLimiter() error:", err)
+	}
+	if got := limiter.rules[0].Rule; got == rule ||
+		got.Scope != ScopeConnection || got.Action != ActionReject || got.Burst != 1 {
+		t.Errorf("default rule got %+v\n", got)
+	}
+	if len(rule.Scope) != 0 || len(rule.Action) != 0 || rule.Burst != 0 {
+		t.Errorf("rule changed: %+v\n", rule)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := newTestLimiter(t, "limiter", `[
+		{"commands": ["msg"], "rate": 1, "burst": 2, "action": "delay", "max-delay": 1500},
+		{"commands": ["msg"], "scope": "user", "rate": 1, "burst": 3},
+		{"commands": ["*"], "scope": "app", "rate": 1, "burst": 5, "action": "disconnect"}
+	]`)
+	now := time.Now()
+	expect := func(connID, userID, name string, action string, delay time.Duration) {
+		decision := limiter.Check(connID, userID, name, now)
+		if decision.Action != action || decision.Delay != delay {
+			t.Errorf("Check(%s, %s, %s) got %+v, expect %s %s\n", connID, userID, name, decision, action, delay)
+		}
+	}
+
+	// 连接的令牌桶
+	expect("u1/d1", "u1", "msg/chat", ActionAllow, 0)
+	expect("u1/d1", "u1", "msg/chat", ActionAllow, 0)
+	expect("u1/d1", "u1", "msg/chat", ActionDelay, time.Second)
+	// 用户的令牌桶在另一个连接上也生效
+	expect("u1/d2", "u1", "msg", ActionReject, 0)
+	// 不匹配msg的信令只使用应用的令牌桶，被拒绝的信令不消耗后面规则的令牌
+	expect("u2/d1", "u2", "ping", ActionAllow, 0)
+	expect("u3/d1", "u3", "message", ActionAllow, 0)
+	expect("u3/d1", "u3", "message", ActionDisconnect, 0)
+
+	if metric("limiter.delay") != 1 || metric("limiter.reject") != 1 || metric("limiter.disconnect") != 1 {
+		t.Errorf("metrics: %s\n", metrics)
+	}
+}
+
+func TestLimiterRefund(t *testing.T) {
+	limiter := newTestLimiter(t, "refund", `[
+		{"rate": 0.001, "burst": 1},
+		{"scope": "user", "rate": 1, "burst": 1},
+		{"scope": "app", "rate": 0.001, "burst": 2}
+	]`)
+	now := time.Now()
+	if decision := limiter.Check("u1/d1", "u1", "msg", now); decision.Action != ActionAllow {
+		t.Fatalf("first command got %+v\n", decision)
+	}
+	// 用户的令牌用完，拒绝时退回连接的令牌，也不消耗应用的令牌
+	if decision := limiter.Check("u1/d2", "u1", "msg", now); decision.Action != ActionReject {
+		t.Fatalf("second command got %+v\n", decision)
+	}
+	if decision := limiter.Check("u1/d2", "u1", "msg", now.Add(time.Second)); decision.Action != ActionAllow {
+		t.Errorf("connection token should be refunded, got %+v\n", decision)
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	limiter := newTestLimiter(t, "sweep", `[{"rate": 10}]`)
+	now := time.Now()
+	limiter.Check("u1/d1", "u1", "msg", now)
+	limiter.Check("u2/d1", "u2", "msg", now.Add(time.Second))
+	if limiter.Len() != 2 {
+		t.Errorf("buckets: %d\n", limiter.Len())
+	}
+	// 清理令牌已满的令牌桶
+	limiter.Check("u2/d1", "u2", "msg", now.Add(SweepInterval+time.Second))
+	if limiter.Len() != 1 {
+		t.Errorf("buckets after sweep: %d\n", limiter.Len())
+	}
+}
@@ -3,6 +3,7 @@
 package util
 
 import (
+	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha1"
@@ -52,6 +53,27 @@ func CheckSumMD5(fields ...[]byte) string {
 	return CheckSum(h, fields...)
 }
 
+// CanonicalFields 字段编码，每个字段前加4字节（大端）长度，避免字段边界不明确
+func CanonicalFields(fields ...[]byte) []byte {
+	size := 0
+	for _, field := range fields {
+		size += 4 + len(field)
+	}
+	buf := make([]byte, 0, size)
+	for _, field := range fields {
+		buf = append(buf, byte(len(field)>>24), byte(len(field)>>16), byte(len(field)>>8), byte(len(field)))
+		buf = append(buf, field...)
+	}
+	return buf
+}
+
+// SignHMACSHA256 使用秘钥对编码后的字段计算HMAC-SHA256签名
+func SignHMACSHA256(key []byte, fields ...[]byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write(CanonicalFields(fields...))
+	return fmt.Sprintf("%X", h.Sum(nil))
+}
+
 // NewNonce 新建Nonce
 func NewNonce() (nonce string, err error) {
 	b := make([]byte, NonceBytes)
@@ -71,3 +71,18 @@ func TestNewNonce(t *testing.T) {
 		t.Errorf("NewNonce no nonce\n")
 	}
 }
+
+func TestSignHMACSHA256(t *testing.T) {
+	got := SignHMACSHA256([]byte("1234567890"), []byte("foo"), []byte("bar"))
+	expect := "0F66014ED49332132049664A78B3C5EE8119682C318A7C2B81C54E21D666F6F2"
+	if got != expect {
+		t.Errorf("SignHMACSHA256 got: %s, expect: %s\n", got, expect)
+	}
+	// 字段边界不同，签名不同
+	if SignHMACSHA256([]byte("key"), []byte("ab"), []byte("c")) == SignHMACSHA256([]byte("key"), []byte("a"), []byte("bc")) {
+		t.Error("SignHMACSHA256 should distinguish field boundaries")
+	}
+	if string(CanonicalFields([]byte("ab"), nil)) != "\x00\x00\x00\x02ab\x00\x00\x00\x00" {
+		t.Errorf("CanonicalFields got: %q\n", CanonicalFields([]byte("ab"), nil))
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+/*
+Package webhook 网关事件通知
+
+应用配置webhooks后，网关事件以HTTP POST异步通知应用服务，应用服务不需要应答：
+
+	"webhooks": [{"url": "https://example.com/zim/events", "events": ["connect", "disconnect"]}]
+
+events为空或者包含"*"时通知所有事件。事件类型：
+
+	connect       用户登入成功，连接建立
+	disconnect    已登入的连接断开
+	login-failed  登入认证失败
+	push-dropped  推送给连接失败，消息被丢弃
+	kick          连接被踢下线（管理接口或者业务服务的close命令）
+
+请求内容为JSON格式的事件，例如：
+
+	{"type":"connect","appid":"foo","userid":"u1","deviceid":"d1","timestamp":1500000000}
+
+请求Header与httpapi Broker相同，tag为webhook，Zim-Name为"webhook/<事件类型>"，
+使用应用的服务端密钥签名，应用服务可以使用httpapi.ParseCommand检查签名。
+
+通知放入异步队列发送，队列已满时丢弃。应用服务返回2xx表示成功；
+网络错误、408、429和5xx时按重试间隔递增等待后重试，其他状态码不重试。网关退出时队列中的通知被丢弃。
+
+配置（viper参数前缀 + ".webhook."）：
+
+* queue-size: 队列长度（默认10000）
+
+* workers: 并发发送数（默认4）
+
+* retry: 失败后的最大重试次数（默认3）
+
+* retry-interval: 第一次重试的等待时间（单位：毫秒，默认1000），第n次重试等待n倍
+
+* timeout: 请求超时时间（单位：毫秒，默认5000）
+*/
+package webhook
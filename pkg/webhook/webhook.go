@@ -0,0 +1,270 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/httpapi"
+	"github.com/zhangpeihao/zim/pkg/protocol"
+)
+
+// 事件类型
+const (
+	// EventConnect 用户登入成功，连接建立
+	EventConnect = "connect"
+	// EventDisconnect 已登入的连接断开
+	EventDisconnect = "disconnect"
+	// EventLoginFailed 登入认证失败
+	EventLoginFailed = "login-failed"
+	// EventPushDropped 推送给连接失败，消息被丢弃
+	EventPushDropped = "push-dropped"
+	// EventKick 连接被踢下线
+	EventKick = "kick"
+)
+
+const (
+	// Tag 计算CheckSum使用的tag
+	Tag = "webhook"
+	// NamePrefix 信令名前缀，信令名为"webhook/<事件类型>"
+	NamePrefix = Tag + "/"
+	// DefaultQueueSize 默认队列长度
+	DefaultQueueSize = 10000
+	// DefaultWorkers 默认并发发送数
+	DefaultWorkers = 4
+	// DefaultRetry 默认最大重试次数
+	DefaultRetry = 3
+	// DefaultRetryInterval 默认第一次重试的等待时间
+	DefaultRetryInterval = time.Second
+	// DefaultTimeout 默认请求超时时间
+	DefaultTimeout = 5 * time.Second
+)
+
+// Event 网关事件
+type Event struct {
+	// Type 事件类型
+	Type string `json:"type"`
+	// AppID 应用ID
+	AppID string `json:"appid"`
+	// UserID 用户ID
+	UserID string `json:"userid,omitempty"`
+	// DeviceID 设备ID
+	DeviceID string `json:"deviceid,omitempty"`
+	// Reason 事件原因，例如登入失败和推送失败的错误信息
+	Reason string `json:"reason,omitempty"`
+	// Timestamp 事件时间戳（单位：秒）
+	Timestamp int64 `json:"timestamp"`
+}
+
+// delivery 待发送的通知
+type delivery struct {
+	url   string
+	event *Event
+	body  []byte
+}
+
+// statusError 应用服务返回的错误状态码
+type statusError int
+
+func (code statusError) Error() string {
+	return fmt.Sprintf("webhook response status %d", int(code))
+}
+
+// composeError 生成请求失败，例如应用没有服务端密钥
+type composeError struct {
+	error
+}
+
+// retryable 是否重试
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case statusError:
+		return e == http.StatusRequestTimeout || e == http.StatusTooManyRequests || e >= 500
+	case composeError:
+		return false
+	}
+	return true
+}
+
+// Notifier 事件通知，nil Notifier不发送通知
+type Notifier struct {
+	// ctx 上下文，用于查找应用密钥
+	ctx context.Context
+	// queue 发送队列
+	queue chan *delivery
+	// workers 并发发送数
+	workers int
+	// retry 最大重试次数
+	retry int
+	// retryInterval 第一次重试的等待时间
+	retryInterval time.Duration
+	// timeout 请求超时时间
+	timeout time.Duration
+	// client HTTP客户端
+	client *http.Client
+	// delivered, failed, dropped 统计
+	delivered, failed, dropped int64
+}
+
+// NewNotifier 新建事件通知
+func NewNotifier(viperPerfix string) *Notifier {
+	n := &Notifier{
+		workers:       viper.GetInt(viperPerfix + ".webhook.workers"),
+		retry:         viper.GetInt(viperPerfix + ".webhook.retry"),
+		retryInterval: time.Duration(viper.GetInt(viperPerfix+".webhook.retry-interval")) * time.Millisecond,
+		timeout:       time.Duration(viper.GetInt(viperPerfix+".webhook.timeout")) * time.Millisecond,
+	}
+	queueSize := viper.GetInt(viperPerfix + ".webhook.queue-size")
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	n.queue = make(chan *delivery, queueSize)
+	if n.workers <= 0 {
+		n.workers = DefaultWorkers
+	}
+	if !viper.IsSet(viperPerfix + ".webhook.retry") {
+		n.retry = DefaultRetry
+	}
+	if n.retryInterval <= 0 {
+		n.retryInterval = DefaultRetryInterval
+	}
+	if n.timeout <= 0 {
+		n.timeout = DefaultTimeout
+	}
+	n.client = &http.Client{
+		Transport: httpapi.NewTransport(nil, n.workers, httpapi.DefaultIdleConnTimeout),
+	}
+	return n
+}
+
+// Run 启动发送，ctx中需要包含AppController
+func (n *Notifier) Run(ctx context.Context) error {
+	glog.Infoln("webhook::Notifier::Run()")
+	n.ctx = ctx
+	for i := 0; i < n.workers; i++ {
+		go n.work()
+	}
+	return nil
+}
+
+// Close 关闭，队列中的通知被丢弃
+func (n *Notifier) Close(timeout time.Duration) error {
+	glog.Infoln("webhook::Notifier::Close()")
+	n.client.CloseIdleConnections()
+	return nil
+}
+
+// Notify 通知应用的事件，放入发送队列后立即返回，返回放入队列的通知数
+func (n *Notifier) Notify(a *app.App, event *Event) int {
+	if n == nil || a == nil || len(a.Webhooks) == 0 {
+		return 0
+	}
+	event.AppID = a.ID
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	var body []byte
+	queued := 0
+	for _, webhook := range a.Webhooks {
+		if !webhook.Accept(event.Type) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(event); err != nil {
+				glog.Warningf("webhook::Notifier::Notify() marshal event error: %s\n", err)
+				return 0
+			}
+		}
+		select {
+		case n.queue <- &delivery{url: webhook.URL, event: event, body: body}:
+			queued++
+		default:
+			atomic.AddInt64(&n.dropped, 1)
+			glog.Warningf("webhook::Notifier::Notify() queue full, drop %s event of app(%s)\n",
+				event.Type, a.ID)
+		}
+	}
+	return queued
+}
+
+// Stats 发送成功、失败（重试后仍失败）和队列已满丢弃的通知数
+func (n *Notifier) Stats() (delivered, failed, dropped int64) {
+	return atomic.LoadInt64(&n.delivered), atomic.LoadInt64(&n.failed), atomic.LoadInt64(&n.dropped)
+}
+
+// work 从队列取出通知并发送，ctx结束时退出
+func (n *Notifier) work() {
+	for {
+		select {
+		case d := <-n.queue:
+			n.deliver(d)
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver 发送通知，失败时重试
+func (n *Notifier) deliver(d *delivery) {
+	for attempt := 0; ; attempt++ {
+		err := n.post(d)
+		if err == nil {
+			atomic.AddInt64(&n.delivered, 1)
+			return
+		}
+		if attempt >= n.retry || !retryable(err) {
+			atomic.AddInt64(&n.failed, 1)
+			glog.Warningf("webhook::Notifier::deliver() %s event of app(%s) to %s failed after %d attempts: %s\n",
+				d.event.Type, d.event.AppID, d.url, attempt+1, err)
+			return
+		}
+		glog.Infof("webhook::Notifier::deliver() %s event of app(%s) to %s error: %s, retry\n",
+			d.event.Type, d.event.AppID, d.url, err)
+		select {
+		case <-time.After(n.retryInterval * time.Duration(attempt+1)):
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// post 发送一次通知，请求签名与httpapi Broker相同
+func (n *Notifier) post(d *delivery) error {
+	req, err := http.NewRequest("POST", d.url, bytes.NewReader(d.body))
+	if err != nil {
+		return composeError{err}
+	}
+	// 每次发送重新签名，使用新的Nonce和Timestamp
+	if err = httpapi.ComposeCommand(n.ctx, Tag, req.Header, &protocol.Command{
+		AppID:   d.event.AppID,
+		Name:    NamePrefix + d.event.Type,
+		Payload: d.body,
+	}); err != nil {
+		return composeError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(n.ctx, n.timeout)
+	defer cancel()
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return statusError(resp.StatusCode)
+	}
+	return nil
+}
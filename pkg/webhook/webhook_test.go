@@ -0,0 +1,133 @@
+// Copyright 2017 Zhang Peihao <zhangpeihao@gmail.com>
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zhangpeihao/zim/pkg/app"
+	"github.com/zhangpeihao/zim/pkg/broker/httpapi"
+	"github.com/zhangpeihao/zim/pkg/broker/register"
+
+	_ "github.com/zhangpeihao/zim/pkg/broker/mock"
+)
+
+func init() {
+	flag.Set("v", "4")
+	flag.Set("logtostderr", "true")
+}
+
+func TestNotifier(t *testing.T) {
+	var (
+		locker   sync.Mutex
+		events   []*Event
+		requests int
+	)
+	nonces := httpapi.NewNonceCache(0)
+	var ctx context.Context
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locker.Lock()
+		defer locker.Unlock()
+		requests++
+		// 第一次请求失败，测试重试
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		cmd, err := httpapi.ParseCommand(ctx, Tag, r.Header, body, httpapi.DefaultTimeout, nonces)
+		if err != nil {
+			t.Errorf("ParseCommand() error: %s\n", err)
+			httpapi.WriteError(w, err)
+			return
+		}
+		var event Event
+		if err = json.Unmarshal(cmd.Payload, &event); err != nil || cmd.Name != NamePrefix+event.Type {
+			t.Errorf("event %s: %s, %v\n", cmd.Name, cmd.Payload, err)
+		}
+		if r.URL.Path == "/reject" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events = append(events, &event)
+	}))
+	defer server.Close()
+
+	if err := register.Init("test"); err != nil {
+		t.Fatal("register.Init() error:", err)
+	}
+	a, err := app.ParseApp(strings.NewReader(`{
+		"id": "foo",
+		"key": "123",
+		"router": {"*": {"broker": "mock"}},
+		"webhooks": [
+			{"url": "` + server.URL + `/all"},
+			{"url": "` + server.URL + `/kick", "events": ["kick"]},
+			{"url": "` + server.URL + `/reject", "events": ["login-failed"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal("ParseApp() error:", err)
+	}
+	controller, _ := app.NewController(nil)
+	controller.AddApp(a)
+	runCtx, cancel := context.WithCancel(controller.SaveIntoContext(context.Background()))
+	defer cancel()
+	ctx = runCtx
+
+	viper.Set("test.webhook.retry-interval", 10)
+	viper.Set("test.webhook.queue-size", 5)
+	viper.Set("test.webhook.workers", 1)
+	n := NewNotifier("test")
+	if queued := n.Notify(a, &Event{Type: EventConnect, UserID: "u1", DeviceID: "d1"}); queued != 1 {
+		t.Errorf("connect queued: %d\n", queued)
+	}
+	if queued := n.Notify(a, &Event{Type: EventKick, UserID: "u1"}); queued != 2 {
+		t.Errorf("kick queued: %d\n", queued)
+	}
+	if queued := n.Notify(a, &Event{Type: EventLoginFailed, UserID: "u2"}); queued != 2 {
+		t.Errorf("login-failed queued: %d\n", queued)
+	}
+	// 没有配置webhooks的应用和nil Notifier不通知
+	if n.Notify(&app.App{ID: "bar"}, &Event{Type: EventConnect}) != 0 ||
+		(*Notifier)(nil).Notify(a, &Event{Type: EventConnect}) != 0 {
+		t.Error("Notify() without webhooks should not queue")
+	}
+	// 队列已满时丢弃
+	if queued := n.Notify(a, &Event{Type: EventKick, UserID: "u3"}); queued != 0 {
+		t.Errorf("queue full queued: %d\n", queued)
+	}
+
+	n.Run(ctx)
+	defer n.Close(time.Second)
+	for i := 0; i < 100; i++ {
+		if delivered, failed, _ := n.Stats(); delivered+failed == 5 {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	delivered, failed, dropped := n.Stats()
+	if delivered != 4 || failed != 1 || dropped != 2 {
+		t.Errorf("Stats() delivered: %d, failed: %d, dropped: %d\n", delivered, failed, dropped)
+	}
+	locker.Lock()
+	defer locker.Unlock()
+	// 重试1次，400不重试
+	if requests != 6 || len(events) != 4 {
+		t.Fatalf("requests: %d, events: %d\n", requests, len(events))
+	}
+	if e := events[0]; e.Type != EventConnect || e.AppID != "foo" || e.UserID != "u1" ||
+		e.DeviceID != "d1" || e.Timestamp == 0 {
+		t.Errorf("event: %+v\n", e)
+	}
+}
@@ -28,6 +28,7 @@ type Connection struct {
 	userID         string
 	appID          string
 	deviceID       string
+	tags           []string
 	defaultVersion string
 	c              *websocket.Conn
 }
@@ -78,7 +79,7 @@ func (conn *Connection) ReadCommand() (cmd *protocol.Command, err error) {
 		cmd = HeartBeatResponseCommand
 	case websocket.TextMessage:
 		if message == nil || len(message) == 0 {
-			glog.Warningln("websocket::connection::ReadCommand() message unsupport\n")
+			glog.Warningln("websocket::connection::ReadCommand() message unsupport")
 			err = define.ErrUnsupportProtocol
 			return nil, err
 		}
@@ -106,11 +107,22 @@ func (conn *Connection) String() string {
 func (conn *Connection) LoginSuccess(appID, userID, deviceID, defaultVersion string) {
 	conn.appID = appID
 	conn.userID = userID
+	conn.deviceID = deviceID
 	conn.id = define.ConnectionID(appID, userID)
 	conn.defaultVersion = defaultVersion
 	conn.login = true
 }
 
+// Tags 用户标签
+func (conn *Connection) Tags() []string {
+	return conn.tags
+}
+
+// SetTags 设置用户标签
+func (conn *Connection) SetTags(tags []string) {
+	conn.tags = tags
+}
+
 // IsLogin 登入状态
 func (conn *Connection) IsLogin() bool {
 	return conn.login